@@ -32,6 +32,15 @@ def haversine_km(lat1, lon1, lat2, lon2):
 	return R * c
 
 
+def fits(moto, order):
+	"""True si el pedido cabe en el peso y volumen restantes de la moto"""
+	if moto["weight_left"] is not None and order["weight_kg"] > moto["weight_left"] + 1e-9:
+		return False
+	if moto["volume_left"] is not None and order["volume_l"] > moto["volume_left"] + 1e-9:
+		return False
+	return True
+
+
 @app.route('/optimize-assignments', methods=['POST'])
 def optimize_assignments():
 	"""
@@ -39,7 +48,7 @@ def optimize_assignments():
 	
 	Mejoras sobre el algoritmo anterior (Greedy):
 	1. Distribuye pedidos equitativamente entre motos disponibles
-	2. Respeta la capacidad máxima de cada moto
+	2. Respeta la capacidad máxima de cada moto (pedidos, peso y volumen)
 	3. Considera la ubicación actual de cada moto
 	4. Asigna el pedido más cercano a cada moto en cada ronda
 	"""
@@ -69,6 +78,10 @@ def optimize_assignments():
 		capacity = int(m.get("max_orders_capacity") or max_orders_per_moto)
 		current_count = int(m.get("current_orders_count") or 0)
 		available_capacity = capacity - current_count
+
+		# Peso y volumen restantes (límite 0 o ausente = sin límite)
+		max_weight = float(m.get("max_weight_kg") or 0)
+		max_volume = float(m.get("max_volume_l") or 0)
 		
 		if available_capacity > 0:
 			moto_states.append({
@@ -79,6 +92,8 @@ def optimize_assignments():
 				"current_lng": lng,
 				"capacity": available_capacity,
 				"assigned_count": 0,
+				"weight_left": max_weight - float(m.get("current_weight_kg") or 0) if max_weight > 0 else None,
+				"volume_left": max_volume - float(m.get("current_volume_l") or 0) if max_volume > 0 else None,
 				"license_plate": m.get("license_plate", f"MOTO-{moto_id}")
 			})
 
@@ -92,6 +107,8 @@ def optimize_assignments():
 			"latitude": float(o.get("latitude", depot_lat)),
 			"longitude": float(o.get("longitude", depot_lng)),
 			"address": o.get("address", ""),
+			"weight_kg": float(o.get("weight_kg") or 0),
+			"volume_l": float(o.get("volume_l") or 0),
 		}
 		for o in orders
 		if o.get("id") is not None
@@ -125,6 +142,8 @@ def optimize_assignments():
 			best_dist = None
 			
 			for idx, order in enumerate(unassigned):
+				if not fits(moto, order):
+					continue
 				d = haversine_km(
 					moto["current_lat"], moto["current_lng"],
 					order["latitude"], order["longitude"]
@@ -150,6 +169,10 @@ def optimize_assignments():
 				moto["current_lat"] = best_order["latitude"]
 				moto["current_lng"] = best_order["longitude"]
 				moto["assigned_count"] += 1
+				if moto["weight_left"] is not None:
+					moto["weight_left"] -= best_order["weight_kg"]
+				if moto["volume_left"] is not None:
+					moto["volume_left"] -= best_order["volume_l"]
 				made_assignment = True
 		
 		# Si en esta ronda no se pudo asignar nada, salir
@@ -6,6 +6,8 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	pathpkg "path"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/logitrack/api-gateway/logging"
@@ -28,6 +30,11 @@ func main() {
 	}
 	logger.Info().Msg("Rate limiter inicializado con Redis")
 
+	// Sin JWT_SECRET no se puede validar ningún token: no arrancar
+	if err := middleware.InitJWT(); err != nil {
+		logger.Fatal().Err(err).Msg("Error en la configuración de JWT")
+	}
+
 	// ========================================
 	// MIDDLEWARES GLOBALES (ORDEN IMPORTANTE)
 	// ========================================
@@ -35,10 +42,13 @@ func main() {
 	// 1. Request ID (correlación)
 	r.Use(middleware.RequestIDMiddleware())
 
-	// 2. Security headers
+	// 2. Los headers de identidad solo los establece el gateway (JWTAuth)
+	r.Use(middleware.StripIdentityHeaders())
+
+	// 3. Security headers
 	r.Use(middleware.SecurityHeaders())
 
-	// 3. CORS estricto (solo orígenes permitidos)
+	// 4. CORS estricto (solo orígenes permitidos)
 	r.Use(func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 
@@ -59,6 +69,7 @@ func main() {
 
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Total-Count, X-Next-Cursor")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
@@ -70,10 +81,10 @@ func main() {
 		c.Next()
 	})
 
-	// 4. Rate limiting (después de CORS)
+	// 5. Rate limiting (después de CORS)
 	r.Use(middleware.RateLimitMiddleware())
 
-	// 5. Métricas Prometheus (último para medir todo)
+	// 6. Métricas Prometheus (último para medir todo)
 	r.Use(middleware.MetricsMiddleware())
 
 	// Health check del gateway
@@ -120,15 +131,56 @@ func main() {
 	r.POST("/orders", proxyTo(orderServiceURL, "/orders"))
 	r.PUT("/orders/:id/status", proxyToWithNestedParam(orderServiceURL, "/orders", "/status"))
 	r.PUT("/orders/:id/assign", proxyToWithNestedParam(orderServiceURL, "/orders", "/assign"))
+	r.PUT("/orders/:id/pin", proxyToWithNestedParam(orderServiceURL, "/orders", "/pin"))
 	r.GET("/orders/:id/eta", proxyToWithNestedParam(orderServiceURL, "/orders", "/eta"))
 	r.POST("/orders/:id/delivery-proof", proxyToWithNestedParam(orderServiceURL, "/orders", "/delivery-proof"))
 	r.GET("/orders/:id/delivery-proof", proxyToWithNestedParam(orderServiceURL, "/orders", "/delivery-proof"))
+	r.POST("/orders/:id/pickup-proof", proxyToWithNestedParam(orderServiceURL, "/orders", "/pickup-proof"))
+	r.GET("/orders/:id/pickup-proof", proxyToWithNestedParam(orderServiceURL, "/orders", "/pickup-proof"))
+	r.GET("/orders/:id/attempts", proxyToWithNestedParam(orderServiceURL, "/orders", "/attempts"))
+	r.PUT("/orders/:id/reschedule", proxyToWithNestedParam(orderServiceURL, "/orders", "/reschedule"))
+	r.GET("/attempt-reasons", proxyTo(orderServiceURL, "/attempt-reasons"))
+
+	// Bultos y cadena de custodia
+	r.GET("/orders/:id/parcels", proxyToWithNestedParam(orderServiceURL, "/orders", "/parcels"))
+	r.POST("/orders/:id/parcels", proxyToWithNestedParam(orderServiceURL, "/orders", "/parcels"))
+	r.GET("/parcels/:id", proxyToWithParam(orderServiceURL, "/parcels"))
+	r.GET("/orders/:id/label", proxyToWithNestedParam(orderServiceURL, "/orders", "/label"))
+	r.GET("/labels", proxyTo(orderServiceURL, "/labels"))
+	r.GET("/motos/:id/manifest", proxyToWithNestedParam(orderServiceURL, "/motos", "/manifest"))
+	r.POST("/scans", middleware.JWTAuth(), proxyTo(orderServiceURL, "/scans"))
+	r.GET("/scans/reconciliation", proxyTo(orderServiceURL, "/scans/reconciliation"))
+
+	// Clientes y libreta de direcciones
+	r.GET("/customers", proxyTo(orderServiceURL, "/customers"))
+	r.POST("/customers", proxyTo(orderServiceURL, "/customers"))
+	r.GET("/customers/duplicates", proxyTo(orderServiceURL, "/customers/duplicates"))
+	r.GET("/customers/:id", proxyToWithParam(orderServiceURL, "/customers"))
+	r.PUT("/customers/:id", proxyToWithParam(orderServiceURL, "/customers"))
+	r.POST("/customers/:id/merge", proxyToWithNestedParam(orderServiceURL, "/customers", "/merge"))
+	r.GET("/customers/:id/orders", proxyToWithNestedParam(orderServiceURL, "/customers", "/orders"))
+	r.GET("/customers/:id/addresses", proxyToWithNestedParam(orderServiceURL, "/customers", "/addresses"))
+	r.POST("/customers/:id/addresses", proxyToWithNestedParam(orderServiceURL, "/customers", "/addresses"))
+	r.PUT("/addresses/:id", proxyToWithParam(orderServiceURL, "/addresses"))
+	r.DELETE("/addresses/:id", proxyToWithParam(orderServiceURL, "/addresses"))
+
+	// Motorista autenticado (la moto se resuelve desde el token)
+	r.GET("/me/stops", middleware.JWTAuth(), proxyTo(orderServiceURL, "/me/stops"))
+	r.GET("/me/next-stop", middleware.JWTAuth(), proxyTo(orderServiceURL, "/me/next-stop"))
+	r.POST("/me/stops/:id/start", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/me/stops", "/start"))
+	r.POST("/me/stops/:id/arrived", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/me/stops", "/arrived"))
+	r.POST("/me/stops/:id/pickup", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/me/stops", "/pickup"))
+	r.POST("/me/stops/:id/complete", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/me/stops", "/complete"))
+	r.POST("/me/stops/:id/failed", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/me/stops", "/failed"))
 
 	// ========================================
 	// ✅ RUTAS DE MOTOS
 	// ========================================
-	r.GET("/motos", proxyTo(orderServiceURL, "/motos"))
+	r.GET("/motos", middleware.JWTAuth(), proxyTo(orderServiceURL, "/motos")) // Filtra por la sucursal del usuario
 	r.GET("/motos/available", proxyTo(orderServiceURL, "/motos/available"))
+	r.GET("/motos/nearest", proxyTo(orderServiceURL, "/motos/nearest"))
+	r.GET("/motos/load-reconciliation", middleware.JWTAuth(), proxyTo(orderServiceURL, "/motos/load-reconciliation"))
+	r.POST("/motos/load-reconciliation", middleware.JWTAuth(), proxyTo(orderServiceURL, "/motos/load-reconciliation"))
 	r.GET("/motos/:id", proxyToWithParam(orderServiceURL, "/motos"))
 	r.POST("/motos", proxyTo(orderServiceURL, "/motos"))
 	r.PUT("/motos/:id", proxyToWithParam(orderServiceURL, "/motos"))
@@ -145,6 +197,33 @@ func main() {
 	r.PUT("/branches/:id", proxyToWithParam(orderServiceURL, "/branches"))
 	r.DELETE("/branches/:id", proxyToWithParam(orderServiceURL, "/branches"))
 	r.PUT("/branches/:id/toggle", proxyToWithNestedParam(orderServiceURL, "/branches", "/toggle"))
+	r.GET("/branches/:id/zones", proxyToWithNestedParam(orderServiceURL, "/branches", "/zones"))
+	r.POST("/branches/:id/zones", proxyToWithNestedParam(orderServiceURL, "/branches", "/zones"))
+
+	// ========================================
+	// ✅ RUTAS DE ZONAS DE ENTREGA
+	// ========================================
+	r.GET("/zones/lookup", proxyTo(orderServiceURL, "/zones/lookup"))
+	r.GET("/geocode", proxyTo(orderServiceURL, "/geocode"))
+
+	// ========================================
+	// ✅ RUTAS DE TARIFAS
+	// ========================================
+	r.POST("/quotes", proxyTo(orderServiceURL, "/quotes"))
+	r.GET("/pricing/contracts", proxyTo(orderServiceURL, "/pricing/contracts"))
+	r.POST("/pricing/contracts", middleware.JWTAuth(), proxyTo(orderServiceURL, "/pricing/contracts"))
+	r.PUT("/pricing/contracts/:id", middleware.JWTAuth(), proxyToWithParam(orderServiceURL, "/pricing/contracts"))
+	r.PUT("/branches/:id/rain", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/branches", "/rain"))
+
+	// ========================================
+	// ✅ RUTAS DE COBRO CONTRA ENTREGA
+	// ========================================
+	r.GET("/shifts/:id/cash", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/shifts", "/cash"))
+	r.POST("/shifts/:id/cash-handover", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/shifts", "/cash-handover"))
+	r.GET("/cash-handovers", middleware.JWTAuth(), proxyTo(orderServiceURL, "/cash-handovers"))
+	r.POST("/cash-handovers/:id/confirm", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/cash-handovers", "/confirm"))
+	r.PUT("/zones/:id", proxyToWithParam(orderServiceURL, "/zones"))
+	r.DELETE("/zones/:id", proxyToWithParam(orderServiceURL, "/zones"))
 
 	// ========================================
 	// ✅ RUTAS DE OPTIMIZACIÓN
@@ -152,11 +231,23 @@ func main() {
 	r.GET("/optimization/assignments", proxyTo(orderServiceURL, "/optimization/assignments"))
 	r.POST("/optimization/apply", proxyTo(orderServiceURL, "/optimization/apply"))
 
+	// Despacho automático
+	r.GET("/dispatch/settings", proxyTo(orderServiceURL, "/dispatch/settings"))
+	r.PUT("/dispatch/settings/:id", proxyToWithParam(orderServiceURL, "/dispatch/settings"))
+	r.POST("/dispatch/run", proxyTo(orderServiceURL, "/dispatch/run"))
+	r.GET("/dispatch/decisions", proxyTo(orderServiceURL, "/dispatch/decisions"))
+
+	// Ofertas de asignación (respuesta del motorista autenticado)
+	r.GET("/offers", proxyTo(orderServiceURL, "/offers"))
+	r.PUT("/offers/:id/accept", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/offers", "/accept"))
+	r.PUT("/offers/:id/reject", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/offers", "/reject"))
+
 	// ========================================
 	// ✅ RUTAS DE KPIs (Dashboard Gerencial)
 	// ========================================
 	r.GET("/kpis/motos", proxyTo(orderServiceURL, "/kpis/motos"))
 	r.GET("/kpis/branches", proxyTo(orderServiceURL, "/kpis/branches"))
+	r.GET("/kpis/drivers", proxyTo(orderServiceURL, "/kpis/drivers"))
 
 	// ========================================
 	// ✅ RUTAS DE COORDINADORES (Visitas y Checklist)
@@ -173,16 +264,35 @@ func main() {
 	// ========================================
 	// ✅ RUTAS DE TRANSFERENCIAS (Motos entre Sucursales)
 	// ========================================
-	r.POST("/transfers", proxyTo(orderServiceURL, "/transfers"))
-	r.PUT("/motos/:id/return", proxyToWithNestedParam(orderServiceURL, "/motos", "/return"))
+	r.POST("/transfers", middleware.JWTAuth(), proxyTo(orderServiceURL, "/transfers"))
+	r.PUT("/motos/:id/return", middleware.JWTAuth(), proxyToWithNestedParam(orderServiceURL, "/motos", "/return"))
 	r.GET("/transfers", proxyTo(orderServiceURL, "/transfers"))
 	r.GET("/transfers/history", proxyTo(orderServiceURL, "/transfers/history"))
 	r.POST("/transfers/expire", proxyTo(orderServiceURL, "/transfers/expire"))
 
 	// ========================================
-	// ✅ RUTAS DE GEOLOCALIZACIÓN (wildcard)
+	// ✅ REVISIÓN DE GPS SOSPECHOSO (resolver requiere JWT para registrar al revisor)
+	// ========================================
+	r.GET("/gps-reviews", proxyTo(orderServiceURL, "/gps-reviews"))
+	r.PUT("/gps-reviews/:id", middleware.JWTAuth(), proxyToWithParam(orderServiceURL, "/gps-reviews"))
+
+	// ========================================
+	// ✅ MANTENIMIENTO DEL HISTORIAL GPS (solo admin)
+	// ========================================
+	r.GET("/maintenance/gps/policies", middleware.JWTAuth(), middleware.RequireRole("admin"), proxyTo(geoServiceURL, "/maintenance/gps/policies"))
+	r.PUT("/maintenance/gps/policies/:id", middleware.JWTAuth(), middleware.RequireRole("admin"), proxyToWithParam(geoServiceURL, "/maintenance/gps/policies"))
+	r.POST("/maintenance/gps/run", middleware.JWTAuth(), middleware.RequireRole("admin"), proxyTo(geoServiceURL, "/maintenance/gps/run"))
+
+	// ========================================
+	// ✅ RUTAS DE GEOLOCALIZACIÓN (wildcard; el mantenimiento no se expone aquí)
+	// ========================================
+	r.Any("/geo/*path", proxyWildcard(geoServiceURL, "/maintenance/"))
+
+	// ========================================
+	// ✅ CANAL EN VIVO DE LA FLOTA (WebSocket + SSE, requiere JWT)
 	// ========================================
-	r.Any("/geo/*path", proxyWildcard(geoServiceURL))
+	r.GET("/live/ws", middleware.JWTAuth(), proxyStream(geoServiceURL, "/live/ws"))
+	r.GET("/live/events", middleware.JWTAuth(), proxyStream(geoServiceURL, "/live/events"))
 
 	// ========================================
 	// ✅ RUTAS DE IA (wildcard)
@@ -293,9 +403,10 @@ func proxyToWithNestedParam(targetBase, basePath, suffix string) gin.HandlerFunc
 	}
 }
 
-// proxyWildcard crea proxy para rutas wildcard
+// proxyWildcard crea proxy para rutas wildcard. Las rutas que empiezan con
+// alguno de los prefijos bloqueados responden 404 (tienen su ruta explícita)
 // Ejemplo: /geo/locations → http://geolocation-service:8083/locations
-func proxyWildcard(targetBase string) gin.HandlerFunc {
+func proxyWildcard(targetBase string, blockedPrefixes ...string) gin.HandlerFunc {
 	targetURL, _ := url.Parse(targetBase)
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
@@ -310,6 +421,13 @@ func proxyWildcard(targetBase string) gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		path := c.Param("path")
+		cleaned := pathpkg.Clean(path) + "/"
+		for _, prefix := range blockedPrefixes {
+			if strings.HasPrefix(cleaned, prefix) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Ruta no encontrada"})
+				return
+			}
+		}
 		c.Request.URL.Path = path
 		c.Request.URL.Host = targetURL.Host
 		c.Request.URL.Scheme = targetURL.Scheme
@@ -318,6 +436,33 @@ func proxyWildcard(targetBase string) gin.HandlerFunc {
 	}
 }
 
+// proxyStream crea proxy para conexiones de larga duración (WebSocket y SSE).
+// ReverseProxy maneja el upgrade a WebSocket; FlushInterval -1 envía cada
+// evento SSE al cliente sin buffer
+// Ejemplo: /live/ws → ws://geolocation-service:8083/live/ws
+func proxyStream(targetBase, targetPath string) gin.HandlerFunc {
+	targetURL, _ := url.Parse(targetBase)
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.FlushInterval = -1
+
+	// Modificar respuesta para eliminar headers CORS duplicados
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		resp.Header.Del("Access-Control-Allow-Origin")
+		resp.Header.Del("Access-Control-Allow-Methods")
+		resp.Header.Del("Access-Control-Allow-Headers")
+		resp.Header.Del("Access-Control-Max-Age")
+		return nil
+	}
+
+	return func(c *gin.Context) {
+		c.Request.URL.Path = targetPath
+		c.Request.URL.Host = targetURL.Host
+		c.Request.URL.Scheme = targetURL.Scheme
+		c.Request.Host = targetURL.Host
+		proxy.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
 // getEnv obtiene variable de entorno con valor por defecto
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
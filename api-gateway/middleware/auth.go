@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Headers de identidad que el gateway inyecta hacia los servicios internos
+const (
+	UserIDHeader     = "X-User-ID"
+	UserRoleHeader   = "X-User-Role"
+	UserBranchHeader = "X-User-Branch"
+)
+
+var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+
+// ErrMissingJWTSecret el gateway no puede validar tokens sin JWT_SECRET: con
+// una clave vacía cualquiera podría firmar tokens válidos
+var ErrMissingJWTSecret = errors.New("JWT_SECRET no está configurado")
+
+// InitJWT verifica la configuración de JWTAuth al arrancar
+func InitJWT() error {
+	if len(jwtSecret) == 0 {
+		return ErrMissingJWTSecret
+	}
+	return nil
+}
+
+// accessClaims replica los claims emitidos por user-service
+type accessClaims struct {
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// StripIdentityHeaders elimina los headers de identidad enviados por el
+// cliente para que solo el gateway pueda establecerlos
+func StripIdentityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del(UserIDHeader)
+		c.Request.Header.Del(UserRoleHeader)
+		c.Request.Header.Del(UserBranchHeader)
+		c.Next()
+	}
+}
+
+// JWTAuth valida el access token y propaga la identidad en X-User-ID y
+// X-User-Role. El token se toma del header Authorization o, para WebSocket y
+// EventSource (que no permiten headers personalizados), del query ?token=
+func JWTAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if tokenString == "" {
+			tokenString = c.Query("token")
+		}
+		if tokenString == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token requerido"})
+			return
+		}
+
+		claims, err := parseAccessToken(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token inválido o expirado"})
+			return
+		}
+
+		// No reenviar el token en la URL hacia los servicios internos
+		query := c.Request.URL.Query()
+		if query.Has("token") {
+			query.Del("token")
+			c.Request.URL.RawQuery = query.Encode()
+		}
+
+		c.Request.Header.Set(UserIDHeader, strconv.Itoa(claims.UserID))
+		c.Request.Header.Set(UserRoleHeader, claims.Role)
+		c.Set("user_id", claims.UserID)
+		c.Set("user_role", claims.Role)
+
+		c.Next()
+	}
+}
+
+// RequireRole permite continuar solo si el rol validado por JWTAuth está entre
+// los indicados; debe encadenarse después de JWTAuth
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("user_role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No tiene permisos para esta operación"})
+	}
+}
+
+func parseAccessToken(tokenString string) (*accessClaims, error) {
+	if len(jwtSecret) == 0 {
+		return nil, ErrMissingJWTSecret
+	}
+	claims := &accessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("método de firma inesperado")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("token inválido")
+	}
+	if claims.UserID == 0 || claims.Role == "" {
+		return nil, errors.New("claims incompletos")
+	}
+	return claims, nil
+}
@@ -0,0 +1,317 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatos de exportación soportados
+const (
+	FormatGPX     = "gpx"
+	FormatKML     = "kml"
+	FormatGeoJSON = "geojson"
+)
+
+// ErrUnknownFormat se devuelve para un formato no soportado
+var ErrUnknownFormat = errors.New("formato no soportado (gpx, kml o geojson)")
+
+// Point es un punto GPS del recorrido o una parada (waypoint)
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64
+	Timestamp time.Time
+	PointType string
+	OrderID   *int
+	Address   string
+}
+
+// Track identifica el recorrido de un turno
+type Track struct {
+	ShiftID  int
+	DriverID int
+	Branch   string
+	Start    time.Time
+	End      *time.Time
+}
+
+func (t Track) name() string {
+	return fmt.Sprintf("Turno %d - driver %d", t.ShiftID, t.DriverID)
+}
+
+// Writer escribe un documento de forma incremental: primero las paradas,
+// luego cada recorrido punto por punto, sin acumular el turno en memoria.
+// GPX exige que los waypoints precedan a los tracks.
+type Writer interface {
+	WriteWaypoint(p Point) error
+	BeginTrack(t Track) error
+	WriteTrackPoint(p Point) error
+	EndTrack() error
+	Close() error
+}
+
+// ContentType devuelve el MIME type del formato
+func ContentType(format string) string {
+	switch format {
+	case FormatGPX:
+		return "application/gpx+xml"
+	case FormatKML:
+		return "application/vnd.google-earth.kml+xml"
+	case FormatGeoJSON:
+		return "application/geo+json"
+	}
+	return "application/octet-stream"
+}
+
+// IsSupported indica si el formato es exportable
+func IsSupported(format string) bool {
+	return format == FormatGPX || format == FormatKML || format == FormatGeoJSON
+}
+
+// NewWriter crea el writer del formato indicado y escribe la cabecera del documento
+func NewWriter(format string, w io.Writer, name string) (Writer, error) {
+	bw := bufio.NewWriter(w)
+	var out Writer
+	switch format {
+	case FormatGPX:
+		out = &gpxWriter{w: bw}
+		fmt.Fprintf(bw, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+			`<gpx version="1.1" creator="LogiTrack" xmlns="http://www.topografix.com/GPX/1/1">`+"\n"+
+			"<metadata><name>%s</name><time>%s</time></metadata>\n", escape(name), formatTime(time.Now()))
+	case FormatKML:
+		out = &kmlWriter{w: bw}
+		fmt.Fprintf(bw, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+			`<kml xmlns="http://www.opengis.net/kml/2.2">`+"\n"+
+			"<Document><name>%s</name>\n", escape(name))
+	case FormatGeoJSON:
+		out = &geojsonWriter{w: bw}
+		fmt.Fprintf(bw, `{"type":"FeatureCollection","name":%s,"features":[`, jsonString(name))
+	default:
+		return nil, ErrUnknownFormat
+	}
+	return out, nil
+}
+
+// ========================================
+// GPX 1.1
+// ========================================
+
+type gpxWriter struct {
+	w *bufio.Writer
+}
+
+func (g *gpxWriter) WriteWaypoint(p Point) error {
+	fmt.Fprintf(g.w, `<wpt lat="%s" lon="%s">`, coord(p.Latitude), coord(p.Longitude))
+	g.writePointBody(p)
+	fmt.Fprintf(g.w, "<name>%s</name>", escape(waypointName(p)))
+	if p.Address != "" {
+		fmt.Fprintf(g.w, "<desc>%s</desc>", escape(p.Address))
+	}
+	_, err := fmt.Fprintf(g.w, "<type>%s</type></wpt>\n", escape(p.PointType))
+	return err
+}
+
+func (g *gpxWriter) BeginTrack(t Track) error {
+	_, err := fmt.Fprintf(g.w, "<trk><name>%s</name><number>%d</number><trkseg>\n", escape(t.name()), t.ShiftID)
+	return err
+}
+
+func (g *gpxWriter) WriteTrackPoint(p Point) error {
+	fmt.Fprintf(g.w, `<trkpt lat="%s" lon="%s">`, coord(p.Latitude), coord(p.Longitude))
+	g.writePointBody(p)
+	_, err := g.w.WriteString("</trkpt>\n")
+	return err
+}
+
+func (g *gpxWriter) writePointBody(p Point) {
+	if p.Altitude != nil {
+		fmt.Fprintf(g.w, "<ele>%s</ele>", strconv.FormatFloat(*p.Altitude, 'f', 2, 64))
+	}
+	fmt.Fprintf(g.w, "<time>%s</time>", formatTime(p.Timestamp))
+}
+
+func (g *gpxWriter) EndTrack() error {
+	g.w.WriteString("</trkseg></trk>\n")
+	return g.w.Flush()
+}
+
+func (g *gpxWriter) Close() error {
+	g.w.WriteString("</gpx>\n")
+	return g.w.Flush()
+}
+
+// ========================================
+// KML 2.2
+// ========================================
+
+type kmlWriter struct {
+	w *bufio.Writer
+}
+
+func (k *kmlWriter) WriteWaypoint(p Point) error {
+	fmt.Fprintf(k.w, "<Placemark><name>%s</name>", escape(waypointName(p)))
+	if p.Address != "" {
+		fmt.Fprintf(k.w, "<description>%s</description>", escape(p.Address))
+	}
+	_, err := fmt.Fprintf(k.w, "<TimeStamp><when>%s</when></TimeStamp><Point><coordinates>%s</coordinates></Point></Placemark>\n",
+		formatTime(p.Timestamp), kmlCoord(p))
+	return err
+}
+
+func (k *kmlWriter) BeginTrack(t Track) error {
+	fmt.Fprintf(k.w, "<Placemark><name>%s</name><TimeSpan><begin>%s</begin>", escape(t.name()), formatTime(t.Start))
+	if t.End != nil {
+		fmt.Fprintf(k.w, "<end>%s</end>", formatTime(*t.End))
+	}
+	_, err := k.w.WriteString("</TimeSpan><LineString><tessellate>1</tessellate><coordinates>\n")
+	return err
+}
+
+func (k *kmlWriter) WriteTrackPoint(p Point) error {
+	_, err := fmt.Fprintf(k.w, "%s\n", kmlCoord(p))
+	return err
+}
+
+func (k *kmlWriter) EndTrack() error {
+	k.w.WriteString("</coordinates></LineString></Placemark>\n")
+	return k.w.Flush()
+}
+
+func (k *kmlWriter) Close() error {
+	k.w.WriteString("</Document></kml>\n")
+	return k.w.Flush()
+}
+
+func kmlCoord(p Point) string {
+	s := coord(p.Longitude) + "," + coord(p.Latitude)
+	if p.Altitude != nil {
+		s += "," + strconv.FormatFloat(*p.Altitude, 'f', 2, 64)
+	}
+	return s
+}
+
+// ========================================
+// GeoJSON (RFC 7946)
+// ========================================
+
+type geojsonWriter struct {
+	w          *bufio.Writer
+	features   int
+	trackCoord int
+}
+
+func (g *geojsonWriter) separator() {
+	if g.features > 0 {
+		g.w.WriteString(",")
+	}
+	g.features++
+}
+
+func (g *geojsonWriter) WriteWaypoint(p Point) error {
+	g.separator()
+	props := map[string]interface{}{
+		"name":       waypointName(p),
+		"point_type": p.PointType,
+		"time":       formatTime(p.Timestamp),
+	}
+	if p.OrderID != nil {
+		props["order_id"] = *p.OrderID
+	}
+	if p.Address != "" {
+		props["address"] = p.Address
+	}
+	buf, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(g.w, "\n"+`{"type":"Feature","properties":%s,"geometry":{"type":"Point","coordinates":%s}}`,
+		buf, geojsonCoord(p))
+	return err
+}
+
+// BeginTrack escribe las propiedades antes de la geometría para poder
+// emitir las coordenadas a medida que se leen
+func (g *geojsonWriter) BeginTrack(t Track) error {
+	g.separator()
+	g.trackCoord = 0
+	props := map[string]interface{}{
+		"name":      t.name(),
+		"shift_id":  t.ShiftID,
+		"driver_id": t.DriverID,
+		"branch":    t.Branch,
+		"start":     formatTime(t.Start),
+	}
+	if t.End != nil {
+		props["end"] = formatTime(*t.End)
+	}
+	buf, err := json.Marshal(props)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(g.w, "\n"+`{"type":"Feature","properties":%s,"geometry":{"type":"LineString","coordinates":[`, buf)
+	return err
+}
+
+func (g *geojsonWriter) WriteTrackPoint(p Point) error {
+	if g.trackCoord > 0 {
+		g.w.WriteString(",")
+	}
+	g.trackCoord++
+	_, err := g.w.WriteString(geojsonCoord(p))
+	return err
+}
+
+func (g *geojsonWriter) EndTrack() error {
+	g.w.WriteString("]}}")
+	return g.w.Flush()
+}
+
+func (g *geojsonWriter) Close() error {
+	g.w.WriteString("\n]}\n")
+	return g.w.Flush()
+}
+
+func geojsonCoord(p Point) string {
+	s := "[" + coord(p.Longitude) + "," + coord(p.Latitude)
+	if p.Altitude != nil {
+		s += "," + strconv.FormatFloat(*p.Altitude, 'f', 2, 64)
+	}
+	return s + "]"
+}
+
+// ========================================
+// Utilidades
+// ========================================
+
+func waypointName(p Point) string {
+	if p.OrderID != nil {
+		return fmt.Sprintf("Entrega pedido #%d", *p.OrderID)
+	}
+	return p.PointType
+}
+
+func coord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 7, 64)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func jsonString(s string) string {
+	buf, _ := json.Marshal(s)
+	return string(buf)
+}
@@ -0,0 +1,216 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+const trickyAddress = `Av. <Norte> & "5"`
+
+// writeSample escribe un documento con dos paradas y un recorrido de dos puntos
+func writeSample(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, "Turno <1> & co")
+	if err != nil {
+		t.Fatalf("NewWriter(%s): %v", format, err)
+	}
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	orderID := 42
+	alt := 25.5
+
+	steps := []error{
+		w.WriteWaypoint(Point{Latitude: -34.6, Longitude: -58.38, Timestamp: start, PointType: "START", Address: trickyAddress}),
+		w.WriteWaypoint(Point{Latitude: -34.61, Longitude: -58.39, Timestamp: end, PointType: "DELIVERY", OrderID: &orderID}),
+		w.BeginTrack(Track{ShiftID: 7, DriverID: 3, Branch: "norte", Start: start, End: &end}),
+		w.WriteTrackPoint(Point{Latitude: -34.6, Longitude: -58.38, Timestamp: start, Altitude: &alt}),
+		w.WriteTrackPoint(Point{Latitude: -34.61, Longitude: -58.39, Timestamp: end}),
+		w.EndTrack(),
+		w.Close(),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("%s paso %d: %v", format, i, err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestGPXStructure(t *testing.T) {
+	var doc struct {
+		XMLName  xml.Name `xml:"gpx"`
+		Metadata struct {
+			Name string `xml:"name"`
+		} `xml:"metadata"`
+		Waypoints []struct {
+			Lat  string `xml:"lat,attr"`
+			Lon  string `xml:"lon,attr"`
+			Name string `xml:"name"`
+			Desc string `xml:"desc"`
+			Type string `xml:"type"`
+		} `xml:"wpt"`
+		Tracks []struct {
+			Number int `xml:"number"`
+			Points []struct {
+				Lat string   `xml:"lat,attr"`
+				Ele *float64 `xml:"ele"`
+			} `xml:"trkseg>trkpt"`
+		} `xml:"trk"`
+	}
+	if err := xml.Unmarshal(writeSample(t, FormatGPX), &doc); err != nil {
+		t.Fatalf("GPX inválido: %v", err)
+	}
+
+	if doc.Metadata.Name != "Turno <1> & co" {
+		t.Errorf("metadata name = %q", doc.Metadata.Name)
+	}
+	if len(doc.Waypoints) != 2 {
+		t.Fatalf("se esperaban 2 waypoints, hay %d", len(doc.Waypoints))
+	}
+	if w := doc.Waypoints[0]; w.Lat != "-34.6000000" || w.Lon != "-58.3800000" || w.Desc != trickyAddress || w.Type != "START" {
+		t.Errorf("waypoint inicial = %+v", w)
+	}
+	if got := doc.Waypoints[1].Name; got != "Entrega pedido #42" {
+		t.Errorf("nombre de la entrega = %q", got)
+	}
+	if len(doc.Tracks) != 1 || doc.Tracks[0].Number != 7 || len(doc.Tracks[0].Points) != 2 {
+		t.Fatalf("track inesperado: %+v", doc.Tracks)
+	}
+	if ele := doc.Tracks[0].Points[0].Ele; ele == nil || *ele != 25.5 {
+		t.Errorf("se esperaba ele 25.5 en el primer punto")
+	}
+	if doc.Tracks[0].Points[1].Ele != nil {
+		t.Errorf("el segundo punto no tiene altitud")
+	}
+}
+
+func TestKMLStructure(t *testing.T) {
+	var doc struct {
+		XMLName  xml.Name `xml:"kml"`
+		Document struct {
+			Name       string `xml:"name"`
+			Placemarks []struct {
+				Name        string `xml:"name"`
+				Description string `xml:"description"`
+				Point       string `xml:"Point>coordinates"`
+				LineString  string `xml:"LineString>coordinates"`
+				End         string `xml:"TimeSpan>end"`
+			} `xml:"Placemark"`
+		} `xml:"Document"`
+	}
+	if err := xml.Unmarshal(writeSample(t, FormatKML), &doc); err != nil {
+		t.Fatalf("KML inválido: %v", err)
+	}
+
+	if doc.Document.Name != "Turno <1> & co" {
+		t.Errorf("nombre del documento = %q", doc.Document.Name)
+	}
+	pm := doc.Document.Placemarks
+	if len(pm) != 3 {
+		t.Fatalf("se esperaban 3 placemarks, hay %d", len(pm))
+	}
+	if pm[0].Description != trickyAddress {
+		t.Errorf("descripción = %q", pm[0].Description)
+	}
+	// KML usa longitud,latitud
+	if pm[0].Point != "-58.3800000,-34.6000000" {
+		t.Errorf("coordenadas del waypoint = %q", pm[0].Point)
+	}
+	lines := strings.Fields(pm[2].LineString)
+	if len(lines) != 2 || lines[0] != "-58.3800000,-34.6000000,25.50" || lines[1] != "-58.3900000,-34.6100000" {
+		t.Errorf("coordenadas del recorrido = %q", lines)
+	}
+	if pm[2].End != "2024-03-01T13:00:00Z" {
+		t.Errorf("fin del recorrido = %q", pm[2].End)
+	}
+}
+
+func TestGeoJSONStructure(t *testing.T) {
+	var doc struct {
+		Type     string `json:"type"`
+		Name     string `json:"name"`
+		Features []struct {
+			Type       string                 `json:"type"`
+			Properties map[string]interface{} `json:"properties"`
+			Geometry   struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(writeSample(t, FormatGeoJSON), &doc); err != nil {
+		t.Fatalf("GeoJSON inválido: %v", err)
+	}
+
+	if doc.Type != "FeatureCollection" || doc.Name != "Turno <1> & co" {
+		t.Errorf("colección = %q %q", doc.Type, doc.Name)
+	}
+	if len(doc.Features) != 3 {
+		t.Fatalf("se esperaban 3 features, hay %d", len(doc.Features))
+	}
+	if got := doc.Features[0].Properties["address"]; got != trickyAddress {
+		t.Errorf("address = %v", got)
+	}
+	if got := doc.Features[1].Properties["order_id"]; got != float64(42) {
+		t.Errorf("order_id = %v", got)
+	}
+
+	track := doc.Features[2]
+	if track.Geometry.Type != "LineString" || track.Properties["shift_id"] != float64(7) || track.Properties["branch"] != "norte" {
+		t.Errorf("track inesperado: %+v", track)
+	}
+	var coords [][]float64
+	if err := json.Unmarshal(track.Geometry.Coordinates, &coords); err != nil {
+		t.Fatalf("coordenadas inválidas: %v", err)
+	}
+	if len(coords) != 2 || len(coords[0]) != 3 || coords[0][0] != -58.38 || coords[0][1] != -34.6 || len(coords[1]) != 2 {
+		t.Errorf("coordenadas = %v", coords)
+	}
+}
+
+func TestEmptyDocuments(t *testing.T) {
+	for _, format := range []string{FormatGPX, FormatKML, FormatGeoJSON} {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf, "vacío")
+		if err != nil {
+			t.Fatalf("NewWriter(%s): %v", format, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s Close: %v", format, err)
+		}
+		if format == FormatGeoJSON {
+			var v map[string]interface{}
+			err = json.Unmarshal(buf.Bytes(), &v)
+		} else {
+			var v struct{ XMLName xml.Name }
+			err = xml.Unmarshal(buf.Bytes(), &v)
+		}
+		if err != nil {
+			t.Errorf("%s vacío inválido: %v", format, err)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewWriter("csv", &bytes.Buffer{}, "x"); err != ErrUnknownFormat {
+		t.Errorf("se esperaba ErrUnknownFormat, se obtuvo %v", err)
+	}
+	if IsSupported("csv") || ContentType("csv") != "application/octet-stream" {
+		t.Errorf("csv no debería estar soportado")
+	}
+	for format, want := range map[string]string{
+		FormatGPX:     "application/gpx+xml",
+		FormatKML:     "application/vnd.google-earth.kml+xml",
+		FormatGeoJSON: "application/geo+json",
+	} {
+		if !IsSupported(format) || ContentType(format) != want {
+			t.Errorf("%s: soporte o content type incorrecto", format)
+		}
+	}
+}
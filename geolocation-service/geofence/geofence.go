@@ -0,0 +1,202 @@
+package geofence
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/logitrack/geolocation-service/geo"
+)
+
+// Tipos de zona
+const (
+	ZoneBranch   = "branch"   // Cobertura de una sucursal (radius_km)
+	ZoneDelivery = "delivery" // Destino de un pedido activo
+)
+
+// Tipos de evento
+const (
+	EventEntered = "entered"
+	EventExited  = "exited"
+	EventDwell   = "dwell"
+)
+
+// Zone es una geocerca circular
+type Zone struct {
+	Type         string  `json:"zone_type"`
+	ID           int     `json:"zone_id"` // branch_id u order_id según el tipo
+	Latitude     float64 `json:"zone_latitude"`
+	Longitude    float64 `json:"zone_longitude"`
+	RadiusMeters float64 `json:"zone_radius_m"`
+}
+
+// Key identifica la zona de forma única dentro de un turno
+func (z Zone) Key() string {
+	return z.Type + ":" + strconv.Itoa(z.ID)
+}
+
+// Point es una posición GPS evaluada contra las geocercas
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Timestamp time.Time
+}
+
+// Event es una transición detectada por el motor
+type Event struct {
+	Type         string
+	Zone         Zone
+	Point        Point
+	DwellSeconds int // Tiempo dentro de la zona (dwell y exited)
+}
+
+type presence struct {
+	zone         Zone
+	enteredAt    time.Time
+	dwellEmitted bool
+}
+
+// State guarda en qué zonas está un sujeto (un turno)
+type State struct {
+	inside map[string]*presence
+}
+
+// NewState crea un estado vacío
+func NewState() *State {
+	return &State{inside: make(map[string]*presence)}
+}
+
+// Restore marca al sujeto dentro de la zona desde enteredAt.
+// Se usa para reconstruir el estado a partir de eventos persistidos.
+func (s *State) Restore(z Zone, enteredAt time.Time, dwellEmitted bool) {
+	s.inside[z.Key()] = &presence{zone: z, enteredAt: enteredAt, dwellEmitted: dwellEmitted}
+}
+
+// Engine evalúa puntos contra zonas y genera eventos
+type Engine struct {
+	// DwellAfter es el tiempo dentro de una zona tras el cual se emite "dwell"
+	DwellAfter time.Duration
+	// ExitHysteresis multiplica el radio para decidir la salida y evitar
+	// eventos intermitentes por el ruido del GPS en el borde de la zona
+	ExitHysteresis float64
+}
+
+// Evaluate compara el punto con las zonas activas y con las zonas en las que
+// el sujeto ya se encuentra (aunque ya no estén activas, p. ej. un pedido
+// entregado), actualiza el estado y devuelve los eventos generados.
+func (e Engine) Evaluate(s *State, zones []Zone, p Point) []Event {
+	hysteresis := e.ExitHysteresis
+	if hysteresis < 1 {
+		hysteresis = 1
+	}
+
+	candidates := make(map[string]Zone, len(zones)+len(s.inside))
+	for _, z := range zones {
+		candidates[z.Key()] = z
+	}
+	for key, pr := range s.inside {
+		if _, ok := candidates[key]; !ok {
+			candidates[key] = pr.zone
+		}
+	}
+
+	var events []Event
+	for key, z := range candidates {
+		distance := geo.HaversineMeters(p.Latitude, p.Longitude, z.Latitude, z.Longitude)
+		pr, wasInside := s.inside[key]
+
+		switch {
+		case !wasInside && distance <= z.RadiusMeters:
+			s.inside[key] = &presence{zone: z, enteredAt: p.Timestamp}
+			events = append(events, Event{Type: EventEntered, Zone: z, Point: p})
+
+		case wasInside && distance > z.RadiusMeters*hysteresis:
+			delete(s.inside, key)
+			events = append(events, Event{
+				Type:         EventExited,
+				Zone:         pr.zone,
+				Point:        p,
+				DwellSeconds: secondsBetween(pr.enteredAt, p.Timestamp),
+			})
+
+		case wasInside && !pr.dwellEmitted && p.Timestamp.Sub(pr.enteredAt) >= e.DwellAfter:
+			pr.dwellEmitted = true
+			events = append(events, Event{
+				Type:         EventDwell,
+				Zone:         pr.zone,
+				Point:        p,
+				DwellSeconds: secondsBetween(pr.enteredAt, p.Timestamp),
+			})
+		}
+	}
+
+	return events
+}
+
+// ExitAll cierra todas las presencias abiertas (p. ej. al finalizar el turno)
+func (e Engine) ExitAll(s *State, p Point) []Event {
+	var events []Event
+	for key, pr := range s.inside {
+		delete(s.inside, key)
+		events = append(events, Event{
+			Type:         EventExited,
+			Zone:         pr.zone,
+			Point:        p,
+			DwellSeconds: secondsBetween(pr.enteredAt, p.Timestamp),
+		})
+	}
+	return events
+}
+
+func secondsBetween(from, to time.Time) int {
+	d := to.Sub(from)
+	if d < 0 {
+		return 0
+	}
+	return int(d.Seconds())
+}
+
+// Tracker mantiene el estado de geocercas por turno en memoria
+type Tracker struct {
+	mu     sync.Mutex
+	engine Engine
+	states map[int]*State
+}
+
+// NewTracker crea un tracker con el motor indicado
+func NewTracker(engine Engine) *Tracker {
+	return &Tracker{engine: engine, states: make(map[int]*State)}
+}
+
+// Process evalúa un punto para un turno. Si el turno no tiene estado en
+// memoria (p. ej. tras un reinicio) se reconstruye con load.
+func (t *Tracker) Process(shiftID int, zones []Zone, p Point, load func() *State) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[shiftID]
+	if !ok {
+		state = load()
+		if state == nil {
+			state = NewState()
+		}
+		t.states[shiftID] = state
+	}
+	return t.engine.Evaluate(state, zones, p)
+}
+
+// Close cierra las zonas abiertas del turno y libera su estado
+func (t *Tracker) Close(shiftID int, p Point, load func() *State) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[shiftID]
+	if !ok {
+		state = load()
+	}
+	delete(t.states, shiftID)
+	if state == nil {
+		return nil
+	}
+	return t.engine.ExitAll(state, p)
+}
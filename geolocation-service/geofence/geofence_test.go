@@ -0,0 +1,125 @@
+package geofence
+
+import (
+	"testing"
+	"time"
+)
+
+// 0.001° de latitud ≈ 111 m
+const (
+	zoneLat = 14.60
+	zoneLng = -90.50
+)
+
+var start = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+var delivery = Zone{Type: ZoneDelivery, ID: 7, Latitude: zoneLat, Longitude: zoneLng, RadiusMeters: 100}
+
+func at(dLat float64, minutes int) Point {
+	return Point{Latitude: zoneLat + dLat, Longitude: zoneLng, Timestamp: start.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func TestEvaluateSequence(t *testing.T) {
+	engine := Engine{DwellAfter: 5 * time.Minute, ExitHysteresis: 1.5}
+	state := NewState()
+	zones := []Zone{delivery}
+
+	steps := []struct {
+		name  string
+		point Point
+		want  string // tipo de evento esperado, "" = ninguno
+		dwell int
+	}{
+		{"lejos", at(0.01, 0), "", 0},
+		{"entra", at(0.0005, 1), EventEntered, 0},
+		{"sigue dentro antes del dwell", at(0, 4), "", 0},
+		{"dwell", at(0, 6), EventDwell, 300},
+		{"dwell se emite una vez", at(0, 8), "", 0},
+		{"fuera del radio pero dentro de la histéresis", at(0.0012, 9), "", 0},
+		{"sale", at(0.002, 10), EventExited, 540},
+		{"vuelve a entrar", at(0, 11), EventEntered, 0},
+	}
+	for _, st := range steps {
+		events := engine.Evaluate(state, zones, st.point)
+		if st.want == "" {
+			if len(events) != 0 {
+				t.Errorf("%s: eventos = %+v, no se esperaba ninguno", st.name, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].Type != st.want {
+			t.Errorf("%s: eventos = %+v, se esperaba %s", st.name, events, st.want)
+			continue
+		}
+		if events[0].DwellSeconds != st.dwell {
+			t.Errorf("%s: DwellSeconds = %d, se esperaba %d", st.name, events[0].DwellSeconds, st.dwell)
+		}
+		if events[0].Zone.Key() != "delivery:7" {
+			t.Errorf("%s: zona = %s", st.name, events[0].Zone.Key())
+		}
+	}
+}
+
+func TestEvaluateExitsInactiveZone(t *testing.T) {
+	// El pedido se entregó: la zona ya no está activa pero la salida se detecta igual
+	engine := Engine{DwellAfter: time.Hour}
+	state := NewState()
+	state.Restore(delivery, start, false)
+
+	if events := engine.Evaluate(state, nil, at(0.0005, 1)); len(events) != 0 {
+		t.Fatalf("eventos = %+v, no se esperaba ninguno dentro de la zona", events)
+	}
+	events := engine.Evaluate(state, nil, at(0.002, 3))
+	if len(events) != 1 || events[0].Type != EventExited || events[0].DwellSeconds != 180 {
+		t.Fatalf("eventos = %+v, se esperaba exited tras 180 s", events)
+	}
+}
+
+func TestExitAll(t *testing.T) {
+	engine := Engine{DwellAfter: time.Hour}
+	state := NewState()
+	branch := Zone{Type: ZoneBranch, ID: 1, Latitude: zoneLat, Longitude: zoneLng, RadiusMeters: 5000}
+	engine.Evaluate(state, []Zone{delivery, branch}, at(0, 0))
+
+	events := engine.ExitAll(state, at(0, 2))
+	if len(events) != 2 {
+		t.Fatalf("eventos = %+v, se esperaban 2 salidas", events)
+	}
+	for _, ev := range events {
+		if ev.Type != EventExited || ev.DwellSeconds != 120 {
+			t.Errorf("evento = %+v", ev)
+		}
+	}
+	if events := engine.ExitAll(state, at(0, 3)); len(events) != 0 {
+		t.Errorf("segunda llamada: eventos = %+v", events)
+	}
+}
+
+func TestTrackerRestoresState(t *testing.T) {
+	tracker := NewTracker(Engine{DwellAfter: 5 * time.Minute})
+	loads := 0
+	load := func() *State {
+		loads++
+		s := NewState()
+		s.Restore(delivery, start, false)
+		return s
+	}
+
+	// Tras un reinicio el turno ya estaba dentro: no se repite "entered"
+	events := tracker.Process(3, []Zone{delivery}, at(0, 6), load)
+	if len(events) != 1 || events[0].Type != EventDwell {
+		t.Fatalf("eventos = %+v, se esperaba dwell", events)
+	}
+	tracker.Process(3, []Zone{delivery}, at(0, 7), load)
+	if loads != 1 {
+		t.Errorf("load llamado %d veces, se esperaba 1", loads)
+	}
+
+	events = tracker.Close(3, at(0, 8), load)
+	if len(events) != 1 || events[0].Type != EventExited {
+		t.Fatalf("Close: eventos = %+v", events)
+	}
+	if events := tracker.Close(4, at(0, 8), func() *State { return nil }); events != nil {
+		t.Errorf("Close sin estado: eventos = %+v", events)
+	}
+}
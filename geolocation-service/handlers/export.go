@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/geolocation-service/export"
+)
+
+// maxExportRange limita el rango de fechas de la exportación multi-turno
+const maxExportRange = 31 * 24 * time.Hour
+
+// streamShiftExport escribe los recorridos de los turnos en el formato pedido.
+// Las entregas (puntos DELIVERY) se emiten primero como waypoints y luego cada
+// turno como un track, leyendo los puntos fila a fila desde la BD.
+func streamShiftExport(c *gin.Context, format string, shifts []Shift, filename string) {
+	shiftIDs := make([]int64, len(shifts))
+	for i, s := range shifts {
+		shiftIDs[i] = int64(s.ID)
+	}
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+	c.Status(http.StatusOK)
+
+	w, err := export.NewWriter(format, c.Writer, filename)
+	if err != nil {
+		return
+	}
+
+	// 1. Paradas de entrega como waypoints
+	rows, err := db.Query(`
+		SELECT latitude, longitude, altitude, timestamp, point_type, order_id, COALESCE(address, '')
+		FROM route_points
+		WHERE shift_id = ANY($1) AND point_type = 'DELIVERY'
+		ORDER BY timestamp ASC, id ASC
+	`, pq.Array(shiftIDs))
+	if err != nil {
+		log.Printf("Export: error obteniendo entregas: %v", err)
+		return
+	}
+	for rows.Next() {
+		p, err := scanExportPoint(rows)
+		if err != nil {
+			continue
+		}
+		if err := w.WriteWaypoint(p); err != nil {
+			rows.Close()
+			return
+		}
+	}
+	rows.Close()
+
+	// 2. Un track por turno
+	for _, s := range shifts {
+		if err := streamShiftTrack(w, s); err != nil {
+			log.Printf("Export: error exportando turno %d: %v", s.ID, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+
+	w.Close()
+}
+
+func streamShiftTrack(w export.Writer, s Shift) error {
+	rows, err := db.Query(`
+		SELECT latitude, longitude, altitude, timestamp, point_type, order_id, COALESCE(address, '')
+		FROM route_points
+		WHERE shift_id = $1
+		ORDER BY timestamp ASC, id ASC
+	`, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if err := w.BeginTrack(export.Track{
+		ShiftID:  s.ID,
+		DriverID: s.DriverID,
+		Branch:   s.Branch,
+		Start:    s.StartTime,
+		End:      s.EndTime,
+	}); err != nil {
+		return err
+	}
+	for rows.Next() {
+		p, err := scanExportPoint(rows)
+		if err != nil {
+			continue
+		}
+		if err := w.WriteTrackPoint(p); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.EndTrack()
+}
+
+func scanExportPoint(rows *sql.Rows) (export.Point, error) {
+	var p export.Point
+	err := rows.Scan(&p.Latitude, &p.Longitude, &p.Altitude, &p.Timestamp, &p.PointType, &p.OrderID, &p.Address)
+	return p, err
+}
+
+// parseExportDate acepta YYYY-MM-DD o RFC3339
+func parseExportDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// exportDateRange valida from/to para la exportación de turnos de un driver.
+// Una fecha sin hora en "to" incluye el día completo.
+func exportDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	fromRaw, toRaw := c.Query("from"), c.Query("to")
+	if fromRaw == "" || toRaw == "" {
+		return time.Time{}, time.Time{}, errors.New("los parámetros from y to son requeridos para exportar")
+	}
+	from, _, err := parseExportDate(fromRaw)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("fecha from inválida")
+	}
+	to, dateOnly, err := parseExportDate(toRaw)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("fecha to inválida")
+	}
+	if dateOnly {
+		to = to.Add(24 * time.Hour)
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, errors.New("el rango de fechas es inválido")
+	}
+	if to.Sub(from) > maxExportRange {
+		return time.Time{}, time.Time{}, errors.New("el rango máximo de exportación es de 31 días")
+	}
+	return from, to, nil
+}
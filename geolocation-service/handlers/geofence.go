@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/geolocation-service/geofence"
+	"github.com/logitrack/geolocation-service/live"
+)
+
+// GeofenceEvent evento de geocerca persistido para auditoría y KPIs
+type GeofenceEvent struct {
+	ID           int       `json:"id"`
+	ShiftID      int       `json:"shift_id"`
+	DriverID     int       `json:"driver_id"`
+	ZoneType     string    `json:"zone_type"`
+	ZoneID       int       `json:"zone_id"`
+	EventType    string    `json:"event_type"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	DwellSeconds *int      `json:"dwell_seconds,omitempty"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+var (
+	geofenceTracker        *geofence.Tracker
+	deliveryGeofenceRadius = 75.0 // metros alrededor del destino del pedido
+)
+
+// InitGeofence configura el motor de geocercas a partir de variables de entorno
+func InitGeofence() {
+	dwellSeconds := 120
+	if v, err := strconv.Atoi(os.Getenv("GEOFENCE_DWELL_SECONDS")); err == nil && v > 0 {
+		dwellSeconds = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("GEOFENCE_DELIVERY_RADIUS_M"), 64); err == nil && v > 0 {
+		deliveryGeofenceRadius = v
+	}
+
+	geofenceTracker = geofence.NewTracker(geofence.Engine{
+		DwellAfter:     time.Duration(dwellSeconds) * time.Second,
+		ExitHysteresis: 1.2,
+	})
+}
+
+// loadGeofenceZones obtiene las zonas de sucursales activas y los destinos de
+// los pedidos asignados a la moto del driver
+func loadGeofenceZones(driverID int) ([]geofence.Zone, error) {
+	var zones []geofence.Zone
+
+	rows, err := db.Query(`SELECT id, latitude, longitude, radius_km FROM branches WHERE is_active = true`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var z geofence.Zone
+		var radiusKm float64
+		if err := rows.Scan(&z.ID, &z.Latitude, &z.Longitude, &radiusKm); err != nil {
+			continue
+		}
+		z.Type = geofence.ZoneBranch
+		z.RadiusMeters = radiusKm * 1000
+		zones = append(zones, z)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT o.id, o.latitude, o.longitude
+		FROM orders o
+		JOIN motos m ON m.id = o.assigned_moto_id
+		WHERE m.driver_id = $1
+		  AND o.status IN ('assigned', 'in_route')
+		  AND o.latitude IS NOT NULL AND o.longitude IS NOT NULL
+	`, driverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var z geofence.Zone
+		if err := rows.Scan(&z.ID, &z.Latitude, &z.Longitude); err != nil {
+			continue
+		}
+		z.Type = geofence.ZoneDelivery
+		z.RadiusMeters = deliveryGeofenceRadius
+		zones = append(zones, z)
+	}
+
+	return zones, nil
+}
+
+// loadGeofenceState reconstruye desde la BD las zonas en las que el turno
+// sigue dentro (último evento por zona distinto de "exited")
+func loadGeofenceState(shiftID int) *geofence.State {
+	state := geofence.NewState()
+
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (zone_type, zone_id)
+		       zone_type, zone_id, event_type, zone_latitude, zone_longitude,
+		       zone_radius_m, COALESCE(dwell_seconds, 0), occurred_at
+		FROM geofence_events
+		WHERE shift_id = $1
+		ORDER BY zone_type, zone_id, occurred_at DESC, id DESC
+	`, shiftID)
+	if err != nil {
+		log.Printf("Geofence: error reconstruyendo estado del turno %d: %v", shiftID, err)
+		return state
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var z geofence.Zone
+		var eventType string
+		var dwellSeconds int
+		var occurredAt time.Time
+		if err := rows.Scan(&z.Type, &z.ID, &eventType, &z.Latitude, &z.Longitude,
+			&z.RadiusMeters, &dwellSeconds, &occurredAt); err != nil {
+			continue
+		}
+		switch eventType {
+		case geofence.EventEntered:
+			state.Restore(z, occurredAt, false)
+		case geofence.EventDwell:
+			state.Restore(z, occurredAt.Add(-time.Duration(dwellSeconds)*time.Second), true)
+		}
+	}
+
+	return state
+}
+
+// processGeofence evalúa un punto GPS del turno, persiste los eventos y
+// registra automáticamente el punto DELIVERY al llegar al destino de un pedido
+func processGeofence(sc shiftContext, p geofence.Point) []geofence.Event {
+	if geofenceTracker == nil {
+		return nil
+	}
+
+	zones, err := loadGeofenceZones(sc.DriverID)
+	if err != nil {
+		log.Printf("Geofence: error cargando zonas del driver %d: %v", sc.DriverID, err)
+		return nil
+	}
+
+	events := geofenceTracker.Process(sc.ShiftID, zones, p, func() *geofence.State {
+		return loadGeofenceState(sc.ShiftID)
+	})
+	persistGeofenceEvents(sc, events)
+	return events
+}
+
+// closeGeofence cierra las zonas abiertas al finalizar el turno
+func closeGeofence(sc shiftContext, p geofence.Point) {
+	if geofenceTracker == nil {
+		return
+	}
+	events := geofenceTracker.Close(sc.ShiftID, p, func() *geofence.State {
+		return loadGeofenceState(sc.ShiftID)
+	})
+	persistGeofenceEvents(sc, events)
+}
+
+// persistGeofenceEvents guarda los eventos y los publica en el canal en vivo
+func persistGeofenceEvents(sc shiftContext, events []geofence.Event) {
+	shiftID, driverID := sc.ShiftID, sc.DriverID
+	for _, ev := range events {
+		var dwell *int
+		if ev.Type != geofence.EventEntered {
+			d := ev.DwellSeconds
+			dwell = &d
+		}
+
+		_, err := db.Exec(`
+			INSERT INTO geofence_events (
+				shift_id, driver_id, zone_type, zone_id, event_type, latitude, longitude,
+				zone_latitude, zone_longitude, zone_radius_m, dwell_seconds, occurred_at
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		`, shiftID, driverID, ev.Zone.Type, ev.Zone.ID, ev.Type, ev.Point.Latitude, ev.Point.Longitude,
+			ev.Zone.Latitude, ev.Zone.Longitude, ev.Zone.RadiusMeters, dwell, ev.Point.Timestamp)
+		if err != nil {
+			log.Printf("Geofence: error guardando evento %s %s: %v", ev.Type, ev.Zone.Key(), err)
+			continue
+		}
+
+		if ev.Type == geofence.EventEntered && ev.Zone.Type == geofence.ZoneDelivery {
+			recordDeliveryArrival(shiftID, ev)
+		}
+
+		publishShiftEvent(live.EventGeofence, sc, gin.H{
+			"event_type":    ev.Type,
+			"zone_type":     ev.Zone.Type,
+			"zone_id":       ev.Zone.ID,
+			"latitude":      ev.Point.Latitude,
+			"longitude":     ev.Point.Longitude,
+			"dwell_seconds": dwell,
+			"occurred_at":   ev.Point.Timestamp,
+		})
+	}
+}
+
+// recordDeliveryArrival inserta el punto DELIVERY del pedido si aún no existe en el turno
+func recordDeliveryArrival(shiftID int, ev geofence.Event) {
+	_, err := db.Exec(`
+		INSERT INTO route_points (shift_id, latitude, longitude, point_type, order_id, address, timestamp)
+		SELECT $1, $2, $3, 'DELIVERY', $4, 'Llegada detectada por geocerca', $5
+		WHERE NOT EXISTS (
+			SELECT 1 FROM route_points
+			WHERE shift_id = $1 AND point_type = 'DELIVERY' AND order_id = $4
+		)
+	`, shiftID, ev.Point.Latitude, ev.Point.Longitude, ev.Zone.ID, ev.Point.Timestamp)
+	if err != nil {
+		log.Printf("Geofence: error registrando llegada del pedido %d: %v", ev.Zone.ID, err)
+	}
+}
+
+// GetShiftGeofenceEvents obtiene los eventos de geocerca de un turno
+func GetShiftGeofenceEvents(c *gin.Context) {
+	shiftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return
+	}
+
+	events, err := queryGeofenceEvents(`WHERE shift_id = $1 ORDER BY occurred_at ASC, id ASC`, shiftID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener eventos de geocerca"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shift_id": shiftID,
+		"events":   events,
+		"total":    len(events),
+	})
+}
+
+// GetGeofenceEvents lista eventos de geocerca con filtros opcionales
+func GetGeofenceEvents(c *gin.Context) {
+	driverID := c.Query("driver_id")
+	zoneType := c.Query("zone_type")
+	zoneID := c.Query("zone_id")
+	eventType := c.Query("event_type")
+	from := c.Query("from")
+	to := c.Query("to")
+	limit := c.DefaultQuery("limit", "200")
+
+	where := "WHERE 1=1"
+	args := []interface{}{}
+	argIdx := 1
+
+	if driverID != "" {
+		where += " AND driver_id = $" + strconv.Itoa(argIdx)
+		args = append(args, driverID)
+		argIdx++
+	}
+	if zoneType != "" {
+		where += " AND zone_type = $" + strconv.Itoa(argIdx)
+		args = append(args, zoneType)
+		argIdx++
+	}
+	if zoneID != "" {
+		where += " AND zone_id = $" + strconv.Itoa(argIdx)
+		args = append(args, zoneID)
+		argIdx++
+	}
+	if eventType != "" {
+		where += " AND event_type = $" + strconv.Itoa(argIdx)
+		args = append(args, eventType)
+		argIdx++
+	}
+	if from != "" {
+		where += " AND occurred_at >= $" + strconv.Itoa(argIdx)
+		args = append(args, from)
+		argIdx++
+	}
+	if to != "" {
+		where += " AND occurred_at < $" + strconv.Itoa(argIdx)
+		args = append(args, to)
+		argIdx++
+	}
+
+	where += " ORDER BY occurred_at DESC, id DESC LIMIT $" + strconv.Itoa(argIdx)
+	args = append(args, limit)
+
+	events, err := queryGeofenceEvents(where, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener eventos de geocerca"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  len(events),
+	})
+}
+
+// GetCustomerDwellKPIs resume el tiempo en destino de clientes por driver
+// a partir de los eventos "exited" de zonas de entrega
+func GetCustomerDwellKPIs(c *gin.Context) {
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.Query("to")
+
+	query := `
+		SELECT e.driver_id, COALESCE(u.name, ''),
+		       COUNT(*) AS visits,
+		       AVG(e.dwell_seconds) AS avg_dwell_seconds,
+		       SUM(e.dwell_seconds) AS total_dwell_seconds,
+		       MAX(e.dwell_seconds) AS max_dwell_seconds
+		FROM geofence_events e
+		LEFT JOIN users u ON u.id = e.driver_id
+		WHERE e.zone_type = 'delivery' AND e.event_type = 'exited'
+		  AND e.occurred_at >= $1`
+	args := []interface{}{from}
+
+	if to != "" {
+		query += " AND e.occurred_at < $2"
+		args = append(args, to)
+	}
+	query += " GROUP BY e.driver_id, u.name ORDER BY avg_dwell_seconds DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular KPIs de geocerca"})
+		return
+	}
+	defer rows.Close()
+
+	type dwellKPI struct {
+		DriverID          int     `json:"driver_id"`
+		DriverName        string  `json:"driver_name"`
+		Visits            int     `json:"visits"`
+		AvgDwellSeconds   float64 `json:"avg_dwell_seconds"`
+		TotalDwellSeconds int     `json:"total_dwell_seconds"`
+		MaxDwellSeconds   int     `json:"max_dwell_seconds"`
+	}
+
+	var kpis []dwellKPI
+	for rows.Next() {
+		var k dwellKPI
+		var avg sql.NullFloat64
+		var total, max sql.NullInt64
+		if err := rows.Scan(&k.DriverID, &k.DriverName, &k.Visits, &avg, &total, &max); err != nil {
+			continue
+		}
+		k.AvgDwellSeconds = avg.Float64
+		k.TotalDwellSeconds = int(total.Int64)
+		k.MaxDwellSeconds = int(max.Int64)
+		kpis = append(kpis, k)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"drivers": kpis,
+	})
+}
+
+func queryGeofenceEvents(where string, args ...interface{}) ([]GeofenceEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, shift_id, driver_id, zone_type, zone_id, event_type,
+		       latitude, longitude, dwell_seconds, occurred_at
+		FROM geofence_events `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []GeofenceEvent
+	for rows.Next() {
+		var ev GeofenceEvent
+		if err := rows.Scan(&ev.ID, &ev.ShiftID, &ev.DriverID, &ev.ZoneType, &ev.ZoneID,
+			&ev.EventType, &ev.Latitude, &ev.Longitude, &ev.DwellSeconds, &ev.OccurredAt); err != nil {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/geolocation-service/integrity"
+)
+
+var integrityConfig = integrity.DefaultConfig
+
+// InitIntegrity configura las heurísticas de GPS falso a partir de variables de entorno
+func InitIntegrity() {
+	if v, err := strconv.Atoi(os.Getenv("GPS_SUSPICIOUS_SCORE")); err == nil && v > 0 {
+		integrityConfig.SuspiciousScore = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("GPS_MAX_SPEED_MS"), 64); err == nil && v > 0 {
+		integrityConfig.MaxSpeed = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("GPS_FROZEN_REPEATS")); err == nil && v > 0 {
+		integrityConfig.FrozenRepeats = v
+	}
+}
+
+// queryer permite leer el historial dentro o fuera de una transacción
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadShiftFixes obtiene las últimas lecturas del turno por orden de llegada
+func loadShiftFixes(q queryer, shiftID int) ([]integrity.Fix, error) {
+	rows, err := q.Query(`
+		SELECT latitude, longitude, accuracy, speed, timestamp
+		FROM route_points
+		WHERE shift_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, shiftID, integrityConfig.FrozenRepeats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fixes []integrity.Fix
+	for rows.Next() {
+		var f integrity.Fix
+		if err := rows.Scan(&f.Latitude, &f.Longitude, &f.Accuracy, &f.Speed, &f.Timestamp); err != nil {
+			continue
+		}
+		fixes = append(fixes, f)
+	}
+	return fixes, rows.Err()
+}
+
+// flagSuspiciousShift acumula el punto sospechoso en el turno y abre (o
+// actualiza) su revisión
+func flagSuspiciousShift(tx *sql.Tx, sc *shiftContext, res integrity.Result) error {
+	_, err := tx.Exec(`
+		UPDATE shifts
+		SET gps_suspicious_points = gps_suspicious_points + 1,
+		    gps_max_score = GREATEST(gps_max_score, $2)
+		WHERE id = $1
+	`, sc.ShiftID, res.Score)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO gps_reviews (entity_type, entity_id, user_id, score, flags)
+		VALUES ('shift', $1, $2, $3, $4)
+		ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+			score = GREATEST(gps_reviews.score, EXCLUDED.score),
+			flags = ARRAY(SELECT DISTINCT unnest(gps_reviews.flags || EXCLUDED.flags)),
+			suspicious_points = gps_reviews.suspicious_points + 1,
+			updated_at = CURRENT_TIMESTAMP
+	`, sc.ShiftID, sc.DriverID, res.Score, pq.Array(res.Flags))
+	return err
+}
+
+// ScoreFixRequest lectura a puntuar. El historial puede enviarse explícitamente
+// (p. ej. check-ins anteriores de un coordinador) o se toma del turno activo
+// del driver o de la moto indicados.
+type ScoreFixRequest struct {
+	Fix      integrity.Fix   `json:"fix"`
+	History  []integrity.Fix `json:"history"` // De la más reciente a la más antigua
+	DriverID *int            `json:"driver_id"`
+	MotoID   *int            `json:"moto_id"`
+}
+
+// ScoreFix puntúa una lectura GPS sin registrarla
+func ScoreFix(c *gin.Context) {
+	var req ScoreFixRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.Fix.Timestamp.IsZero() {
+		req.Fix.Timestamp = time.Now()
+	}
+
+	history := req.History
+	shiftID := 0
+	if len(history) == 0 {
+		driverID := req.DriverID
+		if driverID == nil && req.MotoID != nil {
+			var id sql.NullInt64
+			db.QueryRow("SELECT driver_id FROM motos WHERE id = $1", *req.MotoID).Scan(&id)
+			if id.Valid {
+				d := int(id.Int64)
+				driverID = &d
+			}
+		}
+		if driverID != nil {
+			db.QueryRow(`
+				SELECT id FROM shifts
+				WHERE driver_id = $1 AND status = 'ACTIVE'
+				ORDER BY start_time DESC LIMIT 1
+			`, *driverID).Scan(&shiftID)
+		}
+		if shiftID != 0 {
+			fixes, err := loadShiftFixes(db, shiftID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener historial GPS"})
+				return
+			}
+			history = fixes
+		}
+	}
+
+	res := integrity.Assess(req.Fix, history, time.Now(), integrityConfig)
+
+	resp := gin.H{
+		"score":         res.Score,
+		"suspicious":    res.Suspicious,
+		"flags":         res.Flags,
+		"history_count": len(history),
+		"threshold":     integrityConfig.SuspiciousScore,
+	}
+	if shiftID != 0 {
+		resp["shift_id"] = shiftID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetShiftIntegrity lista los puntos sospechosos de un turno
+func GetShiftIntegrity(c *gin.Context) {
+	shiftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return
+	}
+
+	var suspiciousPoints, maxScore int
+	err = db.QueryRow(`
+		SELECT gps_suspicious_points, gps_max_score FROM shifts WHERE id = $1
+	`, shiftID).Scan(&suspiciousPoints, &maxScore)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener turno"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, latitude, longitude, accuracy, speed, timestamp, point_type, order_id,
+		       integrity_score, integrity_flags
+		FROM route_points
+		WHERE shift_id = $1 AND integrity_score >= $2
+		ORDER BY timestamp ASC, id ASC
+	`, shiftID, integrityConfig.SuspiciousScore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener puntos"})
+		return
+	}
+	defer rows.Close()
+
+	type suspiciousPoint struct {
+		ID        int       `json:"id"`
+		Latitude  float64   `json:"latitude"`
+		Longitude float64   `json:"longitude"`
+		Accuracy  *float64  `json:"accuracy,omitempty"`
+		Speed     *float64  `json:"speed,omitempty"`
+		Timestamp time.Time `json:"timestamp"`
+		PointType string    `json:"point_type"`
+		OrderID   *int      `json:"order_id,omitempty"`
+		Score     int       `json:"score"`
+		Flags     []string  `json:"flags"`
+	}
+
+	points := []suspiciousPoint{}
+	for rows.Next() {
+		var p suspiciousPoint
+		var flags pq.StringArray
+		if err := rows.Scan(&p.ID, &p.Latitude, &p.Longitude, &p.Accuracy, &p.Speed,
+			&p.Timestamp, &p.PointType, &p.OrderID, &p.Score, &flags); err != nil {
+			continue
+		}
+		p.Flags = []string(flags)
+		points = append(points, p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shift_id":          shiftID,
+		"suspicious":        suspiciousPoints > 0,
+		"suspicious_points": suspiciousPoints,
+		"max_score":         maxScore,
+		"points":            points,
+	})
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/geolocation-service/live"
+	"golang.org/x/net/websocket"
+)
+
+// liveHub distribuye posiciones, turnos y eventos de geocerca en tiempo real
+var liveHub = live.NewHub()
+
+// liveHeartbeat mantiene viva la conexión a través de proxies intermedios
+const liveHeartbeat = 30 * time.Second
+
+// liveCommand es un mensaje enviado por el cliente WebSocket
+type liveCommand struct {
+	Action string `json:"action"` // subscribe | unsubscribe
+	MotoID int    `json:"moto_id"`
+	Branch string `json:"branch"`
+}
+
+// shiftContext identifica al turno, driver, moto y sucursal de un punto GPS
+type shiftContext struct {
+	ShiftID  int
+	DriverID int
+	MotoID   *int
+	Branch   string
+}
+
+// resolveLiveScope determina el alcance del cliente a partir de la identidad
+// que el api-gateway inyecta tras validar el JWT
+func resolveLiveScope(c *gin.Context) (live.Scope, bool) {
+	userID, err := strconv.Atoi(c.GetHeader("X-User-ID"))
+	role := c.GetHeader("X-User-Role")
+	if err != nil || userID == 0 || role == "" {
+		return live.Scope{}, false
+	}
+
+	var branch string
+	if liveRoleNeedsBranch(role) {
+		// Código de la sucursal asignada al usuario (users.branch_id)
+		var code sql.NullString
+		err := db.QueryRow(`
+			SELECT b.code FROM users u
+			JOIN branches b ON b.id = u.branch_id
+			WHERE u.id = $1`, userID).Scan(&code)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Live: error obteniendo la sucursal del usuario %d: %v", userID, err)
+		}
+		branch = code.String
+	}
+	return liveScopeFor(userID, role, branch)
+}
+
+// liveRoleNeedsBranch indica si el rol ve solo los eventos de su sucursal
+func liveRoleNeedsBranch(role string) bool {
+	return role == "supervisor" || role == "operator"
+}
+
+// liveScopeFor alcance según el rol; branch es el código de la sucursal del
+// usuario (requerido para supervisor y operator)
+func liveScopeFor(userID int, role, branch string) (live.Scope, bool) {
+	switch role {
+	case "admin", "manager", "coordinator", "analyst":
+		return live.Scope{AllBranches: true}, true
+	case "driver":
+		return live.Scope{DriverID: userID}, true
+	case "supervisor", "operator":
+		if branch == "" {
+			return live.Scope{}, false
+		}
+		return live.Scope{Branch: branch}, true
+	}
+	return live.Scope{}, false
+}
+
+// subscribeFromQuery aplica las suscripciones iniciales (?moto_id=1,2&branch=norte)
+func subscribeFromQuery(c *gin.Context, client *live.Client) error {
+	for _, raw := range strings.Split(c.Query("moto_id"), ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(raw)); err == nil && id > 0 {
+			client.Subscribe(id, "")
+		}
+	}
+	for _, branch := range strings.Split(c.Query("branch"), ",") {
+		if branch = strings.TrimSpace(branch); branch != "" {
+			if err := client.Subscribe(0, branch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LiveWebSocket transmite eventos de la flota por WebSocket. El cliente puede
+// enviar {"action":"subscribe","moto_id":3} o {"action":"subscribe","branch":"norte"}
+func LiveWebSocket(c *gin.Context) {
+	scope, ok := resolveLiveScope(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No autorizado para el canal en vivo"})
+		return
+	}
+
+	client := liveHub.Register(scope)
+	if err := subscribeFromQuery(c, client); err != nil {
+		liveHub.Unregister(client)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		defer liveHub.Unregister(client)
+
+		// Lector de comandos de suscripción
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				var cmd liveCommand
+				if err := websocket.JSON.Receive(ws, &cmd); err != nil {
+					if err != io.EOF {
+						log.Printf("Live: conexión WebSocket cerrada: %v", err)
+					}
+					return
+				}
+				switch cmd.Action {
+				case "subscribe":
+					if err := client.Subscribe(cmd.MotoID, cmd.Branch); err != nil {
+						websocket.JSON.Send(ws, gin.H{"type": "error", "error": err.Error()})
+					}
+				case "unsubscribe":
+					client.Unsubscribe(cmd.MotoID, cmd.Branch)
+				}
+			}
+		}()
+
+		heartbeat := time.NewTicker(liveHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case ev, ok := <-client.Events():
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(ws, ev); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if err := websocket.JSON.Send(ws, gin.H{"type": "ping", "timestamp": time.Now()}); err != nil {
+					return
+				}
+			}
+		}
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+
+	// Si el handshake falla el Handler nunca corre: liberar el cliente
+	liveHub.Unregister(client)
+}
+
+// LiveSSE transmite los mismos eventos por Server-Sent Events como alternativa
+// cuando WebSocket no está disponible. Las suscripciones se pasan por query.
+func LiveSSE(c *gin.Context) {
+	scope, ok := resolveLiveScope(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No autorizado para el canal en vivo"})
+		return
+	}
+
+	client := liveHub.Register(scope)
+	defer liveHub.Unregister(client)
+	if err := subscribeFromQuery(c, client); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case ev, ok := <-client.Events():
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Type, ev)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"timestamp": time.Now()})
+			return true
+		}
+	})
+}
+
+// GetLiveStats devuelve el número de clientes conectados
+func GetLiveStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"connected_clients": liveHub.Count()})
+}
+
+// motoIDForDriver obtiene la moto asignada a un driver (si tiene)
+func motoIDForDriver(driverID int) *int {
+	var motoID int
+	err := db.QueryRow("SELECT id FROM motos WHERE driver_id = $1 ORDER BY id LIMIT 1", driverID).Scan(&motoID)
+	if err != nil {
+		return nil
+	}
+	return &motoID
+}
+
+// publishShiftEvent publica un evento asociado a un turno
+func publishShiftEvent(eventType string, sc shiftContext, data interface{}) {
+	shiftID, driverID := sc.ShiftID, sc.DriverID
+	liveHub.Publish(live.Event{
+		Type:     eventType,
+		Branch:   sc.Branch,
+		MotoID:   sc.MotoID,
+		DriverID: &driverID,
+		ShiftID:  &shiftID,
+		Data:     data,
+	})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/logitrack/geolocation-service/live"
+)
+
+func TestLiveScopeFor(t *testing.T) {
+	tests := []struct {
+		role   string
+		branch string
+		want   live.Scope
+		ok     bool
+	}{
+		{"admin", "", live.Scope{AllBranches: true}, true},
+		{"manager", "", live.Scope{AllBranches: true}, true},
+		{"coordinator", "norte", live.Scope{AllBranches: true}, true},
+		{"analyst", "", live.Scope{AllBranches: true}, true},
+		{"driver", "norte", live.Scope{DriverID: 7}, true},
+		{"supervisor", "norte", live.Scope{Branch: "norte"}, true},
+		{"operator", "sur", live.Scope{Branch: "sur"}, true},
+		{"supervisor", "", live.Scope{}, false},
+		{"operator", "", live.Scope{}, false},
+		{"customer", "norte", live.Scope{}, false},
+		{"", "", live.Scope{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := liveScopeFor(7, tt.role, tt.branch)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("liveScopeFor(%q, %q) = %+v, %v; se esperaba %+v, %v", tt.role, tt.branch, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLiveRoleNeedsBranch(t *testing.T) {
+	for role, want := range map[string]bool{
+		"supervisor": true, "operator": true, "admin": false, "driver": false, "coordinator": false,
+	} {
+		if got := liveRoleNeedsBranch(role); got != want {
+			t.Errorf("liveRoleNeedsBranch(%q) = %v, se esperaba %v", role, got, want)
+		}
+	}
+}
@@ -2,7 +2,13 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/logitrack/geolocation-service/models"
 )
@@ -13,41 +19,169 @@ func SetDB(database *sql.DB) {
 	db = database
 }
 
+// Límites de la consulta del historial de ubicaciones
+const (
+	defaultLocationsRange = 24 * time.Hour
+	maxLocationsRange     = 31 * 24 * time.Hour
+	defaultLocationsPage  = 500
+	maxLocationsPage      = 5000
+)
+
+// SaveLocation registra una ubicación (endpoint legado). Pasa por la misma
+// ingesta que POST /positions para mantener motos, locations y route_points en sync.
 func SaveLocation(c *gin.Context) {
 	var loc models.Location
 	if err := c.ShouldBindJSON(&loc); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_, err := db.Exec("INSERT INTO locations (order_id, moto_id, latitude, longitude, type) VALUES ($1, $2, $3, $4, $5)",
-		loc.OrderID, loc.MotoID, loc.Latitude, loc.Longitude, loc.Type)
+	_, err := ingestPosition(PositionReport{
+		MotoID:     loc.MotoID,
+		OrderID:    loc.OrderID,
+		Latitude:   &loc.Latitude,
+		Longitude:  &loc.Longitude,
+		Type:       loc.Type,
+		RecordedAt: loc.Timestamp,
+	})
 	if err != nil {
+		if status := positionErrorStatus(err); status != http.StatusInternalServerError {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save location"})
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"message": "Location saved"})
 }
 
+// GetLocations devuelve el historial de ubicaciones acotado por fechas
+// (por defecto las últimas 24 horas, máximo 31 días), del más reciente al más
+// antiguo. Paginación con limit y cursor (X-Next-Cursor, ausente en la última
+// página); sin limit ni cursor devuelve todas las del rango.
 func GetLocations(c *gin.Context) {
-	rows, err := db.Query("SELECT id, order_id, moto_id, latitude, longitude, type FROM locations")
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		t, dateOnly, err := parseExportDate(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fecha to inválida"})
+			return
+		}
+		if dateOnly {
+			t = t.Add(24 * time.Hour)
+		}
+		to = t
+	}
+	from := to.Add(-defaultLocationsRange)
+	if v := c.Query("from"); v != "" {
+		t, _, err := parseExportDate(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fecha from inválida"})
+			return
+		}
+		from = t
+	}
+	if !to.After(from) || to.Sub(from) > maxLocationsRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El rango de fechas es inválido (máximo 31 días)"})
+		return
+	}
+
+	// Siempre se pagina: limit por defecto 500, recortado a maxLocationsPage
+	limit := defaultLocationsPage
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit debe ser un entero positivo"})
+			return
+		}
+		limit = min(n, maxLocationsPage)
+	}
+
+	query := `SELECT id, order_id, moto_id, latitude, longitude, type, timestamp
+		FROM locations WHERE timestamp >= $1 AND timestamp < $2`
+	args := []interface{}{from, to}
+
+	for _, f := range []struct{ param, column string }{
+		{"moto_id", "moto_id"},
+		{"order_id", "order_id"},
+		{"type", "type"},
+	} {
+		if v := c.Query(f.param); v != "" {
+			args = append(args, v)
+			query += " AND " + f.column + " = $" + strconv.Itoa(len(args))
+		}
+	}
+
+	// Paginación por cursor (timestamp, id) en orden descendente
+	if v := c.Query("cursor"); v != "" {
+		ts, id, err := decodeLocationsCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cursor inválido"})
+			return
+		}
+		args = append(args, ts, id)
+		query += " AND (timestamp, id) < ($" + strconv.Itoa(len(args)-1) + ", $" + strconv.Itoa(len(args)) + ")"
+	}
+
+	args = append(args, limit+1)
+	query += " ORDER BY timestamp DESC, id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener ubicaciones"})
 		return
 	}
 	defer rows.Close()
 
-	var locations []models.Location
+	locations := []models.Location{}
 	for rows.Next() {
 		var loc models.Location
-		err := rows.Scan(&loc.ID, &loc.OrderID, &loc.MotoID, &loc.Latitude, &loc.Longitude, &loc.Type)
+		var ts time.Time
+		err := rows.Scan(&loc.ID, &loc.OrderID, &loc.MotoID, &loc.Latitude, &loc.Longitude, &loc.Type, &ts)
 		if err != nil {
 			continue
 		}
+		loc.Timestamp = &ts
 		locations = append(locations, loc)
 	}
+
+	// X-Next-Cursor vacío indica que no hay más páginas
+	nextCursor := ""
+	if len(locations) > limit {
+		locations = locations[:limit]
+		last := locations[limit-1]
+		nextCursor = encodeLocationsCursor(*last.Timestamp, last.ID)
+	}
+	// c.Header elimina el header si el valor es vacío
+	c.Writer.Header().Set("X-Next-Cursor", nextCursor)
+
 	c.JSON(http.StatusOK, locations)
 }
 
+func encodeLocationsCursor(ts time.Time, id int) string {
+	raw := ts.Format(time.RFC3339Nano) + "|" + strconv.Itoa(id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLocationsCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, errors.New("cursor inválido")
+	}
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return ts, id, nil
+}
+
 // GetLatestLocationsByMoto returns the latest 'current' location for each moto.
 func GetLatestLocationsByMoto(c *gin.Context) {
 	rows, err := db.Query(`SELECT DISTINCT ON (moto_id) id, order_id, moto_id, latitude, longitude, type FROM locations WHERE moto_id IS NOT NULL AND type = 'current' ORDER BY moto_id, timestamp DESC`)
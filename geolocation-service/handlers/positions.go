@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/geolocation-service/geofence"
+	"github.com/logitrack/geolocation-service/integrity"
+	"github.com/logitrack/geolocation-service/live"
+)
+
+// Errores de la ingesta de posiciones
+var (
+	errPositionTarget = errors.New("se requiere moto_id, driver_id o shift_id")
+	errMotoNotFound   = errors.New("moto no encontrada")
+	errShiftNotFound  = errors.New("turno no encontrado")
+	errShiftNotActive = errors.New("el turno no está activo")
+	errCoordinates    = errors.New("latitude debe estar entre -90 y 90 y longitude entre -180 y 180")
+)
+
+// positionStaleAfter es la antigüedad a partir de la cual una posición se
+// considera desactualizada (POSITION_STALE_SECONDS, por defecto 5 minutos)
+var positionStaleAfter = 5 * time.Minute
+
+func init() {
+	if v, err := strconv.Atoi(os.Getenv("POSITION_STALE_SECONDS")); err == nil && v > 0 {
+		positionStaleAfter = time.Duration(v) * time.Second
+	}
+}
+
+// PositionReport es un reporte GPS de una moto, un driver o un turno.
+// Es la única vía de escritura de posiciones: actualiza la última posición
+// de la moto (motos), el historial (locations) y la ruta del turno activo
+// (route_points) en una sola transacción.
+type PositionReport struct {
+	MotoID     *int       `json:"moto_id"`
+	DriverID   *int       `json:"driver_id"`
+	ShiftID    *int       `json:"shift_id"`
+	OrderID    *int       `json:"order_id"`
+	Latitude   *float64   `json:"latitude" binding:"required"` // puntero: 0 es una coordenada válida
+	Longitude  *float64   `json:"longitude" binding:"required"`
+	Accuracy   *float64   `json:"accuracy"`
+	Speed      *float64   `json:"speed"`
+	Heading    *float64   `json:"heading"`
+	Altitude   *float64   `json:"altitude"`
+	Type       string     `json:"type"`       // locations: current (defecto), pickup, delivery
+	PointType  string     `json:"point_type"` // route_points: TRACKING (defecto), DELIVERY, PAUSE
+	Address    string     `json:"address"`
+	RecordedAt *time.Time `json:"recorded_at"` // hora del dispositivo (defecto: hora del servidor)
+}
+
+// IngestedPosition es el resultado de registrar un reporte
+type IngestedPosition struct {
+	LocationID    int         `json:"location_id"`
+	MotoID        *int        `json:"moto_id,omitempty"`
+	DriverID      *int        `json:"driver_id,omitempty"`
+	ShiftID       *int        `json:"shift_id,omitempty"`
+	Latitude      float64     `json:"latitude"`
+	Longitude     float64     `json:"longitude"`
+	RecordedAt    time.Time   `json:"recorded_at"`
+	LatestUpdated bool        `json:"latest_updated"` // false si llegó un reporte más reciente antes
+	RoutePoint    *RoutePoint `json:"route_point,omitempty"`
+}
+
+// MotoPosition es la última posición conocida de una moto
+type MotoPosition struct {
+	MotoID     int        `json:"moto_id"`
+	BranchID   *int       `json:"branch_id,omitempty"`
+	Latitude   float64    `json:"latitude"`
+	Longitude  float64    `json:"longitude"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+	AgeSeconds *int       `json:"age_seconds"`
+	Stale      bool       `json:"stale"`
+}
+
+// resolvePositionContext completa moto, driver y turno activo del reporte
+func resolvePositionContext(rep *PositionReport) (*shiftContext, error) {
+	switch {
+	case rep.ShiftID != nil:
+		var status string
+		sc := shiftContext{ShiftID: *rep.ShiftID}
+		err := db.QueryRow("SELECT status, driver_id, COALESCE(branch, '') FROM shifts WHERE id = $1", *rep.ShiftID).
+			Scan(&status, &sc.DriverID, &sc.Branch)
+		if err == sql.ErrNoRows {
+			return nil, errShiftNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status != "ACTIVE" {
+			return nil, errShiftNotActive
+		}
+		rep.DriverID = &sc.DriverID
+		if rep.MotoID == nil {
+			rep.MotoID = motoIDForDriver(sc.DriverID)
+		}
+		sc.MotoID = rep.MotoID
+		return &sc, nil
+
+	case rep.MotoID != nil:
+		var driverID sql.NullInt64
+		err := db.QueryRow("SELECT driver_id FROM motos WHERE id = $1", *rep.MotoID).Scan(&driverID)
+		if err == sql.ErrNoRows {
+			return nil, errMotoNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if driverID.Valid && rep.DriverID == nil {
+			id := int(driverID.Int64)
+			rep.DriverID = &id
+		}
+
+	case rep.DriverID != nil:
+		rep.MotoID = motoIDForDriver(*rep.DriverID)
+	}
+
+	if rep.DriverID == nil {
+		return nil, nil
+	}
+
+	// Turno activo del driver (si tiene)
+	sc := shiftContext{DriverID: *rep.DriverID, MotoID: rep.MotoID}
+	err := db.QueryRow(`
+		SELECT id, COALESCE(branch, '') FROM shifts
+		WHERE driver_id = $1 AND status = 'ACTIVE'
+		ORDER BY start_time DESC LIMIT 1
+	`, *rep.DriverID).Scan(&sc.ShiftID, &sc.Branch)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rep.ShiftID = &sc.ShiftID
+	return &sc, nil
+}
+
+// validCoordinates indica si ambas coordenadas están presentes y en rango
+func validCoordinates(lat, lng *float64) bool {
+	return lat != nil && lng != nil &&
+		*lat >= -90 && *lat <= 90 && *lng >= -180 && *lng <= 180
+}
+
+// ingestPosition registra el reporte en las tres tablas de forma atómica y,
+// tras confirmar, publica la posición en vivo y evalúa geocercas
+func ingestPosition(rep PositionReport) (*IngestedPosition, error) {
+	if !validCoordinates(rep.Latitude, rep.Longitude) {
+		return nil, errCoordinates
+	}
+	lat, lng := *rep.Latitude, *rep.Longitude
+	if rep.Type == "" {
+		rep.Type = "current"
+	}
+	if rep.PointType == "" {
+		rep.PointType = "TRACKING"
+	}
+	var recordedAt interface{}
+	if rep.RecordedAt != nil && !rep.RecordedAt.IsZero() && rep.RecordedAt.Before(time.Now()) {
+		recordedAt = rep.RecordedAt.UTC()
+	}
+
+	sc, err := resolvePositionContext(&rep)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// 1. Historial
+	res := IngestedPosition{
+		MotoID:    rep.MotoID,
+		DriverID:  rep.DriverID,
+		ShiftID:   rep.ShiftID,
+		Latitude:  lat,
+		Longitude: lng,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO locations (order_id, moto_id, latitude, longitude, type, timestamp)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, CURRENT_TIMESTAMP))
+		RETURNING id, timestamp
+	`, rep.OrderID, rep.MotoID, lat, lng, rep.Type, recordedAt).
+		Scan(&res.LocationID, &res.RecordedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Los reportes pickup/delivery marcan puntos del pedido, no la posición de la moto
+	if rep.Type == "current" {
+		// 2. Última posición (no retroceder ante reportes atrasados)
+		if rep.MotoID != nil {
+			r, err := tx.Exec(`
+				UPDATE motos SET latitude = $1, longitude = $2, last_location_update = $3
+				WHERE id = $4 AND (last_location_update IS NULL OR last_location_update <= $3)
+			`, lat, lng, res.RecordedAt, *rep.MotoID)
+			if err != nil {
+				return nil, err
+			}
+			affected, _ := r.RowsAffected()
+			res.LatestUpdated = affected > 0
+		}
+
+		// 3. Ruta del turno activo
+		if sc != nil {
+			// Puntaje de integridad contra las lecturas anteriores del turno
+			// (con la hora original del dispositivo, aunque sea futura)
+			history, err := loadShiftFixes(tx, sc.ShiftID)
+			if err != nil {
+				return nil, err
+			}
+			fix := integrity.Fix{
+				Latitude:  lat,
+				Longitude: lng,
+				Accuracy:  rep.Accuracy,
+				Speed:     rep.Speed,
+				Timestamp: res.RecordedAt,
+			}
+			if rep.RecordedAt != nil && !rep.RecordedAt.IsZero() {
+				fix.Timestamp = *rep.RecordedAt
+			}
+			check := integrity.Assess(fix, history, time.Now(), integrityConfig)
+
+			var point RoutePoint
+			err = tx.QueryRow(`
+				INSERT INTO route_points (
+					shift_id, latitude, longitude, accuracy, speed, heading, altitude,
+					point_type, order_id, address, timestamp, integrity_score, integrity_flags
+				)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+				RETURNING id, shift_id, latitude, longitude, accuracy, speed, heading,
+						  altitude, timestamp, point_type, order_id, address, created_at
+			`, sc.ShiftID, lat, lng, rep.Accuracy, rep.Speed,
+				rep.Heading, rep.Altitude, rep.PointType, rep.OrderID, rep.Address, res.RecordedAt,
+				check.Score, pq.Array(check.Flags)).Scan(
+				&point.ID, &point.ShiftID, &point.Latitude,
+				&point.Longitude, &point.Accuracy, &point.Speed,
+				&point.Heading, &point.Altitude, &point.Timestamp,
+				&point.PointType, &point.OrderID, &point.Address,
+				&point.CreatedAt,
+			)
+			if err != nil {
+				return nil, err
+			}
+			point.IntegrityScore = &check.Score
+			point.IntegrityFlags = check.Flags
+			if check.Suspicious {
+				if err := flagSuspiciousShift(tx, sc, check); err != nil {
+					return nil, err
+				}
+			}
+			res.RoutePoint = &point
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if rep.Type == "current" {
+		publishPosition(sc, &res)
+		if sc != nil {
+			processGeofence(*sc, geofence.Point{
+				Latitude:  lat,
+				Longitude: lng,
+				Timestamp: res.RecordedAt,
+			})
+			processSafety(*sc, res.RoutePoint)
+		}
+	}
+
+	return &res, nil
+}
+
+// publishPosition publica la posición en el canal en vivo
+func publishPosition(sc *shiftContext, res *IngestedPosition) {
+	if sc != nil {
+		publishShiftEvent(live.EventPosition, *sc, res)
+		return
+	}
+	if res.MotoID == nil {
+		return
+	}
+
+	var branch string
+	db.QueryRow(`SELECT b.code FROM motos m
+		JOIN branches b ON b.id = COALESCE(m.current_branch_id, m.branch_id)
+		WHERE m.id = $1`, *res.MotoID).Scan(&branch)
+	liveHub.Publish(live.Event{
+		Type:     live.EventPosition,
+		Branch:   branch,
+		MotoID:   res.MotoID,
+		DriverID: res.DriverID,
+		Data:     res,
+	})
+}
+
+// positionErrorStatus traduce los errores de ingesta a códigos HTTP
+func positionErrorStatus(err error) int {
+	switch err {
+	case errPositionTarget, errCoordinates:
+		return http.StatusBadRequest
+	case errMotoNotFound, errShiftNotFound:
+		return http.StatusNotFound
+	case errShiftNotActive:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// IngestPosition registra un reporte GPS (POST /positions)
+func IngestPosition(c *gin.Context) {
+	var rep PositionReport
+	if err := c.ShouldBindJSON(&rep); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if rep.MotoID == nil && rep.DriverID == nil && rep.ShiftID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errPositionTarget.Error()})
+		return
+	}
+	if rep.Type != "" && rep.Type != "current" && rep.Type != "pickup" && rep.Type != "delivery" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de ubicación inválido"})
+		return
+	}
+
+	res, err := ingestPosition(rep)
+	if err != nil {
+		status := positionErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("Positions: error registrando posición: %v", err)
+			c.JSON(status, gin.H{"error": "Error al registrar posición"})
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+// GetMotoPositions devuelve la última posición de las motos con su antigüedad.
+// Filtros opcionales: ids=1,2,3 y branch_id (sucursal efectiva).
+func GetMotoPositions(c *gin.Context) {
+	query := `
+		SELECT id, COALESCE(current_branch_id, branch_id), latitude, longitude, last_location_update
+		FROM motos
+		WHERE latitude IS NOT NULL AND longitude IS NOT NULL`
+	args := []interface{}{}
+
+	if raw := c.Query("ids"); raw != "" {
+		var ids []string
+		for _, part := range strings.Split(raw, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ids inválidos"})
+				return
+			}
+			args = append(args, id)
+			ids = append(ids, "$"+strconv.Itoa(len(args)))
+		}
+		query += " AND id IN (" + strings.Join(ids, ", ") + ")"
+	}
+	if branchID := c.Query("branch_id"); branchID != "" {
+		args = append(args, branchID)
+		query += " AND COALESCE(current_branch_id, branch_id) = $" + strconv.Itoa(len(args))
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener posiciones"})
+		return
+	}
+	defer rows.Close()
+
+	positions := []MotoPosition{}
+	for rows.Next() {
+		p, err := scanMotoPosition(rows)
+		if err != nil {
+			continue
+		}
+		positions = append(positions, *p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"positions":     positions,
+		"stale_after_s": int(positionStaleAfter.Seconds()),
+		"server_time":   time.Now(),
+	})
+}
+
+// GetMotoPosition devuelve la última posición de una moto
+func GetMotoPosition(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de moto inválido"})
+		return
+	}
+
+	row := db.QueryRow(`
+		SELECT id, COALESCE(current_branch_id, branch_id), latitude, longitude, last_location_update
+		FROM motos
+		WHERE id = $1 AND latitude IS NOT NULL AND longitude IS NOT NULL
+	`, id)
+	p, err := scanMotoPosition(row)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "La moto no tiene posición registrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener posición"})
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+func scanMotoPosition(row interface{ Scan(...interface{}) error }) (*MotoPosition, error) {
+	var p MotoPosition
+	var branchID sql.NullInt64
+	var updatedAt sql.NullTime
+	if err := row.Scan(&p.MotoID, &branchID, &p.Latitude, &p.Longitude, &updatedAt); err != nil {
+		return nil, err
+	}
+	if branchID.Valid {
+		id := int(branchID.Int64)
+		p.BranchID = &id
+	}
+
+	// Sin marca de tiempo la posición es la inicial (seed) y se considera desactualizada
+	p.Stale = true
+	if updatedAt.Valid {
+		age := int(time.Since(updatedAt.Time).Seconds())
+		if age < 0 {
+			age = 0
+		}
+		p.UpdatedAt = &updatedAt.Time
+		p.AgeSeconds = &age
+		p.Stale = time.Duration(age)*time.Second > positionStaleAfter
+	}
+	return &p, nil
+}
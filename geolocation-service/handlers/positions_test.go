@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestValidCoordinates(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name     string
+		lat, lng *float64
+		want     bool
+	}{
+		{"cero es válido", f(0), f(0), true},
+		{"límites", f(-90), f(180), true},
+		{"buenos aires", f(-34.6), f(-58.38), true},
+		{"sin latitud", nil, f(-58.38), false},
+		{"sin longitud", f(-34.6), nil, false},
+		{"latitud fuera de rango", f(90.1), f(0), false},
+		{"longitud fuera de rango", f(0), f(-180.1), false},
+	}
+	for _, tt := range tests {
+		if got := validCoordinates(tt.lat, tt.lng); got != tt.want {
+			t.Errorf("%s: validCoordinates = %v, se esperaba %v", tt.name, got, tt.want)
+		}
+	}
+}
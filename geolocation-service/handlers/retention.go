@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gpsTables tablas GPS particionadas por mes
+var gpsTables = []string{"locations", "route_points"}
+
+// partitionsAhead meses futuros con partición creada de antemano
+const partitionsAhead = 3
+
+// RetentionPolicy retención y reducción de resolución de una tabla GPS
+type RetentionPolicy struct {
+	TableName                 string     `json:"table_name"`
+	RetentionDays             int        `json:"retention_days"`
+	DownsampleAfterDays       int        `json:"downsample_after_days"`
+	DownsampleIntervalSeconds int        `json:"downsample_interval_seconds"`
+	DownsampledUntil          *time.Time `json:"downsampled_until,omitempty"`
+	LastRunAt                 *time.Time `json:"last_run_at,omitempty"`
+	LastDeletedRows           int64      `json:"last_deleted_rows"`
+	LastDownsampledRows       int64      `json:"last_downsampled_rows"`
+}
+
+// MaintenanceResult resultado del mantenimiento de una tabla
+type MaintenanceResult struct {
+	TableName         string   `json:"table_name"`
+	CreatedPartitions []string `json:"created_partitions"`
+	DroppedPartitions []string `json:"dropped_partitions"`
+	DeletedRows       int64    `json:"deleted_rows"`
+	DownsampledRows   int64    `json:"downsampled_rows"`
+	DurationMillis    int64    `json:"duration_ms"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// downsampleQueries elimina, dentro de [$1, $2), todos los puntos de cada
+// intervalo de $3 segundos salvo el primero. En route_points solo se reducen
+// los puntos TRACKING sin señales de GPS falso (START, END, DELIVERY y PAUSE
+// se conservan siempre); en locations solo las posiciones 'current'.
+var downsampleQueries = map[string]string{
+	"route_points": `
+		DELETE FROM route_points rp
+		USING (
+			SELECT id, timestamp, ROW_NUMBER() OVER (
+				PARTITION BY shift_id, floor(extract(epoch FROM timestamp) / $3::int)
+				ORDER BY timestamp, id
+			) AS rn
+			FROM route_points
+			WHERE timestamp >= $1 AND timestamp < $2
+			  AND point_type = 'TRACKING' AND COALESCE(integrity_score, 0) = 0
+		) d
+		WHERE rp.id = d.id AND rp.timestamp = d.timestamp AND d.rn > 1`,
+	"locations": `
+		DELETE FROM locations l
+		USING (
+			SELECT id, timestamp, ROW_NUMBER() OVER (
+				PARTITION BY moto_id, order_id, floor(extract(epoch FROM timestamp) / $3::int)
+				ORDER BY timestamp, id
+			) AS rn
+			FROM locations
+			WHERE timestamp >= $1 AND timestamp < $2 AND type = 'current'
+		) d
+		WHERE l.id = d.id AND l.timestamp = d.timestamp AND d.rn > 1`,
+}
+
+// gpsMaintenanceMu evita ejecuciones simultáneas (ticker y endpoint)
+var gpsMaintenanceMu sync.Mutex
+
+// InitRetention inicia el mantenimiento periódico del historial GPS cada
+// GPS_MAINTENANCE_INTERVAL_HOURS horas (por defecto 24, 0 lo desactiva y
+// queda solo POST /maintenance/gps/run para un cron externo)
+func InitRetention() {
+	interval := 24
+	if v, err := strconv.Atoi(os.Getenv("GPS_MAINTENANCE_INTERVAL_HOURS")); err == nil && v >= 0 {
+		interval = v
+	}
+	if interval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, ok := runGPSMaintenance(); !ok {
+				log.Println("Mantenimiento GPS: ejecución anterior aún en curso")
+			}
+		}
+	}()
+}
+
+// runGPSMaintenance crea las particiones futuras y aplica la política de cada
+// tabla. Devuelve false si ya hay una ejecución en curso.
+func runGPSMaintenance() ([]MaintenanceResult, bool) {
+	if !gpsMaintenanceMu.TryLock() {
+		return nil, false
+	}
+	defer gpsMaintenanceMu.Unlock()
+
+	policies, err := loadRetentionPolicies()
+	if err != nil {
+		log.Printf("Mantenimiento GPS: error cargando políticas: %v", err)
+	}
+	byTable := make(map[string]RetentionPolicy, len(policies))
+	for _, p := range policies {
+		byTable[p.TableName] = p
+	}
+
+	results := []MaintenanceResult{}
+	for _, table := range gpsTables {
+		start := time.Now()
+		res := MaintenanceResult{TableName: table, CreatedPartitions: []string{}, DroppedPartitions: []string{}}
+
+		err := ensureGPSPartitions(table, &res)
+		if p, ok := byTable[table]; ok && err == nil {
+			err = applyRetention(p, &res)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			log.Printf("Mantenimiento GPS: error en %s: %v", table, err)
+		}
+
+		res.DurationMillis = time.Since(start).Milliseconds()
+		results = append(results, res)
+	}
+	return results, true
+}
+
+// ensureGPSPartitions crea las particiones del mes actual y los siguientes
+func ensureGPSPartitions(table string, res *MaintenanceResult) error {
+	existing, err := gpsPartitions(table)
+	if err != nil {
+		return err
+	}
+	month := time.Now().UTC()
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= partitionsAhead; i++ {
+		m := month.AddDate(0, i, 0)
+		name := table + "_" + m.Format("200601")
+		if _, ok := existing[name]; ok {
+			continue
+		}
+		if _, err := db.Exec("SELECT create_gps_partition($1, $2)", table, m.Format("2006-01-02")); err != nil {
+			return err
+		}
+		res.CreatedPartitions = append(res.CreatedPartitions, name)
+	}
+	return nil
+}
+
+// gpsPartitions lista las particiones mensuales de la tabla con su mes
+func gpsPartitions(table string) (map[string]time.Time, error) {
+	rows, err := db.Query(`
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass
+	`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			continue
+		}
+		month, err := time.Parse("200601", strings.TrimPrefix(name, table+"_"))
+		if err != nil {
+			continue // Partición por defecto u otra no mensual
+		}
+		partitions[name] = month
+	}
+	return partitions, rows.Err()
+}
+
+// applyRetention elimina los datos vencidos (particiones completas y el resto
+// fila a fila) y reduce la resolución de los datos antiguos día por día,
+// registrando el avance para no volver a procesarlos
+func applyRetention(p RetentionPolicy, res *MaintenanceResult) error {
+	// Los cortes se calculan en la BD: las columnas son TIMESTAMP sin zona
+	var retentionCutoff, downsampleCutoff time.Time
+	err := db.QueryRow(`
+		SELECT (CURRENT_TIMESTAMP - make_interval(days => $1))::timestamp,
+		       date_trunc('day', CURRENT_TIMESTAMP - make_interval(days => $2))::timestamp
+	`, p.RetentionDays, p.DownsampleAfterDays).Scan(&retentionCutoff, &downsampleCutoff)
+	if err != nil {
+		return err
+	}
+
+	// 1. Retención: particiones que terminan antes del corte
+	partitions, err := gpsPartitions(p.TableName)
+	if err != nil {
+		return err
+	}
+	for name, month := range partitions {
+		if month.AddDate(0, 1, 0).After(retentionCutoff) {
+			continue
+		}
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + quoteIdent(name)); err != nil {
+			return err
+		}
+		res.DroppedPartitions = append(res.DroppedPartitions, name)
+	}
+	r, err := db.Exec("DELETE FROM "+quoteIdent(p.TableName)+" WHERE timestamp < $1", retentionCutoff)
+	if err != nil {
+		return err
+	}
+	res.DeletedRows, _ = r.RowsAffected()
+
+	// 2. Reducción de resolución desde donde quedó la ejecución anterior
+	from := retentionCutoff
+	if p.DownsampledUntil != nil && p.DownsampledUntil.After(from) {
+		from = *p.DownsampledUntil
+	}
+	for from.Before(downsampleCutoff) {
+		to := from.AddDate(0, 0, 1)
+		if to.After(downsampleCutoff) {
+			to = downsampleCutoff
+		}
+		r, err := db.Exec(downsampleQueries[p.TableName], from, to, p.DownsampleIntervalSeconds)
+		if err != nil {
+			return err
+		}
+		n, _ := r.RowsAffected()
+		res.DownsampledRows += n
+
+		if _, err := db.Exec(`
+			UPDATE gps_retention_policies SET downsampled_until = $2 WHERE table_name = $1
+		`, p.TableName, to); err != nil {
+			return err
+		}
+		from = to
+	}
+
+	_, err = db.Exec(`
+		UPDATE gps_retention_policies
+		SET last_run_at = CURRENT_TIMESTAMP, last_deleted_rows = $2, last_downsampled_rows = $3
+		WHERE table_name = $1
+	`, p.TableName, res.DeletedRows, res.DownsampledRows)
+	return err
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func loadRetentionPolicies() ([]RetentionPolicy, error) {
+	rows, err := db.Query(`
+		SELECT table_name, retention_days, downsample_after_days, downsample_interval_seconds,
+		       downsampled_until, last_run_at, last_deleted_rows, last_downsampled_rows
+		FROM gps_retention_policies
+		ORDER BY table_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []RetentionPolicy{}
+	for rows.Next() {
+		var p RetentionPolicy
+		if err := rows.Scan(&p.TableName, &p.RetentionDays, &p.DownsampleAfterDays, &p.DownsampleIntervalSeconds,
+			&p.DownsampledUntil, &p.LastRunAt, &p.LastDeletedRows, &p.LastDownsampledRows); err != nil {
+			continue
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// GetRetentionPolicies lista las políticas y las particiones de cada tabla
+func GetRetentionPolicies(c *gin.Context) {
+	policies, err := loadRetentionPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener políticas de retención"})
+		return
+	}
+
+	type partitionInfo struct {
+		Name          string `json:"name"`
+		EstimatedRows int64  `json:"estimated_rows"`
+	}
+	partitions := map[string][]partitionInfo{}
+	for _, table := range gpsTables {
+		partitions[table] = []partitionInfo{}
+		rows, err := db.Query(`
+			SELECT c.relname, GREATEST(c.reltuples, 0)::bigint
+			FROM pg_inherits i
+			JOIN pg_class c ON c.oid = i.inhrelid
+			WHERE i.inhparent = $1::regclass
+			ORDER BY c.relname
+		`, table)
+		if err != nil {
+			continue
+		}
+		for rows.Next() {
+			var p partitionInfo
+			if err := rows.Scan(&p.Name, &p.EstimatedRows); err == nil {
+				partitions[table] = append(partitions[table], p)
+			}
+		}
+		rows.Close()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"policies":   policies,
+		"partitions": partitions,
+	})
+}
+
+// UpdateRetentionPolicy actualiza la política de una tabla GPS
+func UpdateRetentionPolicy(c *gin.Context) {
+	table := c.Param("table")
+	known := false
+	for _, t := range gpsTables {
+		known = known || t == table
+	}
+	if !known {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tabla sin política de retención"})
+		return
+	}
+
+	var req struct {
+		RetentionDays             int `json:"retention_days" binding:"required,min=1"`
+		DownsampleAfterDays       int `json:"downsample_after_days" binding:"required,min=1"`
+		DownsampleIntervalSeconds int `json:"downsample_interval_seconds" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if req.DownsampleAfterDays >= req.RetentionDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "downsample_after_days debe ser menor que retention_days"})
+		return
+	}
+
+	// Con un intervalo distinto la reducción vuelve a recorrer los datos antiguos
+	_, err := db.Exec(`
+		INSERT INTO gps_retention_policies (table_name, retention_days, downsample_after_days, downsample_interval_seconds)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (table_name) DO UPDATE SET
+			retention_days = EXCLUDED.retention_days,
+			downsample_after_days = EXCLUDED.downsample_after_days,
+			downsampled_until = CASE
+				WHEN gps_retention_policies.downsample_interval_seconds = EXCLUDED.downsample_interval_seconds
+				THEN gps_retention_policies.downsampled_until
+			END,
+			downsample_interval_seconds = EXCLUDED.downsample_interval_seconds,
+			updated_at = CURRENT_TIMESTAMP
+	`, table, req.RetentionDays, req.DownsampleAfterDays, req.DownsampleIntervalSeconds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar política de retención"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"table_name":                  table,
+		"retention_days":              req.RetentionDays,
+		"downsample_after_days":       req.DownsampleAfterDays,
+		"downsample_interval_seconds": req.DownsampleIntervalSeconds,
+		"message":                     "Política de retención actualizada",
+	})
+}
+
+// RunGPSMaintenance ejecuta el mantenimiento del historial GPS (endpoint para cron)
+func RunGPSMaintenance(c *gin.Context) {
+	results, ok := runGPSMaintenance()
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "El mantenimiento ya está en ejecución"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"message": "Mantenimiento GPS completado",
+	})
+}
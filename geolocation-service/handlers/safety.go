@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/geolocation-service/live"
+	"github.com/logitrack/geolocation-service/safety"
+)
+
+// SafetyIncident incidente de conducción persistido
+type SafetyIncident struct {
+	ID         int       `json:"id"`
+	ShiftID    int       `json:"shift_id"`
+	DriverID   int       `json:"driver_id"`
+	DriverName string    `json:"driver_name,omitempty"`
+	MotoID     *int      `json:"moto_id,omitempty"`
+	Branch     string    `json:"branch"`
+	Type       string    `json:"incident_type"`
+	Severity   string    `json:"severity"`
+	Value      float64   `json:"value"`
+	Threshold  float64   `json:"threshold"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// BranchSafetySettings umbrales de una sucursal
+type BranchSafetySettings struct {
+	BranchID   int    `json:"branch_id"`
+	BranchCode string `json:"branch_code"`
+	BranchName string `json:"branch_name"`
+	IsDefault  bool   `json:"is_default"` // true si la sucursal usa los valores por defecto
+	safety.Rules
+}
+
+// safetyRulesTTL tiempo de vida del caché de umbrales por sucursal
+const safetyRulesTTL = 5 * time.Minute
+
+type cachedSafetyRules struct {
+	rules    safety.Rules
+	loadedAt time.Time
+}
+
+var (
+	safetyTracker *safety.Tracker
+	safetyDefault = safety.DefaultRules
+
+	safetyRulesMu    sync.Mutex
+	safetyRulesCache = map[string]cachedSafetyRules{}
+)
+
+// InitSafety configura el motor de reglas de seguridad a partir de variables de entorno
+func InitSafety() {
+	loc, err := time.LoadLocation(getEnvDefault("SAFETY_TIMEZONE", "America/Guatemala"))
+	if err != nil {
+		log.Printf("Safety: zona horaria inválida, usando UTC-6: %v", err)
+		loc = time.FixedZone("UTC-6", -6*3600)
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("SAFETY_SPEED_LIMIT_KMH"), 64); err == nil && v > 0 {
+		safetyDefault.SpeedLimitKmh = v
+	}
+
+	safetyTracker = safety.NewTracker(safety.Engine{Location: loc})
+}
+
+func getEnvDefault(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// loadSafetyRules obtiene los umbrales de la sucursal (por código) con caché
+func loadSafetyRules(branchCode string) safety.Rules {
+	safetyRulesMu.Lock()
+	cached, ok := safetyRulesCache[branchCode]
+	safetyRulesMu.Unlock()
+	if ok && time.Since(cached.loadedAt) < safetyRulesTTL {
+		return cached.rules
+	}
+
+	rules := safetyDefault
+	err := db.QueryRow(`
+		SELECT s.speed_limit_kmh, s.harsh_accel_ms2, s.harsh_brake_ms2, s.night_start_hour, s.night_end_hour
+		FROM branch_safety_settings s
+		JOIN branches b ON b.id = s.branch_id
+		WHERE b.code = $1
+	`, branchCode).Scan(&rules.SpeedLimitKmh, &rules.HarshAccelMS2, &rules.HarshBrakeMS2,
+		&rules.NightStartHour, &rules.NightEndHour)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Safety: error cargando umbrales de %s: %v", branchCode, err)
+	}
+
+	safetyRulesMu.Lock()
+	safetyRulesCache[branchCode] = cachedSafetyRules{rules: rules, loadedAt: time.Now()}
+	safetyRulesMu.Unlock()
+	return rules
+}
+
+func invalidateSafetyRules() {
+	safetyRulesMu.Lock()
+	safetyRulesCache = map[string]cachedSafetyRules{}
+	safetyRulesMu.Unlock()
+}
+
+// loadSafetyState reconstruye el estado del turno: penúltimo punto (el último
+// es el que se está evaluando) e incidente nocturno ya reportado
+func loadSafetyState(shiftID int) *safety.State {
+	state := &safety.State{}
+
+	var last safety.Sample
+	err := db.QueryRow(`
+		SELECT latitude, longitude, speed, timestamp
+		FROM route_points
+		WHERE shift_id = $1
+		ORDER BY timestamp DESC, id DESC
+		OFFSET 1 LIMIT 1
+	`, shiftID).Scan(&last.Latitude, &last.Longitude, &last.Speed, &last.Timestamp)
+	var lastPtr *safety.Sample
+	if err == nil {
+		lastPtr = &last
+	}
+
+	var nightReported bool
+	db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM safety_incidents WHERE shift_id = $1 AND incident_type = 'night_riding')
+	`, shiftID).Scan(&nightReported)
+
+	state.Restore(lastPtr, false, nightReported)
+	return state
+}
+
+// processSafety evalúa un punto del turno, guarda los incidentes y los
+// publica como alertas en el canal en vivo
+func processSafety(sc shiftContext, p *RoutePoint) {
+	if safetyTracker == nil || p == nil {
+		return
+	}
+
+	incidents := safetyTracker.Process(sc.ShiftID, loadSafetyRules(sc.Branch), safety.Sample{
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Speed:     p.Speed,
+		Timestamp: p.Timestamp,
+	}, func() *safety.State {
+		return loadSafetyState(sc.ShiftID)
+	})
+
+	for _, inc := range incidents {
+		var id int
+		err := db.QueryRow(`
+			INSERT INTO safety_incidents (
+				shift_id, driver_id, moto_id, branch, incident_type, severity,
+				value, threshold, latitude, longitude, occurred_at
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id
+		`, sc.ShiftID, sc.DriverID, sc.MotoID, sc.Branch, inc.Type, inc.Severity,
+			inc.Value, inc.Threshold, inc.Latitude, inc.Longitude, inc.Timestamp).Scan(&id)
+		if err != nil {
+			log.Printf("Safety: error guardando incidente %s del turno %d: %v", inc.Type, sc.ShiftID, err)
+			continue
+		}
+
+		publishShiftEvent(live.EventSafetyAlert, sc, gin.H{
+			"incident_id":   id,
+			"incident_type": inc.Type,
+			"severity":      inc.Severity,
+			"value":         inc.Value,
+			"threshold":     inc.Threshold,
+			"latitude":      inc.Latitude,
+			"longitude":     inc.Longitude,
+			"occurred_at":   inc.Timestamp,
+		})
+	}
+}
+
+// closeSafety libera el estado del turno al finalizarlo
+func closeSafety(shiftID int) {
+	if safetyTracker != nil {
+		safetyTracker.Close(shiftID)
+	}
+}
+
+// GetSafetySettings lista los umbrales de todas las sucursales activas
+func GetSafetySettings(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT b.id, b.code, b.name,
+		       s.speed_limit_kmh, s.harsh_accel_ms2, s.harsh_brake_ms2, s.night_start_hour, s.night_end_hour
+		FROM branches b
+		LEFT JOIN branch_safety_settings s ON s.branch_id = b.id
+		WHERE b.is_active = true
+		ORDER BY b.name
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener configuración de seguridad"})
+		return
+	}
+	defer rows.Close()
+
+	settings := []BranchSafetySettings{}
+	for rows.Next() {
+		var s BranchSafetySettings
+		var speed, accel, brake sql.NullFloat64
+		var nightStart, nightEnd sql.NullInt64
+		if err := rows.Scan(&s.BranchID, &s.BranchCode, &s.BranchName,
+			&speed, &accel, &brake, &nightStart, &nightEnd); err != nil {
+			continue
+		}
+		s.Rules = safetyDefault
+		s.IsDefault = !speed.Valid
+		if speed.Valid {
+			s.SpeedLimitKmh = speed.Float64
+			s.HarshAccelMS2 = accel.Float64
+			s.HarshBrakeMS2 = brake.Float64
+			s.NightStartHour = int(nightStart.Int64)
+			s.NightEndHour = int(nightEnd.Int64)
+		}
+		settings = append(settings, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+		"defaults": safetyDefault,
+	})
+}
+
+// UpdateSafetySettings crea o actualiza los umbrales de una sucursal
+func UpdateSafetySettings(c *gin.Context) {
+	branchID, err := strconv.Atoi(c.Param("branch_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de sucursal inválido"})
+		return
+	}
+
+	rules := safetyDefault
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if rules.SpeedLimitKmh <= 0 || rules.HarshAccelMS2 <= 0 || rules.HarshBrakeMS2 <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Los umbrales deben ser mayores a cero"})
+		return
+	}
+	if rules.NightStartHour < 0 || rules.NightStartHour > 23 || rules.NightEndHour < 0 || rules.NightEndHour > 23 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Las horas nocturnas deben estar entre 0 y 23"})
+		return
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO branch_safety_settings (
+			branch_id, speed_limit_kmh, harsh_accel_ms2, harsh_brake_ms2, night_start_hour, night_end_hour
+		)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (branch_id) DO UPDATE SET
+			speed_limit_kmh = EXCLUDED.speed_limit_kmh,
+			harsh_accel_ms2 = EXCLUDED.harsh_accel_ms2,
+			harsh_brake_ms2 = EXCLUDED.harsh_brake_ms2,
+			night_start_hour = EXCLUDED.night_start_hour,
+			night_end_hour = EXCLUDED.night_end_hour,
+			updated_at = CURRENT_TIMESTAMP
+	`, branchID, rules.SpeedLimitKmh, rules.HarshAccelMS2, rules.HarshBrakeMS2,
+		rules.NightStartHour, rules.NightEndHour)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sucursal no encontrada o datos inválidos"})
+		return
+	}
+	invalidateSafetyRules()
+
+	c.JSON(http.StatusOK, gin.H{
+		"branch_id": branchID,
+		"rules":     rules,
+		"message":   "Configuración de seguridad actualizada",
+	})
+}
+
+// GetSafetyIncidents lista incidentes con filtros opcionales
+func GetSafetyIncidents(c *gin.Context) {
+	where := "WHERE 1=1"
+	args := []interface{}{}
+	argIdx := 1
+
+	filters := []struct{ param, column string }{
+		{"shift_id", "i.shift_id"},
+		{"driver_id", "i.driver_id"},
+		{"moto_id", "i.moto_id"},
+		{"branch", "i.branch"},
+		{"incident_type", "i.incident_type"},
+		{"severity", "i.severity"},
+	}
+	for _, f := range filters {
+		if v := c.Query(f.param); v != "" {
+			where += " AND " + f.column + " = $" + strconv.Itoa(argIdx)
+			args = append(args, v)
+			argIdx++
+		}
+	}
+	if from := c.Query("from"); from != "" {
+		where += " AND i.occurred_at >= $" + strconv.Itoa(argIdx)
+		args = append(args, from)
+		argIdx++
+	}
+	if to := c.Query("to"); to != "" {
+		where += " AND i.occurred_at < $" + strconv.Itoa(argIdx)
+		args = append(args, to)
+		argIdx++
+	}
+
+	where += " ORDER BY i.occurred_at DESC, i.id DESC LIMIT $" + strconv.Itoa(argIdx)
+	args = append(args, c.DefaultQuery("limit", "200"))
+
+	incidents, err := querySafetyIncidents(where, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener incidentes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"incidents": incidents,
+		"total":     len(incidents),
+	})
+}
+
+// GetShiftSafetyIncidents obtiene los incidentes de un turno
+func GetShiftSafetyIncidents(c *gin.Context) {
+	shiftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return
+	}
+
+	incidents, err := querySafetyIncidents(`WHERE i.shift_id = $1 ORDER BY i.occurred_at ASC, i.id ASC`, shiftID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener incidentes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shift_id":  shiftID,
+		"incidents": incidents,
+		"total":     len(incidents),
+	})
+}
+
+func querySafetyIncidents(where string, args ...interface{}) ([]SafetyIncident, error) {
+	rows, err := db.Query(`
+		SELECT i.id, i.shift_id, i.driver_id, COALESCE(u.name, ''), i.moto_id, COALESCE(i.branch, ''),
+		       i.incident_type, i.severity, i.value, i.threshold, i.latitude, i.longitude, i.occurred_at
+		FROM safety_incidents i
+		LEFT JOIN users u ON u.id = i.driver_id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []SafetyIncident{}
+	for rows.Next() {
+		var i SafetyIncident
+		if err := rows.Scan(&i.ID, &i.ShiftID, &i.DriverID, &i.DriverName, &i.MotoID, &i.Branch,
+			&i.Type, &i.Severity, &i.Value, &i.Threshold, &i.Latitude, &i.Longitude, &i.OccurredAt); err != nil {
+			continue
+		}
+		incidents = append(incidents, i)
+	}
+	return incidents, nil
+}
+
+// GetSafetyReport resume los incidentes por driver en un período
+// (por defecto los últimos 7 días), filtrable por sucursal
+func GetSafetyReport(c *gin.Context) {
+	from := c.DefaultQuery("from", time.Now().AddDate(0, 0, -7).Format("2006-01-02"))
+	to := c.Query("to")
+	branch := c.Query("branch")
+
+	query := `
+		SELECT i.driver_id, COALESCE(u.name, ''),
+		       COUNT(DISTINCT i.shift_id) AS shifts,
+		       COUNT(*) AS total,
+		       COUNT(*) FILTER (WHERE i.incident_type = 'speeding') AS speeding,
+		       COUNT(*) FILTER (WHERE i.incident_type = 'harsh_acceleration') AS harsh_acceleration,
+		       COUNT(*) FILTER (WHERE i.incident_type = 'harsh_braking') AS harsh_braking,
+		       COUNT(*) FILTER (WHERE i.incident_type = 'night_riding') AS night_riding,
+		       COUNT(*) FILTER (WHERE i.severity = 'high') AS high_severity,
+		       COALESCE(MAX(i.value) FILTER (WHERE i.incident_type = 'speeding'), 0) AS max_speed_kmh,
+		       MAX(i.occurred_at) AS last_incident
+		FROM safety_incidents i
+		LEFT JOIN users u ON u.id = i.driver_id
+		WHERE i.occurred_at >= $1`
+	args := []interface{}{from}
+
+	if to != "" {
+		args = append(args, to)
+		query += " AND i.occurred_at < $" + strconv.Itoa(len(args))
+	}
+	if branch != "" {
+		args = append(args, branch)
+		query += " AND i.branch = $" + strconv.Itoa(len(args))
+	}
+	query += " GROUP BY i.driver_id, u.name ORDER BY high_severity DESC, total DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar reporte de seguridad"})
+		return
+	}
+	defer rows.Close()
+
+	type driverSafety struct {
+		DriverID          int       `json:"driver_id"`
+		DriverName        string    `json:"driver_name"`
+		Shifts            int       `json:"shifts_with_incidents"`
+		Total             int       `json:"total_incidents"`
+		Speeding          int       `json:"speeding"`
+		HarshAcceleration int       `json:"harsh_acceleration"`
+		HarshBraking      int       `json:"harsh_braking"`
+		NightRiding       int       `json:"night_riding"`
+		HighSeverity      int       `json:"high_severity"`
+		MaxSpeedKmh       float64   `json:"max_speed_kmh"`
+		LastIncident      time.Time `json:"last_incident"`
+	}
+
+	drivers := []driverSafety{}
+	for rows.Next() {
+		var d driverSafety
+		if err := rows.Scan(&d.DriverID, &d.DriverName, &d.Shifts, &d.Total, &d.Speeding,
+			&d.HarshAcceleration, &d.HarshBraking, &d.NightRiding, &d.HighSeverity,
+			&d.MaxSpeedKmh, &d.LastIncident); err != nil {
+			continue
+		}
+		drivers = append(drivers, d)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"branch":  branch,
+		"drivers": drivers,
+		"total":   len(drivers),
+	})
+}
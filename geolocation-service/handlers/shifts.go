@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/logitrack/geolocation-service/export"
+	"github.com/logitrack/geolocation-service/geofence"
+	"github.com/logitrack/geolocation-service/live"
 )
 
 type Shift struct {
@@ -37,19 +41,25 @@ type RoutePoint struct {
 	OrderID   *int       `json:"order_id,omitempty"`
 	Address   string     `json:"address,omitempty"`
 	CreatedAt time.Time  `json:"created_at"`
+
+	// Puntaje de GPS falso (solo en puntos recién registrados)
+	IntegrityScore *int     `json:"integrity_score,omitempty"`
+	IntegrityFlags []string `json:"integrity_flags,omitempty"`
 }
 
+// Las coordenadas son punteros para que binding:"required" acepte 0 (ecuador,
+// meridiano de Greenwich) y rechace solo la ausencia del campo
 type StartShiftRequest struct {
-	DriverID  int     `json:"driver_id" binding:"required"`
-	Branch    string  `json:"branch"`
-	Latitude  float64 `json:"latitude" binding:"required"`
-	Longitude float64 `json:"longitude" binding:"required"`
-	Address   string  `json:"address"`
+	DriverID  int      `json:"driver_id" binding:"required"`
+	Branch    string   `json:"branch"`
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+	Address   string   `json:"address"`
 }
 
 type AddRoutePointRequest struct {
-	Latitude  float64  `json:"latitude" binding:"required"`
-	Longitude float64  `json:"longitude" binding:"required"`
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
 	Accuracy  *float64 `json:"accuracy"`
 	Speed     *float64 `json:"speed"`
 	Heading   *float64 `json:"heading"`
@@ -60,9 +70,9 @@ type AddRoutePointRequest struct {
 }
 
 type EndShiftRequest struct {
-	Latitude  float64 `json:"latitude" binding:"required"`
-	Longitude float64 `json:"longitude" binding:"required"`
-	Notes     string  `json:"notes"`
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+	Notes     string   `json:"notes"`
 }
 
 // InitShiftHandlers inicializa los handlers con la conexión a BD
@@ -77,6 +87,10 @@ func StartShift(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
 		return
 	}
+	if !validCoordinates(req.Latitude, req.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errCoordinates.Error()})
+		return
+	}
 
 	// Verificar si el driver ya tiene un turno activo
 	var activeShiftCount int
@@ -126,7 +140,7 @@ func StartShift(c *gin.Context) {
 		INSERT INTO route_points (shift_id, latitude, longitude, point_type, address, timestamp)
 		VALUES ($1, $2, $3, 'START', $4, NOW())
 		RETURNING id, shift_id, latitude, longitude, timestamp, point_type, address, created_at
-	`, shift.ID, req.Latitude, req.Longitude, req.Address).Scan(
+	`, shift.ID, *req.Latitude, *req.Longitude, req.Address).Scan(
 		&routePoint.ID, &routePoint.ShiftID, &routePoint.Latitude,
 		&routePoint.Longitude, &routePoint.Timestamp, &routePoint.PointType,
 		&routePoint.Address, &routePoint.CreatedAt,
@@ -142,6 +156,13 @@ func StartShift(c *gin.Context) {
 		return
 	}
 
+	publishShiftEvent(live.EventShiftStarted, shiftContext{
+		ShiftID:  shift.ID,
+		DriverID: shift.DriverID,
+		MotoID:   motoIDForDriver(shift.DriverID),
+		Branch:   shift.Branch,
+	}, gin.H{"shift": shift, "start_point": routePoint})
+
 	c.JSON(http.StatusCreated, gin.H{
 		"shift":       shift,
 		"start_point": routePoint,
@@ -163,49 +184,36 @@ func AddRoutePoint(c *gin.Context) {
 		return
 	}
 
-	// Verificar que el turno existe y está activo
-	var status string
-	err = db.QueryRow("SELECT status FROM shifts WHERE id = $1", shiftID).Scan(&status)
-	if err == sql.ErrNoRows {
+	// Registrar la posición (última posición de la moto, historial y ruta del turno)
+	res, err := ingestPosition(PositionReport{
+		ShiftID:   &shiftID,
+		OrderID:   req.OrderID,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Accuracy:  req.Accuracy,
+		Speed:     req.Speed,
+		Heading:   req.Heading,
+		Altitude:  req.Altitude,
+		PointType: req.PointType,
+		Address:   req.Address,
+	})
+	switch err {
+	case nil:
+	case errShiftNotFound:
 		c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado"})
 		return
-	}
-	if status != "ACTIVE" {
+	case errShiftNotActive:
 		c.JSON(http.StatusConflict, gin.H{"error": "El turno no está activo"})
 		return
-	}
-
-	// Establecer tipo de punto por defecto
-	pointType := "TRACKING"
-	if req.PointType != "" {
-		pointType = req.PointType
-	}
-
-	// Insertar punto de ruta
-	var routePoint RoutePoint
-	err = db.QueryRow(`
-		INSERT INTO route_points (
-			shift_id, latitude, longitude, accuracy, speed, heading, altitude,
-			point_type, order_id, address, timestamp
-		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
-		RETURNING id, shift_id, latitude, longitude, accuracy, speed, heading, 
-				  altitude, timestamp, point_type, order_id, address, created_at
-	`, shiftID, req.Latitude, req.Longitude, req.Accuracy, req.Speed,
-		req.Heading, req.Altitude, pointType, req.OrderID, req.Address).Scan(
-		&routePoint.ID, &routePoint.ShiftID, &routePoint.Latitude,
-		&routePoint.Longitude, &routePoint.Accuracy, &routePoint.Speed,
-		&routePoint.Heading, &routePoint.Altitude, &routePoint.Timestamp,
-		&routePoint.PointType, &routePoint.OrderID, &routePoint.Address,
-		&routePoint.CreatedAt,
-	)
-
-	if err != nil {
+	case errCoordinates:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar punto de ruta"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, routePoint)
+	c.JSON(http.StatusCreated, res.RoutePoint)
 }
 
 // EndShift finaliza un turno
@@ -221,6 +229,10 @@ func EndShift(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
 		return
 	}
+	if !validCoordinates(req.Latitude, req.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errCoordinates.Error()})
+		return
+	}
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -233,7 +245,7 @@ func EndShift(c *gin.Context) {
 	_, err = tx.Exec(`
 		INSERT INTO route_points (shift_id, latitude, longitude, point_type, timestamp)
 		VALUES ($1, $2, $3, 'END', NOW())
-	`, shiftID, req.Latitude, req.Longitude)
+	`, shiftID, *req.Latitude, *req.Longitude)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear punto final"})
@@ -271,13 +283,30 @@ func EndShift(c *gin.Context) {
 		return
 	}
 
+	sc := shiftContext{
+		ShiftID:  shift.ID,
+		DriverID: shift.DriverID,
+		MotoID:   motoIDForDriver(shift.DriverID),
+		Branch:   shift.Branch,
+	}
+
+	// Cerrar las geocercas abiertas del turno
+	closeGeofence(sc, geofence.Point{
+		Latitude:  *req.Latitude,
+		Longitude: *req.Longitude,
+		Timestamp: time.Now(),
+	})
+	closeSafety(shift.ID)
+	publishShiftEvent(live.EventShiftEnded, sc, gin.H{"shift": shift})
+
 	c.JSON(http.StatusOK, gin.H{
 		"shift":   shift,
 		"message": "Turno finalizado exitosamente",
 	})
 }
 
-// GetShiftRoute obtiene todos los puntos de ruta de un turno
+// GetShiftRoute obtiene todos los puntos de ruta de un turno.
+// Con ?format=gpx|kml|geojson descarga el recorrido para herramientas GIS.
 func GetShiftRoute(c *gin.Context) {
 	shiftID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -285,6 +314,28 @@ func GetShiftRoute(c *gin.Context) {
 		return
 	}
 
+	if format := c.Query("format"); format != "" && format != "json" {
+		if !export.IsSupported(format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": export.ErrUnknownFormat.Error()})
+			return
+		}
+		var shift Shift
+		err := db.QueryRow(`
+			SELECT id, driver_id, branch, start_time, end_time
+			FROM shifts WHERE id = $1
+		`, shiftID).Scan(&shift.ID, &shift.DriverID, &shift.Branch, &shift.StartTime, &shift.EndTime)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener turno"})
+			return
+		}
+		streamShiftExport(c, format, []Shift{shift}, "turno-"+strconv.Itoa(shiftID))
+		return
+	}
+
 	rows, err := db.Query(`
 		SELECT id, shift_id, latitude, longitude, accuracy, speed, heading, altitude,
 		       timestamp, point_type, order_id, address, created_at
@@ -321,7 +372,8 @@ func GetShiftRoute(c *gin.Context) {
 	})
 }
 
-// GetDriverShifts obtiene todos los turnos de un driver
+// GetDriverShifts obtiene todos los turnos de un driver.
+// Con ?format=gpx|kml|geojson&from=&to= exporta los recorridos del rango.
 func GetDriverShifts(c *gin.Context) {
 	driverID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -333,6 +385,22 @@ func GetDriverShifts(c *gin.Context) {
 	status := c.Query("status")
 	branch := c.Query("branch")
 	limit := c.DefaultQuery("limit", "50")
+	format := c.Query("format")
+	if format == "json" {
+		format = ""
+	}
+
+	var from, to time.Time
+	if format != "" {
+		if !export.IsSupported(format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": export.ErrUnknownFormat.Error()})
+			return
+		}
+		if from, to, err = exportDateRange(c); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	query := `
 		SELECT id, driver_id, branch, start_time, end_time, status,
@@ -355,8 +423,15 @@ func GetDriverShifts(c *gin.Context) {
 		argIdx++
 	}
 
-	query += " ORDER BY start_time DESC LIMIT $" + strconv.Itoa(argIdx)
-	args = append(args, limit)
+	// Exportación: todos los turnos del rango en orden cronológico
+	if format != "" {
+		query += " AND start_time >= $" + strconv.Itoa(argIdx) + " AND start_time < $" + strconv.Itoa(argIdx+1)
+		query += " ORDER BY start_time ASC"
+		args = append(args, from, to)
+	} else {
+		query += " ORDER BY start_time DESC LIMIT $" + strconv.Itoa(argIdx)
+		args = append(args, limit)
+	}
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -379,6 +454,12 @@ func GetDriverShifts(c *gin.Context) {
 		shifts = append(shifts, shift)
 	}
 
+	if format != "" {
+		filename := fmt.Sprintf("driver-%d-%s-%s", driverID, from.Format("20060102"), to.Add(-time.Second).Format("20060102"))
+		streamShiftExport(c, format, shifts, filename)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"driver_id": driverID,
 		"shifts":    shifts,
@@ -394,7 +475,7 @@ func GetActiveShifts(c *gin.Context) {
 		SELECT s.id, s.driver_id, s.branch, s.start_time, s.status,
 		       s.total_distance_km, s.total_deliveries, s.created_at, s.updated_at,
 		       u.name as driver_name, u.email as driver_email,
-		       COUNT(rp.id) as total_points
+		       COUNT(rp.id) as total_points, s.gps_suspicious_points
 		FROM shifts s
 		INNER JOIN users u ON s.driver_id = u.id
 		LEFT JOIN route_points rp ON s.id = rp.shift_id
@@ -421,6 +502,8 @@ func GetActiveShifts(c *gin.Context) {
 		DriverName  string `json:"driver_name"`
 		DriverEmail string `json:"driver_email"`
 		TotalPoints int    `json:"total_points"`
+		// Puntos con posible GPS falso (ver /shifts/:id/integrity)
+		GPSSuspiciousPoints int `json:"gps_suspicious_points"`
 	}
 
 	var shifts []ActiveShiftInfo
@@ -430,7 +513,7 @@ func GetActiveShifts(c *gin.Context) {
 			&shift.ID, &shift.DriverID, &shift.Branch, &shift.StartTime,
 			&shift.Status, &shift.TotalDistanceKm, &shift.TotalDeliveries,
 			&shift.CreatedAt, &shift.UpdatedAt, &shift.DriverName,
-			&shift.DriverEmail, &shift.TotalPoints,
+			&shift.DriverEmail, &shift.TotalPoints, &shift.GPSSuspiciousPoints,
 		)
 		if err != nil {
 			continue
@@ -438,6 +521,8 @@ func GetActiveShifts(c *gin.Context) {
 		shifts = append(shifts, shift)
 	}
 
+	// Paradas y tiempo ocioso: GET /shifts/:id/summary (analizar cada turno aquí
+	// recorrería todos los puntos de todos los turnos activos)
 	c.JSON(http.StatusOK, gin.H{
 		"active_shifts": shifts,
 		"total":         len(shifts),
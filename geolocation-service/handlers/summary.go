@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/geolocation-service/tracks"
+)
+
+var (
+	stopConfig       = tracks.DefaultConfig
+	stopBranchRadius = 150.0 // metros alrededor de la sucursal para etiquetar paradas
+)
+
+// InitTracks configura la detección de paradas a partir de variables de entorno
+func InitTracks() {
+	if v, err := strconv.ParseFloat(os.Getenv("STOP_RADIUS_M"), 64); err == nil && v > 0 {
+		stopConfig.StopRadiusMeters = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("STOP_MIN_SECONDS")); err == nil && v > 0 {
+		stopConfig.MinStopDuration = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("STOP_MOVING_SPEED_MS"), 64); err == nil && v > 0 {
+		stopConfig.MovingSpeed = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("STOP_BRANCH_RADIUS_M"), 64); err == nil && v > 0 {
+		stopBranchRadius = v
+	}
+}
+
+// loadShiftTrack obtiene los puntos del turno ordenados por tiempo
+func loadShiftTrack(shiftID int) ([]tracks.Point, error) {
+	rows, err := db.Query(`
+		SELECT latitude, longitude, speed, timestamp
+		FROM route_points
+		WHERE shift_id = $1
+		ORDER BY timestamp ASC, id ASC
+	`, shiftID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []tracks.Point
+	for rows.Next() {
+		var p tracks.Point
+		if err := rows.Scan(&p.Latitude, &p.Longitude, &p.Speed, &p.Timestamp); err != nil {
+			continue
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// loadStopPlaces obtiene los lugares conocidos para etiquetar paradas: sucursales
+// activas y destinos de los pedidos del turno (entregados, detectados por
+// geocerca o asignados actualmente a la moto del driver)
+func loadStopPlaces(shiftID, driverID int) ([]tracks.Place, error) {
+	var places []tracks.Place
+
+	rows, err := db.Query(`SELECT id, name, latitude, longitude FROM branches WHERE is_active = true`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		p := tracks.Place{Label: tracks.LabelBranch, RadiusMeters: stopBranchRadius}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Latitude, &p.Longitude); err != nil {
+			continue
+		}
+		places = append(places, p)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT o.id, COALESCE(o.address, ''), o.latitude, o.longitude
+		FROM orders o
+		WHERE o.latitude IS NOT NULL AND o.longitude IS NOT NULL
+		  AND (
+		      o.id IN (SELECT order_id FROM route_points WHERE shift_id = $1 AND order_id IS NOT NULL)
+		   OR o.id IN (SELECT zone_id FROM geofence_events WHERE shift_id = $1 AND zone_type = 'delivery')
+		   OR o.assigned_moto_id IN (SELECT id FROM motos WHERE driver_id = $2)
+		  )
+	`, shiftID, driverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := tracks.Place{Label: tracks.LabelDelivery, RadiusMeters: deliveryGeofenceRadius}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Latitude, &p.Longitude); err != nil {
+			continue
+		}
+		places = append(places, p)
+	}
+
+	return places, nil
+}
+
+// analyzeShift segmenta el recorrido del turno y etiqueta sus paradas
+func analyzeShift(shiftID, driverID int) ([]tracks.Segment, tracks.Summary, error) {
+	points, err := loadShiftTrack(shiftID)
+	if err != nil {
+		return nil, tracks.Summary{}, err
+	}
+	places, err := loadStopPlaces(shiftID, driverID)
+	if err != nil {
+		return nil, tracks.Summary{}, err
+	}
+
+	segments := tracks.Segmentize(points, stopConfig)
+	tracks.LabelStops(segments, places)
+	return segments, tracks.Summarize(segments), nil
+}
+
+// GetShiftSummary devuelve los intervalos en movimiento y detenidos del turno,
+// las paradas etiquetadas (entrega, sucursal o desconocida) y el tiempo ocioso
+func GetShiftSummary(c *gin.Context) {
+	shiftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return
+	}
+
+	var shift Shift
+	var notes sql.NullString
+	err = db.QueryRow(`
+		SELECT id, driver_id, branch, start_time, end_time, status,
+		       total_distance_km, total_deliveries, notes, created_at, updated_at
+		FROM shifts WHERE id = $1
+	`, shiftID).Scan(
+		&shift.ID, &shift.DriverID, &shift.Branch, &shift.StartTime,
+		&shift.EndTime, &shift.Status, &shift.TotalDistanceKm,
+		&shift.TotalDeliveries, &notes, &shift.CreatedAt, &shift.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener turno"})
+		return
+	}
+	shift.Notes = notes.String
+
+	segments, summary, err := analyzeShift(shift.ID, shift.DriverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al analizar recorrido"})
+		return
+	}
+	if segments == nil {
+		segments = []tracks.Segment{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shift":        shift,
+		"summary":      summary,
+		"idle_minutes": summary.IdleSeconds / 60,
+		"stops":        tracks.Stops(segments),
+		"segments":     segments,
+		"config": gin.H{
+			"stop_radius_m":     stopConfig.StopRadiusMeters,
+			"min_stop_seconds":  int(stopConfig.MinStopDuration.Seconds()),
+			"moving_speed_ms":   stopConfig.MovingSpeed,
+			"branch_radius_m":   stopBranchRadius,
+			"delivery_radius_m": deliveryGeofenceRadius,
+		},
+	})
+}
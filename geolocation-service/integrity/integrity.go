@@ -0,0 +1,155 @@
+package integrity
+
+import (
+	"math"
+	"time"
+
+	"github.com/logitrack/geolocation-service/geo"
+)
+
+// Señales de posible GPS falso
+const (
+	FlagTeleport          = "teleport"            // Salto imposible respecto al punto anterior
+	FlagFrozenCoordinates = "frozen_coordinates"  // Coordenadas idénticas repetidas
+	FlagImpossibleAcc     = "impossible_accuracy" // Precisión fuera del rango físico de un teléfono
+	FlagOutOfOrder        = "timestamp_out_of_order"
+	FlagFutureTimestamp   = "timestamp_in_future"
+	FlagSpeedMismatch     = "speed_mismatch" // Velocidad reportada vs. calculada
+)
+
+// weights puntaje que suma cada señal (el total se limita a 100)
+var weights = map[string]int{
+	FlagTeleport:          60,
+	FlagFrozenCoordinates: 40,
+	FlagImpossibleAcc:     30,
+	FlagOutOfOrder:        30,
+	FlagFutureTimestamp:   30,
+	FlagSpeedMismatch:     25,
+}
+
+// Fix es una lectura GPS. Speed en m/s y Accuracy en metros (como los reporta el navegador).
+type Fix struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Accuracy  *float64  `json:"accuracy,omitempty"`
+	Speed     *float64  `json:"speed,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Config umbrales de las heurísticas
+type Config struct {
+	// MaxSpeed velocidad máxima plausible para una moto (m/s)
+	MaxSpeed float64
+	// TeleportMinMeters distancia mínima para considerar un salto (ignora el ruido del GPS)
+	TeleportMinMeters float64
+	// FrozenRepeats lecturas consecutivas idénticas para marcar coordenadas congeladas
+	FrozenRepeats int
+	// MinAccuracy y MaxAccuracy rango plausible de precisión (metros)
+	MinAccuracy float64
+	MaxAccuracy float64
+	// SpeedMismatch diferencia tolerada entre velocidad reportada y calculada (m/s)
+	SpeedMismatch float64
+	// MaxClockSkew tolerancia de reloj del dispositivo hacia el futuro
+	MaxClockSkew time.Duration
+	// SuspiciousScore puntaje a partir del cual la lectura es sospechosa
+	SuspiciousScore int
+}
+
+// DefaultConfig valores por defecto para motos en ciudad
+var DefaultConfig = Config{
+	MaxSpeed:          50, // 180 km/h
+	TeleportMinMeters: 300,
+	FrozenRepeats:     10,
+	MinAccuracy:       1,
+	MaxAccuracy:       100000,
+	SpeedMismatch:     10,
+	MaxClockSkew:      2 * time.Minute,
+	SuspiciousScore:   50,
+}
+
+// La velocidad calculada solo se compara con la reportada entre lecturas
+// cercanas; con huecos mayores el promedio no representa la velocidad real
+const (
+	minSpeedInterval = 2 * time.Second
+	maxSpeedInterval = 60 * time.Second
+)
+
+// Result puntaje de una lectura: 0 es limpia, 100 casi seguro falsa
+type Result struct {
+	Score      int      `json:"score"`
+	Suspicious bool     `json:"suspicious"`
+	Flags      []string `json:"flags"`
+}
+
+// Assess puntúa una lectura contra las anteriores del mismo dispositivo,
+// ordenadas por llegada de la más reciente a la más antigua.
+func Assess(fix Fix, history []Fix, now time.Time, cfg Config) Result {
+	var flags []string
+
+	// 1. Precisión imposible (los simuladores suelen reportar 0 o valores negativos)
+	if fix.Accuracy != nil {
+		acc := *fix.Accuracy
+		if math.IsNaN(acc) || acc < cfg.MinAccuracy || acc > cfg.MaxAccuracy {
+			flags = append(flags, FlagImpossibleAcc)
+		}
+	}
+
+	// 2. Reloj del dispositivo adelantado
+	if !fix.Timestamp.IsZero() && fix.Timestamp.After(now.Add(cfg.MaxClockSkew)) {
+		flags = append(flags, FlagFutureTimestamp)
+	}
+
+	if len(history) > 0 {
+		prev := history[0]
+		dt := fix.Timestamp.Sub(prev.Timestamp)
+		dist := geo.HaversineMeters(prev.Latitude, prev.Longitude, fix.Latitude, fix.Longitude)
+
+		// 3. Lectura anterior a la ya recibida
+		if dt < 0 {
+			flags = append(flags, FlagOutOfOrder)
+		}
+
+		// 4. Salto imposible
+		if dist > cfg.TeleportMinMeters && (dt <= 0 || dist/dt.Seconds() > cfg.MaxSpeed) {
+			flags = append(flags, FlagTeleport)
+		}
+
+		// 5. Velocidad reportada incoherente con el desplazamiento
+		if fix.Speed != nil && *fix.Speed >= 0 {
+			reported := *fix.Speed
+			mismatch := reported > cfg.MaxSpeed
+			if dt >= minSpeedInterval && dt <= maxSpeedInterval {
+				computed := dist / dt.Seconds()
+				mismatch = mismatch || math.Abs(reported-computed) > cfg.SpeedMismatch
+			}
+			if mismatch {
+				flags = append(flags, FlagSpeedMismatch)
+			}
+		}
+
+		// 6. Coordenadas congeladas: un GPS real siempre tiene ruido
+		repeats := 1
+		for _, h := range history {
+			if h.Latitude != fix.Latitude || h.Longitude != fix.Longitude {
+				break
+			}
+			repeats++
+		}
+		if cfg.FrozenRepeats > 0 && repeats >= cfg.FrozenRepeats {
+			flags = append(flags, FlagFrozenCoordinates)
+		}
+	}
+
+	res := Result{Flags: flags}
+	if res.Flags == nil {
+		res.Flags = []string{}
+	}
+	for _, f := range flags {
+		res.Score += weights[f]
+	}
+	if res.Score > 100 {
+		res.Score = 100
+	}
+	res.Suspicious = res.Score >= cfg.SuspiciousScore
+	return res
+}
@@ -0,0 +1,137 @@
+package integrity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func f(v float64) *float64 { return &v }
+
+// fix lectura desplazada dLat grados (0.001° ≈ 111 m) y seconds después de now-10m
+func fix(dLat float64, seconds int) Fix {
+	return Fix{Latitude: 14.6 + dLat, Longitude: -90.5, Timestamp: now.Add(-10*time.Minute + time.Duration(seconds)*time.Second)}
+}
+
+func with(x Fix, modify func(*Fix)) Fix {
+	modify(&x)
+	return x
+}
+
+func TestAssess(t *testing.T) {
+	prev := fix(0, 0)
+	frozen := make([]Fix, 9)
+	for i := range frozen {
+		frozen[i] = fix(0, -10*(i+1))
+	}
+
+	tests := []struct {
+		name       string
+		fix        Fix
+		history    []Fix
+		flags      []string
+		score      int
+		suspicious bool
+	}{
+		{
+			name:    "lectura limpia",
+			fix:     with(fix(0.001, 10), func(x *Fix) { x.Accuracy, x.Speed = f(8), f(11) }),
+			history: []Fix{prev},
+			flags:   []string{},
+		},
+		{
+			name:  "primera lectura sin historial",
+			fix:   fix(0, 0),
+			flags: []string{},
+		},
+		{
+			name:  "precisión cero",
+			fix:   with(fix(0, 0), func(x *Fix) { x.Accuracy = f(0) }),
+			flags: []string{FlagImpossibleAcc},
+			score: 30,
+		},
+		{
+			name:  "reloj adelantado",
+			fix:   Fix{Latitude: 14.6, Longitude: -90.5, Timestamp: now.Add(5 * time.Minute)},
+			flags: []string{FlagFutureTimestamp},
+			score: 30,
+		},
+		{
+			name:    "lectura anterior a la recibida",
+			fix:     fix(0, -10),
+			history: []Fix{prev},
+			flags:   []string{FlagOutOfOrder},
+			score:   30,
+		},
+		{
+			name:       "salto imposible",
+			fix:        fix(0.05, 10),
+			history:    []Fix{prev},
+			flags:      []string{FlagTeleport},
+			score:      60,
+			suspicious: true,
+		},
+		{
+			name:       "salto con la misma marca de tiempo",
+			fix:        fix(0.01, 0),
+			history:    []Fix{prev},
+			flags:      []string{FlagTeleport},
+			score:      60,
+			suspicious: true,
+		},
+		{
+			name:    "ruido del GPS bajo la distancia mínima",
+			fix:     fix(0.002, 1),
+			history: []Fix{prev},
+			flags:   []string{},
+		},
+		{
+			name:    "velocidad reportada incoherente",
+			fix:     with(fix(0.001, 10), func(x *Fix) { x.Speed = f(30) }),
+			history: []Fix{prev},
+			flags:   []string{FlagSpeedMismatch},
+			score:   25,
+		},
+		{
+			name:    "velocidad reportada imposible con hueco largo",
+			fix:     with(fix(0.001, 300), func(x *Fix) { x.Speed = f(60) }),
+			history: []Fix{prev},
+			flags:   []string{FlagSpeedMismatch},
+			score:   25,
+		},
+		{
+			name:    "coordenadas congeladas",
+			fix:     fix(0, 0),
+			history: frozen,
+			flags:   []string{FlagFrozenCoordinates},
+			score:   40,
+		},
+		{
+			name:    "coordenadas repetidas bajo el umbral",
+			fix:     fix(0, 0),
+			history: frozen[:7],
+			flags:   []string{},
+		},
+		{
+			name:       "puntaje limitado a 100",
+			fix:        with(fix(0.05, -10), func(x *Fix) { x.Accuracy = f(-1) }),
+			history:    []Fix{prev},
+			flags:      []string{FlagImpossibleAcc, FlagOutOfOrder, FlagTeleport},
+			score:      100,
+			suspicious: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Assess(tt.fix, tt.history, now, DefaultConfig)
+			if !reflect.DeepEqual(res.Flags, tt.flags) {
+				t.Errorf("Flags = %v, se esperaba %v", res.Flags, tt.flags)
+			}
+			if res.Score != tt.score || res.Suspicious != tt.suspicious {
+				t.Errorf("Score = %d, Suspicious = %v; se esperaba %d, %v", res.Score, res.Suspicious, tt.score, tt.suspicious)
+			}
+		})
+	}
+}
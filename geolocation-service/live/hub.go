@@ -0,0 +1,185 @@
+package live
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Tipos de evento publicados a los clientes
+const (
+	EventPosition     = "position"
+	EventShiftStarted = "shift_started"
+	EventShiftEnded   = "shift_ended"
+	EventGeofence     = "geofence"
+	EventSafetyAlert  = "safety_alert"
+)
+
+// clientBuffer es la cantidad de eventos pendientes por cliente antes de
+// descartar (un cliente lento no debe bloquear la ingesta de GPS)
+const clientBuffer = 64
+
+// ErrForbiddenBranch se devuelve al suscribirse a una sucursal fuera del alcance
+var ErrForbiddenBranch = errors.New("sin acceso a la sucursal solicitada")
+
+// Event es un mensaje del canal en vivo
+type Event struct {
+	Type      string      `json:"type"`
+	Branch    string      `json:"branch,omitempty"`
+	MotoID    *int        `json:"moto_id,omitempty"`
+	DriverID  *int        `json:"driver_id,omitempty"`
+	ShiftID   *int        `json:"shift_id,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Scope define qué eventos puede recibir un cliente según su rol
+type Scope struct {
+	AllBranches bool   // admin, manager, coordinator
+	Branch      string // supervisor/operator: solo su sucursal
+	DriverID    int    // driver: solo sus propios eventos (0 = no aplica)
+}
+
+func (s Scope) allows(ev Event) bool {
+	if s.DriverID != 0 {
+		return ev.DriverID != nil && *ev.DriverID == s.DriverID
+	}
+	if s.AllBranches {
+		return true
+	}
+	return ev.Branch != "" && ev.Branch == s.Branch
+}
+
+// Client es una conexión suscrita al hub (WebSocket o SSE)
+type Client struct {
+	scope Scope
+	send  chan Event
+
+	mu       sync.RWMutex
+	motos    map[int]bool
+	branches map[string]bool
+}
+
+// Events devuelve el canal de eventos del cliente; se cierra al desregistrarlo
+func (c *Client) Events() <-chan Event {
+	return c.send
+}
+
+// Subscribe agrega una moto o una sucursal a las suscripciones del cliente.
+// Sin suscripciones el cliente recibe todo lo que permite su alcance.
+func (c *Client) Subscribe(motoID int, branch string) error {
+	if branch != "" && !c.scope.AllBranches && c.scope.DriverID == 0 && branch != c.scope.Branch {
+		return ErrForbiddenBranch
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if motoID > 0 {
+		c.motos[motoID] = true
+	}
+	if branch != "" {
+		c.branches[branch] = true
+	}
+	return nil
+}
+
+// Unsubscribe quita una moto o una sucursal de las suscripciones
+func (c *Client) Unsubscribe(motoID int, branch string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.motos, motoID)
+	delete(c.branches, branch)
+}
+
+// Subscriptions devuelve las motos y sucursales suscritas
+func (c *Client) Subscriptions() ([]int, []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	motos := make([]int, 0, len(c.motos))
+	for id := range c.motos {
+		motos = append(motos, id)
+	}
+	branches := make([]string, 0, len(c.branches))
+	for b := range c.branches {
+		branches = append(branches, b)
+	}
+	return motos, branches
+}
+
+func (c *Client) wants(ev Event) bool {
+	if !c.scope.allows(ev) {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.motos) == 0 && len(c.branches) == 0 {
+		return true
+	}
+	if ev.MotoID != nil && c.motos[*ev.MotoID] {
+		return true
+	}
+	return ev.Branch != "" && c.branches[ev.Branch]
+}
+
+// Hub distribuye eventos a los clientes conectados
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+}
+
+// NewHub crea un hub vacío
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*Client]struct{})}
+}
+
+// Register conecta un nuevo cliente con el alcance indicado
+func (h *Hub) Register(scope Scope) *Client {
+	c := &Client{
+		scope:    scope,
+		send:     make(chan Event, clientBuffer),
+		motos:    make(map[int]bool),
+		branches: make(map[string]bool),
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+// Unregister desconecta el cliente y cierra su canal
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// Publish envía el evento a los clientes interesados sin bloquear.
+// Si el buffer de un cliente está lleno el evento se descarta para ese cliente.
+func (h *Hub) Publish(ev Event) {
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if !c.wants(ev) {
+			continue
+		}
+		select {
+		case c.send <- ev:
+		default:
+		}
+	}
+}
+
+// Count devuelve el número de clientes conectados
+func (h *Hub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
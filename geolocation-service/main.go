@@ -28,6 +28,11 @@ func initDB() {
 func main() {
 	initDB()
 	handlers.InitShiftHandlers(db) // Inicializar handlers de turnos
+	handlers.InitGeofence()        // Motor de geocercas
+	handlers.InitTracks()          // Detección de paradas
+	handlers.InitSafety()          // Reglas de conducción segura
+	handlers.InitIntegrity()       // Detección de GPS falso
+	handlers.InitRetention()       // Particiones, retención y reducción del historial GPS
 
 	// Inicializar logger estructurado
 	logging.InitLogger("geolocation-service")
@@ -49,6 +54,14 @@ func main() {
 	r.GET("/locations", handlers.GetLocations)
 	r.GET("/locations/motos/latest", handlers.GetLatestLocationsByMoto)
 
+	// Ingesta unificada de posiciones y última posición por moto
+	positions := r.Group("/positions")
+	{
+		positions.POST("", handlers.IngestPosition)           // Reporte GPS (moto, driver o turno)
+		positions.GET("/motos", handlers.GetMotoPositions)    // Última posición + antigüedad
+		positions.GET("/motos/:id", handlers.GetMotoPosition) // Última posición de una moto
+	}
+
 	// Rutas de turnos (nuevas)
 	shifts := r.Group("/shifts")
 	{
@@ -57,14 +70,53 @@ func main() {
 		shifts.POST("/:id/end", handlers.EndShift)        // Finalizar turno
 		shifts.GET("/:id/route", handlers.GetShiftRoute)  // Obtener ruta completa
 		shifts.GET("/active", handlers.GetActiveShifts)   // Turnos activos (supervisores)
+		shifts.GET("/:id/geofence-events", handlers.GetShiftGeofenceEvents)
+		shifts.GET("/:id/summary", handlers.GetShiftSummary) // Paradas y tiempo ocioso
+		shifts.GET("/:id/safety-incidents", handlers.GetShiftSafetyIncidents)
+		shifts.GET("/:id/integrity", handlers.GetShiftIntegrity) // Puntos con posible GPS falso
+	}
+
+	// Rutas de geocercas
+	geofences := r.Group("/geofence")
+	{
+		geofences.GET("/events", handlers.GetGeofenceEvents)                 // Auditoría de eventos
+		geofences.GET("/kpis/customer-dwell", handlers.GetCustomerDwellKPIs) // Tiempo en destino
+	}
+
+	// Rutas de conducción segura
+	safetyRoutes := r.Group("/safety")
+	{
+		safetyRoutes.GET("/settings", handlers.GetSafetySettings)               // Umbrales por sucursal
+		safetyRoutes.PUT("/settings/:branch_id", handlers.UpdateSafetySettings) // Actualizar umbrales
+		safetyRoutes.GET("/incidents", handlers.GetSafetyIncidents)             // Incidentes con filtros
+		safetyRoutes.GET("/report", handlers.GetSafetyReport)                   // Resumen por driver
+	}
+
+	// Mantenimiento del historial GPS (particiones, retención, reducción de resolución)
+	maintenance := r.Group("/maintenance/gps")
+	{
+		maintenance.GET("/policies", handlers.GetRetentionPolicies)
+		maintenance.PUT("/policies/:table", handlers.UpdateRetentionPolicy)
+		maintenance.POST("/run", handlers.RunGPSMaintenance) // Endpoint para cron
 	}
 
+	// Puntaje de integridad de una lectura GPS (check-ins, pruebas de entrega)
+	r.POST("/integrity/score", handlers.ScoreFix)
+
 	// Rutas de drivers
 	drivers := r.Group("/drivers")
 	{
 		drivers.GET("/:id/shifts", handlers.GetDriverShifts) // Historial de turnos
 	}
 
+	// Canal en vivo de la flota (posiciones, turnos y geocercas)
+	liveRoutes := r.Group("/live")
+	{
+		liveRoutes.GET("/ws", handlers.LiveWebSocket) // WebSocket con suscripciones
+		liveRoutes.GET("/events", handlers.LiveSSE)   // Server-Sent Events (fallback)
+		liveRoutes.GET("/stats", handlers.GetLiveStats)
+	}
+
 	// Endpoint de métricas Prometheus
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Location struct {
 	ID       int     `json:"id"`
 	OrderID  *int    `json:"order_id"`
@@ -7,4 +9,5 @@ type Location struct {
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
 	Type     string  `json:"type"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
 }
@@ -0,0 +1,232 @@
+package safety
+
+import (
+	"sync"
+	"time"
+
+	"github.com/logitrack/geolocation-service/geo"
+)
+
+// Tipos de incidente
+const (
+	IncidentSpeeding          = "speeding"
+	IncidentHarshAcceleration = "harsh_acceleration"
+	IncidentHarshBraking      = "harsh_braking"
+	IncidentNightRiding       = "night_riding"
+)
+
+// Severidades
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Rules umbrales de conducción segura (configurables por sucursal)
+type Rules struct {
+	SpeedLimitKmh  float64 `json:"speed_limit_kmh"`
+	HarshAccelMS2  float64 `json:"harsh_accel_ms2"`
+	HarshBrakeMS2  float64 `json:"harsh_brake_ms2"`
+	NightStartHour int     `json:"night_start_hour"` // 0-23, hora local
+	NightEndHour   int     `json:"night_end_hour"`
+}
+
+// DefaultRules valores por defecto para motos en ciudad
+var DefaultRules = Rules{
+	SpeedLimitKmh:  60,
+	HarshAccelMS2:  3.0,
+	HarshBrakeMS2:  3.5,
+	NightStartHour: 22,
+	NightEndHour:   5,
+}
+
+// Sample es un punto GPS evaluado. Speed en m/s (como lo reporta el navegador).
+type Sample struct {
+	Latitude  float64
+	Longitude float64
+	Speed     *float64
+	Timestamp time.Time
+}
+
+// Incident es una infracción detectada
+type Incident struct {
+	Type      string    `json:"incident_type"`
+	Severity  string    `json:"severity"`
+	Value     float64   `json:"value"`     // km/h, m/s² u hora local según el tipo
+	Threshold float64   `json:"threshold"` // Umbral que se superó
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"occurred_at"`
+}
+
+// Las aceleraciones solo se calculan entre puntos cercanos en el tiempo;
+// con huecos mayores la diferencia de velocidad no es confiable
+const (
+	minAccelInterval = 1 * time.Second
+	maxAccelInterval = 15 * time.Second
+)
+
+// speedingRelease fracción del límite bajo la cual termina un episodio de exceso
+const speedingRelease = 0.9
+
+// State estado de un turno entre puntos consecutivos
+type State struct {
+	last          *Sample
+	lastSpeed     float64 // m/s
+	speeding      bool
+	nightReported bool
+}
+
+// Restore reconstruye el estado desde la BD tras un reinicio
+func (s *State) Restore(last *Sample, speeding, nightReported bool) {
+	s.last = last
+	s.speeding = speeding
+	s.nightReported = nightReported
+	if last != nil && last.Speed != nil {
+		s.lastSpeed = *last.Speed
+	}
+}
+
+// Engine evalúa las reglas punto a punto
+type Engine struct {
+	Location *time.Location // Zona horaria para la regla nocturna
+}
+
+// Evaluate compara el punto con el anterior del turno y devuelve los incidentes.
+// Un exceso de velocidad sostenido genera un único incidente por episodio y la
+// conducción nocturna uno por turno.
+func (e Engine) Evaluate(s *State, rules Rules, p Sample) []Incident {
+	var incidents []Incident
+
+	// Velocidad reportada o derivada de la distancia al punto anterior
+	speed := -1.0
+	if p.Speed != nil && *p.Speed >= 0 {
+		speed = *p.Speed
+	}
+	var dt time.Duration
+	if s.last != nil {
+		dt = p.Timestamp.Sub(s.last.Timestamp)
+		if speed < 0 && dt > 0 {
+			d := geo.HaversineMeters(s.last.Latitude, s.last.Longitude, p.Latitude, p.Longitude)
+			speed = d / dt.Seconds()
+		}
+	}
+
+	// 1. Exceso de velocidad
+	if speed >= 0 && rules.SpeedLimitKmh > 0 {
+		kmh := speed * 3.6
+		switch {
+		case !s.speeding && kmh > rules.SpeedLimitKmh:
+			s.speeding = true
+			incidents = append(incidents, e.incident(IncidentSpeeding,
+				severityFor(kmh/rules.SpeedLimitKmh, 1.2, 1.4), kmh, rules.SpeedLimitKmh, p))
+		case s.speeding && kmh < rules.SpeedLimitKmh*speedingRelease:
+			s.speeding = false
+		}
+	}
+
+	// 2. Aceleración y frenado bruscos
+	if s.last != nil && speed >= 0 && dt >= minAccelInterval && dt <= maxAccelInterval {
+		accel := (speed - s.lastSpeed) / dt.Seconds()
+		switch {
+		case rules.HarshAccelMS2 > 0 && accel > rules.HarshAccelMS2:
+			incidents = append(incidents, e.incident(IncidentHarshAcceleration,
+				severityFor(accel/rules.HarshAccelMS2, 1.5, 2), accel, rules.HarshAccelMS2, p))
+		case rules.HarshBrakeMS2 > 0 && -accel > rules.HarshBrakeMS2:
+			incidents = append(incidents, e.incident(IncidentHarshBraking,
+				severityFor(-accel/rules.HarshBrakeMS2, 1.5, 2), -accel, rules.HarshBrakeMS2, p))
+		}
+	}
+
+	// 3. Conducción nocturna (solo si la moto está en movimiento)
+	if !s.nightReported && speed > 1 && e.isNight(rules, p.Timestamp) {
+		s.nightReported = true
+		hour := float64(p.Timestamp.In(e.location()).Hour())
+		incidents = append(incidents, e.incident(IncidentNightRiding, SeverityLow,
+			hour, float64(rules.NightStartHour), p))
+	}
+
+	last := p
+	s.last = &last
+	if speed >= 0 {
+		s.lastSpeed = speed
+	}
+	return incidents
+}
+
+func (e Engine) location() *time.Location {
+	if e.Location != nil {
+		return e.Location
+	}
+	return time.UTC
+}
+
+func (e Engine) isNight(rules Rules, t time.Time) bool {
+	if rules.NightStartHour == rules.NightEndHour {
+		return false
+	}
+	h := t.In(e.location()).Hour()
+	if rules.NightStartHour > rules.NightEndHour {
+		return h >= rules.NightStartHour || h < rules.NightEndHour
+	}
+	return h >= rules.NightStartHour && h < rules.NightEndHour
+}
+
+func (e Engine) incident(kind, severity string, value, threshold float64, p Sample) Incident {
+	return Incident{
+		Type:      kind,
+		Severity:  severity,
+		Value:     value,
+		Threshold: threshold,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Timestamp: p.Timestamp,
+	}
+}
+
+// severityFor clasifica por cuánto se superó el umbral (ratio = valor/umbral)
+func severityFor(ratio, medium, high float64) string {
+	switch {
+	case ratio >= high:
+		return SeverityHigh
+	case ratio >= medium:
+		return SeverityMedium
+	}
+	return SeverityLow
+}
+
+// Tracker mantiene el estado de seguridad por turno en memoria
+type Tracker struct {
+	mu     sync.Mutex
+	engine Engine
+	states map[int]*State
+}
+
+// NewTracker crea un tracker con el motor indicado
+func NewTracker(engine Engine) *Tracker {
+	return &Tracker{engine: engine, states: make(map[int]*State)}
+}
+
+// Process evalúa un punto del turno. Si el turno no tiene estado en memoria
+// (p. ej. tras un reinicio) se reconstruye con load.
+func (t *Tracker) Process(shiftID int, rules Rules, p Sample, load func() *State) []Incident {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.states[shiftID]
+	if !ok {
+		state = load()
+		if state == nil {
+			state = &State{}
+		}
+		t.states[shiftID] = state
+	}
+	return t.engine.Evaluate(state, rules, p)
+}
+
+// Close libera el estado del turno
+func (t *Tracker) Close(shiftID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, shiftID)
+}
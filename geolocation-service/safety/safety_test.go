@@ -0,0 +1,154 @@
+package safety
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var noon = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func speed(v float64) *float64 { return &v }
+
+func sample(seconds int, mps *float64) Sample {
+	return Sample{Latitude: 14.6, Longitude: -90.5, Speed: mps, Timestamp: noon.Add(time.Duration(seconds) * time.Second)}
+}
+
+type incidentKind struct {
+	Type     string
+	Severity string
+}
+
+func kindsOf(incidents []Incident) []incidentKind {
+	var out []incidentKind
+	for _, in := range incidents {
+		out = append(out, incidentKind{in.Type, in.Severity})
+	}
+	return out
+}
+
+func TestEvaluateSequence(t *testing.T) {
+	engine := Engine{}
+	state := &State{}
+
+	// Límite 60 km/h ≈ 16.7 m/s; el episodio termina bajo 54 km/h (15 m/s)
+	steps := []struct {
+		name    string
+		seconds int
+		speed   float64
+		want    []incidentKind
+	}{
+		{"bajo el límite", 0, 10, nil},
+		{"exceso 72 km/h", 5, 20, []incidentKind{{IncidentSpeeding, SeverityMedium}}},
+		{"exceso sostenido no se repite", 10, 21, nil},
+		{"bajo el límite pero sobre la liberación", 15, 16, nil},
+		{"termina el episodio", 20, 14, nil},
+		{"nuevo exceso con aceleración brusca", 25, 30, []incidentKind{
+			{IncidentSpeeding, SeverityHigh}, {IncidentHarshAcceleration, SeverityLow}}},
+		{"frenado brusco", 27, 20, []incidentKind{{IncidentHarshBraking, SeverityLow}}},
+		{"hueco largo no cuenta como frenado", 60, 0, nil},
+		{"intervalo menor a un segundo", 60, 20, []incidentKind{{IncidentSpeeding, SeverityMedium}}},
+	}
+	for _, st := range steps {
+		got := kindsOf(engine.Evaluate(state, DefaultRules, sample(st.seconds, speed(st.speed))))
+		if !reflect.DeepEqual(got, st.want) {
+			t.Errorf("%s: incidentes = %v, se esperaba %v", st.name, got, st.want)
+		}
+	}
+}
+
+func TestEvaluateDerivedSpeed(t *testing.T) {
+	engine := Engine{}
+	state := &State{}
+	first := sample(0, nil)
+	engine.Evaluate(state, DefaultRules, first)
+
+	// 0.004° de latitud ≈ 445 m en 20 s ≈ 80 km/h (fuera del intervalo de
+	// aceleración, para que solo cuente el exceso)
+	second := sample(20, nil)
+	second.Latitude += 0.004
+	incidents := engine.Evaluate(state, DefaultRules, second)
+	if len(incidents) != 1 || incidents[0].Type != IncidentSpeeding {
+		t.Fatalf("incidentes = %+v, se esperaba exceso de velocidad", incidents)
+	}
+	if v := incidents[0].Value; v < 79 || v > 81 {
+		t.Errorf("Value = %.1f km/h, se esperaban ~80", v)
+	}
+}
+
+func TestEvaluateNightRiding(t *testing.T) {
+	loc := time.FixedZone("CST", -6*3600)
+	engine := Engine{Location: loc}
+	state := &State{}
+	night := time.Date(2024, 5, 1, 23, 30, 0, 0, loc)
+
+	stopped := Sample{Speed: speed(0.5), Timestamp: night}
+	if incidents := engine.Evaluate(state, DefaultRules, stopped); len(incidents) != 0 {
+		t.Fatalf("detenido: incidentes = %+v", incidents)
+	}
+	moving := Sample{Speed: speed(5), Timestamp: night.Add(10 * time.Second)}
+	incidents := engine.Evaluate(state, DefaultRules, moving)
+	if len(incidents) != 1 || incidents[0].Type != IncidentNightRiding || incidents[0].Value != 23 {
+		t.Fatalf("incidentes = %+v, se esperaba night_riding a las 23", incidents)
+	}
+	moving.Timestamp = moving.Timestamp.Add(10 * time.Second)
+	if incidents := engine.Evaluate(state, DefaultRules, moving); len(incidents) != 0 {
+		t.Errorf("night_riding repetido: %+v", incidents)
+	}
+}
+
+func TestIsNight(t *testing.T) {
+	engine := Engine{}
+	tests := []struct {
+		start, end int
+		hours      map[int]bool
+	}{
+		{22, 5, map[int]bool{21: false, 22: true, 0: true, 4: true, 5: false, 12: false}},
+		{1, 4, map[int]bool{0: false, 1: true, 3: true, 4: false}},
+		{3, 3, map[int]bool{2: false, 3: false, 4: false}},
+	}
+	for _, tt := range tests {
+		rules := Rules{NightStartHour: tt.start, NightEndHour: tt.end}
+		for hour, want := range tt.hours {
+			ts := time.Date(2024, 5, 1, hour, 0, 0, 0, time.UTC)
+			if got := engine.isNight(rules, ts); got != want {
+				t.Errorf("isNight(%d) con horario %d-%d = %v, se esperaba %v", hour, tt.start, tt.end, got, want)
+			}
+		}
+	}
+}
+
+func TestSeverityFor(t *testing.T) {
+	tests := []struct {
+		ratio float64
+		want  string
+	}{
+		{1.1, SeverityLow},
+		{1.2, SeverityMedium},
+		{1.39, SeverityMedium},
+		{1.4, SeverityHigh},
+	}
+	for _, tt := range tests {
+		if got := severityFor(tt.ratio, 1.2, 1.4); got != tt.want {
+			t.Errorf("severityFor(%v) = %s, se esperaba %s", tt.ratio, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerRestoresState(t *testing.T) {
+	tracker := NewTracker(Engine{})
+	last := sample(0, speed(20))
+	load := func() *State {
+		s := &State{}
+		s.Restore(&last, true, false)
+		return s
+	}
+	// El episodio de exceso seguía abierto antes del reinicio
+	if incidents := tracker.Process(9, DefaultRules, sample(5, speed(21)), load); len(incidents) != 0 {
+		t.Errorf("incidentes = %+v, el exceso ya estaba reportado", incidents)
+	}
+	tracker.Close(9)
+	if incidents := tracker.Process(9, DefaultRules, sample(10, speed(21)), func() *State { return nil }); len(incidents) != 1 {
+		t.Errorf("tras Close: incidentes = %+v, se esperaba un exceso nuevo", incidents)
+	}
+}
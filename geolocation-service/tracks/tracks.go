@@ -0,0 +1,238 @@
+package tracks
+
+import (
+	"time"
+
+	"github.com/logitrack/geolocation-service/geo"
+)
+
+// Tipos de segmento
+const (
+	SegmentMoving  = "moving"
+	SegmentStopped = "stopped"
+)
+
+// Etiquetas de parada
+const (
+	LabelDelivery = "delivery" // En el destino de un pedido
+	LabelBranch   = "branch"   // En una sucursal
+	LabelUnknown  = "unknown"  // Sin lugar conocido: tiempo ocioso
+)
+
+// Point es un punto GPS del recorrido. Speed en m/s (como lo reporta el navegador).
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Speed     *float64
+	Timestamp time.Time
+}
+
+// Config parámetros de detección de paradas
+type Config struct {
+	// StopRadiusMeters radio máximo alrededor del centro de la parada
+	StopRadiusMeters float64
+	// MinStopDuration duración mínima para considerar una parada
+	MinStopDuration time.Duration
+	// MovingSpeed velocidad (m/s) a partir de la cual el punto se considera en movimiento
+	MovingSpeed float64
+}
+
+// DefaultConfig valores por defecto para motos en ciudad
+var DefaultConfig = Config{
+	StopRadiusMeters: 30,
+	MinStopDuration:  3 * time.Minute,
+	MovingSpeed:      1.5,
+}
+
+// Segment es un intervalo continuo en movimiento o detenido
+type Segment struct {
+	Type            string    `json:"type"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds int       `json:"duration_seconds"`
+	DistanceMeters  float64   `json:"distance_meters"`
+	PointCount      int       `json:"point_count"`
+	// Centro de la parada (solo segmentos detenidos)
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	// Lugar donde ocurrió la parada (ver Label)
+	Label     string `json:"label,omitempty"`
+	PlaceID   *int   `json:"place_id,omitempty"`
+	PlaceName string `json:"place_name,omitempty"`
+}
+
+// Place es un lugar conocido con el que se etiquetan las paradas
+type Place struct {
+	Label        string // LabelDelivery o LabelBranch
+	ID           int    // order_id o branch_id
+	Name         string
+	Latitude     float64
+	Longitude    float64
+	RadiusMeters float64
+}
+
+// Summary totales de un recorrido
+type Summary struct {
+	MovingSeconds   int     `json:"moving_seconds"`
+	StoppedSeconds  int     `json:"stopped_seconds"`
+	IdleSeconds     int     `json:"idle_seconds"` // Paradas sin lugar conocido
+	DeliverySeconds int     `json:"delivery_seconds"`
+	BranchSeconds   int     `json:"branch_seconds"`
+	DistanceMeters  float64 `json:"distance_meters"`
+	StopCount       int     `json:"stop_count"`
+	IdleStopCount   int     `json:"idle_stop_count"`
+}
+
+func (cfg Config) moving(p Point) bool {
+	return p.Speed != nil && *p.Speed > cfg.MovingSpeed
+}
+
+// Segmentize divide un recorrido ordenado por tiempo en intervalos en
+// movimiento y detenidos. Una parada es un grupo de puntos consecutivos que
+// permanecen dentro de StopRadiusMeters de su centro, sin superar MovingSpeed,
+// durante al menos MinStopDuration.
+func Segmentize(points []Point, cfg Config) []Segment {
+	n := len(points)
+	if n == 0 {
+		return nil
+	}
+
+	// Paradas detectadas como rangos de índices [start, end]
+	type span struct{ start, end int }
+	var stops []span
+
+	for i := 0; i < n; {
+		if cfg.moving(points[i]) {
+			i++
+			continue
+		}
+		lat, lng := points[i].Latitude, points[i].Longitude
+		j := i
+		for j+1 < n {
+			next := points[j+1]
+			if cfg.moving(next) || geo.HaversineMeters(lat, lng, next.Latitude, next.Longitude) > cfg.StopRadiusMeters {
+				break
+			}
+			j++
+			// Centro como promedio móvil de los puntos del grupo
+			k := float64(j - i + 1)
+			lat += (next.Latitude - lat) / k
+			lng += (next.Longitude - lng) / k
+		}
+		if points[j].Timestamp.Sub(points[i].Timestamp) >= cfg.MinStopDuration {
+			stops = append(stops, span{i, j})
+			i = j + 1
+			continue
+		}
+		i++
+	}
+
+	var segments []Segment
+	movingFrom := 0
+	for _, s := range stops {
+		// Tramo en movimiento entre la parada anterior y esta (comparten el punto de unión)
+		if s.start > movingFrom {
+			segments = append(segments, buildSegment(SegmentMoving, points, movingFrom, s.start))
+		}
+		segments = append(segments, buildSegment(SegmentStopped, points, s.start, s.end))
+		movingFrom = s.end
+	}
+	if movingFrom < n-1 || len(stops) == 0 {
+		segments = append(segments, buildSegment(SegmentMoving, points, movingFrom, n-1))
+	}
+	return segments
+}
+
+func buildSegment(kind string, points []Point, from, to int) Segment {
+	s := Segment{
+		Type:       kind,
+		Start:      points[from].Timestamp,
+		End:        points[to].Timestamp,
+		PointCount: to - from + 1,
+	}
+	s.DurationSeconds = int(s.End.Sub(s.Start).Seconds())
+
+	var sumLat, sumLng float64
+	for k := from; k <= to; k++ {
+		sumLat += points[k].Latitude
+		sumLng += points[k].Longitude
+		if k > from {
+			s.DistanceMeters += geo.HaversineMeters(points[k-1].Latitude, points[k-1].Longitude,
+				points[k].Latitude, points[k].Longitude)
+		}
+	}
+	if kind == SegmentStopped {
+		s.Latitude = sumLat / float64(s.PointCount)
+		s.Longitude = sumLng / float64(s.PointCount)
+	}
+	return s
+}
+
+// LabelStops asigna a cada parada el lugar más cercano dentro de su radio.
+// Los destinos de entrega tienen prioridad sobre las sucursales.
+func LabelStops(segments []Segment, places []Place) {
+	for i := range segments {
+		s := &segments[i]
+		if s.Type != SegmentStopped {
+			continue
+		}
+		s.Label = LabelUnknown
+
+		var best *Place
+		bestDist := 0.0
+		for k := range places {
+			p := &places[k]
+			d := geo.HaversineMeters(s.Latitude, s.Longitude, p.Latitude, p.Longitude)
+			if d > p.RadiusMeters {
+				continue
+			}
+			better := best == nil ||
+				(p.Label == LabelDelivery && best.Label != LabelDelivery) ||
+				(p.Label == best.Label && d < bestDist)
+			if better {
+				best, bestDist = p, d
+			}
+		}
+		if best != nil {
+			id := best.ID
+			s.Label = best.Label
+			s.PlaceID = &id
+			s.PlaceName = best.Name
+		}
+	}
+}
+
+// Summarize calcula los totales de los segmentos
+func Summarize(segments []Segment) Summary {
+	var sum Summary
+	for _, s := range segments {
+		sum.DistanceMeters += s.DistanceMeters
+		if s.Type == SegmentMoving {
+			sum.MovingSeconds += s.DurationSeconds
+			continue
+		}
+		sum.StopCount++
+		sum.StoppedSeconds += s.DurationSeconds
+		switch s.Label {
+		case LabelDelivery:
+			sum.DeliverySeconds += s.DurationSeconds
+		case LabelBranch:
+			sum.BranchSeconds += s.DurationSeconds
+		default:
+			sum.IdleSeconds += s.DurationSeconds
+			sum.IdleStopCount++
+		}
+	}
+	return sum
+}
+
+// Stops filtra los segmentos detenidos
+func Stops(segments []Segment) []Segment {
+	stops := []Segment{}
+	for _, s := range segments {
+		if s.Type == SegmentStopped {
+			stops = append(stops, s)
+		}
+	}
+	return stops
+}
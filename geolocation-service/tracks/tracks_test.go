@@ -0,0 +1,173 @@
+package tracks
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+// track arma un recorrido con un punto cada 30 s; cada tramo repite count
+// veces la posición (0.001° de latitud ≈ 111 m) con la velocidad indicada
+func track(legs ...[3]float64) []Point {
+	var points []Point
+	for _, leg := range legs {
+		dLat, speed, count := leg[0], leg[1], int(leg[2])
+		for k := 0; k < count; k++ {
+			v := speed
+			points = append(points, Point{
+				Latitude:  14.6 + dLat,
+				Longitude: -90.5,
+				Speed:     &v,
+				Timestamp: start.Add(time.Duration(len(points)*30) * time.Second),
+			})
+		}
+	}
+	return points
+}
+
+// shift recorrido de prueba: dos paradas largas y una corta entre ellas
+func shift() []Point {
+	return track(
+		[3]float64{0, 10, 1}, [3]float64{0.003, 10, 1}, [3]float64{0.006, 10, 1},
+		[3]float64{0.009, 0, 9}, // parada de 4 min
+		[3]float64{0.012, 10, 1}, [3]float64{0.015, 10, 1},
+		[3]float64{0.018, 0, 3}, // detenido 1 min: no alcanza MinStopDuration
+		[3]float64{0.021, 10, 1},
+		[3]float64{0.024, 0, 11}, // parada de 5 min al final
+	)
+}
+
+type segmentShape struct {
+	Type     string
+	Duration int
+	Points   int
+}
+
+func shapes(segments []Segment) []segmentShape {
+	var out []segmentShape
+	for _, s := range segments {
+		out = append(out, segmentShape{s.Type, s.DurationSeconds, s.PointCount})
+	}
+	return out
+}
+
+func TestSegmentize(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		want   []segmentShape
+	}{
+		{"vacío", nil, nil},
+		{
+			name:   "paradas y tramos en movimiento",
+			points: shift(),
+			want: []segmentShape{
+				{SegmentMoving, 90, 4},
+				{SegmentStopped, 240, 9},
+				{SegmentMoving, 210, 8},
+				{SegmentStopped, 300, 11},
+			},
+		},
+		{
+			name:   "sin paradas",
+			points: track([3]float64{0, 10, 1}, [3]float64{0.003, 10, 1}, [3]float64{0.006, 10, 1}),
+			want:   []segmentShape{{SegmentMoving, 60, 3}},
+		},
+		{
+			name:   "detenido todo el recorrido",
+			points: track([3]float64{0, 0, 7}),
+			want:   []segmentShape{{SegmentStopped, 180, 7}},
+		},
+		{
+			// Un punto en movimiento corta la parada aunque siga dentro del radio
+			name:   "movimiento dentro del radio",
+			points: track([3]float64{0, 0, 4}, [3]float64{0, 5, 1}, [3]float64{0, 0, 4}),
+			want:   []segmentShape{{SegmentMoving, 240, 9}},
+		},
+		{
+			name:   "parada seguida de movimiento",
+			points: track([3]float64{0, 0, 7}, [3]float64{0.003, 10, 1}, [3]float64{0.006, 10, 1}),
+			want:   []segmentShape{{SegmentStopped, 180, 7}, {SegmentMoving, 60, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shapes(Segmentize(tt.points, DefaultConfig)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Segmentize = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentizeStopCenter(t *testing.T) {
+	points := track([3]float64{0, 0, 7})
+	for i := range points {
+		// Ruido del GPS de ±5 m alrededor del centro
+		if i%2 == 0 {
+			points[i].Latitude += 0.00005
+		} else {
+			points[i].Latitude -= 0.00005
+		}
+		points[i].Speed = nil
+	}
+	segments := Segmentize(points, DefaultConfig)
+	if len(segments) != 1 || segments[0].Type != SegmentStopped {
+		t.Fatalf("segmentos = %v", shapes(segments))
+	}
+	if d := segments[0].Latitude - 14.6; d > 0.00001 || d < -0.00001 {
+		t.Errorf("centro desplazado %.6f°", d)
+	}
+}
+
+func TestLabelStopsAndSummarize(t *testing.T) {
+	segments := Segmentize(shift(), DefaultConfig)
+	LabelStops(segments, []Place{
+		{Label: LabelBranch, ID: 1, Name: "Central", Latitude: 14.609, Longitude: -90.5, RadiusMeters: 150},
+		{Label: LabelDelivery, ID: 42, Name: "Pedido 42", Latitude: 14.6092, Longitude: -90.5, RadiusMeters: 50},
+		{Label: LabelBranch, ID: 2, Name: "Norte", Latitude: 14.6245, Longitude: -90.5, RadiusMeters: 100},
+		{Label: LabelBranch, ID: 3, Name: "Norte 2", Latitude: 14.6242, Longitude: -90.5, RadiusMeters: 100},
+	})
+
+	stops := Stops(segments)
+	if len(stops) != 2 {
+		t.Fatalf("paradas = %v", shapes(stops))
+	}
+	// El destino de entrega gana aunque la sucursal esté más cerca
+	if stops[0].Label != LabelDelivery || *stops[0].PlaceID != 42 {
+		t.Errorf("primera parada = %s %v", stops[0].Label, *stops[0].PlaceID)
+	}
+	// Entre dos sucursales, la más cercana
+	if stops[1].Label != LabelBranch || *stops[1].PlaceID != 3 || stops[1].PlaceName != "Norte 2" {
+		t.Errorf("segunda parada = %s %v %s", stops[1].Label, *stops[1].PlaceID, stops[1].PlaceName)
+	}
+
+	sum := Summarize(segments)
+	want := Summary{
+		MovingSeconds:   300,
+		StoppedSeconds:  540,
+		DeliverySeconds: 240,
+		BranchSeconds:   300,
+		StopCount:       2,
+		DistanceMeters:  sum.DistanceMeters,
+	}
+	if sum != want {
+		t.Errorf("Summarize = %+v, se esperaba %+v", sum, want)
+	}
+	if sum.DistanceMeters < 2600 || sum.DistanceMeters > 2700 {
+		t.Errorf("DistanceMeters = %.0f, se esperaban ~2670", sum.DistanceMeters)
+	}
+}
+
+func TestLabelStopsIdle(t *testing.T) {
+	segments := Segmentize(track([3]float64{0, 0, 7}), DefaultConfig)
+	LabelStops(segments, []Place{{Label: LabelBranch, ID: 1, Latitude: 14.61, Longitude: -90.5, RadiusMeters: 100}})
+	if segments[0].Label != LabelUnknown || segments[0].PlaceID != nil {
+		t.Errorf("parada = %+v, se esperaba sin lugar", segments[0])
+	}
+	sum := Summarize(segments)
+	if sum.IdleSeconds != 180 || sum.IdleStopCount != 1 {
+		t.Errorf("Summarize = %+v", sum)
+	}
+}
@@ -32,17 +32,17 @@ type IntegrationConfig struct {
 
 // ExternalOrder representa un pedido del sistema externo
 type ExternalOrder struct {
-	ExternalID    string  `json:"external_id"`
-	ClientName    string  `json:"client_name"`
-	ClientPhone   string  `json:"client_phone,omitempty"`
-	ClientEmail   string  `json:"client_email,omitempty"`
-	Address       string  `json:"address"`
-	Latitude      float64 `json:"latitude,omitempty"`
-	Longitude     float64 `json:"longitude,omitempty"`
-	Branch        string  `json:"branch,omitempty"`
-	Notes         string  `json:"notes,omitempty"`
-	Priority      int     `json:"priority,omitempty"`
-	ScheduledTime string  `json:"scheduled_time,omitempty"`
+	ExternalID    string   `json:"external_id"`
+	ClientName    string   `json:"client_name"`
+	ClientPhone   string   `json:"client_phone,omitempty"`
+	ClientEmail   string   `json:"client_email,omitempty"`
+	Address       string   `json:"address"`
+	Latitude      *float64 `json:"latitude,omitempty"` // Sin coordenadas order-service geocodifica
+	Longitude     *float64 `json:"longitude,omitempty"`
+	Branch        string   `json:"branch,omitempty"`
+	Notes         string   `json:"notes,omitempty"`
+	Priority      int      `json:"priority,omitempty"`
+	ScheduledTime string   `json:"scheduled_time,omitempty"`
 }
 
 // SyncResult resultado de una sincronización
@@ -379,10 +379,13 @@ func createOrderFromExternal(integrationID int, ext ExternalOrder) (bool, error)
 		"client_name":  ext.ClientName,
 		"client_email": ext.ClientEmail,
 		"address":      ext.Address,
-		"latitude":     ext.Latitude,
-		"longitude":    ext.Longitude,
 		"branch":       ext.Branch,
 	}
+	// Latitud 0 es válida: solo se omiten las coordenadas que no vinieron
+	if ext.Latitude != nil && ext.Longitude != nil {
+		orderPayload["latitude"] = *ext.Latitude
+		orderPayload["longitude"] = *ext.Longitude
+	}
 
 	payloadBytes, _ := json.Marshal(orderPayload)
 
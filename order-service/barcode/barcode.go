@@ -0,0 +1,60 @@
+package barcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prefix identifica los códigos de bulto de LogiTrack
+const Prefix = "LT"
+
+// idDigits dígitos del número de bulto dentro del código
+const idDigits = 10
+
+// ForParcel genera el código del bulto: prefijo, ID con ceros a la izquierda y
+// dígito verificador (Luhn). Ej. el bulto 42 es LT00000000422.
+func ForParcel(parcelID int) string {
+	digits := fmt.Sprintf("%0*d", idDigits, parcelID)
+	return Prefix + digits + string(rune('0'+checkDigit(digits)))
+}
+
+// Valid indica si el código tiene el formato esperado y su dígito verificador
+// es correcto, para rechazar lecturas erróneas antes de consultar la BD
+func Valid(code string) bool {
+	if !strings.HasPrefix(code, Prefix) {
+		return false
+	}
+	digits := code[len(Prefix):]
+	if len(digits) != idDigits+1 {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return checkDigit(digits[:idDigits]) == int(digits[idDigits]-'0')
+}
+
+// Normalize limpia la lectura del escáner (espacios y minúsculas)
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// checkDigit dígito verificador Luhn de una cadena de dígitos
+func checkDigit(digits string) int {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
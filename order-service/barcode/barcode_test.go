@@ -0,0 +1,126 @@
+package barcode
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestForParcel(t *testing.T) {
+	tests := map[int]string{
+		1:          "LT00000000018",
+		42:         "LT00000000422",
+		1234:       "LT00000012344",
+		9999999999: "LT99999999990",
+	}
+	for id, want := range tests {
+		if got := ForParcel(id); got != want {
+			t.Errorf("ForParcel(%d) = %q, se esperaba %q", id, got, want)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"LT00000000422", true},
+		{"LT00000000423", false}, // dígito verificador
+		{"LT00000000242", false}, // dígitos transpuestos
+		{"LT0000000042", false},  // corto
+		{"LT000000004222", false},
+		{"XX00000000422", false},
+		{"lt00000000422", false}, // sin Normalize
+		{"LT0000000042A", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.code); got != tt.want {
+			t.Errorf("Valid(%q) = %v, se esperaba %v", tt.code, got, tt.want)
+		}
+	}
+	for id := 1; id < 5000; id++ {
+		if code := ForParcel(id); !Valid(code) {
+			t.Fatalf("Valid(ForParcel(%d)) = false (%s)", id, code)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("  lt00000000422\n"); got != "LT00000000422" {
+		t.Errorf("Normalize = %q", got)
+	}
+}
+
+// sqlParcelBarcode transcripción de la función parcel_barcode de la
+// migración 017, que genera el código de los bultos creados en la BD
+func sqlParcelBarcode(parcelID int) string {
+	digits := fmt.Sprintf("%010d", parcelID)
+	total := 0
+	for i := 1; i <= 10; i++ {
+		d := int(digits[11-i-1] - '0') // SUBSTRING(digits FROM 11 - i FOR 1)
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		total += d
+	}
+	return "LT" + digits + fmt.Sprint((10-total%10)%10)
+}
+
+func TestParityWithSQL(t *testing.T) {
+	sql, err := os.ReadFile("../migrations/017_parcels.sql")
+	if err != nil {
+		t.Fatalf("leer migración: %v", err)
+	}
+	// Si la función SQL cambia, hay que actualizar la transcripción
+	for _, line := range []string{
+		"digits TEXT := LPAD(parcel_id::TEXT, 10, '0');",
+		"FOR i IN 1..10 LOOP",
+		"d := SUBSTRING(digits FROM 11 - i FOR 1)::INTEGER;",
+		"IF i % 2 = 1 THEN",
+		"IF d > 9 THEN d := d - 9; END IF;",
+		"RETURN 'LT' || digits || ((10 - total % 10) % 10)::TEXT;",
+	} {
+		if !strings.Contains(string(sql), line) {
+			t.Fatalf("parcel_barcode cambió en 017_parcels.sql: falta %q", line)
+		}
+	}
+
+	for _, id := range []int{1, 9, 10, 42, 99, 1000, 123456, 987654321, 2147483647} {
+		if got, want := ForParcel(id), sqlParcelBarcode(id); got != want {
+			t.Errorf("ForParcel(%d) = %q, parcel_barcode = %q", id, got, want)
+		}
+	}
+	for id := 1; id < 20000; id++ {
+		if got, want := ForParcel(id), sqlParcelBarcode(id); got != want {
+			t.Fatalf("ForParcel(%d) = %q, parcel_barcode = %q", id, got, want)
+		}
+	}
+}
+
+func TestCode128(t *testing.T) {
+	modules, err := Code128(ForParcel(42))
+	if err != nil {
+		t.Fatalf("Code128: %v", err)
+	}
+	// Inicio + 13 caracteres + verificador de 11 módulos, parada de 13 y zonas en blanco
+	if want := quietZone + 15*11 + 13 + quietZone; len(modules) != want {
+		t.Errorf("len = %d, se esperaba %d", len(modules), want)
+	}
+	for i := 0; i < quietZone; i++ {
+		if modules[i] || modules[len(modules)-1-i] {
+			t.Fatal("las zonas en blanco tienen barras")
+		}
+	}
+	if !modules[quietZone] {
+		t.Error("el código no empieza con barra")
+	}
+	if _, err := Code128("ñ"); err == nil {
+		t.Error("Code128 aceptó un carácter fuera del juego B")
+	}
+}
@@ -0,0 +1,55 @@
+package barcode
+
+import "fmt"
+
+// Símbolos especiales de Code 128
+const (
+	code128StartB = 104
+	code128Stop   = 106
+)
+
+// code128Patterns anchos barra/espacio de cada símbolo (el de parada tiene 7)
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// quietZone módulos en blanco a cada lado del código
+const quietZone = 10
+
+// Code128 codifica el texto (ASCII imprimible, juego B) y devuelve los módulos
+// del código incluyendo las zonas en blanco (true = barra)
+func Code128(data string) ([]bool, error) {
+	symbols := []int{code128StartB}
+	checksum := code128StartB
+	for i, r := range data {
+		if r < 32 || r > 126 {
+			return nil, fmt.Errorf("carácter no soportado en Code 128: %q", r)
+		}
+		value := int(r) - 32
+		symbols = append(symbols, value)
+		checksum += value * (i + 1)
+	}
+	symbols = append(symbols, checksum%103, code128Stop)
+
+	modules := make([]bool, quietZone, quietZone+len(symbols)*11+2+quietZone)
+	for _, s := range symbols {
+		bar := true
+		for _, w := range code128Patterns[s] {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, bar)
+			}
+			bar = !bar
+		}
+	}
+	return append(modules, make([]bool, quietZone)...), nil
+}
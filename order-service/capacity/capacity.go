@@ -0,0 +1,105 @@
+package capacity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dimensiones de capacidad de una moto
+const (
+	DimensionOrders = "orders"    // Cantidad de pedidos
+	DimensionWeight = "weight_kg" // Peso total
+	DimensionVolume = "volume_l"  // Volumen total
+)
+
+// Load carga de una moto o de un pedido
+type Load struct {
+	Orders   int     `json:"orders"`
+	WeightKg float64 `json:"weight_kg"`
+	VolumeL  float64 `json:"volume_l"`
+}
+
+// Add suma dos cargas
+func (l Load) Add(o Load) Load {
+	return Load{Orders: l.Orders + o.Orders, WeightKg: l.WeightKg + o.WeightKg, VolumeL: l.VolumeL + o.VolumeL}
+}
+
+// Limits límites de la moto. Peso y volumen en 0 significan sin límite.
+type Limits struct {
+	MaxOrders   int     `json:"max_orders"`
+	MaxWeightKg float64 `json:"max_weight_kg,omitempty"`
+	MaxVolumeL  float64 `json:"max_volume_l,omitempty"`
+}
+
+// Violation límite superado al agregar una carga
+type Violation struct {
+	Dimension string  `json:"dimension"`
+	Limit     float64 `json:"limit"`
+	Current   float64 `json:"current"`   // Carga de la moto antes de agregar
+	Requested float64 `json:"requested"` // Carga que se intentó agregar
+}
+
+// Message explicación legible del límite superado
+func (v Violation) Message() string {
+	switch v.Dimension {
+	case DimensionWeight:
+		return fmt.Sprintf("peso: %.2f kg + %.2f kg supera el máximo de %.2f kg", v.Current, v.Requested, v.Limit)
+	case DimensionVolume:
+		return fmt.Sprintf("volumen: %.1f L + %.1f L supera el máximo de %.1f L", v.Current, v.Requested, v.Limit)
+	}
+	return fmt.Sprintf("pedidos: %.0f + %.0f supera el máximo de %.0f", v.Current, v.Requested, v.Limit)
+}
+
+// Check devuelve los límites que se superan al agregar la carga (vacío si cabe)
+func Check(limits Limits, current, add Load) []Violation {
+	var out []Violation
+	if add.Orders > 0 && current.Orders+add.Orders > limits.MaxOrders {
+		out = append(out, Violation{DimensionOrders, float64(limits.MaxOrders), float64(current.Orders), float64(add.Orders)})
+	}
+	if limits.MaxWeightKg > 0 && current.WeightKg+add.WeightKg > limits.MaxWeightKg+1e-9 {
+		out = append(out, Violation{DimensionWeight, limits.MaxWeightKg, current.WeightKg, add.WeightKg})
+	}
+	if limits.MaxVolumeL > 0 && current.VolumeL+add.VolumeL > limits.MaxVolumeL+1e-9 {
+		out = append(out, Violation{DimensionVolume, limits.MaxVolumeL, current.VolumeL, add.VolumeL})
+	}
+	return out
+}
+
+// Ratio ocupación de la dimensión más cargada (0 = vacía, 1 = llena)
+func Ratio(limits Limits, load Load) float64 {
+	ratio := 0.0
+	if limits.MaxOrders > 0 {
+		ratio = float64(load.Orders) / float64(limits.MaxOrders)
+	}
+	if limits.MaxWeightKg > 0 {
+		ratio = max(ratio, load.WeightKg/limits.MaxWeightKg)
+	}
+	if limits.MaxVolumeL > 0 {
+		ratio = max(ratio, load.VolumeL/limits.MaxVolumeL)
+	}
+	return ratio
+}
+
+// ExceededError asignación bloqueada por uno o más límites de capacidad
+type ExceededError struct {
+	MotoID     int         `json:"moto_id"`
+	OrderID    int         `json:"order_id"`
+	Violations []Violation `json:"violations"`
+}
+
+func (e *ExceededError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message()
+	}
+	return fmt.Sprintf("la moto %d no tiene capacidad para el pedido %d (%s)", e.MotoID, e.OrderID, strings.Join(msgs, "; "))
+}
+
+// Dimensions dimensiones que bloquearon la asignación
+func (e *ExceededError) Dimensions() []string {
+	out := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		out[i] = v.Dimension
+	}
+	return out
+}
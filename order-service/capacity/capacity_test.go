@@ -0,0 +1,82 @@
+package capacity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	limits := Limits{MaxOrders: 3, MaxWeightKg: 20, MaxVolumeL: 40}
+	tests := []struct {
+		name    string
+		limits  Limits
+		current Load
+		add     Load
+		want    []string
+	}{
+		{"cabe", limits, Load{1, 10, 10}, Load{1, 5, 10}, nil},
+		{"justo en el límite", limits, Load{2, 15, 30}, Load{1, 5, 10}, nil},
+		{"límite con error de redondeo", limits, Load{2, 0.1 + 0.2, 0}, Load{1, 19.7, 0}, nil},
+		{"cupo de pedidos", limits, Load{3, 0, 0}, Load{1, 0, 0}, []string{DimensionOrders}},
+		{"peso", limits, Load{1, 18, 0}, Load{1, 2.5, 0}, []string{DimensionWeight}},
+		{"volumen", limits, Load{1, 0, 35}, Load{1, 0, 6}, []string{DimensionVolume}},
+		{"varias dimensiones", limits, Load{3, 20, 40}, Load{1, 1, 1}, []string{DimensionOrders, DimensionWeight, DimensionVolume}},
+		{"peso y volumen sin límite", Limits{MaxOrders: 3}, Load{0, 500, 500}, Load{1, 100, 100}, nil},
+		// Un bulto extra de un pedido ya asignado no ocupa cupo de pedidos
+		{"solo bultos", limits, Load{3, 10, 10}, Load{0, 5, 5}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range Check(tt.limits, tt.current, tt.add) {
+				got = append(got, v.Dimension)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckViolationValues(t *testing.T) {
+	v := Check(Limits{MaxOrders: 5, MaxWeightKg: 20}, Load{1, 18, 0}, Load{1, 2.5, 0})
+	want := []Violation{{Dimension: DimensionWeight, Limit: 20, Current: 18, Requested: 2.5}}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("Check = %+v, se esperaba %+v", v, want)
+	}
+	if got := v[0].Message(); got != "peso: 18.00 kg + 2.50 kg supera el máximo de 20.00 kg" {
+		t.Errorf("Message = %q", got)
+	}
+}
+
+func TestRatio(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		load   Load
+		want   float64
+	}{
+		{"vacía", Limits{MaxOrders: 4, MaxWeightKg: 20}, Load{}, 0},
+		{"pedidos", Limits{MaxOrders: 4}, Load{Orders: 1}, 0.25},
+		{"dimensión más cargada", Limits{MaxOrders: 4, MaxWeightKg: 20, MaxVolumeL: 40}, Load{1, 15, 10}, 0.75},
+		{"sin límites", Limits{}, Load{3, 10, 10}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Ratio(tt.limits, tt.load); got != tt.want {
+				t.Errorf("Ratio = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExceededError(t *testing.T) {
+	err := &ExceededError{MotoID: 7, OrderID: 42, Violations: Check(Limits{MaxOrders: 1, MaxVolumeL: 10}, Load{1, 0, 8}, Load{1, 0, 4})}
+	if got := err.Dimensions(); !reflect.DeepEqual(got, []string{DimensionOrders, DimensionVolume}) {
+		t.Errorf("Dimensions = %v", got)
+	}
+	want := "la moto 7 no tiene capacidad para el pedido 42 (pedidos: 1 + 1 supera el máximo de 1; volumen: 8.0 L + 4.0 L supera el máximo de 10.0 L)"
+	if got := err.Error(); got != want {
+		t.Errorf("Error = %q", got)
+	}
+}
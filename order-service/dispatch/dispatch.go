@@ -0,0 +1,231 @@
+package dispatch
+
+import (
+	"math"
+
+	"github.com/logitrack/order-service/capacity"
+	"github.com/logitrack/order-service/geo"
+)
+
+// Motivos por los que un pedido queda sin asignar
+const (
+	ReasonNoCandidates = "no_candidates" // Ninguna moto disponible con capacidad
+	ReasonOutOfRange   = "out_of_range"  // Todas las motos superan la distancia máxima
+	ReasonOverCapacity = "over_capacity" // Las motos en rango no tienen peso, volumen o cupo
+	ReasonConflict     = "conflict"      // El pedido o la moto cambiaron antes de aplicar
+)
+
+// Stop es un destino en la ruta de una moto
+type Stop struct {
+	OrderID   int
+	Latitude  float64
+	Longitude float64
+}
+
+// Order es un pedido pendiente de asignar
+type Order struct {
+	ID        int
+	Latitude  float64
+	Longitude float64
+	Load      capacity.Load // Un pedido más el peso y volumen de sus bultos
+	Exclude   []int         // Motos que ya rechazaron o dejaron vencer la oferta
+}
+
+// Moto es una moto candidata con su posición, carga y ruta actual
+type Moto struct {
+	ID        int
+	Latitude  float64
+	Longitude float64
+	Capacity  capacity.Limits
+	Load      capacity.Load
+	Stops     []Stop // Pedidos asignados aún no entregados, en orden de visita
+}
+
+// Weights pesos del puntaje (menor es mejor). La distancia y el costo de
+// inserción se miden en km; Load son los km equivalentes de una moto llena.
+type Weights struct {
+	Distance  float64 `json:"weight_distance"`
+	Load      float64 `json:"weight_load"`
+	Insertion float64 `json:"weight_insertion"`
+}
+
+// DefaultWeights valores por defecto
+var DefaultWeights = Weights{Distance: 1, Load: 5, Insertion: 1}
+
+// Config parámetros de la asignación
+type Config struct {
+	Weights
+	MaxDistanceKm float64 // 0 = sin límite
+}
+
+// Assignment asignación elegida con el detalle del puntaje
+type Assignment struct {
+	OrderID     int     `json:"order_id"`
+	MotoID      int     `json:"moto_id"`
+	Score       float64 `json:"score"`
+	DistanceKm  float64 `json:"distance_km"`  // Moto → pedido en línea recta
+	InsertionKm float64 `json:"insertion_km"` // Km extra que agrega a la ruta de la moto
+	LoadRatio   float64 `json:"load_ratio"`   // Carga de la moto antes de asignar
+	Position    int     `json:"position"`     // Posición del pedido en la ruta
+	RouteKm     float64 `json:"route_km"`     // Km desde la moto hasta el pedido siguiendo la ruta
+}
+
+// Unassigned pedido que no se pudo asignar
+type Unassigned struct {
+	OrderID   int      `json:"order_id"`
+	Reason    string   `json:"reason"`
+	BlockedBy []string `json:"blocked_by,omitempty"` // Dimensiones de capacidad superadas (over_capacity)
+}
+
+// Result resultado de un lote
+type Result struct {
+	Assignments []Assignment
+	Unassigned  []Unassigned
+	Routes      map[int][]Stop // Ruta final de cada moto que recibió pedidos
+}
+
+type candidate struct {
+	Assignment
+	feasible bool
+	reason   string
+	blocked  []capacity.Violation
+}
+
+// Plan asigna un lote de pedidos a las motos de forma voraz: en cada paso
+// toma el par pedido-moto de menor puntaje, lo inserta en la ruta de la moto
+// y recalcula los puntajes de esa moto para los pedidos restantes.
+func Plan(orders []Order, motos []Moto, cfg Config) Result {
+	res := Result{Routes: map[int][]Stop{}}
+
+	// Copias para no modificar la entrada
+	fleet := make([]Moto, len(motos))
+	for i, m := range motos {
+		fleet[i] = m
+		fleet[i].Stops = append([]Stop(nil), m.Stops...)
+	}
+
+	pending := make(map[int]Order, len(orders))
+	for _, o := range orders {
+		pending[o.ID] = o
+	}
+
+	for len(pending) > 0 {
+		var best *candidate
+		for _, o := range orders {
+			if _, ok := pending[o.ID]; !ok {
+				continue
+			}
+			for i := range fleet {
+				c := evaluate(o, &fleet[i], cfg)
+				if !c.feasible {
+					continue
+				}
+				if best == nil || c.Score < best.Score ||
+					(c.Score == best.Score && (c.OrderID < best.OrderID ||
+						(c.OrderID == best.OrderID && c.MotoID < best.MotoID))) {
+					cc := c
+					best = &cc
+				}
+			}
+		}
+		if best == nil {
+			break
+		}
+
+		for i := range fleet {
+			m := &fleet[i]
+			if m.ID != best.MotoID {
+				continue
+			}
+			o := pending[best.OrderID]
+			stop := Stop{OrderID: o.ID, Latitude: o.Latitude, Longitude: o.Longitude}
+			m.Stops = append(m.Stops[:best.Position], append([]Stop{stop}, m.Stops[best.Position:]...)...)
+			m.Load = m.Load.Add(o.Load)
+			res.Routes[m.ID] = m.Stops
+		}
+		res.Assignments = append(res.Assignments, best.Assignment)
+		delete(pending, best.OrderID)
+	}
+
+	// Pedidos restantes con el motivo
+	for _, o := range orders {
+		if _, ok := pending[o.ID]; !ok {
+			continue
+		}
+		// Una moto en rango sin capacidad explica mejor el motivo que una lejana
+		u := Unassigned{OrderID: o.ID, Reason: ReasonNoCandidates}
+		blocked := map[string]bool{}
+		for i := range fleet {
+			c := evaluate(o, &fleet[i], cfg)
+			switch {
+			case c.reason == ReasonOverCapacity:
+				u.Reason = ReasonOverCapacity
+				for _, v := range c.blocked {
+					if !blocked[v.Dimension] {
+						blocked[v.Dimension] = true
+						u.BlockedBy = append(u.BlockedBy, v.Dimension)
+					}
+				}
+			case c.reason == ReasonOutOfRange && u.Reason == ReasonNoCandidates:
+				u.Reason = ReasonOutOfRange
+			}
+		}
+		res.Unassigned = append(res.Unassigned, u)
+	}
+	return res
+}
+
+// evaluate calcula el puntaje de asignar el pedido a la moto con la
+// inserción más barata en su ruta
+func evaluate(o Order, m *Moto, cfg Config) candidate {
+	c := candidate{Assignment: Assignment{OrderID: o.ID, MotoID: m.ID}}
+	for _, id := range o.Exclude {
+		if id == m.ID {
+			c.reason = ReasonNoCandidates
+			return c
+		}
+	}
+
+	c.DistanceKm = km(m.Latitude, m.Longitude, o.Latitude, o.Longitude)
+	if cfg.MaxDistanceKm > 0 && c.DistanceKm > cfg.MaxDistanceKm {
+		c.reason = ReasonOutOfRange
+		return c
+	}
+	if c.blocked = capacity.Check(m.Capacity, m.Load, o.Load); len(c.blocked) > 0 {
+		c.reason = ReasonOverCapacity
+		return c
+	}
+
+	// Inserción más barata: entre dos paradas consecutivas o al final
+	prevLat, prevLng := m.Latitude, m.Longitude
+	routeKm := 0.0
+	c.InsertionKm = math.Inf(1)
+	for i := 0; i <= len(m.Stops); i++ {
+		toOrder := km(prevLat, prevLng, o.Latitude, o.Longitude)
+		extra := toOrder
+		var legKm float64
+		if i < len(m.Stops) {
+			next := m.Stops[i]
+			legKm = km(prevLat, prevLng, next.Latitude, next.Longitude)
+			extra += km(o.Latitude, o.Longitude, next.Latitude, next.Longitude) - legKm
+		}
+		if extra < c.InsertionKm {
+			c.InsertionKm = extra
+			c.Position = i
+			c.RouteKm = routeKm + toOrder
+		}
+		if i < len(m.Stops) {
+			routeKm += legKm
+			prevLat, prevLng = m.Stops[i].Latitude, m.Stops[i].Longitude
+		}
+	}
+
+	c.LoadRatio = capacity.Ratio(m.Capacity, m.Load)
+	c.Score = cfg.Weights.Distance*c.DistanceKm + cfg.Weights.Insertion*c.InsertionKm + cfg.Weights.Load*c.LoadRatio
+	c.feasible = true
+	return c
+}
+
+func km(lat1, lng1, lat2, lng2 float64) float64 {
+	return geo.HaversineMeters(lat1, lng1, lat2, lng2) / 1000
+}
@@ -0,0 +1,165 @@
+package dispatch
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/logitrack/order-service/capacity"
+)
+
+// Puntos en línea sobre el mismo meridiano: 0.01° de latitud ≈ 1.11 km
+const (
+	baseLat = 14.60
+	baseLng = -90.50
+)
+
+func order(id int, dLat float64) Order {
+	return Order{ID: id, Latitude: baseLat + dLat, Longitude: baseLng, Load: capacity.Load{Orders: 1}}
+}
+
+func moto(id int, dLat float64, maxOrders int) Moto {
+	return Moto{ID: id, Latitude: baseLat + dLat, Longitude: baseLng, Capacity: capacity.Limits{MaxOrders: maxOrders}}
+}
+
+func assigned(res Result) map[int]int {
+	out := map[int]int{}
+	for _, a := range res.Assignments {
+		out[a.OrderID] = a.MotoID
+	}
+	return out
+}
+
+func TestPlan(t *testing.T) {
+	heavy := order(2, 0.01)
+	heavy.Load.WeightKg = 30
+	excluded := order(1, 0.01)
+	excluded.Exclude = []int{10}
+	withWeight := moto(10, 0, 5)
+	withWeight.Capacity.MaxWeightKg = 20
+
+	tests := []struct {
+		name       string
+		orders     []Order
+		motos      []Moto
+		cfg        Config
+		want       map[int]int // pedido → moto
+		unassigned []Unassigned
+	}{
+		{
+			name:   "moto más cercana",
+			orders: []Order{order(1, 0.01)},
+			motos:  []Moto{moto(10, 0.05, 5), moto(11, 0, 5)},
+			want:   map[int]int{1: 11},
+		},
+		{
+			name:       "cupo de pedidos",
+			orders:     []Order{order(1, 0.01), order(2, 0.02)},
+			motos:      []Moto{moto(10, 0, 1)},
+			want:       map[int]int{1: 10},
+			unassigned: []Unassigned{{OrderID: 2, Reason: ReasonOverCapacity, BlockedBy: []string{capacity.DimensionOrders}}},
+		},
+		{
+			name:       "peso",
+			orders:     []Order{order(1, 0.01), heavy},
+			motos:      []Moto{withWeight},
+			want:       map[int]int{1: 10},
+			unassigned: []Unassigned{{OrderID: 2, Reason: ReasonOverCapacity, BlockedBy: []string{capacity.DimensionWeight}}},
+		},
+		{
+			name:       "fuera de rango",
+			orders:     []Order{order(1, 0.05)},
+			motos:      []Moto{moto(10, 0, 5)},
+			cfg:        Config{MaxDistanceKm: 2},
+			want:       map[int]int{},
+			unassigned: []Unassigned{{OrderID: 1, Reason: ReasonOutOfRange}},
+		},
+		{
+			name:       "sin capacidad pesa más que fuera de rango",
+			orders:     []Order{order(1, 0.01), order(2, 0.01)},
+			motos:      []Moto{moto(10, 0, 1), moto(11, 0.10, 5)},
+			cfg:        Config{MaxDistanceKm: 2},
+			want:       map[int]int{1: 10},
+			unassigned: []Unassigned{{OrderID: 2, Reason: ReasonOverCapacity, BlockedBy: []string{capacity.DimensionOrders}}},
+		},
+		{
+			name:       "moto excluida",
+			orders:     []Order{excluded},
+			motos:      []Moto{moto(10, 0, 5)},
+			want:       map[int]int{},
+			unassigned: []Unassigned{{OrderID: 1, Reason: ReasonNoCandidates}},
+		},
+		{
+			name:       "sin motos",
+			orders:     []Order{order(1, 0.01)},
+			want:       map[int]int{},
+			unassigned: []Unassigned{{OrderID: 1, Reason: ReasonNoCandidates}},
+		},
+		{
+			name:   "empate entre motos gana el menor id",
+			orders: []Order{order(1, 0.01)},
+			motos:  []Moto{moto(12, 0, 5), moto(11, 0, 5)},
+			want:   map[int]int{1: 11},
+		},
+		{
+			name:       "empate entre pedidos gana el menor id",
+			orders:     []Order{order(3, 0.01), order(2, 0.01)},
+			motos:      []Moto{moto(10, 0, 1)},
+			want:       map[int]int{2: 10},
+			unassigned: []Unassigned{{OrderID: 3, Reason: ReasonOverCapacity, BlockedBy: []string{capacity.DimensionOrders}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Weights = DefaultWeights
+			res := Plan(tt.orders, tt.motos, cfg)
+			if got := assigned(res); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("asignaciones = %v, se esperaba %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(res.Unassigned, tt.unassigned) {
+				t.Errorf("sin asignar = %+v, se esperaba %+v", res.Unassigned, tt.unassigned)
+			}
+		})
+	}
+}
+
+func TestPlanInsertsBetweenStops(t *testing.T) {
+	m := moto(10, 0, 5)
+	m.Stops = []Stop{{OrderID: 99, Latitude: baseLat + 0.04, Longitude: baseLng}}
+	m.Load = capacity.Load{Orders: 1}
+	motos := []Moto{m}
+
+	res := Plan([]Order{order(1, 0.02)}, motos, Config{Weights: DefaultWeights})
+	if len(res.Assignments) != 1 {
+		t.Fatalf("asignaciones = %+v", res.Assignments)
+	}
+	a := res.Assignments[0]
+	if a.Position != 0 {
+		t.Errorf("Position = %d, se esperaba 0 (antes de la parada existente)", a.Position)
+	}
+	if a.InsertionKm > 0.01 {
+		t.Errorf("InsertionKm = %.3f, el pedido está en el camino", a.InsertionKm)
+	}
+	route := res.Routes[10]
+	if len(route) != 2 || route[0].OrderID != 1 || route[1].OrderID != 99 {
+		t.Errorf("ruta = %+v", route)
+	}
+	// La entrada no se modifica
+	if len(motos[0].Stops) != 1 || motos[0].Stops[0].OrderID != 99 {
+		t.Errorf("Plan modificó las paradas de la entrada: %+v", motos[0].Stops)
+	}
+}
+
+func TestPlanPrefersEmptierMoto(t *testing.T) {
+	busy := moto(10, 0, 2)
+	busy.Load = capacity.Load{Orders: 1}
+	idle := moto(11, 0, 2)
+
+	res := Plan([]Order{order(1, 0.01)}, []Moto{busy, idle}, Config{Weights: DefaultWeights})
+	if got := assigned(res); got[1] != 11 {
+		t.Errorf("asignaciones = %v, se esperaba la moto 11 (vacía)", got)
+	}
+	if a := res.Assignments[0]; a.LoadRatio != 0 {
+		t.Errorf("LoadRatio = %v, se esperaba 0", a.LoadRatio)
+	}
+}
@@ -0,0 +1,77 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// Cuadrado de 0 a 10 con un hueco de 4 a 6, y una isla aparte de 20 a 22
+const zoneWithHole = `{"type":"MultiPolygon","coordinates":[
+	[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[4,4],[6,4],[6,6],[4,6],[4,4]]],
+	[[[20,20],[22,20],[22,22],[20,22],[20,20]]]
+]}`
+
+func TestContains(t *testing.T) {
+	g, err := ParseGeometry([]byte(zoneWithHole))
+	if err != nil {
+		t.Fatalf("ParseGeometry: %v", err)
+	}
+	tests := []struct {
+		name     string
+		lat, lng float64
+		want     bool
+	}{
+		{"dentro", 2, 2, true},
+		{"dentro del hueco", 5, 5, false},
+		{"entre el hueco y el borde", 5, 8, true},
+		{"fuera del recuadro", 15, 15, false},
+		{"dentro del recuadro pero fuera de los polígonos", 15, 5, false},
+		{"segundo polígono", 21, 21, true},
+		// Orden [lng, lat]: lat 2, lng 21 queda fuera
+		{"coordenadas invertidas", 2, 21, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.Contains(tt.lat, tt.lng); got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, se esperaba %v", tt.lat, tt.lng, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGeometry(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"polygon", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`, true},
+		{"feature", `{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}`, true},
+		{"feature sin geometry", `{"type":"Feature"}`, false},
+		{"tipo no soportado", `{"type":"Point","coordinates":[0,0]}`, false},
+		{"json inválido", `{"type":`, false},
+		{"anillo abierto", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`, false},
+		{"anillo corto", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`, false},
+		{"sin anillos", `{"type":"Polygon","coordinates":[]}`, false},
+		{"multipolygon vacío", `{"type":"MultiPolygon","coordinates":[]}`, false},
+		{"latitud fuera de rango", `{"type":"Polygon","coordinates":[[[0,0],[1,95],[1,1],[0,0]]]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseGeometry([]byte(tt.json))
+			if (err == nil) != tt.ok {
+				t.Errorf("ParseGeometry err = %v, se esperaba ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHaversineMeters(t *testing.T) {
+	// Un grado de latitud ≈ 111.19 km
+	if d := HaversineMeters(14, -90.5, 15, -90.5); math.Abs(d-111195) > 10 {
+		t.Errorf("1° de latitud = %.0f m", d)
+	}
+	if d := HaversineMeters(14.6, -90.5, 14.6, -90.5); d != 0 {
+		t.Errorf("mismo punto = %v m", d)
+	}
+}
@@ -0,0 +1,143 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Point es una coordenada [longitud, latitud] en el orden de GeoJSON
+type Point [2]float64
+
+// Ring es un anillo cerrado de un polígono
+type Ring []Point
+
+// Polygon es un anillo exterior seguido de cero o más huecos
+type Polygon []Ring
+
+// Geometry es un Polygon o MultiPolygon GeoJSON ya validado
+type Geometry struct {
+	Polygons []Polygon
+	minLng   float64
+	minLat   float64
+	maxLng   float64
+	maxLat   float64
+}
+
+type rawGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    json.RawMessage `json:"geometry"` // Si viene como Feature
+}
+
+// ParseGeometry interpreta un Polygon, MultiPolygon o Feature GeoJSON
+func ParseGeometry(data []byte) (*Geometry, error) {
+	var raw rawGeometry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("GeoJSON inválido: %w", err)
+	}
+
+	if raw.Type == "Feature" {
+		if len(raw.Geometry) == 0 {
+			return nil, errors.New("la Feature no tiene geometry")
+		}
+		return ParseGeometry(raw.Geometry)
+	}
+
+	var polygons []Polygon
+	switch raw.Type {
+	case "Polygon":
+		var p Polygon
+		if err := json.Unmarshal(raw.Coordinates, &p); err != nil {
+			return nil, fmt.Errorf("coordenadas de Polygon inválidas: %w", err)
+		}
+		polygons = []Polygon{p}
+	case "MultiPolygon":
+		if err := json.Unmarshal(raw.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("coordenadas de MultiPolygon inválidas: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("tipo de geometría no soportado: %q (se espera Polygon o MultiPolygon)", raw.Type)
+	}
+
+	g := &Geometry{Polygons: polygons}
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Geometry) validate() error {
+	if len(g.Polygons) == 0 {
+		return errors.New("la geometría no tiene polígonos")
+	}
+
+	first := true
+	for pi, polygon := range g.Polygons {
+		if len(polygon) == 0 {
+			return fmt.Errorf("el polígono %d no tiene anillos", pi)
+		}
+		for ri, ring := range polygon {
+			if len(ring) < 4 {
+				return fmt.Errorf("el anillo %d del polígono %d necesita al menos 4 posiciones", ri, pi)
+			}
+			if ring[0] != ring[len(ring)-1] {
+				return fmt.Errorf("el anillo %d del polígono %d no está cerrado", ri, pi)
+			}
+			for _, pt := range ring {
+				lng, lat := pt[0], pt[1]
+				if lng < -180 || lng > 180 || lat < -90 || lat > 90 {
+					return fmt.Errorf("coordenada fuera de rango: [%f, %f]", lng, lat)
+				}
+				if first {
+					g.minLng, g.maxLng, g.minLat, g.maxLat = lng, lng, lat, lat
+					first = false
+					continue
+				}
+				g.minLng = min(g.minLng, lng)
+				g.maxLng = max(g.maxLng, lng)
+				g.minLat = min(g.minLat, lat)
+				g.maxLat = max(g.maxLat, lat)
+			}
+		}
+	}
+	return nil
+}
+
+// Contains indica si el punto (lat, lng) está dentro de la geometría.
+// Un punto dentro de un hueco del polígono se considera fuera.
+func (g *Geometry) Contains(lat, lng float64) bool {
+	if lng < g.minLng || lng > g.maxLng || lat < g.minLat || lat > g.maxLat {
+		return false
+	}
+
+	for _, polygon := range g.Polygons {
+		if !ringContains(polygon[0], lat, lng) {
+			continue
+		}
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, lat, lng) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains aplica ray casting sobre un anillo cerrado
+func ringContains(ring Ring, lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,59 @@
+package geocoding
+
+import (
+	"context"
+	"sync"
+)
+
+// Store guarda resultados por dirección normalizada
+type Store interface {
+	Get(ctx context.Context, key string) (Result, bool)
+	Set(ctx context.Context, key string, r Result)
+}
+
+// Cached Geocoder que consulta el Store antes que el proveedor. Solo se
+// guardan los resultados encontrados.
+type Cached struct {
+	Geocoder Geocoder
+	Store    Store
+}
+
+// Geocode implementa Geocoder
+func (c Cached) Geocode(ctx context.Context, address string) (Result, error) {
+	key := Normalize(address)
+	if r, ok := c.Store.Get(ctx, key); ok {
+		return r, nil
+	}
+	r, err := c.Geocoder.Geocode(ctx, address)
+	if err != nil {
+		return r, err
+	}
+	c.Store.Set(ctx, key, r)
+	return r, nil
+}
+
+// MemoryStore caché en memoria del proceso
+type MemoryStore struct {
+	mu      sync.RWMutex
+	results map[string]Result
+}
+
+// NewMemoryStore caché en memoria vacío
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{results: make(map[string]Result)}
+}
+
+// Get implementa Store
+func (m *MemoryStore) Get(_ context.Context, key string) (Result, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.results[key]
+	return r, ok
+}
+
+// Set implementa Store
+func (m *MemoryStore) Set(_ context.Context, key string, r Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[key] = r
+}
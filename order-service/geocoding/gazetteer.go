@@ -0,0 +1,70 @@
+package geocoding
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Place lugar conocido del nomenclátor local (colonia, zona, edificio...)
+type Place struct {
+	Name       string  `json:"name"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Confidence float64 `json:"confidence"` // Precisión del punto (0 a 1)
+}
+
+// Gazetteer nomenclátor local: busca en la dirección el nombre más largo de
+// sus lugares. Sirve sin conexión y para lugares que los mapas no conocen.
+type Gazetteer struct {
+	places []Place
+	keys   []string // Nombres normalizados
+}
+
+// NewGazetteer nomenclátor con los lugares indicados
+func NewGazetteer(places []Place) *Gazetteer {
+	g := &Gazetteer{places: places, keys: make([]string, len(places))}
+	for i, p := range places {
+		g.keys[i] = Normalize(p.Name)
+	}
+	return g
+}
+
+// LoadGazetteer lee los lugares de un archivo JSON (lista de Place)
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var places []Place
+	if err := json.Unmarshal(b, &places); err != nil {
+		return nil, err
+	}
+	return NewGazetteer(places), nil
+}
+
+// Geocode implementa Geocoder
+func (g *Gazetteer) Geocode(_ context.Context, address string) (Result, error) {
+	text := " " + Normalize(address) + " "
+	best := -1
+	for i, key := range g.keys {
+		if key == "" || !strings.Contains(text, " "+key+" ") {
+			continue
+		}
+		if best < 0 || len(key) > len(g.keys[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Result{}, ErrNotFound
+	}
+	p := g.places[best]
+	return Result{
+		Latitude:    p.Latitude,
+		Longitude:   p.Longitude,
+		DisplayName: p.Name,
+		Confidence:  p.Confidence,
+		Provider:    "gazetteer",
+	}, nil
+}
@@ -0,0 +1,78 @@
+// Package geocoding convierte direcciones en coordenadas. Los proveedores
+// implementan Geocoder: un adaptador HTTP compatible con Nominatim y un
+// nomenclátor local. Chain los prueba en orden y Cached guarda los resultados.
+package geocoding
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrNotFound ningún proveedor encontró la dirección
+var ErrNotFound = errors.New("dirección no encontrada")
+
+// Result coordenadas de una dirección. Confidence va de 0 a 1: cerca de 1 es
+// una dirección exacta; valores bajos (solo la zona o la ciudad) requieren que
+// alguien fije el punto a mano.
+type Result struct {
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	DisplayName string  `json:"display_name,omitempty"`
+	Confidence  float64 `json:"confidence"`
+	Provider    string  `json:"provider"`
+}
+
+// Geocoder proveedor de geocodificación
+type Geocoder interface {
+	Geocode(ctx context.Context, address string) (Result, error)
+}
+
+// Chain prueba los proveedores en orden y devuelve el primer resultado con
+// confianza de al menos minConfidence; si ninguno llega, el de mayor confianza
+type Chain struct {
+	Providers     []Geocoder
+	MinConfidence float64
+}
+
+// Geocode implementa Geocoder
+func (c Chain) Geocode(ctx context.Context, address string) (Result, error) {
+	var best Result
+	found := false
+	var lastErr error
+	for _, p := range c.Providers {
+		r, err := p.Geocode(ctx, address)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				lastErr = err
+			}
+			continue
+		}
+		if r.Confidence >= c.MinConfidence {
+			return r, nil
+		}
+		if !found || r.Confidence > best.Confidence {
+			best, found = r, true
+		}
+	}
+	if found {
+		return best, nil
+	}
+	if lastErr != nil {
+		return Result{}, lastErr
+	}
+	return Result{}, ErrNotFound
+}
+
+// Normalize texto en minúsculas, sin acentos y con un solo espacio entre
+// palabras (clave del caché y del nomenclátor)
+func Normalize(s string) string {
+	s = accents.Replace(strings.ToLower(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+var accents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+	"à", "a", "è", "e", "ì", "i", "ò", "o", "ù", "u")
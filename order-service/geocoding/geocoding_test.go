@@ -0,0 +1,148 @@
+package geocoding
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// stubGeocoder devuelve siempre el mismo resultado y cuenta las llamadas
+type stubGeocoder struct {
+	result Result
+	err    error
+	calls  int
+}
+
+func (s *stubGeocoder) Geocode(context.Context, string) (Result, error) {
+	s.calls++
+	return s.result, s.err
+}
+
+func TestChain(t *testing.T) {
+	errDown := errors.New("proveedor caído")
+	low := Result{Confidence: 0.3, Provider: "low"}
+	mid := Result{Confidence: 0.6, Provider: "mid"}
+	high := Result{Confidence: 0.9, Provider: "high"}
+
+	tests := []struct {
+		name      string
+		providers []*stubGeocoder
+		want      string
+		wantErr   error
+		wantCalls []int
+	}{
+		{
+			name:      "primer resultado suficiente",
+			providers: []*stubGeocoder{{result: high}, {result: mid}},
+			want:      "high",
+			wantCalls: []int{1, 0},
+		},
+		{
+			name:      "salta no encontrados y errores",
+			providers: []*stubGeocoder{{err: ErrNotFound}, {err: errDown}, {result: high}},
+			want:      "high",
+			wantCalls: []int{1, 1, 1},
+		},
+		{
+			name:      "ninguno suficiente devuelve el de mayor confianza",
+			providers: []*stubGeocoder{{result: low}, {result: mid}, {result: low}},
+			want:      "mid",
+			wantCalls: []int{1, 1, 1},
+		},
+		{
+			name:      "todos sin resultado",
+			providers: []*stubGeocoder{{err: ErrNotFound}, {err: ErrNotFound}},
+			wantErr:   ErrNotFound,
+		},
+		{
+			name:      "propaga el último error distinto de no encontrado",
+			providers: []*stubGeocoder{{err: errDown}, {err: ErrNotFound}},
+			wantErr:   errDown,
+		},
+		{
+			name:    "sin proveedores",
+			wantErr: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := Chain{MinConfidence: 0.8}
+			for _, p := range tt.providers {
+				chain.Providers = append(chain.Providers, p)
+			}
+			r, err := chain.Geocode(context.Background(), "6a avenida")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, se esperaba %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Geocode: %v", err)
+			}
+			if r.Provider != tt.want {
+				t.Errorf("Provider = %q, se esperaba %q", r.Provider, tt.want)
+			}
+			for i, want := range tt.wantCalls {
+				if got := tt.providers[i].calls; got != want {
+					t.Errorf("proveedor %d: %d llamadas, se esperaban %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCached(t *testing.T) {
+	stub := &stubGeocoder{result: Result{Latitude: 14.6, Longitude: -90.5, Confidence: 1, Provider: "stub"}}
+	c := Cached{Geocoder: stub, Store: NewMemoryStore()}
+	ctx := context.Background()
+
+	first, err := c.Geocode(ctx, "6a Avenida, Zona 1")
+	if err != nil {
+		t.Fatalf("Geocode: %v", err)
+	}
+	// Misma dirección con otra capitalización, acentos y puntuación
+	second, err := c.Geocode(ctx, "  6A  AVENIDA zona 1 ")
+	if err != nil {
+		t.Fatalf("Geocode: %v", err)
+	}
+	if stub.calls != 1 {
+		t.Errorf("proveedor llamado %d veces, se esperaba 1", stub.calls)
+	}
+	if first != second {
+		t.Errorf("resultado del caché %+v, se esperaba %+v", second, first)
+	}
+}
+
+func TestCachedSkipsErrors(t *testing.T) {
+	stub := &stubGeocoder{err: ErrNotFound}
+	store := NewMemoryStore()
+	c := Cached{Geocoder: stub, Store: store}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Geocode(ctx, "calle sin nombre"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("err = %v, se esperaba ErrNotFound", err)
+		}
+	}
+	if stub.calls != 2 {
+		t.Errorf("proveedor llamado %d veces, se esperaban 2 (los errores no se guardan)", stub.calls)
+	}
+	if _, ok := store.Get(ctx, Normalize("calle sin nombre")); ok {
+		t.Error("el error quedó guardado en el caché")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"6a Avenida, Zona 1":        "6a avenida zona 1",
+		"  Calzada  Aguilar Batres": "calzada aguilar batres",
+		"Pétapa #12-34, Guatemala":  "petapa 12 34 guatemala",
+		"Niño Güegüense":            "nino gueguense",
+	}
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, se esperaba %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package geocoding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Nominatim adaptador para la API /search de Nominatim (OpenStreetMap) o
+// cualquier servicio compatible, p. ej. un stub local en pruebas
+type Nominatim struct {
+	BaseURL      string // p. ej. https://nominatim.openstreetmap.org
+	UserAgent    string // requerido por la política de uso de Nominatim
+	CountryCodes string // limita la búsqueda, p. ej. "gt"
+	Client       *http.Client
+}
+
+// NewNominatim adaptador con timeout de 5 segundos
+func NewNominatim(baseURL, userAgent, countryCodes string) *Nominatim {
+	return &Nominatim{
+		BaseURL:      baseURL,
+		UserAgent:    userAgent,
+		CountryCodes: countryCodes,
+		Client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type nominatimPlace struct {
+	Lat         string  `json:"lat"`
+	Lon         string  `json:"lon"`
+	DisplayName string  `json:"display_name"`
+	PlaceRank   int     `json:"place_rank"`
+	Importance  float64 `json:"importance"`
+}
+
+// Geocode implementa Geocoder
+func (n *Nominatim) Geocode(ctx context.Context, address string) (Result, error) {
+	params := url.Values{}
+	params.Set("q", address)
+	params.Set("format", "jsonv2")
+	params.Set("limit", "1")
+	if n.CountryCodes != "" {
+		params.Set("countrycodes", n.CountryCodes)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.BaseURL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if n.UserAgent != "" {
+		req.Header.Set("User-Agent", n.UserAgent)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("nominatim: status %d", resp.StatusCode)
+	}
+
+	var places []nominatimPlace
+	if err := json.NewDecoder(resp.Body).Decode(&places); err != nil {
+		return Result{}, fmt.Errorf("nominatim: %w", err)
+	}
+	if len(places) == 0 {
+		return Result{}, ErrNotFound
+	}
+	p := places[0]
+	lat, errLat := strconv.ParseFloat(p.Lat, 64)
+	lng, errLng := strconv.ParseFloat(p.Lon, 64)
+	if errLat != nil || errLng != nil {
+		return Result{}, fmt.Errorf("nominatim: coordenadas inválidas %q, %q", p.Lat, p.Lon)
+	}
+	return Result{
+		Latitude:    lat,
+		Longitude:   lng,
+		DisplayName: p.DisplayName,
+		Confidence:  placeConfidence(p),
+		Provider:    "nominatim",
+	}, nil
+}
+
+// placeConfidence según el nivel del lugar encontrado: place_rank 30 es un
+// edificio o número de casa, 26-27 una calle, 16-22 una ciudad o colonia.
+// Servicios compatibles sin place_rank usan importance.
+func placeConfidence(p nominatimPlace) float64 {
+	if p.PlaceRank <= 0 {
+		return min(max(p.Importance, 0), 1)
+	}
+	return min(float64(p.PlaceRank)/30, 1)
+}
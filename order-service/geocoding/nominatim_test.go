@@ -0,0 +1,92 @@
+package geocoding
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func nominatimStub(t *testing.T, status int, body string) *Nominatim {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			t.Errorf("path = %q, se esperaba /search", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("countrycodes"); got != "gt" {
+			t.Errorf("countrycodes = %q, se esperaba gt", got)
+		}
+		if got := r.Header.Get("User-Agent"); got != "logitrack-test" {
+			t.Errorf("User-Agent = %q", got)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return NewNominatim(srv.URL, "logitrack-test", "gt")
+}
+
+func TestNominatimGeocode(t *testing.T) {
+	n := nominatimStub(t, http.StatusOK,
+		`[{"lat":"14.6349","lon":"-90.5069","display_name":"6a Avenida, Zona 1","place_rank":30,"importance":0.2}]`)
+
+	r, err := n.Geocode(context.Background(), "6a avenida zona 1")
+	if err != nil {
+		t.Fatalf("Geocode: %v", err)
+	}
+	if r.Latitude != 14.6349 || r.Longitude != -90.5069 {
+		t.Errorf("coordenadas = %v, %v", r.Latitude, r.Longitude)
+	}
+	if r.DisplayName != "6a Avenida, Zona 1" || r.Provider != "nominatim" {
+		t.Errorf("resultado = %+v", r)
+	}
+	if r.Confidence != 1 {
+		t.Errorf("Confidence = %v, se esperaba 1", r.Confidence)
+	}
+}
+
+func TestNominatimNotFound(t *testing.T) {
+	n := nominatimStub(t, http.StatusOK, `[]`)
+	if _, err := n.Geocode(context.Background(), "nada"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, se esperaba ErrNotFound", err)
+	}
+}
+
+func TestNominatimStatusError(t *testing.T) {
+	n := nominatimStub(t, http.StatusTooManyRequests, `{"error":"rate limited"}`)
+	_, err := n.Geocode(context.Background(), "6a avenida")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, se esperaba error de estado", err)
+	}
+}
+
+func TestNominatimInvalidCoordinates(t *testing.T) {
+	n := nominatimStub(t, http.StatusOK, `[{"lat":"x","lon":"-90.5"}]`)
+	if _, err := n.Geocode(context.Background(), "6a avenida"); err == nil {
+		t.Fatal("se esperaba error por coordenadas inválidas")
+	}
+}
+
+func TestPlaceConfidence(t *testing.T) {
+	tests := []struct {
+		name string
+		p    nominatimPlace
+		want float64
+	}{
+		{"edificio", nominatimPlace{PlaceRank: 30}, 1},
+		{"calle", nominatimPlace{PlaceRank: 27}, 0.9},
+		{"ciudad", nominatimPlace{PlaceRank: 16, Importance: 0.9}, 16.0 / 30},
+		{"rango mayor a 30", nominatimPlace{PlaceRank: 32}, 1},
+		{"sin place_rank usa importance", nominatimPlace{Importance: 0.45}, 0.45},
+		{"importance mayor a 1", nominatimPlace{Importance: 1.3}, 1},
+		{"importance negativa", nominatimPlace{Importance: -0.2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := placeConfidence(tt.p); got != tt.want {
+				t.Errorf("placeConfidence(%+v) = %v, se esperaba %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
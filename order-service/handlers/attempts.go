@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// INTENTOS FALLIDOS Y REPROGRAMACIÓN
+// ========================================
+
+// AttemptReasons códigos de motivo de un intento fallido
+var AttemptReasons = map[string]string{
+	"customer_absent": "Cliente ausente",
+	"wrong_address":   "Dirección incorrecta",
+	"refused":         "Cliente rechazó el pedido",
+	"unreachable":     "Cliente no contesta",
+	"business_closed": "Negocio cerrado",
+	"damaged":         "Paquete dañado",
+	"other":           "Otro",
+}
+
+// Resultado de un intento fallido
+const (
+	AttemptReturned    = "returned"    // Vuelve al pool de pendientes
+	AttemptRescheduled = "rescheduled" // Pendiente a partir de la nueva ventana
+	AttemptFailed      = "failed"      // Agotó los intentos: el pedido queda 'failed'
+)
+
+// Límites de intentos y reprogramación
+const (
+	defaultMaxDeliveryAttempts = 3
+	maxRescheduleDays          = 30
+)
+
+var errAttemptConflict = errors.New("el pedido ya no está asignado a la moto")
+
+// FailedAttemptRequest reporte de intento de entrega fallido
+type FailedAttemptRequest struct {
+	ReasonCode  string     `json:"reason_code" binding:"required"`
+	Reason      string     `json:"reason"` // Descripción libre
+	Notes       string     `json:"notes"`
+	PhotoBase64 string     `json:"photo_base64"`
+	Latitude    *float64   `json:"latitude"`
+	Longitude   *float64   `json:"longitude"`
+	Accuracy    *float64   `json:"accuracy"`
+	CapturedAt  *time.Time `json:"captured_at"`
+
+	// Nueva ventana de entrega; sin ventana el pedido vuelve al pool
+	WindowStart *time.Time `json:"window_start"`
+	WindowEnd   *time.Time `json:"window_end"`
+}
+
+// RescheduleRequest reprogramación manual de un pedido pendiente o fallido
+type RescheduleRequest struct {
+	WindowStart         *time.Time `json:"window_start"`
+	WindowEnd           *time.Time `json:"window_end"`
+	MaxDeliveryAttempts *int       `json:"max_delivery_attempts"`
+}
+
+// DeliveryAttempt intento fallido registrado
+type DeliveryAttempt struct {
+	ID            int        `json:"id"`
+	OrderID       int        `json:"order_id"`
+	AttemptNumber int        `json:"attempt_number"`
+	MotoID        *int       `json:"moto_id"`
+	DriverID      *int       `json:"driver_id"`
+	ReasonCode    string     `json:"reason_code"`
+	ReasonLabel   string     `json:"reason_label"`
+	Reason        string     `json:"reason,omitempty"`
+	Notes         string     `json:"notes,omitempty"`
+	PhotoURL      string     `json:"photo_url,omitempty"`
+	Latitude      *float64   `json:"latitude,omitempty"`
+	Longitude     *float64   `json:"longitude,omitempty"`
+	Accuracy      *float64   `json:"accuracy,omitempty"`
+	CapturedAt    *time.Time `json:"captured_at,omitempty"`
+	Outcome       string     `json:"outcome"`
+	WindowStart   *time.Time `json:"window_start,omitempty"`
+	WindowEnd     *time.Time `json:"window_end,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// validateWindow verifica una ventana de entrega (inicio requerido si hay fin)
+func validateWindow(start, end *time.Time) error {
+	if start == nil {
+		if end != nil {
+			return errors.New("window_start es requerido cuando se envía window_end")
+		}
+		return nil
+	}
+	if end != nil && !end.After(*start) {
+		return errors.New("window_end debe ser posterior a window_start")
+	}
+	if end != nil && end.Before(time.Now()) {
+		return errors.New("la ventana de entrega ya pasó")
+	}
+	if start.After(time.Now().AddDate(0, 0, maxRescheduleDays)) {
+		return fmt.Errorf("la entrega no se puede reprogramar a más de %d días", maxRescheduleDays)
+	}
+	return nil
+}
+
+// saveAttemptPhoto guarda la foto del intento junto a las pruebas de entrega
+func saveAttemptPhoto(orderID, attempt int, photoBase64 string) string {
+	if photoBase64 == "" {
+		return ""
+	}
+	data, err := decodeBase64Image(photoBase64)
+	if err != nil {
+		return ""
+	}
+	uploadsDir := os.Getenv("UPLOADS_DIR")
+	if uploadsDir == "" {
+		uploadsDir = "/tmp/uploads/delivery_proofs"
+	}
+	os.MkdirAll(uploadsDir, 0755)
+
+	filename := fmt.Sprintf("attempt_%d_%d_%s.jpg", orderID, attempt, time.Now().Format("20060102_150405"))
+	if err := os.WriteFile(filepath.Join(uploadsDir, filename), data, 0644); err != nil {
+		return ""
+	}
+	return "/uploads/delivery_proofs/" + filename
+}
+
+// recordFailedAttempt registra el intento de la moto y decide el destino del
+// pedido: vuelve al pool, se reprograma o queda 'failed' si agotó los intentos
+func recordFailedAttempt(orderID, motoID, driverID int, req FailedAttemptRequest) (*DeliveryAttempt, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var assigned sql.NullInt64
+	var count, maxAttempts int
+	err = tx.QueryRow(`
+		SELECT status, assigned_moto_id, delivery_attempts_count, max_delivery_attempts
+		FROM orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&status, &assigned, &count, &maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	if (status != "assigned" && status != "in_route") || !assigned.Valid || int(assigned.Int64) != motoID {
+		return nil, errAttemptConflict
+	}
+
+	a := &DeliveryAttempt{
+		OrderID:       orderID,
+		AttemptNumber: count + 1,
+		MotoID:        &motoID,
+		DriverID:      &driverID,
+		ReasonCode:    req.ReasonCode,
+		ReasonLabel:   AttemptReasons[req.ReasonCode],
+		Reason:        strings.TrimSpace(req.Reason),
+		Notes:         req.Notes,
+		Latitude:      req.Latitude,
+		Longitude:     req.Longitude,
+		Accuracy:      req.Accuracy,
+		CapturedAt:    req.CapturedAt,
+		Outcome:       AttemptReturned,
+	}
+	switch {
+	case a.AttemptNumber >= maxAttempts:
+		a.Outcome = AttemptFailed
+	case req.WindowStart != nil:
+		a.Outcome = AttemptRescheduled
+		a.WindowStart, a.WindowEnd = req.WindowStart, req.WindowEnd
+	}
+	a.PhotoURL = saveAttemptPhoto(orderID, a.AttemptNumber, req.PhotoBase64)
+
+	newStatus := "pending"
+	if a.Outcome == AttemptFailed {
+		newStatus = "failed"
+	}
+	_, err = tx.Exec(`
+		UPDATE orders
+		SET status = $2, assigned_moto_id = NULL, started_at = NULL, arrived_at = NULL,
+		    delivery_attempts_count = $3, window_start = $4, window_end = $5,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, orderID, newStatus, a.AttemptNumber, a.WindowStart, a.WindowEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO delivery_attempts
+		(order_id, attempt_number, moto_id, driver_id, reason_code, reason, notes, photo_url,
+		 latitude, longitude, accuracy, captured_at, outcome, window_start, window_end)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, created_at
+	`, orderID, a.AttemptNumber, motoID, driverID, a.ReasonCode, a.Reason, a.Notes, a.PhotoURL,
+		a.Latitude, a.Longitude, a.Accuracy, a.CapturedAt, a.Outcome, a.WindowStart, a.WindowEnd).
+		Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	extra := map[string]interface{}{
+		"attempt_number": a.AttemptNumber,
+		"reason_code":    a.ReasonCode,
+		"outcome":        a.Outcome,
+	}
+	if a.WindowStart != nil {
+		extra["window_start"] = a.WindowStart.Format(time.RFC3339)
+	}
+	go NotifyOrderStatusChange(orderID, "", "failed_attempt", extra)
+
+	log.Printf("Intento fallido %d del pedido %d (%s): %s", a.AttemptNumber, orderID, a.ReasonCode, a.Outcome)
+	return a, nil
+}
+
+// GetOrderAttempts GET /orders/:id/attempts
+func GetOrderAttempts(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, order_id, attempt_number, moto_id, driver_id, reason_code, COALESCE(reason, ''),
+		       COALESCE(notes, ''), COALESCE(photo_url, ''), latitude, longitude, accuracy, captured_at,
+		       outcome, window_start, window_end, created_at
+		FROM delivery_attempts
+		WHERE order_id = $1
+		ORDER BY attempt_number, id
+	`, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener intentos"})
+		return
+	}
+	defer rows.Close()
+
+	attempts := []DeliveryAttempt{}
+	for rows.Next() {
+		var a DeliveryAttempt
+		if err := rows.Scan(&a.ID, &a.OrderID, &a.AttemptNumber, &a.MotoID, &a.DriverID, &a.ReasonCode,
+			&a.Reason, &a.Notes, &a.PhotoURL, &a.Latitude, &a.Longitude, &a.Accuracy, &a.CapturedAt,
+			&a.Outcome, &a.WindowStart, &a.WindowEnd, &a.CreatedAt); err != nil {
+			continue
+		}
+		a.ReasonLabel = AttemptReasons[a.ReasonCode]
+		attempts = append(attempts, a)
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// GetAttemptReasons GET /attempt-reasons
+func GetAttemptReasons(c *gin.Context) {
+	c.JSON(http.StatusOK, AttemptReasons)
+}
+
+// RescheduleOrder PUT /orders/:id/reschedule: fija una nueva ventana a un
+// pedido pendiente o fallido. Un pedido fallido vuelve a pendiente con un
+// intento adicional.
+func RescheduleOrder(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return
+	}
+
+	var req RescheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	if err := validateWindow(req.WindowStart, req.WindowEnd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxDeliveryAttempts != nil && (*req.MaxDeliveryAttempts < 1 || *req.MaxDeliveryAttempts > 10) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_delivery_attempts debe estar entre 1 y 10"})
+		return
+	}
+
+	var status string
+	var count, maxAttempts int
+	err = db.QueryRow(`
+		SELECT status, delivery_attempts_count, max_delivery_attempts FROM orders WHERE id = $1
+	`, orderID).Scan(&status, &count, &maxAttempts)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el pedido"})
+		return
+	}
+	if status != "pending" && status != "failed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se reprograman pedidos pendientes o fallidos", "status": status})
+		return
+	}
+
+	if req.MaxDeliveryAttempts != nil {
+		maxAttempts = *req.MaxDeliveryAttempts
+	}
+	if maxAttempts <= count {
+		maxAttempts = count + 1
+	}
+
+	_, err = db.Exec(`
+		UPDATE orders
+		SET status = 'pending', window_start = $2, window_end = $3, max_delivery_attempts = $4,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('pending', 'failed')
+	`, orderID, req.WindowStart, req.WindowEnd, maxAttempts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al reprogramar el pedido"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":               "Pedido reprogramado",
+		"order_id":              orderID,
+		"status":                "pending",
+		"window_start":          req.WindowStart,
+		"window_end":            req.WindowEnd,
+		"delivery_attempts":     count,
+		"max_delivery_attempts": maxAttempts,
+	})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/logitrack/order-service/models"
+)
+
+// ========================================
+// CACHÉ DE SUCURSALES ACTIVAS
+// ========================================
+
+// branchCacheTTL limita cuánto puede quedar desactualizada la caché si las
+// sucursales se modifican fuera de este servicio
+const branchCacheTTL = 5 * time.Minute
+
+type branchCache struct {
+	mu       sync.RWMutex
+	byID     map[int]models.Branch
+	byCode   map[string]models.Branch
+	loadedAt time.Time
+	// Se incrementa en cada invalidación
+	generation uint64
+}
+
+var activeBranches = &branchCache{}
+
+// invalidateBranchCache fuerza la recarga en la próxima consulta, también
+// de las zonas de entrega. Se llama desde los handlers que crean o modifican
+// sucursales.
+func invalidateBranchCache() {
+	activeBranches.mu.Lock()
+	activeBranches.loadedAt = time.Time{}
+	activeBranches.generation++
+	activeBranches.mu.Unlock()
+	invalidateZoneCache()
+}
+
+func (bc *branchCache) ensureLoaded() error {
+	bc.mu.RLock()
+	fresh := !bc.loadedAt.IsZero() && time.Since(bc.loadedAt) < branchCacheTTL
+	generation := bc.generation
+	bc.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	rows, err := db.Query(`SELECT id, name, code, address, latitude, longitude, radius_km, is_active FROM branches WHERE is_active = true`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]models.Branch)
+	byCode := make(map[string]models.Branch)
+	for rows.Next() {
+		var b models.Branch
+		if err := rows.Scan(&b.ID, &b.Name, &b.Code, &b.Address, &b.Latitude, &b.Longitude, &b.RadiusKm, &b.IsActive); err != nil {
+			continue
+		}
+		byID[b.ID] = b
+		byCode[b.Code] = b
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	// Si se invalidó durante la carga, el resultado puede ser anterior al
+	// cambio: se descarta y la próxima consulta vuelve a cargar
+	if bc.generation != generation {
+		return nil
+	}
+	bc.byID = byID
+	bc.byCode = byCode
+	bc.loadedAt = time.Now()
+	return nil
+}
+
+// activeBranchByID devuelve la sucursal activa con ese id
+func activeBranchByID(id int) (models.Branch, bool) {
+	if err := activeBranches.ensureLoaded(); err != nil {
+		return models.Branch{}, false
+	}
+	activeBranches.mu.RLock()
+	defer activeBranches.mu.RUnlock()
+	b, ok := activeBranches.byID[id]
+	return b, ok
+}
+
+// activeBranchByCode devuelve la sucursal activa con ese código
+func activeBranchByCode(code string) (models.Branch, bool) {
+	if err := activeBranches.ensureLoaded(); err != nil {
+		return models.Branch{}, false
+	}
+	activeBranches.mu.RLock()
+	defer activeBranches.mu.RUnlock()
+	b, ok := activeBranches.byCode[code]
+	return b, ok
+}
+
+func isActiveBranchCode(code string) bool {
+	_, ok := activeBranchByCode(code)
+	return ok
+}
+
+func isActiveBranchID(id int) bool {
+	_, ok := activeBranchByID(id)
+	return ok
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/capacity"
+	"github.com/logitrack/order-service/dispatch"
+	"github.com/logitrack/order-service/models"
+)
+
+// ========================================
+// CAPACIDAD DE LAS MOTOS (pedidos, peso y volumen)
+// ========================================
+// La carga de un pedido es la suma de sus bultos (vista order_loads). La
+// carga de una moto son sus pedidos ofrecidos, asignados o en ruta (cantidad,
+// peso y volumen), calculada desde orders.
+
+// motoCapacity límites y carga actual de una moto
+type motoCapacity struct {
+	Limits capacity.Limits
+	Load   capacity.Load
+}
+
+// loadOrderLoads carga de cada pedido (cuenta como un pedido más su peso y volumen)
+func loadOrderLoads(q rowsQueryer, ids []int64) (map[int]capacity.Load, error) {
+	rows, err := q.Query(`
+		SELECT order_id, weight_kg, volume_l FROM order_loads WHERE order_id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loads := make(map[int]capacity.Load, len(ids))
+	for rows.Next() {
+		var id int
+		l := capacity.Load{Orders: 1}
+		if err := rows.Scan(&id, &l.WeightKg, &l.VolumeL); err != nil {
+			continue
+		}
+		loads[id] = l
+	}
+	return loads, rows.Err()
+}
+
+// parcelsLoad peso y volumen de los bultos (misma fórmula que order_loads)
+func parcelsLoad(parcels []models.Parcel) (weightKg, volumeL float64) {
+	for _, p := range parcels {
+		if p.WeightKg != nil {
+			weightKg += *p.WeightKg
+		}
+		if p.LengthCm != nil && p.WidthCm != nil && p.HeightCm != nil {
+			volumeL += *p.LengthCm * *p.WidthCm * *p.HeightCm / 1000
+		}
+	}
+	return weightKg, volumeL
+}
+
+// loadMotoCapacities límites y carga de las motos, sin contar el pedido
+// excludeOrder en el peso y volumen (0 = ninguno)
+func loadMotoCapacities(q rowsQueryer, ids []int64, excludeOrder int) (map[int]motoCapacity, error) {
+	rows, err := q.Query(`
+		SELECT m.id, m.max_orders_capacity, COALESCE(m.max_weight_kg, 0), COALESCE(m.max_volume_l, 0),
+		       COUNT(o.id), COALESCE(SUM(l.weight_kg), 0), COALESCE(SUM(l.volume_l), 0)
+		FROM motos m
+		LEFT JOIN orders o ON o.assigned_moto_id = m.id AND o.status IN ('offered', 'assigned', 'in_route') AND o.id <> $2
+		LEFT JOIN order_loads l ON l.order_id = o.id
+		WHERE m.id = ANY($1)
+		GROUP BY m.id
+	`, pq.Array(ids), excludeOrder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]motoCapacity, len(ids))
+	for rows.Next() {
+		var id int
+		var mc motoCapacity
+		if err := rows.Scan(&id, &mc.Limits.MaxOrders, &mc.Limits.MaxWeightKg, &mc.Limits.MaxVolumeL,
+			&mc.Load.Orders, &mc.Load.WeightKg, &mc.Load.VolumeL); err != nil {
+			continue
+		}
+		out[id] = mc
+	}
+	return out, rows.Err()
+}
+
+// checkCapacityTx bloquea la moto y verifica que el pedido quepa en todas las
+// dimensiones. Devuelve errMotoUnavailable si la moto no existe o (con
+// requireAvailable) no está disponible, o *capacity.ExceededError con los
+// límites superados.
+func checkCapacityTx(tx *sql.Tx, motoID, orderID int, requireAvailable bool) error {
+	var status string
+	err := tx.QueryRow("SELECT status FROM motos WHERE id = $1 FOR UPDATE", motoID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return errMotoUnavailable
+	}
+	if err != nil {
+		return err
+	}
+	if requireAvailable && status != "available" {
+		return errMotoUnavailable
+	}
+
+	motos, err := loadMotoCapacities(tx, []int64{int64(motoID)}, orderID)
+	if err != nil {
+		return err
+	}
+	loads, err := loadOrderLoads(tx, []int64{int64(orderID)})
+	if err != nil {
+		return err
+	}
+	add, ok := loads[orderID]
+	if !ok {
+		add = capacity.Load{Orders: 1}
+	}
+	mc := motos[motoID]
+	if v := capacity.Check(mc.Limits, mc.Load, add); len(v) > 0 {
+		return &capacity.ExceededError{MotoID: motoID, OrderID: orderID, Violations: v}
+	}
+	return nil
+}
+
+// respondCapacityError responde 409 con el detalle si err es un límite de
+// capacidad superado
+func respondCapacityError(c *gin.Context, err error) bool {
+	var exceeded *capacity.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":      exceeded.Error(),
+		"code":       "capacity_exceeded",
+		"blocked_by": exceeded.Dimensions(),
+		"violations": exceeded.Violations,
+	})
+	return true
+}
+
+// applyOrderLoads completa la carga de los pedidos del lote de despacho
+func applyOrderLoads(orders []dispatch.Order) error {
+	ids := make([]int64, len(orders))
+	for i, o := range orders {
+		ids[i] = int64(o.ID)
+	}
+	loads, err := loadOrderLoads(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range orders {
+		if l, ok := loads[orders[i].ID]; ok {
+			orders[i].Load = l
+		} else {
+			orders[i].Load = capacity.Load{Orders: 1}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/sqlbuilder"
+)
+
+// ========================================
+// COBRO CONTRA ENTREGA Y ENTREGA DE EFECTIVO
+// ========================================
+// Los pedidos con cod_amount_cents se cobran en la puerta: la prueba de
+// entrega registra el medio de pago y lo cobrado, con el turno activo del
+// motorista. Al terminar el turno el motorista entrega el efectivo en la
+// sucursal (cash_handovers) y un supervisor confirma lo contado; si es menos
+// que lo cobrado según las pruebas, la entrega queda como faltante.
+
+// Medios de pago en la entrega
+const (
+	PaymentCash     = "cash"
+	PaymentCard     = "card"
+	PaymentTransfer = "transfer"
+)
+
+// Estados de la entrega de efectivo
+const (
+	HandoverPending   = "pending"   // Entregado por el motorista, sin confirmar
+	HandoverConfirmed = "confirmed" // Contado y completo
+	HandoverShortage  = "shortage"  // Contado con faltante
+)
+
+// errCashWithoutShift efectivo cobrado sin turno abierto: no habría entrega
+// de efectivo que lo cuadre
+var errCashWithoutShift = errors.New("el cobro en efectivo requiere un turno abierto del motorista")
+
+// checkCODPayment valida el cobro de la prueba de entrega: medio de pago
+// conocido y, si el pedido es contra entrega, medio y monto obligatorios.
+// Responde y devuelve false si no es válido.
+func checkCODPayment(c *gin.Context, orderID int, req DeliveryProofRequest) bool {
+	var cod sql.NullInt64
+	err := db.QueryRow("SELECT cod_amount_cents FROM orders WHERE id = $1", orderID).Scan(&cod)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Orden no encontrada"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la orden"})
+		return false
+	}
+
+	switch req.PaymentMethod {
+	case "", PaymentCash, PaymentCard, PaymentTransfer:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment_method debe ser cash, card o transfer"})
+		return false
+	}
+	if req.CollectedCents != nil && (*req.CollectedCents < 0 || req.PaymentMethod == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "collected_cents no puede ser negativo y requiere payment_method"})
+		return false
+	}
+	if cod.Valid && cod.Int64 > 0 && (req.PaymentMethod == "" || req.CollectedCents == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":            "El pedido es contra entrega: envía payment_method y collected_cents",
+			"code":             "cod_payment_required",
+			"cod_amount_cents": cod.Int64,
+		})
+		return false
+	}
+	return true
+}
+
+// activeShiftID turno activo (o en pausa) del motorista; nil si no tiene
+func activeShiftID(driverID int) (*int, error) {
+	var id int
+	err := db.QueryRow(`
+		SELECT id FROM shifts
+		WHERE driver_id = $1 AND status IN ('ACTIVE', 'PAUSED')
+		ORDER BY start_time DESC
+		LIMIT 1`, driverID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// shiftCollectionsQuery cobros del turno $1: la última prueba de entrega de
+// cada pedido con medio de pago
+const shiftCollectionsQuery = `
+	SELECT DISTINCT ON (p.order_id) p.order_id, COALESCE(o.client_name, ''), o.cod_amount_cents, p.payment_method,
+	       COALESCE(p.collected_cents, 0) AS collected_cents, p.captured_at
+	FROM delivery_proofs p
+	JOIN orders o ON o.id = p.order_id
+	WHERE p.shift_id = $1 AND p.stage = 'delivery' AND p.payment_method IS NOT NULL
+	ORDER BY p.order_id, p.captured_at DESC`
+
+// CashCollection cobro de un pedido en el turno
+type CashCollection struct {
+	OrderID        int       `json:"order_id"`
+	ClientName     string    `json:"client_name"`
+	CODAmountCents *int64    `json:"cod_amount_cents,omitempty"`
+	PaymentMethod  string    `json:"payment_method"`
+	CollectedCents int64     `json:"collected_cents"`
+	CapturedAt     time.Time `json:"captured_at"`
+}
+
+// CashHandover entrega del efectivo de un turno
+type CashHandover struct {
+	ID              int        `json:"id"`
+	ShiftID         int        `json:"shift_id"`
+	DriverID        int        `json:"driver_id"`
+	BranchID        *int       `json:"branch_id,omitempty"`
+	ExpectedCents   int64      `json:"expected_cents"`
+	DeclaredCents   int64      `json:"declared_cents"`
+	CountedCents    *int64     `json:"counted_cents,omitempty"`
+	DifferenceCents *int64     `json:"difference_cents,omitempty"` // counted - expected
+	Status          string     `json:"status"`
+	DriverNotes     *string    `json:"driver_notes,omitempty"`
+	SupervisorNotes *string    `json:"supervisor_notes,omitempty"`
+	HandedOverAt    time.Time  `json:"handed_over_at"`
+	ConfirmedBy     *int       `json:"confirmed_by,omitempty"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+}
+
+const cashHandoverColumns = `id, shift_id, driver_id, branch_id, expected_cents, declared_cents, counted_cents,
+	difference_cents, status, driver_notes, supervisor_notes, handed_over_at, confirmed_by, confirmed_at`
+
+func scanCashHandover(row rowScanner, h *CashHandover) error {
+	return row.Scan(&h.ID, &h.ShiftID, &h.DriverID, &h.BranchID, &h.ExpectedCents, &h.DeclaredCents, &h.CountedCents,
+		&h.DifferenceCents, &h.Status, &h.DriverNotes, &h.SupervisorNotes, &h.HandedOverAt, &h.ConfirmedBy, &h.ConfirmedAt)
+}
+
+// expectedShiftCash efectivo cobrado en el turno según las pruebas de entrega
+func expectedShiftCash(tx *sql.Tx, shiftID int) (int64, error) {
+	var cents int64
+	err := tx.QueryRow(`SELECT COALESCE(SUM(collected_cents), 0) FROM (`+shiftCollectionsQuery+`) c
+		WHERE c.payment_method = 'cash'`, shiftID).Scan(&cents)
+	return cents, err
+}
+
+// isCashSupervisor admin, manager y supervisores confirman entregas de efectivo
+func isCashSupervisor(c *gin.Context) bool {
+	role := c.GetHeader("X-User-Role")
+	return role == "admin" || role == "manager" || role == "supervisor"
+}
+
+// shiftForCash turno de la URL; solo su motorista o un supervisor pueden
+// verlo. Responde y devuelve false si no existe o no tiene permiso.
+func shiftForCash(c *gin.Context) (id, driverID int, status string, ok bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return 0, 0, "", false
+	}
+	err = db.QueryRow("SELECT driver_id, status FROM shifts WHERE id = $1", id).Scan(&driverID, &status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado"})
+		return 0, 0, "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el turno"})
+		return 0, 0, "", false
+	}
+	if !isCashSupervisor(c) && c.GetHeader("X-User-ID") != strconv.Itoa(driverID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "El turno no es tuyo"})
+		return 0, 0, "", false
+	}
+	return id, driverID, status, true
+}
+
+// GetShiftCash GET /shifts/:id/cash cobros del turno, totales por medio de
+// pago y la entrega de efectivo si ya se hizo
+func GetShiftCash(c *gin.Context) {
+	shiftID, driverID, status, ok := shiftForCash(c)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(shiftCollectionsQuery, shiftID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener los cobros"})
+		return
+	}
+	defer rows.Close()
+
+	collections := []CashCollection{}
+	totals := map[string]int64{PaymentCash: 0, PaymentCard: 0, PaymentTransfer: 0}
+	var codDue, codCollected int64
+	for rows.Next() {
+		var cc CashCollection
+		if err := rows.Scan(&cc.OrderID, &cc.ClientName, &cc.CODAmountCents, &cc.PaymentMethod,
+			&cc.CollectedCents, &cc.CapturedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al leer los cobros"})
+			return
+		}
+		totals[cc.PaymentMethod] += cc.CollectedCents
+		if cc.CODAmountCents != nil {
+			codDue += *cc.CODAmountCents
+			codCollected += cc.CollectedCents
+		}
+		collections = append(collections, cc)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al leer los cobros"})
+		return
+	}
+
+	var handover *CashHandover
+	var h CashHandover
+	err = scanCashHandover(db.QueryRow("SELECT "+cashHandoverColumns+" FROM cash_handovers WHERE shift_id = $1", shiftID), &h)
+	if err == nil {
+		handover = &h
+	} else if err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la entrega de efectivo"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shift_id":            shiftID,
+		"driver_id":           driverID,
+		"shift_status":        status,
+		"collections":         collections,
+		"totals_cents":        totals,
+		"cod_due_cents":       codDue,       // Lo que había que cobrar en los pedidos contra entrega
+		"cod_collected_cents": codCollected, // Lo cobrado en esos pedidos (cualquier medio)
+		"handover":            handover,
+	})
+}
+
+// CashHandoverRequest efectivo que el motorista entrega en la sucursal
+type CashHandoverRequest struct {
+	DeclaredCents *int64 `json:"declared_cents" binding:"required"`
+	BranchID      *int   `json:"branch_id"` // Por defecto la sucursal del turno
+	Notes         string `json:"notes"`
+}
+
+// CreateCashHandover POST /shifts/:id/cash-handover el motorista entrega el
+// efectivo del turno ya finalizado; queda pendiente de confirmar
+func CreateCashHandover(c *gin.Context) {
+	shiftID, driverID, status, ok := shiftForCash(c)
+	if !ok {
+		return
+	}
+	var req CashHandoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.DeclaredCents < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "declared_cents no puede ser negativo"})
+		return
+	}
+	if status == "ACTIVE" || status == "PAUSED" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Finaliza el turno antes de entregar el efectivo", "shift_status": status})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	expected, err := expectedShiftCash(tx, shiftID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular el efectivo del turno"})
+		return
+	}
+
+	var h CashHandover
+	err = scanCashHandover(tx.QueryRow(`
+		INSERT INTO cash_handovers (shift_id, driver_id, branch_id, expected_cents, declared_cents, driver_notes)
+		VALUES ($1, $2, COALESCE($3, (SELECT b.id FROM shifts s JOIN branches b ON b.code = s.branch WHERE s.id = $1)),
+		        $4, $5, NULLIF($6, ''))
+		RETURNING `+cashHandoverColumns,
+		shiftID, driverID, req.BranchID, expected, *req.DeclaredCents, req.Notes,
+	), &h)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "El efectivo de este turno ya fue entregado"})
+		return
+	}
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar la entrega de efectivo"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, h)
+}
+
+// ConfirmCashHandoverRequest conteo del supervisor
+type ConfirmCashHandoverRequest struct {
+	CountedCents *int64 `json:"counted_cents" binding:"required"`
+	Notes        string `json:"notes"`
+}
+
+// ConfirmCashHandover POST /cash-handovers/:id/confirm el supervisor cuenta
+// el efectivo; si es menos que lo cobrado la entrega queda como faltante
+func ConfirmCashHandover(c *gin.Context) {
+	if !isCashSupervisor(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Solo un supervisor puede confirmar la entrega de efectivo"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+	var req ConfirmCashHandoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.CountedCents < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "counted_cents no puede ser negativo"})
+		return
+	}
+	supervisorID, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var h CashHandover
+	err = scanCashHandover(tx.QueryRow("SELECT "+cashHandoverColumns+" FROM cash_handovers WHERE id = $1 FOR UPDATE", id), &h)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entrega de efectivo no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la entrega de efectivo"})
+		return
+	}
+	if h.Status != HandoverPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "La entrega de efectivo ya fue confirmada", "status": h.Status})
+		return
+	}
+
+	// Se recalcula por si llegaron pruebas del turno después de la entrega
+	expected, err := expectedShiftCash(tx, h.ShiftID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular el efectivo del turno"})
+		return
+	}
+	difference := *req.CountedCents - expected
+	status := HandoverConfirmed
+	if difference < 0 {
+		status = HandoverShortage
+	}
+
+	err = scanCashHandover(tx.QueryRow(`
+		UPDATE cash_handovers
+		SET expected_cents = $2, counted_cents = $3, difference_cents = $4, status = $5,
+		    supervisor_notes = NULLIF($6, ''), confirmed_by = NULLIF($7, 0), confirmed_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+cashHandoverColumns,
+		id, expected, *req.CountedCents, difference, status, req.Notes, supervisorID,
+	), &h)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al confirmar la entrega de efectivo"})
+		return
+	}
+
+	if status == HandoverShortage {
+		log.Printf("Efectivo: faltante de %d centavos en el turno %d (motorista %d)", -difference, h.ShiftID, h.DriverID)
+	}
+	c.JSON(http.StatusOK, h)
+}
+
+// GetCashHandovers GET /cash-handovers (filtros: status, driver_id, branch_id)
+func GetCashHandovers(c *gin.Context) {
+	if !isCashSupervisor(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para ver las entregas de efectivo"})
+		return
+	}
+	q := sqlbuilder.New(cashHandoverColumns, "cash_handovers")
+	if v := c.Query("status"); v != "" {
+		q.Where("status = ?", v)
+	}
+	for _, param := range []string{"driver_id", "branch_id"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+			return
+		}
+		q.Where(param+" = ?", n)
+	}
+
+	query, args := q.OrderBy("handed_over_at DESC", "id DESC").Limit(500).SQL()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener las entregas de efectivo"})
+		return
+	}
+	defer rows.Close()
+
+	handovers := []CashHandover{}
+	for rows.Next() {
+		var h CashHandover
+		if err := scanCashHandover(rows, &h); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al leer las entregas de efectivo"})
+			return
+		}
+		handovers = append(handovers, h)
+	}
+	c.JSON(http.StatusOK, handovers)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/positions"
 )
 
 // ========================================
@@ -21,6 +22,11 @@ type CheckInRequest struct {
 	Latitude  float64 `json:"latitude" binding:"required"`
 	Longitude float64 `json:"longitude" binding:"required"`
 	Notes     string  `json:"notes"`
+
+	// Datos del GPS del dispositivo para detectar ubicaciones falsas
+	Accuracy   *float64   `json:"accuracy"`
+	Speed      *float64   `json:"speed"`
+	CapturedAt *time.Time `json:"captured_at"`
 }
 
 // CheckIn registra el inicio de una visita
@@ -49,14 +55,30 @@ func CheckIn(c *gin.Context) {
 	// Calcular distancia en metros
 	distance := haversineMeters(req.Latitude, req.Longitude, branchLat, branchLng)
 
+	// Puntaje de integridad GPS contra las visitas anteriores del coordinador
+	fix := positions.Fix{
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Accuracy:  req.Accuracy,
+		Speed:     req.Speed,
+		Timestamp: time.Now(),
+	}
+	if req.CapturedAt != nil && !req.CapturedAt.IsZero() {
+		fix.Timestamp = *req.CapturedAt
+	}
+	gps := scoreVisitFix(coordinatorID, fix)
+	gpsScore, gpsFlags, gpsSuspicious := integrityColumns(gps)
+
 	// Crear visita
 	var visitID int
 	err = db.QueryRow(`
 		INSERT INTO coordinator_visits 
-		(coordinator_id, branch_id, check_in_latitude, check_in_longitude, distance_to_branch_meters, notes, status)
-		VALUES ($1, $2, $3, $4, $5, $6, 'in_progress')
+		(coordinator_id, branch_id, check_in_latitude, check_in_longitude, distance_to_branch_meters, notes, status,
+		 check_in_accuracy, gps_score, gps_flags, gps_suspicious)
+		VALUES ($1, $2, $3, $4, $5, $6, 'in_progress', $7, $8, $9, $10)
 		RETURNING id`,
 		coordinatorID, req.BranchID, req.Latitude, req.Longitude, int(distance), req.Notes,
+		req.Accuracy, gpsScore, gpsFlags, gpsSuspicious,
 	).Scan(&visitID)
 
 	if err != nil {
@@ -64,11 +86,16 @@ func CheckIn(c *gin.Context) {
 		return
 	}
 
+	if gpsSuspicious {
+		openGPSReview("visit", visitID, &coordinatorID, gps)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"visit_id":         visitID,
 		"message":          "Check-in exitoso",
 		"distance_meters":  int(distance),
 		"is_within_branch": distance <= 500, // Dentro de 500m de la sucursal
+		"gps_integrity":    gps,             // null si no se pudo evaluar
 	})
 }
 
@@ -418,10 +445,13 @@ func GetBranchKPIs(c *gin.Context) {
 			COUNT(DISTINCT m.id) as total_motos,
 			COUNT(DISTINCT CASE WHEN m.status = 'available' THEN m.id END) as motos_available,
 			COUNT(DISTINCT CASE WHEN m.status = 'in_route' THEN m.id END) as motos_in_route,
-			(SELECT COUNT(*) FROM orders o WHERE o.branch = b.code AND o.status = 'pending') as pending_orders,
-			(SELECT COUNT(*) FROM orders o WHERE o.branch = b.code AND o.status = 'assigned') as assigned_orders,
-			(SELECT COUNT(*) FROM orders o WHERE o.branch = b.code AND o.status = 'delivered' AND o.updated_at >= CURRENT_DATE) as delivered_today,
-			(SELECT COUNT(*) FROM coordinator_visits cv WHERE cv.branch_id = b.id AND cv.check_in_time >= CURRENT_DATE) as visits_today
+			(SELECT COUNT(*) FROM orders o WHERE o.branch_id = b.id AND o.status = 'pending') as pending_orders,
+			(SELECT COUNT(*) FROM orders o WHERE o.branch_id = b.id AND o.status = 'assigned') as assigned_orders,
+			(SELECT COUNT(*) FROM orders o WHERE o.branch_id = b.id AND o.status = 'delivered' AND o.updated_at >= CURRENT_DATE) as delivered_today,
+			(SELECT COUNT(*) FROM coordinator_visits cv WHERE cv.branch_id = b.id AND cv.check_in_time >= CURRENT_DATE) as visits_today,
+			(SELECT COUNT(*) FROM delivery_attempts da JOIN orders o ON o.id = da.order_id
+			 WHERE o.branch_id = b.id AND da.created_at >= CURRENT_DATE) as failed_attempts_today,
+			(SELECT COUNT(*) FROM orders o WHERE o.branch_id = b.id AND o.status = 'failed') as failed_orders
 		FROM branches b
 		LEFT JOIN motos m ON m.branch_id = b.id
 		WHERE b.is_active = true
@@ -440,7 +470,8 @@ func GetBranchKPIs(c *gin.Context) {
 		var k models.BranchKPI
 		if err := rows.Scan(&k.BranchID, &k.BranchName, &k.BranchCode,
 			&k.TotalMotos, &k.MotosAvailable, &k.MotosInRoute,
-			&k.PendingOrders, &k.AssignedOrders, &k.DeliveredToday, &k.VisitsToday); err != nil {
+			&k.PendingOrders, &k.AssignedOrders, &k.DeliveredToday, &k.VisitsToday,
+			&k.FailedAttemptsToday, &k.FailedOrders); err != nil {
 			continue
 		}
 		kpis = append(kpis, k)
@@ -453,6 +484,8 @@ func GetBranchKPIs(c *gin.Context) {
 		totals.AssignedOrders += k.AssignedOrders
 		totals.DeliveredToday += k.DeliveredToday
 		totals.VisitsToday += k.VisitsToday
+		totals.FailedAttemptsToday += k.FailedAttemptsToday
+		totals.FailedOrders += k.FailedOrders
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,816 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/sqlbuilder"
+	"github.com/logitrack/order-service/validation"
+)
+
+// ========================================
+// CLIENTES Y LIBRETA DE DIRECCIONES
+// ========================================
+// El email (minúsculas) y el teléfono (solo dígitos) identifican al cliente:
+// no se repiten entre clientes activos. Los duplicados que no comparten
+// contacto (p. ej. uno con email y otro con teléfono) se fusionan con
+// POST /customers/:id/merge; el cliente absorbido queda con merged_into_id.
+
+const customerColumns = `id, name, email, phone, notes, merged_into_id, created_at, updated_at`
+
+func scanCustomer(row rowScanner, cu *models.Customer) error {
+	return row.Scan(&cu.ID, &cu.Name, &cu.Email, &cu.Phone, &cu.Notes, &cu.MergedIntoID, &cu.CreatedAt, &cu.UpdatedAt)
+}
+
+const customerAddressColumns = `id, customer_id, label, address, latitude, longitude, instructions, is_default, created_at,
+	needs_pinning, geocode_confidence, geocode_provider`
+
+func scanCustomerAddress(row rowScanner, a *models.CustomerAddress) error {
+	return row.Scan(&a.ID, &a.CustomerID, &a.Label, &a.Address, &a.Latitude, &a.Longitude, &a.Instructions,
+		&a.IsDefault, &a.CreatedAt, &a.NeedsPinning, &a.GeocodeConfidence, &a.GeocodeProvider)
+}
+
+// normalizeEmail email en minúsculas; nil si está vacío
+func normalizeEmail(s string) *string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// normalizePhone solo los dígitos del teléfono; nil si no tiene
+func normalizePhone(s string) *string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+	if digits == "" {
+		return nil
+	}
+	return &digits
+}
+
+// findCustomerByContact cliente activo con ese email o teléfono (0 = ninguno)
+func findCustomerByContact(tx *sql.Tx, email, phone *string, excludeID int) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		SELECT id FROM customers
+		WHERE merged_into_id IS NULL AND id <> $3 AND (email = $1 OR phone = $2)
+		ORDER BY id LIMIT 1
+	`, email, phone, excludeID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// respondDuplicateCustomer responde 409 con el cliente que ya tiene el contacto
+func respondDuplicateCustomer(c *gin.Context, existingID int) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":                "Ya existe un cliente con ese email o teléfono",
+		"code":                 "duplicate_customer",
+		"existing_customer_id": existingID,
+	})
+}
+
+// loadCustomer cliente con sus direcciones
+func loadCustomer(id int) (*models.Customer, error) {
+	var cu models.Customer
+	if err := scanCustomer(db.QueryRow("SELECT "+customerColumns+" FROM customers WHERE id = $1", id), &cu); err != nil {
+		return nil, err
+	}
+	addresses, err := loadCustomerAddresses(db, id)
+	if err != nil {
+		return nil, err
+	}
+	cu.Addresses = addresses
+	return &cu, nil
+}
+
+// loadCustomerAddresses direcciones del cliente, la predeterminada primero
+func loadCustomerAddresses(q rowsQueryer, customerID int) ([]models.CustomerAddress, error) {
+	rows, err := q.Query("SELECT "+customerAddressColumns+" FROM customer_addresses WHERE customer_id = $1 "+
+		"ORDER BY is_default DESC, id", customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []models.CustomerAddress{}
+	for rows.Next() {
+		var a models.CustomerAddress
+		if err := scanCustomerAddress(rows, &a); err != nil {
+			continue
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+// insertCustomerAddressTx guarda una dirección en el punto indicado; la
+// primera del cliente o la marcada is_default pasa a ser la predeterminada
+func insertCustomerAddressTx(tx *sql.Tx, customerID int, req validation.CustomerAddressRequest, pt geoPoint) (models.CustomerAddress, error) {
+	var a models.CustomerAddress
+	err := scanCustomerAddress(tx.QueryRow(`
+		INSERT INTO customer_addresses (customer_id, label, address, latitude, longitude, instructions, is_default,
+		                                needs_pinning, geocode_confidence, geocode_provider)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, NULLIF($6, ''),
+		        $7 OR NOT EXISTS (SELECT 1 FROM customer_addresses WHERE customer_id = $1), $8, $9, $10)
+		RETURNING `+customerAddressColumns,
+		customerID, req.Label, req.Address, pt.Latitude, pt.Longitude, req.Instructions, req.IsDefault,
+		pt.NeedsPinning, pt.Confidence, pt.Provider,
+	), &a)
+	if err != nil {
+		return a, err
+	}
+	if a.IsDefault {
+		err = unsetOtherDefaultsTx(tx, customerID, a.ID)
+	}
+	return a, err
+}
+
+func unsetOtherDefaultsTx(tx *sql.Tx, customerID, addressID int) error {
+	_, err := tx.Exec("UPDATE customer_addresses SET is_default = false WHERE customer_id = $1 AND id <> $2 AND is_default",
+		customerID, addressID)
+	return err
+}
+
+// activeCustomerID sigue merged_into_id hasta el cliente activo
+func activeCustomerID(id int) (int, error) {
+	for i := 0; i < 10; i++ {
+		var mergedInto sql.NullInt64
+		if err := db.QueryRow("SELECT merged_into_id FROM customers WHERE id = $1", id).Scan(&mergedInto); err != nil {
+			return 0, err
+		}
+		if !mergedInto.Valid {
+			return id, nil
+		}
+		id = int(mergedInto.Int64)
+	}
+	return id, nil
+}
+
+// resolveOrderCustomer completa el pedido con los datos del cliente y de la
+// dirección de su libreta (antes de validarlo); con address_id devuelve el
+// punto de esa dirección. Responde y devuelve false si customer_id o
+// address_id no son válidos.
+func resolveOrderCustomer(c *gin.Context, req *validation.CreateOrderRequest) (*geoPoint, bool) {
+	var point *geoPoint
+	if req.AddressID != nil {
+		var a models.CustomerAddress
+		err := scanCustomerAddress(db.QueryRow("SELECT "+customerAddressColumns+" FROM customer_addresses WHERE id = $1",
+			*req.AddressID), &a)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "address_id no existe"})
+			return nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer address"})
+			return nil, false
+		}
+		// Las direcciones siempre son de un cliente activo (la fusión las mueve)
+		if req.CustomerID == nil {
+			req.CustomerID = &a.CustomerID
+		} else if owner, err := activeCustomerID(*req.CustomerID); err != nil || owner != a.CustomerID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La dirección no pertenece al cliente"})
+			return nil, false
+		}
+		req.Address = a.Address
+		point = &geoPoint{
+			Latitude:     a.Latitude,
+			Longitude:    a.Longitude,
+			Confidence:   a.GeocodeConfidence,
+			Provider:     a.GeocodeProvider,
+			NeedsPinning: a.NeedsPinning,
+		}
+	}
+
+	if req.CustomerID == nil {
+		return point, true
+	}
+	id, err := activeCustomerID(*req.CustomerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id no existe"})
+		return nil, false
+	}
+	var cu models.Customer
+	if err == nil {
+		err = scanCustomer(db.QueryRow("SELECT "+customerColumns+" FROM customers WHERE id = $1", id), &cu)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+		return nil, false
+	}
+	req.CustomerID = &cu.ID
+	if strings.TrimSpace(req.ClientName) == "" {
+		req.ClientName = cu.Name
+	}
+	if req.ClientEmail == "" && cu.Email != nil {
+		req.ClientEmail = *cu.Email
+	}
+	return point, true
+}
+
+// linkOrderCustomerTx cliente de un pedido cargado con datos sueltos: el del
+// email, creándolo si no existe. nil si el pedido no trae email.
+func linkOrderCustomerTx(tx *sql.Tx, name, email string) (*int, error) {
+	normalized := normalizeEmail(email)
+	if normalized == nil {
+		return nil, nil
+	}
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO customers (name, email) VALUES ($1, $2)
+		ON CONFLICT (email) WHERE merged_into_id IS NULL DO UPDATE SET updated_at = customers.updated_at
+		RETURNING id
+	`, strings.TrimSpace(name), normalized).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// parseCustomerID lee :id; responde 400 si no es un número
+func parseCustomerID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de cliente inválido"})
+		return 0, false
+	}
+	return id, true
+}
+
+// CreateCustomer POST /customers
+func CreateCustomer(c *gin.Context) {
+	var req validation.CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email, phone := normalizeEmail(req.Email), normalizePhone(req.Phone)
+
+	// Direcciones sin coordenadas se geocodifican antes de abrir la transacción
+	points := make([]geoPoint, len(req.Addresses))
+	for i, a := range req.Addresses {
+		pt, ok := locateAddress(c, a.Address, a.Latitude, a.Longitude)
+		if !ok {
+			return
+		}
+		points[i] = pt
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	if existing, err := findCustomerByContact(tx, email, phone, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar duplicados"})
+		return
+	} else if existing != 0 {
+		respondDuplicateCustomer(c, existing)
+		return
+	}
+
+	var cu models.Customer
+	err = scanCustomer(tx.QueryRow(`
+		INSERT INTO customers (name, email, phone, notes) VALUES ($1, $2, $3, NULLIF($4, ''))
+		RETURNING `+customerColumns,
+		strings.TrimSpace(req.Name), email, phone, req.Notes,
+	), &cu)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear el cliente"})
+		return
+	}
+	for i, a := range req.Addresses {
+		if _, err := insertCustomerAddressTx(tx, cu.ID, a, points[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar la dirección"})
+			return
+		}
+	}
+	if cu.Addresses, err = loadCustomerAddresses(tx, cu.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener las direcciones"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear el cliente"})
+		return
+	}
+	c.JSON(http.StatusCreated, cu)
+}
+
+// GetCustomers GET /customers?q=&limit=&cursor=&include_merged=true
+// q busca en nombre, email y teléfono. Ordenado por nombre, paginado por cursor.
+func GetCustomers(c *gin.Context) {
+	q := sqlbuilder.New(customerColumns, "customers")
+	if c.Query("include_merged") != "true" {
+		q.Where("merged_into_id IS NULL")
+	}
+	if v := strings.TrimSpace(c.Query("q")); v != "" {
+		pattern := sqlbuilder.Contains(v)
+		if phone := normalizePhone(v); phone != nil {
+			q.Where("(name ILIKE ? OR email ILIKE ? OR phone LIKE ?)", pattern, pattern, sqlbuilder.Contains(*phone))
+		} else {
+			q.Where("(name ILIKE ? OR email ILIKE ?)", pattern, pattern)
+		}
+	}
+
+	var total int
+	countSQL, countArgs := q.CountSQL()
+	if err := db.QueryRow(countSQL, countArgs...).Scan(&total); err != nil {
+		log.Printf("Error counting customers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list customers"})
+		return
+	}
+
+	var after *sqlbuilder.Cursor
+	if v := c.Query("cursor"); v != "" {
+		cur, err := sqlbuilder.DecodeCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		after = &cur
+	}
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, 500)
+	}
+	query, args := q.Seek("name", "id", "text", false, after).Limit(limit + 1).SQL()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Error listing customers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list customers"})
+		return
+	}
+	defer rows.Close()
+
+	customers := []models.Customer{}
+	for rows.Next() {
+		var cu models.Customer
+		if err := scanCustomer(rows, &cu); err != nil {
+			continue
+		}
+		customers = append(customers, cu)
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if len(customers) > limit {
+		customers = customers[:limit]
+		last := customers[limit-1]
+		c.Header("X-Next-Cursor", sqlbuilder.Cursor{Value: last.Name, ID: last.ID}.Encode())
+	}
+	c.JSON(http.StatusOK, customers)
+}
+
+// GetCustomer GET /customers/:id con sus direcciones
+func GetCustomer(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+	cu, err := loadCustomer(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el cliente"})
+		return
+	}
+	c.JSON(http.StatusOK, cu)
+}
+
+// UpdateCustomer PUT /customers/:id
+func UpdateCustomer(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+	var req validation.UpdateCustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var cu models.Customer
+	err = scanCustomer(tx.QueryRow("SELECT "+customerColumns+" FROM customers WHERE id = $1 FOR UPDATE", id), &cu)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el cliente"})
+		return
+	}
+	if cu.MergedIntoID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "El cliente fue fusionado", "merged_into_id": *cu.MergedIntoID})
+		return
+	}
+
+	if req.Name != nil {
+		cu.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Email != nil {
+		cu.Email = normalizeEmail(*req.Email)
+	}
+	if req.Phone != nil {
+		cu.Phone = normalizePhone(*req.Phone)
+	}
+	if req.Notes != nil {
+		cu.Notes = req.Notes
+		if *req.Notes == "" {
+			cu.Notes = nil
+		}
+	}
+
+	if existing, err := findCustomerByContact(tx, cu.Email, cu.Phone, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar duplicados"})
+		return
+	} else if existing != 0 {
+		respondDuplicateCustomer(c, existing)
+		return
+	}
+
+	err = scanCustomer(tx.QueryRow(`
+		UPDATE customers SET name = $2, email = $3, phone = $4, notes = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+customerColumns,
+		id, cu.Name, cu.Email, cu.Phone, cu.Notes,
+	), &cu)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar el cliente"})
+		return
+	}
+	c.JSON(http.StatusOK, cu)
+}
+
+// GetCustomerDuplicates GET /customers/duplicates
+// Grupos de clientes activos candidatos a fusionar: mismo nombre normalizado
+// o mismo teléfono en sus últimos 8 dígitos (el mismo número con y sin código
+// de país, que el índice único de teléfono no detecta)
+func GetCustomerDuplicates(c *gin.Context) {
+	rows, err := db.Query(`
+		(SELECT 'name' AS kind, LOWER(REGEXP_REPLACE(TRIM(name), '\s+', ' ', 'g')) AS key, ARRAY_AGG(id ORDER BY id)
+		 FROM customers
+		 WHERE merged_into_id IS NULL
+		 GROUP BY key
+		 HAVING COUNT(*) > 1
+		 ORDER BY key
+		 LIMIT 200)
+		UNION ALL
+		(SELECT 'phone' AS kind, RIGHT(REGEXP_REPLACE(phone, '\D', '', 'g'), 8) AS key, ARRAY_AGG(id ORDER BY id)
+		 FROM customers
+		 WHERE merged_into_id IS NULL AND LENGTH(REGEXP_REPLACE(phone, '\D', '', 'g')) >= 8
+		 GROUP BY key
+		 HAVING COUNT(*) > 1
+		 ORDER BY key
+		 LIMIT 200)
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar clientes duplicados"})
+		return
+	}
+	defer rows.Close()
+
+	type duplicateGroup struct {
+		Match       string  `json:"match"` // name o phone
+		Name        string  `json:"name,omitempty"`
+		Phone       string  `json:"phone,omitempty"` // Últimos 8 dígitos
+		CustomerIDs []int64 `json:"customer_ids"`
+	}
+	groups := []duplicateGroup{}
+	for rows.Next() {
+		var g duplicateGroup
+		var key string
+		if err := rows.Scan(&g.Match, &key, pq.Array(&g.CustomerIDs)); err != nil {
+			continue
+		}
+		if g.Match == "phone" {
+			g.Phone = key
+		} else {
+			g.Name = key
+		}
+		groups = append(groups, g)
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups, "total": len(groups)})
+}
+
+// MergeCustomers POST /customers/:id/merge
+// Fusiona source_id en el cliente de la URL: pedidos y direcciones pasan al
+// destino, que completa email y teléfono si no los tenía.
+func MergeCustomers(c *gin.Context) {
+	targetID, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+	var req validation.MergeCustomersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SourceID == targetID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No se puede fusionar un cliente consigo mismo"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Bloquear ambos en orden de id para evitar interbloqueos
+	rows, err := tx.Query("SELECT "+customerColumns+" FROM customers WHERE id = ANY($1) ORDER BY id FOR UPDATE",
+		pq.Array([]int64{int64(targetID), int64(req.SourceID)}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener los clientes"})
+		return
+	}
+	found := map[int]models.Customer{}
+	for rows.Next() {
+		var cu models.Customer
+		if err := scanCustomer(rows, &cu); err == nil {
+			found[cu.ID] = cu
+		}
+	}
+	rows.Close()
+	target, okTarget := found[targetID]
+	source, okSource := found[req.SourceID]
+	if !okTarget || !okSource {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente no encontrado"})
+		return
+	}
+	if target.MergedIntoID != nil || source.MergedIntoID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Uno de los clientes ya fue fusionado"})
+		return
+	}
+
+	var ordersMoved, addressesMoved int64
+	res, err := tx.Exec("UPDATE orders SET customer_id = $1 WHERE customer_id = $2", targetID, req.SourceID)
+	if err == nil {
+		ordersMoved, _ = res.RowsAffected()
+		res, err = tx.Exec("UPDATE customer_addresses SET customer_id = $1, is_default = false WHERE customer_id = $2",
+			targetID, req.SourceID)
+	}
+	if err == nil {
+		addressesMoved, _ = res.RowsAffected()
+		// El contrato de precios del origen pasa al destino si éste no tiene uno activo
+		_, err = tx.Exec(`
+			UPDATE pricing_contracts SET customer_id = $1, updated_at = CURRENT_TIMESTAMP
+			WHERE customer_id = $2
+			  AND NOT (is_active AND EXISTS (SELECT 1 FROM pricing_contracts WHERE customer_id = $1 AND is_active))
+		`, targetID, req.SourceID)
+	}
+	if err == nil {
+		// Los que ya apuntaban al origen pasan a apuntar al destino
+		_, err = tx.Exec("UPDATE customers SET merged_into_id = $1 WHERE merged_into_id = $2", targetID, req.SourceID)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE customers SET merged_into_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+			targetID, req.SourceID)
+	}
+	if err == nil {
+		// El origen ya no es activo: su email y teléfono quedan libres para el destino
+		_, err = tx.Exec(`
+			UPDATE customers SET email = COALESCE(email, $2), phone = COALESCE(phone, $3),
+			       notes = COALESCE(notes, $4), updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, targetID, source.Email, source.Phone, source.Notes)
+	}
+	if err == nil {
+		// Si el destino no tenía dirección predeterminada, la más antigua
+		_, err = tx.Exec(`
+			UPDATE customer_addresses SET is_default = true
+			WHERE id = (SELECT id FROM customer_addresses WHERE customer_id = $1 ORDER BY id LIMIT 1)
+			  AND NOT EXISTS (SELECT 1 FROM customer_addresses WHERE customer_id = $1 AND is_default)
+		`, targetID)
+	}
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al fusionar los clientes"})
+		return
+	}
+
+	merged, err := loadCustomer(targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el cliente"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"customer":        merged,
+		"merged_id":       req.SourceID,
+		"orders_moved":    ordersMoved,
+		"addresses_moved": addressesMoved,
+	})
+}
+
+// GetCustomerOrders GET /customers/:id/orders historial de pedidos del
+// cliente, con los mismos filtros y paginación que GET /orders
+func GetCustomerOrders(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM customers WHERE id = $1)", id).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el cliente"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente no encontrado"})
+		return
+	}
+	listOrders(c, sqlbuilder.New(orderColumns, "orders").Where("customer_id = ?", id))
+}
+
+// GetCustomerAddresses GET /customers/:id/addresses
+func GetCustomerAddresses(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+	addresses, err := loadCustomerAddresses(db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener las direcciones"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"customer_id": id, "addresses": addresses, "total": len(addresses)})
+}
+
+// AddCustomerAddress POST /customers/:id/addresses
+func AddCustomerAddress(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+	var req validation.CustomerAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pt, ok := locateAddress(c, req.Address, req.Latitude, req.Longitude)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var mergedInto sql.NullInt64
+	err = tx.QueryRow("SELECT merged_into_id FROM customers WHERE id = $1 FOR UPDATE", id).Scan(&mergedInto)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el cliente"})
+		return
+	}
+	if mergedInto.Valid {
+		c.JSON(http.StatusConflict, gin.H{"error": "El cliente fue fusionado", "merged_into_id": mergedInto.Int64})
+		return
+	}
+
+	a, err := insertCustomerAddressTx(tx, id, req, pt)
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar la dirección"})
+		return
+	}
+	c.JSON(http.StatusCreated, a)
+}
+
+// UpdateCustomerAddress PUT /addresses/:id reemplaza la dirección
+func UpdateCustomerAddress(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de dirección inválido"})
+		return
+	}
+	var req validation.CustomerAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pt, ok := locateAddress(c, req.Address, req.Latitude, req.Longitude)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var a models.CustomerAddress
+	err = scanCustomerAddress(tx.QueryRow(`
+		UPDATE customer_addresses
+		SET label = NULLIF($2, ''), address = $3, latitude = $4, longitude = $5, instructions = NULLIF($6, ''),
+		    is_default = is_default OR $7, needs_pinning = $8, geocode_confidence = $9, geocode_provider = $10
+		WHERE id = $1
+		RETURNING `+customerAddressColumns,
+		id, req.Label, req.Address, pt.Latitude, pt.Longitude, req.Instructions, req.IsDefault,
+		pt.NeedsPinning, pt.Confidence, pt.Provider,
+	), &a)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dirección no encontrada"})
+		return
+	}
+	if err == nil && req.IsDefault {
+		err = unsetOtherDefaultsTx(tx, a.CustomerID, a.ID)
+	}
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar la dirección"})
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// DeleteCustomerAddress DELETE /addresses/:id
+// Los pedidos que la usaron conservan su dirección en texto
+func DeleteCustomerAddress(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de dirección inválido"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var customerID int
+	var wasDefault bool
+	err = tx.QueryRow("DELETE FROM customer_addresses WHERE id = $1 RETURNING customer_id, is_default", id).
+		Scan(&customerID, &wasDefault)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dirección no encontrada"})
+		return
+	}
+	if err == nil && wasDefault {
+		// La más antigua pasa a ser la predeterminada
+		_, err = tx.Exec(`
+			UPDATE customer_addresses SET is_default = true
+			WHERE id = (SELECT id FROM customer_addresses WHERE customer_id = $1 ORDER BY id LIMIT 1)
+		`, customerID)
+	}
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar la dirección"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Dirección eliminada"})
+}
@@ -12,6 +12,14 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/positions"
+)
+
+// Etapas de una prueba: recogida (pedidos con recogida) o entrega
+const (
+	ProofStagePickup   = "pickup"
+	ProofStageDelivery = "delivery"
 )
 
 // DeliveryProofRequest representa la prueba de entrega
@@ -20,6 +28,16 @@ type DeliveryProofRequest struct {
 	PhotoBase64     string `json:"photo_base64"`
 	RecipientName   string `json:"recipient_name"`
 	Notes           string `json:"notes"`
+
+	// Ubicación del dispositivo al capturar la prueba
+	Latitude   *float64   `json:"latitude"`
+	Longitude  *float64   `json:"longitude"`
+	Accuracy   *float64   `json:"accuracy"`
+	CapturedAt *time.Time `json:"captured_at"`
+
+	// Cobro en la entrega (requerido si el pedido tiene cod_amount_cents)
+	PaymentMethod  string `json:"payment_method"` // cash, card, transfer
+	CollectedCents *int64 `json:"collected_cents"`
 }
 
 // DeliveryProof modelo de base de datos
@@ -33,6 +51,14 @@ type DeliveryProof struct {
 	CapturedAt    time.Time `json:"captured_at"`
 	Latitude      *float64  `json:"latitude,omitempty"`
 	Longitude     *float64  `json:"longitude,omitempty"`
+	Accuracy      *float64  `json:"accuracy,omitempty"`
+	GPSScore      *int      `json:"gps_score,omitempty"`
+	GPSFlags      []string  `json:"gps_flags,omitempty"`
+	GPSSuspicious bool      `json:"gps_suspicious"`
+
+	PaymentMethod  *string `json:"payment_method,omitempty"`
+	CollectedCents *int64  `json:"collected_cents,omitempty"`
+	ShiftID        *int    `json:"shift_id,omitempty"`
 }
 
 // SaveDeliveryProof guarda la prueba de entrega (firma + foto)
@@ -48,14 +74,104 @@ func SaveDeliveryProof(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !checkCODPayment(c, orderID, req) {
+		return
+	}
 
-	// Verificar que la orden existe
-	var exists bool
-	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1)", orderID).Scan(&exists)
-	if err != nil || !exists {
+	result, err := storeDeliveryProof(orderID, ProofStageDelivery, req)
+	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Orden no encontrada"})
 		return
 	}
+	if err == errCashWithoutShift {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar prueba: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// storeDeliveryProof guarda la prueba de la etapa en su propia transacción y
+// luego notifica la recogida o la entrega. Devuelve sql.ErrNoRows si la orden
+// no existe.
+func storeDeliveryProof(orderID int, stage string, req DeliveryProofRequest) (gin.H, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	proof, err := insertDeliveryProof(tx, orderID, stage, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	proof.publish()
+	return proof.result(), nil
+}
+
+// savedProof prueba registrada en una transacción aún sin confirmar
+type savedProof struct {
+	orderID      int
+	stage        string
+	proofID      int
+	driverID     *int
+	shiftID      *int
+	signatureURL string
+	photoURL     string
+	gps          *positions.Integrity
+	req          DeliveryProofRequest
+}
+
+// insertDeliveryProof guarda los archivos y el registro de la prueba de la
+// etapa dentro de tx y evalúa la ubicación. Las notificaciones quedan para
+// publish, una vez confirmada la transacción.
+func insertDeliveryProof(tx *sql.Tx, orderID int, stage string, req DeliveryProofRequest) (*savedProof, error) {
+	// Verificar que la orden existe
+	var motoID, driverID *int
+	err := tx.QueryRow(`
+		SELECT o.assigned_moto_id, m.driver_id
+		FROM orders o
+		LEFT JOIN motos m ON m.id = o.assigned_moto_id
+		WHERE o.id = $1`, orderID).Scan(&motoID, &driverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Puntaje de integridad GPS contra el recorrido del driver asignado
+	var gps *positions.Integrity
+	if req.Latitude != nil && req.Longitude != nil {
+		fix := positions.Fix{
+			Latitude:  *req.Latitude,
+			Longitude: *req.Longitude,
+			Accuracy:  req.Accuracy,
+			Timestamp: time.Now(),
+		}
+		if req.CapturedAt != nil && !req.CapturedAt.IsZero() {
+			fix.Timestamp = *req.CapturedAt
+		}
+		gps = scoreProofFix(motoID, fix)
+	}
+	gpsScore, gpsFlags, gpsSuspicious := integrityColumns(gps)
+
+	// El cobro se registra solo en la entrega, con el turno activo del motorista
+	var shiftID *int
+	if stage != ProofStageDelivery {
+		req.PaymentMethod, req.CollectedCents = "", nil
+	} else if driverID != nil {
+		if shiftID, err = activeShiftID(*driverID); err != nil {
+			return nil, err
+		}
+	}
+	if req.PaymentMethod == PaymentCash && shiftID == nil {
+		return nil, errCashWithoutShift
+	}
 
 	// Crear directorio de uploads si no existe
 	uploadsDir := os.Getenv("UPLOADS_DIR")
@@ -65,16 +181,16 @@ func SaveDeliveryProof(c *gin.Context) {
 	os.MkdirAll(uploadsDir, 0755)
 
 	timestamp := time.Now().Format("20060102_150405")
-	var signatureURL, photoURL string
+	p := &savedProof{orderID: orderID, stage: stage, driverID: driverID, shiftID: shiftID, gps: gps, req: req}
 
 	// Guardar firma
 	if req.SignatureBase64 != "" {
 		signatureData, err := decodeBase64Image(req.SignatureBase64)
 		if err == nil {
-			signatureFilename := fmt.Sprintf("sig_%d_%s.png", orderID, timestamp)
+			signatureFilename := fmt.Sprintf("%s_sig_%d_%s.png", stage, orderID, timestamp)
 			signaturePath := filepath.Join(uploadsDir, signatureFilename)
 			if err := os.WriteFile(signaturePath, signatureData, 0644); err == nil {
-				signatureURL = "/uploads/delivery_proofs/" + signatureFilename
+				p.signatureURL = "/uploads/delivery_proofs/" + signatureFilename
 			}
 		}
 	}
@@ -83,67 +199,82 @@ func SaveDeliveryProof(c *gin.Context) {
 	if req.PhotoBase64 != "" {
 		photoData, err := decodeBase64Image(req.PhotoBase64)
 		if err == nil {
-			photoFilename := fmt.Sprintf("photo_%d_%s.jpg", orderID, timestamp)
+			photoFilename := fmt.Sprintf("%s_photo_%d_%s.jpg", stage, orderID, timestamp)
 			photoPath := filepath.Join(uploadsDir, photoFilename)
 			if err := os.WriteFile(photoPath, photoData, 0644); err == nil {
-				photoURL = "/uploads/delivery_proofs/" + photoFilename
+				p.photoURL = "/uploads/delivery_proofs/" + photoFilename
 			}
 		}
 	}
 
-	// Guardar en base de datos
-	var proofID int
-	err = db.QueryRow(`
+	// Guardar en base de datos (la tabla la crean las migraciones 010 y 024)
+	err = tx.QueryRow(`
 		INSERT INTO delivery_proofs 
-		(order_id, signature_url, photo_url, recipient_name, notes, captured_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		(order_id, signature_url, photo_url, recipient_name, notes, captured_at,
+		 latitude, longitude, accuracy, gps_score, gps_flags, gps_suspicious, stage,
+		 payment_method, collected_cents, driver_id, shift_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''), $15, $16, $17)
 		RETURNING id`,
-		orderID, signatureURL, photoURL, req.RecipientName, req.Notes, time.Now(),
-	).Scan(&proofID)
-
+		orderID, p.signatureURL, p.photoURL, req.RecipientName, req.Notes, time.Now(),
+		req.Latitude, req.Longitude, req.Accuracy, gpsScore, gpsFlags, gpsSuspicious, stage,
+		req.PaymentMethod, req.CollectedCents, driverID, shiftID,
+	).Scan(&p.proofID)
 	if err != nil {
-		// Si no existe la tabla, crearla
-		db.Exec(`
-			CREATE TABLE IF NOT EXISTS delivery_proofs (
-				id SERIAL PRIMARY KEY,
-				order_id INTEGER NOT NULL REFERENCES orders(id),
-				signature_url TEXT,
-				photo_url TEXT,
-				recipient_name TEXT,
-				notes TEXT,
-				captured_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				latitude DOUBLE PRECISION,
-				longitude DOUBLE PRECISION
-			)
-		`)
-		// Reintentar inserción
-		err = db.QueryRow(`
-			INSERT INTO delivery_proofs 
-			(order_id, signature_url, photo_url, recipient_name, notes, captured_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			RETURNING id`,
-			orderID, signatureURL, photoURL, req.RecipientName, req.Notes, time.Now(),
-		).Scan(&proofID)
+		return nil, err
 	}
+	return p, nil
+}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar prueba: " + err.Error()})
-		return
+// publish abre la revisión de GPS si hace falta y notifica a sistemas
+// externos. Solo se llama tras confirmar la transacción.
+func (p *savedProof) publish() {
+	if p.gps != nil && p.gps.Suspicious {
+		openGPSReview("delivery_proof", p.proofID, p.driverID, p.gps)
 	}
 
-	// Notificar a sistemas externos
-	go NotifyDeliveryCompleted(orderID, "", time.Now(), signatureURL, photoURL)
+	if p.stage == ProofStagePickup {
+		go NotifyOrderStatusChange(p.orderID, "", "picked_up", map[string]interface{}{
+			"picked_up_at":  time.Now().Format(time.RFC3339),
+			"signature_url": p.signatureURL,
+			"photo_url":     p.photoURL,
+		})
+	} else {
+		go NotifyDeliveryCompleted(p.orderID, "", time.Now(), p.signatureURL, p.photoURL)
+	}
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":       "Prueba de entrega guardada",
-		"proof_id":      proofID,
-		"signature_url": signatureURL,
-		"photo_url":     photoURL,
-	})
+// result respuesta de la prueba guardada
+func (p *savedProof) result() gin.H {
+	message := "Prueba de entrega guardada"
+	if p.stage == ProofStagePickup {
+		message = "Prueba de recogida guardada"
+	}
+	return gin.H{
+		"message":       message,
+		"stage":         p.stage,
+		"proof_id":      p.proofID,
+		"signature_url": p.signatureURL,
+		"photo_url":     p.photoURL,
+		"gps_integrity": p.gps, // null si no se envió ubicación o no se pudo evaluar
+		"payment": gin.H{
+			"method":          p.req.PaymentMethod,
+			"collected_cents": p.req.CollectedCents,
+			"shift_id":        p.shiftID,
+		},
+	}
 }
 
 // GetDeliveryProof obtiene la prueba de entrega de una orden
 func GetDeliveryProof(c *gin.Context) {
+	getProof(c, ProofStageDelivery)
+}
+
+// GetPickupProof obtiene la prueba de recogida de una orden
+func GetPickupProof(c *gin.Context) {
+	getProof(c, ProofStagePickup)
+}
+
+func getProof(c *gin.Context, stage string) {
 	orderID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
@@ -153,18 +284,25 @@ func GetDeliveryProof(c *gin.Context) {
 	var proof DeliveryProof
 	var photoURL, recipientName, notes sql.NullString
 	var lat, lng sql.NullFloat64
+	var gpsFlags pq.StringArray
 
 	err = db.QueryRow(`
-		SELECT id, order_id, signature_url, photo_url, recipient_name, notes, captured_at, latitude, longitude
+		SELECT id, order_id, signature_url, photo_url, recipient_name, notes, captured_at, latitude, longitude,
+		       accuracy, gps_score, gps_flags, gps_suspicious, payment_method, collected_cents, shift_id
 		FROM delivery_proofs
-		WHERE order_id = $1
+		WHERE order_id = $1 AND stage = $2
 		ORDER BY captured_at DESC
 		LIMIT 1`,
-		orderID,
-	).Scan(&proof.ID, &proof.OrderID, &proof.SignatureURL, &photoURL, &recipientName, &notes, &proof.CapturedAt, &lat, &lng)
+		orderID, stage,
+	).Scan(&proof.ID, &proof.OrderID, &proof.SignatureURL, &photoURL, &recipientName, &notes, &proof.CapturedAt, &lat, &lng,
+		&proof.Accuracy, &proof.GPSScore, &gpsFlags, &proof.GPSSuspicious, &proof.PaymentMethod, &proof.CollectedCents,
+		&proof.ShiftID)
 
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No hay prueba de entrega"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "No hay prueba de " + map[string]string{
+			ProofStagePickup:   "recogida",
+			ProofStageDelivery: "entrega",
+		}[stage]})
 		return
 	}
 	if err != nil {
@@ -187,6 +325,7 @@ func GetDeliveryProof(c *gin.Context) {
 	if lng.Valid {
 		proof.Longitude = &lng.Float64
 	}
+	proof.GPSFlags = []string(gpsFlags)
 
 	c.JSON(http.StatusOK, proof)
 }
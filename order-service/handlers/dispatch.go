@@ -0,0 +1,606 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/capacity"
+	"github.com/logitrack/order-service/dispatch"
+	"github.com/logitrack/order-service/routing"
+)
+
+// ========================================
+// DESPACHO AUTOMÁTICO
+// ========================================
+
+// dispatchBatchLimit pedidos pendientes que se consideran por lote
+const dispatchBatchLimit = 200
+
+// dispatchSpeedKmh velocidad promedio para estimar el ETA notificado
+const dispatchSpeedKmh = 25.0
+
+// DispatchSettings configuración de despacho automático de una sucursal
+type DispatchSettings struct {
+	BranchID             int     `json:"branch_id"`
+	BranchCode           string  `json:"branch_code"`
+	BranchName           string  `json:"branch_name"`
+	Enabled              bool    `json:"enabled"`
+	HoldingWindowSeconds int     `json:"holding_window_seconds"`
+	MaxDistanceKm        float64 `json:"max_distance_km"`
+	dispatch.Weights
+}
+
+// DispatchRun resultado de un lote de despacho
+type DispatchRun struct {
+	BranchID    int                   `json:"branch_id"`
+	BatchSize   int                   `json:"batch_size"`
+	Candidates  int                   `json:"candidate_motos"`
+	Assignments []DispatchAssignment  `json:"assignments"`
+	Unassigned  []dispatch.Unassigned `json:"unassigned"`
+}
+
+// DispatchAssignment asignación aplicada
+type DispatchAssignment struct {
+	dispatch.Assignment
+	MotoPlate string `json:"moto_plate"`
+	EtaMin    int    `json:"eta_min"`
+}
+
+var (
+	dispatchMu     sync.Mutex
+	dispatchTimers = map[int]*time.Timer{} // Ventanas de espera abiertas por sucursal
+	dispatchLocks  = map[int]*sync.Mutex{} // Un lote a la vez por sucursal
+)
+
+func defaultDispatchSettings(branchID int) DispatchSettings {
+	return DispatchSettings{
+		BranchID:             branchID,
+		HoldingWindowSeconds: 30,
+		MaxDistanceKm:        10,
+		Weights:              dispatch.DefaultWeights,
+	}
+}
+
+// loadDispatchSettings obtiene la configuración de la sucursal (por defecto si no tiene)
+func loadDispatchSettings(branchID int) (DispatchSettings, error) {
+	s := defaultDispatchSettings(branchID)
+	err := db.QueryRow(`
+		SELECT b.code, b.name,
+		       COALESCE(d.enabled, false), COALESCE(d.holding_window_seconds, $2),
+		       COALESCE(d.max_distance_km, $3), COALESCE(d.weight_distance, $4),
+		       COALESCE(d.weight_load, $5), COALESCE(d.weight_insertion, $6)
+		FROM branches b
+		LEFT JOIN branch_dispatch_settings d ON d.branch_id = b.id
+		WHERE b.id = $1
+	`, branchID, s.HoldingWindowSeconds, s.MaxDistanceKm, s.Weights.Distance, s.Weights.Load, s.Weights.Insertion).
+		Scan(&s.BranchCode, &s.BranchName, &s.Enabled, &s.HoldingWindowSeconds, &s.MaxDistanceKm,
+			&s.Weights.Distance, &s.Weights.Load, &s.Weights.Insertion)
+	return s, err
+}
+
+// ScheduleDispatch se llama al crear un pedido. Si la sucursal tiene despacho
+// automático, abre la ventana de espera (o despacha de inmediato si es 0);
+// los pedidos que llegan durante la ventana se asignan en el mismo lote.
+func ScheduleDispatch(branchID int) {
+	s, err := loadDispatchSettings(branchID)
+	if err != nil || !s.Enabled {
+		return
+	}
+
+	if s.HoldingWindowSeconds == 0 {
+		go runDispatchLogged(branchID)
+		return
+	}
+
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+	if _, open := dispatchTimers[branchID]; open {
+		return
+	}
+	dispatchTimers[branchID] = time.AfterFunc(time.Duration(s.HoldingWindowSeconds)*time.Second, func() {
+		dispatchMu.Lock()
+		delete(dispatchTimers, branchID)
+		dispatchMu.Unlock()
+		runDispatchLogged(branchID)
+	})
+}
+
+// InitDispatcher revisa cada DISPATCH_SWEEP_SECONDS segundos (por defecto 60)
+// las sucursales con despacho automático y pedidos pendientes: reintenta los
+// que quedaron sin moto y recupera los lotes perdidos en un reinicio
+func InitDispatcher() {
+	interval := 60 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("DISPATCH_SWEEP_SECONDS")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			rows, err := db.Query(`
+				SELECT DISTINCT d.branch_id
+				FROM branch_dispatch_settings d
+				JOIN orders o ON o.branch_id = d.branch_id
+				WHERE d.enabled = true AND o.status = 'pending' AND o.assigned_moto_id IS NULL AND NOT o.needs_pinning
+				  AND (o.window_start IS NULL OR o.window_start <= CURRENT_TIMESTAMP)
+			`)
+			if err != nil {
+				log.Printf("Despacho: error buscando sucursales pendientes: %v", err)
+				continue
+			}
+			var branches []int
+			for rows.Next() {
+				var id int
+				if rows.Scan(&id) == nil {
+					branches = append(branches, id)
+				}
+			}
+			rows.Close()
+
+			for _, id := range branches {
+				dispatchMu.Lock()
+				_, open := dispatchTimers[id]
+				dispatchMu.Unlock()
+				if !open {
+					runDispatchLogged(id)
+				}
+			}
+		}
+	}()
+}
+
+func runDispatchLogged(branchID int) {
+	run, err := runBranchDispatch(branchID)
+	if err != nil {
+		log.Printf("Despacho: error en sucursal %d: %v", branchID, err)
+		return
+	}
+	if run.BatchSize > 0 {
+		log.Printf("Despacho: sucursal %d, %d pedidos, %d asignados, %d sin moto",
+			branchID, run.BatchSize, len(run.Assignments), len(run.Unassigned))
+	}
+}
+
+func branchDispatchLock(branchID int) *sync.Mutex {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+	l, ok := dispatchLocks[branchID]
+	if !ok {
+		l = &sync.Mutex{}
+		dispatchLocks[branchID] = l
+	}
+	return l
+}
+
+// runBranchDispatch asigna todos los pedidos pendientes de la sucursal
+func runBranchDispatch(branchID int) (*DispatchRun, error) {
+	lock := branchDispatchLock(branchID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	run := &DispatchRun{
+		BranchID:    branchID,
+		Assignments: []DispatchAssignment{},
+		Unassigned:  []dispatch.Unassigned{},
+	}
+
+	settings, err := loadDispatchSettings(branchID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. Pedidos pendientes del lote (los que agotaron las ofertas o esperan
+	// que se fije su punto quedan para asignación manual)
+	rows, err := db.Query(`
+		SELECT id, `+firstStopLatSQL+`, `+firstStopLngSQL+`
+		FROM orders o
+		WHERE branch_id = $1 AND status = 'pending' AND assigned_moto_id IS NULL
+		  AND latitude IS NOT NULL AND longitude IS NOT NULL AND NOT needs_pinning
+		  AND (o.window_start IS NULL OR o.window_start <= CURRENT_TIMESTAMP)
+		  AND (SELECT COUNT(*) FROM assignment_offers ao WHERE ao.order_id = o.id) < $3
+		ORDER BY created_at ASC, id ASC
+		LIMIT $2
+	`, branchID, dispatchBatchLimit, offerMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	var orders []dispatch.Order
+	var sumLat, sumLng float64
+	for rows.Next() {
+		var o dispatch.Order
+		if err := rows.Scan(&o.ID, &o.Latitude, &o.Longitude); err != nil {
+			continue
+		}
+		orders = append(orders, o)
+		sumLat += o.Latitude
+		sumLng += o.Longitude
+	}
+	rows.Close()
+	run.BatchSize = len(orders)
+	if len(orders) == 0 {
+		return run, nil
+	}
+	if err := loadOfferExclusions(orders); err != nil {
+		return nil, err
+	}
+	if err := applyOrderLoads(orders); err != nil {
+		return nil, err
+	}
+
+	// 2. Motos disponibles de la sucursal con posición y ruta actual
+	motos, plates, err := loadDispatchFleet(branchID, sumLat/float64(len(orders)), sumLng/float64(len(orders)))
+	if err != nil {
+		return nil, err
+	}
+	run.Candidates = len(motos)
+
+	// 3. Plan y aplicación
+	plan := dispatch.Plan(orders, motos, dispatch.Config{
+		Weights:       settings.Weights,
+		MaxDistanceKm: settings.MaxDistanceKm,
+	})
+	run.Unassigned = append(run.Unassigned, plan.Unassigned...)
+
+	assignedByMoto := map[int]bool{}
+	notApplied := map[int]bool{} // Planificados que no se pudieron ofrecer
+	for _, a := range plan.Assignments {
+		if err := applyDispatchAssignment(a); err != nil {
+			notApplied[a.OrderID] = true
+			u := dispatch.Unassigned{OrderID: a.OrderID, Reason: dispatch.ReasonConflict}
+			var exceeded *capacity.ExceededError
+			switch {
+			case errors.As(err, &exceeded):
+				u.Reason, u.BlockedBy = dispatch.ReasonOverCapacity, exceeded.Dimensions()
+			case err != errOfferConflict && err != errMotoUnavailable:
+				log.Printf("Despacho: error asignando pedido %d a moto %d: %v", a.OrderID, a.MotoID, err)
+			}
+			run.Unassigned = append(run.Unassigned, u)
+			continue
+		}
+		assignedByMoto[a.MotoID] = true
+		da := DispatchAssignment{
+			Assignment: a,
+			MotoPlate:  plates[a.MotoID],
+			EtaMin:     int(a.RouteKm / dispatchSpeedKmh * 60),
+		}
+		run.Assignments = append(run.Assignments, da)
+		recordDispatchDecision(branchID, run.BatchSize, a.OrderID, &da.Assignment, "")
+	}
+	for _, u := range run.Unassigned {
+		recordDispatchDecision(branchID, run.BatchSize, u.OrderID, nil, u.Reason)
+	}
+
+	// 4. Ruta actualizada de cada moto que recibió pedidos, sin los del plan
+	// que no se aplicaron (saveSequencedRoute vuelve a secuenciar)
+	for _, m := range motos {
+		if !assignedByMoto[m.ID] {
+			continue
+		}
+		sequence := []int{}
+		for _, s := range plan.Routes[m.ID] {
+			if !notApplied[s.OrderID] {
+				sequence = append(sequence, s.OrderID)
+			}
+		}
+		start := routing.Point{Latitude: m.Latitude, Longitude: m.Longitude}
+		if _, err := saveSequencedRoute(m.ID, start, sequence); err != nil {
+			log.Printf("Despacho: error guardando ruta de moto %d: %v", m.ID, err)
+		}
+	}
+
+	return run, nil
+}
+
+// loadDispatchFleet motos disponibles de la sucursal, ordenadas por distancia
+// al punto, con su ruta actual
+func loadDispatchFleet(branchID int, lat, lng float64) ([]dispatch.Moto, map[int]string, error) {
+	nearest, err := FindNearestMotos(NearestQuery{Latitude: lat, Longitude: lng, BranchID: &branchID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	motos := make([]dispatch.Moto, 0, len(nearest))
+	plates := map[int]string{}
+	ids := make([]int64, 0, len(nearest))
+	for _, n := range nearest {
+		motos = append(motos, dispatch.Moto{
+			ID:        n.ID,
+			Latitude:  *n.Latitude,
+			Longitude: *n.Longitude,
+			Capacity:  capacity.Limits{MaxOrders: n.MaxOrdersCapacity},
+			Load:      capacity.Load{Orders: n.CurrentOrdersCount},
+		})
+		plates[n.ID] = n.LicensePlate
+		ids = append(ids, int64(n.ID))
+	}
+
+	// Límites de peso y volumen con la carga actual
+	caps, err := loadMotoCapacities(db, ids, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range motos {
+		if mc, ok := caps[motos[i].ID]; ok {
+			motos[i].Capacity = mc.Limits
+			motos[i].Load = mc.Load
+		}
+	}
+	if err := loadMotoStops(motos, ids); err != nil {
+		return nil, nil, err
+	}
+	return motos, plates, nil
+}
+
+// loadOfferExclusions marca en cada pedido las motos que ya rechazaron o
+// dejaron vencer su oferta
+func loadOfferExclusions(orders []dispatch.Order) error {
+	ids := make([]int64, len(orders))
+	index := make(map[int]int, len(orders))
+	for i, o := range orders {
+		ids[i] = int64(o.ID)
+		index[o.ID] = i
+	}
+	rows, err := db.Query(`
+		SELECT order_id, moto_id FROM assignment_offers
+		WHERE order_id = ANY($1) AND status IN ('rejected', 'expired')
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orderID, motoID int
+		if rows.Scan(&orderID, &motoID) == nil {
+			o := &orders[index[orderID]]
+			o.Exclude = append(o.Exclude, motoID)
+		}
+	}
+	return rows.Err()
+}
+
+// loadMotoStops carga los pedidos ofrecidos o asignados aún no entregados de cada moto
+func loadMotoStops(motos []dispatch.Moto, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT id, assigned_moto_id, `+firstStopLatSQL+`, `+firstStopLngSQL+`
+		FROM orders o
+		WHERE assigned_moto_id = ANY($1) AND status IN ('offered', 'assigned', 'in_route')
+		  AND latitude IS NOT NULL AND longitude IS NOT NULL
+		ORDER BY updated_at ASC, id ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	index := make(map[int]int, len(motos))
+	for i, m := range motos {
+		index[m.ID] = i
+	}
+	for rows.Next() {
+		var s dispatch.Stop
+		var motoID int
+		if err := rows.Scan(&s.OrderID, &motoID, &s.Latitude, &s.Longitude); err != nil {
+			continue
+		}
+		if i, ok := index[motoID]; ok {
+			motos[i].Stops = append(motos[i].Stops, s)
+		}
+	}
+	return rows.Err()
+}
+
+// applyDispatchAssignment ofrece el pedido a la moto elegida. Devuelve
+// errOfferConflict o errMotoUnavailable si el pedido o la moto cambiaron desde
+// que se armó el lote, o el límite de capacidad superado.
+func applyDispatchAssignment(a dispatch.Assignment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	score := a.Score
+	if _, err := offerOrderTx(tx, a.OrderID, a.MotoID, OfferSourceDispatch, &score); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordDispatchDecision guarda la decisión para auditoría. Un pedido que
+// sigue sin moto por el mismo motivo en barridos sucesivos se registra una vez.
+func recordDispatchDecision(branchID, batchSize, orderID int, a *dispatch.Assignment, reason string) {
+	var motoID, score, distance, insertion, load interface{}
+	if a != nil {
+		motoID, score, distance, insertion, load = a.MotoID, a.Score, a.DistanceKm, a.InsertionKm, a.LoadRatio
+	} else {
+		var last sql.NullString
+		err := db.QueryRow(`
+			SELECT reason FROM dispatch_decisions WHERE order_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1
+		`, orderID).Scan(&last)
+		if err == nil && last.Valid && last.String == reason {
+			return
+		}
+	}
+	_, err := db.Exec(`
+		INSERT INTO dispatch_decisions
+		(order_id, branch_id, moto_id, score, distance_km, insertion_km, load_ratio, reason, batch_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9)
+	`, orderID, branchID, motoID, score, distance, insertion, load, reason, batchSize)
+	if err != nil {
+		log.Printf("Despacho: error registrando decisión del pedido %d: %v", orderID, err)
+	}
+}
+
+func motoDriverName(motoID int) string {
+	var name sql.NullString
+	db.QueryRow(`
+		SELECT u.name FROM motos m JOIN users u ON u.id = m.driver_id WHERE m.id = $1
+	`, motoID).Scan(&name)
+	return name.String
+}
+
+// GetDispatchSettings lista la configuración de despacho de las sucursales activas
+func GetDispatchSettings(c *gin.Context) {
+	rows, err := db.Query(`SELECT id FROM branches WHERE is_active = true ORDER BY name`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener sucursales"})
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	settings := []DispatchSettings{}
+	for _, id := range ids {
+		if s, err := loadDispatchSettings(id); err == nil {
+			settings = append(settings, s)
+		}
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateDispatchSettings actualiza la configuración de una sucursal. Los
+// campos omitidos conservan su valor actual.
+func UpdateDispatchSettings(c *gin.Context) {
+	branchID, err := strconv.Atoi(c.Param("branch_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de sucursal inválido"})
+		return
+	}
+
+	s, err := loadDispatchSettings(branchID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sucursal no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener configuración"})
+		return
+	}
+	if err := c.ShouldBindJSON(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos: " + err.Error()})
+		return
+	}
+	s.BranchID = branchID
+	if s.HoldingWindowSeconds < 0 || s.HoldingWindowSeconds > 3600 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "holding_window_seconds debe estar entre 0 y 3600"})
+		return
+	}
+	if s.MaxDistanceKm < 0 || s.Weights.Distance < 0 || s.Weights.Load < 0 || s.Weights.Insertion < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La distancia máxima y los pesos no pueden ser negativos"})
+		return
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO branch_dispatch_settings
+		(branch_id, enabled, holding_window_seconds, max_distance_km, weight_distance, weight_load, weight_insertion)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (branch_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			holding_window_seconds = EXCLUDED.holding_window_seconds,
+			max_distance_km = EXCLUDED.max_distance_km,
+			weight_distance = EXCLUDED.weight_distance,
+			weight_load = EXCLUDED.weight_load,
+			weight_insertion = EXCLUDED.weight_insertion,
+			updated_at = CURRENT_TIMESTAMP
+	`, branchID, s.Enabled, s.HoldingWindowSeconds, s.MaxDistanceKm,
+		s.Weights.Distance, s.Weights.Load, s.Weights.Insertion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar configuración"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+// RunDispatch despacha de inmediato los pedidos pendientes de una sucursal
+func RunDispatch(c *gin.Context) {
+	branchID, err := strconv.Atoi(c.Query("branch_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branch_id es requerido"})
+		return
+	}
+
+	run, err := runBranchDispatch(branchID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sucursal no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al despachar pedidos"})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// GetDispatchDecisions lista las decisiones del despacho automático
+func GetDispatchDecisions(c *gin.Context) {
+	query := `
+		SELECT d.id, d.order_id, d.branch_id, d.moto_id, COALESCE(m.license_plate, ''),
+		       d.score, d.distance_km, d.insertion_km, d.load_ratio, COALESCE(d.reason, ''),
+		       d.batch_size, d.created_at
+		FROM dispatch_decisions d
+		LEFT JOIN motos m ON m.id = d.moto_id
+		WHERE 1=1`
+	args := []interface{}{}
+	if v := c.Query("order_id"); v != "" {
+		args = append(args, v)
+		query += " AND d.order_id = $" + strconv.Itoa(len(args))
+	}
+	if v := c.Query("branch_id"); v != "" {
+		args = append(args, v)
+		query += " AND d.branch_id = $" + strconv.Itoa(len(args))
+	}
+	args = append(args, c.DefaultQuery("limit", "100"))
+	query += " ORDER BY d.created_at DESC, d.id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener decisiones"})
+		return
+	}
+	defer rows.Close()
+
+	type decision struct {
+		ID          int       `json:"id"`
+		OrderID     int       `json:"order_id"`
+		BranchID    *int      `json:"branch_id"`
+		MotoID      *int      `json:"moto_id"`
+		MotoPlate   string    `json:"moto_plate,omitempty"`
+		Score       *float64  `json:"score"`
+		DistanceKm  *float64  `json:"distance_km"`
+		InsertionKm *float64  `json:"insertion_km"`
+		LoadRatio   *float64  `json:"load_ratio"`
+		Reason      string    `json:"reason,omitempty"`
+		BatchSize   int       `json:"batch_size"`
+		CreatedAt   time.Time `json:"created_at"`
+	}
+
+	decisions := []decision{}
+	for rows.Next() {
+		var d decision
+		if err := rows.Scan(&d.ID, &d.OrderID, &d.BranchID, &d.MotoID, &d.MotoPlate, &d.Score,
+			&d.DistanceKm, &d.InsertionKm, &d.LoadRatio, &d.Reason, &d.BatchSize, &d.CreatedAt); err != nil {
+			continue
+		}
+		decisions = append(decisions, d)
+	}
+	c.JSON(http.StatusOK, decisions)
+}
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/positions"
+	"github.com/logitrack/order-service/routing"
+)
+
+// ========================================
+// API DEL MOTORISTA (/me)
+// ========================================
+// La moto se resuelve desde el usuario autenticado (motos.driver_id); cada
+// acción verifica que el pedido esté asignado a esa moto.
+
+// DriverStop parada del día del motorista. Los pedidos con recogida tienen
+// dos paradas: la recogida (kind=pickup) y la entrega (kind=dropoff).
+type DriverStop struct {
+	Sequence    int        `json:"sequence"`
+	OrderID     int        `json:"order_id"`
+	Kind        string     `json:"kind"`
+	OrderType   string     `json:"order_type"`
+	ClientName  string     `json:"client_name"`
+	Address     string     `json:"address"`
+	Latitude    float64    `json:"latitude"`
+	Longitude   float64    `json:"longitude"`
+	Status      string     `json:"status"`
+	Done        bool       `json:"done"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	ArrivedAt   *time.Time `json:"arrived_at,omitempty"`
+	PickedUpAt  *time.Time `json:"picked_up_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+
+	CODAmountCents *int64 `json:"cod_amount_cents,omitempty"` // A cobrar en la entrega
+}
+
+// doneAt momento en que se completó la parada
+func (s DriverStop) doneAt() *time.Time {
+	if s.Kind == routing.Pickup {
+		return s.PickedUpAt
+	}
+	return s.DeliveredAt
+}
+
+type driverContext struct {
+	UserID int
+	MotoID int
+	Plate  string
+}
+
+// currentDriver resuelve la moto del usuario autenticado
+func currentDriver(c *gin.Context) (*driverContext, bool) {
+	userID, err := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return nil, false
+	}
+
+	d := &driverContext{UserID: userID}
+	err = db.QueryRow(`
+		SELECT id, license_plate FROM motos WHERE driver_id = $1 ORDER BY id LIMIT 1
+	`, userID).Scan(&d.MotoID, &d.Plate)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No tienes una moto asignada"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la moto"})
+		return nil, false
+	}
+	return d, true
+}
+
+// driverOrder verifica que el pedido pertenezca a la moto del motorista y
+// esté en alguno de los estados permitidos
+func driverOrder(c *gin.Context, d *driverContext, allowed ...string) (int, bool) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de pedido inválido"})
+		return 0, false
+	}
+
+	var status string
+	var motoID sql.NullInt64
+	err = db.QueryRow("SELECT status, assigned_moto_id FROM orders WHERE id = $1", orderID).Scan(&status, &motoID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pedido no encontrado"})
+		return 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el pedido"})
+		return 0, false
+	}
+	if !motoID.Valid || int(motoID.Int64) != d.MotoID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "El pedido no está asignado a tu moto"})
+		return 0, false
+	}
+	for _, s := range allowed {
+		if status == s {
+			return orderID, true
+		}
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":    "El pedido no admite esta acción en su estado actual",
+		"status":   status,
+		"expected": allowed,
+	})
+	return 0, false
+}
+
+// loadDriverStops paradas del día: pedidos activos de la moto y los entregados
+// hoy, ordenados según la última ruta generada
+func loadDriverStops(motoID int) ([]DriverStop, error) {
+	rows, err := db.Query(`
+		SELECT id, client_name, status, started_at, arrived_at, picked_up_at, delivered_at, cod_amount_cents
+		FROM orders
+		WHERE assigned_moto_id = $1
+		  AND (status IN ('offered', 'assigned', 'in_route')
+		       OR (status = 'delivered' AND delivered_at >= CURRENT_DATE))
+		ORDER BY created_at ASC, id ASC
+	`, motoID)
+	if err != nil {
+		return nil, err
+	}
+	var orders []DriverStop
+	var ids []int64
+	for rows.Next() {
+		var s DriverStop
+		if err := rows.Scan(&s.OrderID, &s.ClientName, &s.Status,
+			&s.StartedAt, &s.ArrivedAt, &s.PickedUpAt, &s.DeliveredAt, &s.CODAmountCents); err != nil {
+			continue
+		}
+		orders = append(orders, s)
+		ids = append(ids, int64(s.OrderID))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	details, err := loadRouteOrders(ids)
+	if err != nil {
+		return nil, err
+	}
+	stops := []DriverStop{}
+	for _, s := range orders {
+		o, ok := details[s.OrderID]
+		if !ok {
+			continue
+		}
+		s.OrderType = o.Type
+		if o.needsPickup() {
+			p := s
+			p.Kind = routing.Pickup
+			point, address := o.pickupStop()
+			p.Latitude, p.Longitude, p.Address = point.Latitude, point.Longitude, address
+			p.Done = s.PickedUpAt != nil || s.Status == "delivered"
+			stops = append(stops, p)
+		}
+		s.Kind = routing.Dropoff
+		point, address := o.dropoffStop()
+		s.Latitude, s.Longitude, s.Address = point.Latitude, point.Longitude, address
+		s.Done = s.Status == "delivered"
+		stops = append(stops, s)
+	}
+
+	// Posición de cada parada en la última ruta de la moto; las rutas sin
+	// paradas guardadas solo tienen el orden de los pedidos
+	type stopKey struct {
+		OrderID int
+		Kind    string
+	}
+	routeIndex := map[stopKey]int{}
+	var sequenceJSON, stopsJSON []byte
+	if err := db.QueryRow(`
+		SELECT order_sequence, stop_sequence FROM routes WHERE moto_id = $1 ORDER BY created_at DESC LIMIT 1
+	`, motoID).Scan(&sequenceJSON, &stopsJSON); err == nil {
+		var routeStops []routing.Stop
+		json.Unmarshal(stopsJSON, &routeStops)
+		for i, rs := range routeStops {
+			routeIndex[stopKey{rs.OrderID, rs.Kind}] = i
+		}
+		if len(routeStops) == 0 {
+			var sequence []int
+			json.Unmarshal(sequenceJSON, &sequence)
+			for i, id := range sequence {
+				routeIndex[stopKey{id, routing.Pickup}] = 2 * i
+				routeIndex[stopKey{id, routing.Dropoff}] = 2*i + 1
+			}
+		}
+	}
+
+	// Completadas primero (ya visitadas), luego los pedidos en ruta, luego el
+	// orden de la ruta; la recogida siempre antes que su entrega
+	rank := func(s DriverStop) (int, int) {
+		i, inRoute := routeIndex[stopKey{s.OrderID, s.Kind}]
+		if !inRoute {
+			i = len(routeIndex)
+		}
+		switch {
+		case s.Done:
+			return 0, 0
+		case s.Status == "in_route":
+			return 1, i
+		case inRoute:
+			return 2, i
+		}
+		return 3, 0
+	}
+	sort.SliceStable(stops, func(i, j int) bool {
+		gi, pi := rank(stops[i])
+		gj, pj := rank(stops[j])
+		if gi != gj {
+			return gi < gj
+		}
+		if gi == 0 {
+			ti, tj := stops[i].doneAt(), stops[j].doneAt()
+			if ti != nil && tj != nil && !ti.Equal(*tj) {
+				return ti.Before(*tj)
+			}
+		}
+		if pi != pj {
+			return pi < pj
+		}
+		return stops[i].OrderID == stops[j].OrderID && stops[i].Kind == routing.Pickup
+	})
+	for i := range stops {
+		stops[i].Sequence = i + 1
+	}
+	return stops, nil
+}
+
+// GetMyStops GET /me/stops
+func GetMyStops(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	stops, err := loadDriverStops(d.MotoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener paradas"})
+		return
+	}
+
+	pending := 0
+	for _, s := range stops {
+		if !s.Done {
+			pending++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"moto_id":    d.MotoID,
+		"moto_plate": d.Plate,
+		"stops":      stops,
+		"total":      len(stops),
+		"pending":    pending,
+	})
+}
+
+// GetMyNextStop GET /me/next-stop: la parada en curso o la siguiente aceptada,
+// con ETA desde la última posición de la moto
+func GetMyNextStop(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	stops, err := loadDriverStops(d.MotoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener paradas"})
+		return
+	}
+
+	var next *DriverStop
+	remaining := 0
+	for i := range stops {
+		if stops[i].Done || (stops[i].Status != "assigned" && stops[i].Status != "in_route") {
+			continue
+		}
+		if next == nil {
+			next = &stops[i]
+		}
+		remaining++
+	}
+	if next == nil {
+		c.JSON(http.StatusOK, gin.H{"next_stop": nil, "remaining": 0})
+		return
+	}
+
+	eta, err := estimateETA(d.MotoID, next.Latitude, next.Longitude)
+	if err != nil {
+		eta = nil // Sin ETA si geolocation-service no responde
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"next_stop": next,
+		"eta":       eta,
+		"remaining": remaining,
+	})
+}
+
+// StartMyStop POST /me/stops/:id/start (assigned → in_route)
+func StartMyStop(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	orderID, ok := driverOrder(c, d, "assigned")
+	if !ok {
+		return
+	}
+
+	res, err := db.Exec(`
+		UPDATE orders SET status = 'in_route', started_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND assigned_moto_id = $2 AND status = 'assigned'
+	`, orderID, d.MotoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al iniciar la entrega"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido cambió de estado"})
+		return
+	}
+
+	if pos, err := positions.Get(d.MotoID); err == nil && pos != nil {
+		go NotifyInRoute(orderID, "", pos.Latitude, pos.Longitude)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Entrega iniciada", "order_id": orderID, "status": "in_route"})
+}
+
+// ArriveMyStop POST /me/stops/:id/arrived
+func ArriveMyStop(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	orderID, ok := driverOrder(c, d, "in_route")
+	if !ok {
+		return
+	}
+
+	var arrivedAt time.Time
+	err := db.QueryRow(`
+		UPDATE orders SET arrived_at = COALESCE(arrived_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND assigned_moto_id = $2 AND status = 'in_route'
+		RETURNING arrived_at
+	`, orderID, d.MotoID).Scan(&arrivedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido cambió de estado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar la llegada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Llegada registrada", "order_id": orderID, "arrived_at": arrivedAt})
+}
+
+// CompleteMyStop POST /me/stops/:id/complete con la prueba de entrega
+func CompleteMyStop(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	orderID, ok := driverOrder(c, d, "in_route")
+	if !ok {
+		return
+	}
+
+	var req DeliveryProofRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orderType, pickedUpAt, err := pickupState(orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el pedido"})
+		return
+	}
+	if (models.Order{OrderType: orderType}).NeedsPickup() && pickedUpAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Registra la recogida antes de completar la entrega"})
+		return
+	}
+	if !checkCODPayment(c, orderID, req) {
+		return
+	}
+
+	// La prueba y el cambio de estado se confirman juntos: si el pedido dejó de
+	// estar en ruta no queda una prueba huérfana ni se notifica la entrega
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al completar la entrega"})
+		return
+	}
+	defer tx.Rollback()
+
+	proof, err := insertDeliveryProof(tx, orderID, ProofStageDelivery, req)
+	if err == errCashWithoutShift {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar prueba: " + err.Error()})
+		return
+	}
+
+	res, err := tx.Exec(`
+		UPDATE orders
+		SET status = 'delivered', delivered_at = CURRENT_TIMESTAMP,
+		    arrived_at = COALESCE(arrived_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND assigned_moto_id = $2 AND status = 'in_route'
+	`, orderID, d.MotoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al completar la entrega"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido ya no está en ruta con tu moto"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al completar la entrega"})
+		return
+	}
+	proof.publish()
+
+	result := proof.result()
+	result["message"] = "Entrega completada"
+	result["order_id"] = orderID
+	result["status"] = "delivered"
+	c.JSON(http.StatusOK, result)
+}
+
+// FailMyStop POST /me/stops/:id/failed: registra el intento fallido; el
+// pedido vuelve al pool, se reprograma o queda 'failed' si agotó los intentos
+func FailMyStop(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	orderID, ok := driverOrder(c, d, "assigned", "in_route")
+	if !ok {
+		return
+	}
+
+	var req FailedAttemptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason_code es requerido"})
+		return
+	}
+	if _, valid := AttemptReasons[req.ReasonCode]; !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason_code inválido", "valid_codes": AttemptReasons})
+		return
+	}
+	if req.ReasonCode == "other" && strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Describe el motivo en reason"})
+		return
+	}
+	if err := validateWindow(req.WindowStart, req.WindowEnd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attempt, err := recordFailedAttempt(orderID, d.MotoID, d.UserID, req)
+	if err == errAttemptConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido cambió de estado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar el intento"})
+		return
+	}
+
+	status := "pending"
+	if attempt.Outcome == AttemptFailed {
+		status = "failed"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Intento fallido registrado",
+		"attempt":  attempt,
+		"order_id": orderID,
+		"status":   status,
+	})
+}
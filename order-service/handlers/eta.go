@@ -4,13 +4,18 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/positions"
 )
 
 type etaResponse struct {
-	DistanceKm float64 `json:"distance_km"`
-	EtaMin     float64 `json:"eta_min"`
+	DistanceKm        float64    `json:"distance_km"`
+	EtaMin            float64    `json:"eta_min"`
+	PositionUpdatedAt *time.Time `json:"position_updated_at,omitempty"`
+	PositionAgeSec    *int       `json:"position_age_seconds,omitempty"`
+	PositionStale     bool       `json:"position_stale"`
 }
 
 // Haversine km
@@ -47,25 +52,37 @@ func GetOrderETA(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has no assigned moto"})
 		return
 	}
-	motoID := *assignedMotoID
-
-	// Get latest location for this moto (type='current')
-	var motoLat, motoLng float64
-	err = db.QueryRow(`SELECT latitude, longitude FROM locations 
-		WHERE moto_id = $1 AND type = 'current' ORDER BY timestamp DESC LIMIT 1`, motoID).
-		Scan(&motoLat, &motoLng)
+	eta, err := estimateETA(*assignedMotoID, orderLat, orderLng)
 	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get moto position"})
+		return
+	}
+
+	c.JSON(http.StatusOK, eta)
+}
+
+// estimateETA distance and ETA from the moto's latest location to a point.
+func estimateETA(motoID int, lat, lng float64) (*etaResponse, error) {
+	// Latest position for this moto from geolocation-service
+	pos, err := positions.Get(motoID)
+	if err != nil && err != positions.ErrNotFound {
+		return nil, err
+	}
+	if pos == nil {
 		// Fallback to depot if no location yet
-		motoLat, motoLng = 10.0, -75.0
+		pos = &positions.Position{Latitude: 10.0, Longitude: -75.0, Stale: true}
 	}
 
 	// Compute distance and ETA
-	distanceKm := haversine(motoLat, motoLng, orderLat, orderLng)
+	distanceKm := haversine(pos.Latitude, pos.Longitude, lat, lng)
 	speedKmh := 25.0 // average city speed
 	etaMin := distanceKm / speedKmh * 60.0
 
-	c.JSON(http.StatusOK, etaResponse{
-		DistanceKm: distanceKm,
-		EtaMin:     etaMin,
-	})
+	return &etaResponse{
+		DistanceKm:        distanceKm,
+		EtaMin:            etaMin,
+		PositionUpdatedAt: pos.UpdatedAt,
+		PositionAgeSec:    pos.AgeSeconds,
+		PositionStale:     pos.Stale,
+	}, nil
 }
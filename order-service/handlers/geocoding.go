@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/geocoding"
+)
+
+// ========================================
+// GEOCODIFICACIÓN
+// ========================================
+// Pedidos y direcciones sin coordenadas se geocodifican con los proveedores
+// de GEOCODER_PROVIDERS (por defecto "gazetteer,nominatim"), con caché en
+// geocode_cache. Bajo GEOCODE_MIN_CONFIDENCE el punto queda needs_pinning.
+
+var (
+	geocoder             geocoding.Geocoder = geocoding.Chain{}
+	minGeocodeConfidence                    = 0.7
+)
+
+// InitGeocoding arma la cadena de proveedores según la configuración:
+// GAZETTEER_FILE (JSON con lugares), GEOCODER_URL (Nominatim o compatible),
+// GEOCODER_USER_AGENT y GEOCODER_COUNTRY_CODES
+func InitGeocoding() {
+	if v, err := strconv.ParseFloat(os.Getenv("GEOCODE_MIN_CONFIDENCE"), 64); err == nil && v >= 0 && v <= 1 {
+		minGeocodeConfidence = v
+	}
+
+	var providers []geocoding.Geocoder
+	for _, name := range strings.Split(getEnvDefault("GEOCODER_PROVIDERS", "gazetteer,nominatim"), ",") {
+		switch strings.TrimSpace(name) {
+		case "gazetteer":
+			path := os.Getenv("GAZETTEER_FILE")
+			if path == "" {
+				continue
+			}
+			g, err := geocoding.LoadGazetteer(path)
+			if err != nil {
+				log.Printf("Geocodificación: no se pudo leer el nomenclátor %s: %v", path, err)
+				continue
+			}
+			providers = append(providers, g)
+		case "nominatim":
+			providers = append(providers, geocoding.NewNominatim(
+				getEnvDefault("GEOCODER_URL", "https://nominatim.openstreetmap.org"),
+				getEnvDefault("GEOCODER_USER_AGENT", "logitrack-order-service"),
+				getEnvDefault("GEOCODER_COUNTRY_CODES", "gt"),
+			))
+		}
+	}
+
+	geocoder = geocoding.Cached{
+		Geocoder: geocoding.Chain{Providers: providers, MinConfidence: minGeocodeConfidence},
+		Store:    dbGeocodeStore{},
+	}
+}
+
+func getEnvDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// dbGeocodeStore caché de geocodificación en geocode_cache
+type dbGeocodeStore struct{}
+
+func (dbGeocodeStore) Get(ctx context.Context, key string) (geocoding.Result, bool) {
+	var r geocoding.Result
+	var display sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT latitude, longitude, display_name, confidence, provider FROM geocode_cache WHERE query_key = $1
+	`, key).Scan(&r.Latitude, &r.Longitude, &display, &r.Confidence, &r.Provider)
+	if err != nil {
+		return r, false
+	}
+	r.DisplayName = display.String
+	return r, true
+}
+
+func (dbGeocodeStore) Set(ctx context.Context, key string, r geocoding.Result) {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO geocode_cache (query_key, latitude, longitude, display_name, confidence, provider)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		ON CONFLICT (query_key) DO UPDATE
+		SET latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude, display_name = EXCLUDED.display_name,
+		    confidence = EXCLUDED.confidence, provider = EXCLUDED.provider, created_at = CURRENT_TIMESTAMP
+	`, key, r.Latitude, r.Longitude, r.DisplayName, r.Confidence, r.Provider)
+	if err != nil {
+		log.Printf("Geocodificación: error guardando caché: %v", err)
+	}
+}
+
+// geoPoint coordenadas de una dirección: las enviadas por el cliente o las
+// geocodificadas (con su confianza y proveedor)
+type geoPoint struct {
+	Latitude     float64
+	Longitude    float64
+	Confidence   *float64
+	Provider     *string
+	NeedsPinning bool
+}
+
+// locateAddress usa lat/lng si vienen ambas; si no, geocodifica la dirección.
+// Responde y devuelve false si no se encuentra o el proveedor falla.
+func locateAddress(c *gin.Context, address string, lat, lng *float64) (geoPoint, bool) {
+	if lat != nil && lng != nil {
+		return geoPoint{Latitude: *lat, Longitude: *lng}, true
+	}
+	if strings.TrimSpace(address) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Se requiere la dirección o las coordenadas"})
+		return geoPoint{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 8*time.Second)
+	defer cancel()
+	r, err := geocoder.Geocode(ctx, address)
+	if errors.Is(err, geocoding.ErrNotFound) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No se encontró la dirección; envía latitude y longitude",
+			"code":  "address_not_found",
+		})
+		return geoPoint{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error de geocodificación: " + err.Error(), "code": "geocoding_unavailable"})
+		return geoPoint{}, false
+	}
+	return geoPoint{
+		Latitude:     r.Latitude,
+		Longitude:    r.Longitude,
+		Confidence:   &r.Confidence,
+		Provider:     &r.Provider,
+		NeedsPinning: r.Confidence < minGeocodeConfidence,
+	}, true
+}
+
+// Geocode GET /geocode?address= resultado del geocodificador configurado
+func Geocode(c *gin.Context) {
+	address := strings.TrimSpace(c.Query("address"))
+	if address == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address es requerido"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 8*time.Second)
+	defer cancel()
+	r, err := geocoder.Geocode(ctx, address)
+	if errors.Is(err, geocoding.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "code": "address_not_found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "code": "geocoding_unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"result":         r,
+		"needs_pinning":  r.Confidence < minGeocodeConfidence,
+		"min_confidence": minGeocodeConfidence,
+	})
+}
+
+// PinOrderRequest punto fijado a mano
+type PinOrderRequest struct {
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+}
+
+// PinOrder PUT /orders/:id/pin fija las coordenadas del pedido y quita la
+// marca needs_pinning; un pedido pendiente se reubica en su zona de entrega
+func PinOrder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order id"})
+		return
+	}
+	var req PinOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lat, lng := *req.Latitude, *req.Longitude
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coordenadas fuera de rango"})
+		return
+	}
+
+	var status string
+	var branchID sql.NullInt64
+	err = db.QueryRow("SELECT status, branch_id FROM orders WHERE id = $1", id).Scan(&status, &branchID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order"})
+		return
+	}
+	if status == "delivered" || status == "cancelled" || status == "failed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido ya está cerrado", "status": status})
+		return
+	}
+
+	// Un pedido pendiente pasa a la sucursal de la zona del nuevo punto
+	if status == "pending" {
+		match, _, err := findBranchForPoint(lat, lng)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve delivery zone"})
+			return
+		}
+		if match != nil {
+			branchID = sql.NullInt64{Int64: int64(match.BranchID), Valid: true}
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE orders
+		SET latitude = $2, longitude = $3, needs_pinning = false, geocode_confidence = 1, geocode_provider = 'manual',
+		    branch_id = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, id, lat, lng, branchID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin order"})
+		return
+	}
+	if status == "pending" && branchID.Valid {
+		ScheduleDispatch(int(branchID.Int64))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order pinned", "id": id, "latitude": lat, "longitude": lng})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/positions"
+)
+
+// ========================================
+// INTEGRIDAD GPS: check-ins y pruebas de entrega
+// ========================================
+
+// GPSReview revisión de una lectura GPS sospechosa
+type GPSReview struct {
+	ID               int        `json:"id"`
+	EntityType       string     `json:"entity_type"` // shift, visit, delivery_proof
+	EntityID         int        `json:"entity_id"`
+	UserID           *int       `json:"user_id,omitempty"`
+	UserName         string     `json:"user_name,omitempty"`
+	Score            int        `json:"score"`
+	Flags            []string   `json:"flags"`
+	SuspiciousPoints int        `json:"suspicious_points"`
+	Status           string     `json:"status"`
+	ReviewedBy       *int       `json:"reviewed_by,omitempty"`
+	ReviewedAt       *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNotes      string     `json:"review_notes,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// scoreVisitFix puntúa el check-in de un coordinador contra sus check-ins y
+// check-outs anteriores. Si geolocation-service no responde el check-in no se
+// bloquea: queda sin puntaje.
+func scoreVisitFix(coordinatorID int, fix positions.Fix) *positions.Integrity {
+	rows, err := db.Query(`
+		SELECT latitude, longitude, ts FROM (
+			SELECT check_in_latitude AS latitude, check_in_longitude AS longitude, check_in_time AS ts
+			FROM coordinator_visits
+			WHERE coordinator_id = $1 AND check_in_latitude IS NOT NULL
+			UNION ALL
+			SELECT check_out_latitude, check_out_longitude, check_out_time
+			FROM coordinator_visits
+			WHERE coordinator_id = $1 AND check_out_latitude IS NOT NULL AND check_out_time IS NOT NULL
+		) h
+		ORDER BY ts DESC
+		LIMIT 10
+	`, coordinatorID)
+	if err != nil {
+		log.Printf("GPS: error obteniendo historial del coordinador %d: %v", coordinatorID, err)
+		return nil
+	}
+	var history []positions.Fix
+	for rows.Next() {
+		var h positions.Fix
+		if err := rows.Scan(&h.Latitude, &h.Longitude, &h.Timestamp); err != nil {
+			continue
+		}
+		history = append(history, h)
+	}
+	rows.Close()
+
+	res, err := positions.Score(positions.ScoreRequest{Fix: fix, History: history})
+	if err != nil {
+		log.Printf("GPS: no se pudo puntuar el check-in del coordinador %d: %v", coordinatorID, err)
+		return nil
+	}
+	return res
+}
+
+// scoreProofFix puntúa la ubicación de una prueba de entrega contra el turno
+// activo del driver de la moto asignada
+func scoreProofFix(motoID *int, fix positions.Fix) *positions.Integrity {
+	res, err := positions.Score(positions.ScoreRequest{Fix: fix, MotoID: motoID})
+	if err != nil {
+		log.Printf("GPS: no se pudo puntuar la prueba de entrega: %v", err)
+		return nil
+	}
+	return res
+}
+
+// openGPSReview agrega la lectura sospechosa a la cola de revisión
+func openGPSReview(entityType string, entityID int, userID *int, res *positions.Integrity) {
+	_, err := db.Exec(`
+		INSERT INTO gps_reviews (entity_type, entity_id, user_id, score, flags)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+			score = GREATEST(gps_reviews.score, EXCLUDED.score),
+			flags = ARRAY(SELECT DISTINCT unnest(gps_reviews.flags || EXCLUDED.flags)),
+			suspicious_points = gps_reviews.suspicious_points + 1,
+			updated_at = CURRENT_TIMESTAMP
+	`, entityType, entityID, userID, res.Score, pq.Array(res.Flags))
+	if err != nil {
+		log.Printf("GPS: error abriendo revisión %s %d: %v", entityType, entityID, err)
+	}
+}
+
+// integrityColumns valores a guardar (NULL si no se pudo puntuar)
+func integrityColumns(res *positions.Integrity) (interface{}, interface{}, bool) {
+	if res == nil {
+		return nil, nil, false
+	}
+	return res.Score, pq.Array(res.Flags), res.Suspicious
+}
+
+// GetGPSReviews lista las revisiones de GPS (por defecto las pendientes)
+func GetGPSReviews(c *gin.Context) {
+	query := `
+		SELECT r.id, r.entity_type, r.entity_id, r.user_id, COALESCE(u.name, ''), r.score, r.flags,
+		       r.suspicious_points, r.status, r.reviewed_by, r.reviewed_at, COALESCE(r.review_notes, ''),
+		       r.created_at, r.updated_at
+		FROM gps_reviews r
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE 1=1`
+	args := []interface{}{}
+
+	status := c.DefaultQuery("status", "pending")
+	if status != "all" {
+		args = append(args, status)
+		query += " AND r.status = $" + strconv.Itoa(len(args))
+	}
+	if entityType := c.Query("entity_type"); entityType != "" {
+		args = append(args, entityType)
+		query += " AND r.entity_type = $" + strconv.Itoa(len(args))
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		args = append(args, userID)
+		query += " AND r.user_id = $" + strconv.Itoa(len(args))
+	}
+	args = append(args, c.DefaultQuery("limit", "100"))
+	query += " ORDER BY r.score DESC, r.created_at DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener revisiones GPS"})
+		return
+	}
+	defer rows.Close()
+
+	reviews := []GPSReview{}
+	for rows.Next() {
+		var r GPSReview
+		var flags pq.StringArray
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.EntityType, &r.EntityID, &r.UserID, &r.UserName, &r.Score, &flags,
+			&r.SuspiciousPoints, &r.Status, &r.ReviewedBy, &reviewedAt, &r.ReviewNotes,
+			&r.CreatedAt, &r.UpdatedAt); err != nil {
+			continue
+		}
+		r.Flags = []string(flags)
+		if reviewedAt.Valid {
+			r.ReviewedAt = &reviewedAt.Time
+		}
+		reviews = append(reviews, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": reviews,
+		"total":   len(reviews),
+	})
+}
+
+// ResolveGPSReview confirma o descarta una revisión
+func ResolveGPSReview(c *gin.Context) {
+	reviewID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de revisión inválido"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required,oneof=confirmed dismissed"`
+		Notes  string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El estado debe ser confirmed o dismissed"})
+		return
+	}
+
+	var reviewedBy interface{}
+	if userID, err := strconv.Atoi(c.GetHeader("X-User-ID")); err == nil {
+		reviewedBy = userID
+	}
+
+	result, err := db.Exec(`
+		UPDATE gps_reviews
+		SET status = $1, review_notes = NULLIF($2, ''), reviewed_by = $3,
+		    reviewed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`, req.Status, req.Notes, reviewedBy, reviewID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar revisión"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revisión no encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      reviewID,
+		"status":  req.Status,
+		"message": "Revisión actualizada",
+	})
+}
@@ -14,6 +14,8 @@ type MotoKPI struct {
 	AvgDeliveryTimeMin *float64 `json:"avg_delivery_time_min"`
 	TotalRouteTimeMin  *float64 `json:"total_route_time_min"`
 	LastDeliveryAt     *string  `json:"last_delivery_at"`
+
+	FailedAttemptsToday int `json:"failed_attempts_today"`
 }
 
 // GetMotosKPIs returns time-based KPIs for motos
@@ -25,7 +27,8 @@ func GetMotosKPIs(c *gin.Context) {
 			COUNT(o.id) FILTER (WHERE o.status = 'delivered' AND DATE(o.created_at) = CURRENT_DATE) AS delivered_today,
 			AVG(EXTRACT(EPOCH FROM (o.updated_at - o.created_at))/60) FILTER (WHERE o.status = 'delivered') AS avg_delivery_time_min,
 			SUM(EXTRACT(EPOCH FROM (o.updated_at - o.created_at))/60) FILTER (WHERE o.status IN ('in_route', 'delivered')) AS total_route_time_min,
-			MAX(o.updated_at) FILTER (WHERE o.status = 'delivered') AS last_delivery_at
+			MAX(o.updated_at) FILTER (WHERE o.status = 'delivered') AS last_delivery_at,
+			(SELECT COUNT(*) FROM delivery_attempts da WHERE da.moto_id = m.id AND da.created_at >= CURRENT_DATE) AS failed_attempts_today
 		FROM motos m
 		LEFT JOIN orders o ON m.id = o.assigned_moto_id
 		GROUP BY m.id, m.license_plate
@@ -48,6 +51,7 @@ func GetMotosKPIs(c *gin.Context) {
 			&k.AvgDeliveryTimeMin,
 			&k.TotalRouteTimeMin,
 			&lastDelivery,
+			&k.FailedAttemptsToday,
 		)
 		if err != nil {
 			continue
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/barcode"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/pdf"
+	"github.com/logitrack/order-service/routing"
+)
+
+// ========================================
+// ETIQUETAS Y MANIFIESTOS (PDF)
+// ========================================
+// Se generan en el servicio sin dependencias externas: una etiqueta 4x6" por
+// bulto con su código Code 128 y un manifiesto de carga por moto con las
+// paradas en el orden de su última ruta.
+
+// maxLabelOrders pedidos por solicitud de etiquetas
+const maxLabelOrders = 200
+
+// labelOrder datos del pedido impresos en la etiqueta
+type labelOrder struct {
+	ID            int
+	ClientName    string
+	Address       string
+	OrderType     string
+	PickupAddress string
+	BranchCode    string
+	BranchAddress string
+	Parcels       []models.Parcel
+}
+
+// loadLabelOrders pedidos con sus bultos en el orden solicitado
+func loadLabelOrders(ids []int64) ([]labelOrder, error) {
+	rows, err := db.Query(`
+		SELECT o.id, o.client_name, o.address, o.order_type, COALESCE(o.pickup_address, ''),
+		       COALESCE(b.code, o.branch, ''), COALESCE(b.address, '')
+		FROM orders o
+		LEFT JOIN branches b ON b.id = o.branch_id
+		WHERE o.id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]*labelOrder{}
+	for rows.Next() {
+		var o labelOrder
+		if err := rows.Scan(&o.ID, &o.ClientName, &o.Address, &o.OrderType, &o.PickupAddress,
+			&o.BranchCode, &o.BranchAddress); err != nil {
+			continue
+		}
+		byID[o.ID] = &o
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	parcels, err := loadParcelsByOrder(ids)
+	if err != nil {
+		return nil, err
+	}
+	orders := []labelOrder{}
+	for _, id := range ids {
+		if o, ok := byID[int(id)]; ok {
+			o.Parcels = parcels[o.ID]
+			orders = append(orders, *o)
+		}
+	}
+	return orders, nil
+}
+
+// loadParcelsByOrder bultos agrupados por pedido
+func loadParcelsByOrder(ids []int64) (map[int][]models.Parcel, error) {
+	rows, err := db.Query("SELECT "+parcelColumns+" FROM parcels WHERE order_id = ANY($1) ORDER BY order_id, id", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parcels := map[int][]models.Parcel{}
+	for rows.Next() {
+		var p models.Parcel
+		if err := scanParcel(rows, &p); err != nil {
+			continue
+		}
+		parcels[p.OrderID] = append(parcels[p.OrderID], p)
+	}
+	return parcels, rows.Err()
+}
+
+// renderLabels una etiqueta por bulto
+func renderLabels(orders []labelOrder) []byte {
+	doc := pdf.New(pdf.LabelWidth, pdf.LabelHeight)
+	printed := time.Now().Format("2006-01-02 15:04")
+	for _, o := range orders {
+		for i, p := range o.Parcels {
+			drawLabel(doc.AddPage(), o, p, i+1, len(o.Parcels), printed)
+		}
+	}
+	return doc.Bytes()
+}
+
+func drawLabel(pg *pdf.Page, o labelOrder, p models.Parcel, n, total int, printed string) {
+	const left, right = 14.0, pdf.LabelWidth - 14.0
+	width := right - left
+
+	// Encabezado con la sucursal
+	pg.Text(left, 408, 12, true, "LOGITRACK")
+	pg.TextRight(right, 404, 24, true, o.BranchCode)
+	pg.Line(left, 395, right, 395, 1)
+
+	// Destinatario; las devoluciones van a la sucursal
+	title, name, address := "ENTREGAR A", o.ClientName, o.Address
+	if o.OrderType == models.OrderTypeReturn {
+		title, name, address = "DEVOLVER A SUCURSAL", "Sucursal "+o.BranchCode, o.BranchAddress
+	}
+	pg.Text(left, 380, 7, false, title)
+	pg.Text(left, 363, 15, true, pdf.Truncate(name, 15, width, true))
+	y := 347.0
+	for _, line := range pdf.Wrap(address, 10, width, false, 4) {
+		pg.Text(left, y, 10, false, line)
+		y -= 13
+	}
+
+	switch o.OrderType {
+	case models.OrderTypePickupDelivery:
+		pg.Text(left, 282, 7, false, "RECOGER EN")
+		y = 270
+		for _, line := range pdf.Wrap(o.PickupAddress, 9, width, false, 2) {
+			pg.Text(left, y, 9, false, line)
+			y -= 11
+		}
+	case models.OrderTypeReturn:
+		pg.Text(left, 282, 7, false, "REMITENTE")
+		pg.Text(left, 270, 9, false, pdf.Truncate(o.ClientName+" - "+o.Address, 9, width, false))
+	}
+	pg.Line(left, 250, right, 250, 1)
+
+	// Pedido y bulto
+	pg.Text(left, 232, 14, true, fmt.Sprintf("Pedido #%d", o.ID))
+	pg.TextRight(right, 232, 12, true, fmt.Sprintf("Bulto %d de %d", n, total))
+	if details := parcelDetails(p); details != "" {
+		pg.Text(left, 216, 9, false, details)
+	}
+
+	// Código de barras centrado con el texto legible debajo
+	if modules, err := barcode.Code128(p.Barcode); err == nil {
+		module := min(1.4, width/float64(len(modules)))
+		x := left + (width-module*float64(len(modules)))/2
+		pg.Bars(x, 110, module, 85, modules)
+	}
+	pg.TextCenter(pdf.LabelWidth/2, 94, 11, true, p.Barcode)
+
+	pg.Text(left, 20, 7, false, "Impreso "+printed)
+}
+
+// parcelDetails peso y medidas del bulto
+func parcelDetails(p models.Parcel) string {
+	var parts []string
+	if p.WeightKg != nil {
+		parts = append(parts, fmt.Sprintf("Peso: %.2f kg", *p.WeightKg))
+	}
+	if p.LengthCm != nil && p.WidthCm != nil && p.HeightCm != nil {
+		parts = append(parts, fmt.Sprintf("%.0f × %.0f × %.0f cm", *p.LengthCm, *p.WidthCm, *p.HeightCm))
+	}
+	if p.Description != nil && *p.Description != "" {
+		parts = append(parts, *p.Description)
+	}
+	return strings.Join(parts, "  ·  ")
+}
+
+func sendPDF(c *gin.Context, filename string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// GetOrderLabel GET /orders/:id/label etiquetas de los bultos del pedido
+func GetOrderLabel(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
+		return
+	}
+	orders, err := loadLabelOrders([]int64{int64(orderID)})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la orden"})
+		return
+	}
+	if len(orders) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Orden no encontrada"})
+		return
+	}
+	if len(orders[0].Parcels) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "La orden no tiene bultos"})
+		return
+	}
+	sendPDF(c, fmt.Sprintf("etiqueta-%d.pdf", orderID), renderLabels(orders))
+}
+
+// GetLabels GET /labels?order_ids=1,2,3 etiquetas de varios pedidos en un PDF
+func GetLabels(c *gin.Context) {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, part := range strings.Split(c.Query("order_ids"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order_ids inválido: " + part})
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order_ids es requerido"})
+		return
+	}
+	if len(ids) > maxLabelOrders {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Máximo %d pedidos por solicitud", maxLabelOrders)})
+		return
+	}
+
+	orders, err := loadLabelOrders(ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener las órdenes"})
+		return
+	}
+	found := 0
+	for _, o := range orders {
+		found += len(o.Parcels)
+	}
+	if found == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No hay bultos para los pedidos indicados"})
+		return
+	}
+	sendPDF(c, "etiquetas.pdf", renderLabels(orders))
+}
+
+// manifestStop parada del manifiesto
+type manifestStop struct {
+	Kind       string
+	OrderID    int
+	OrderType  string
+	ClientName string
+	Address    string
+	Parcels    []models.Parcel
+	OffRoute   bool // Pedido asignado que no está en la última ruta
+}
+
+// loadManifestStops paradas pendientes de la moto en el orden de su última
+// ruta; los pedidos asignados después de generarla van al final
+func loadManifestStops(motoID int) (*models.Route, []manifestStop, error) {
+	route, err := models.NewRouteRepository(db).GetLatestRoute(motoID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, client_name FROM orders
+		WHERE assigned_moto_id = $1 AND status IN ('offered', 'assigned', 'in_route')
+		ORDER BY created_at ASC, id ASC
+	`, motoID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ids []int64
+	names := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			continue
+		}
+		ids = append(ids, int64(id))
+		names[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	orders, err := loadRouteOrders(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	parcels, err := loadParcelsByOrder(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Paradas de la ruta; las rutas antiguas solo guardan el orden de pedidos
+	var sequence []routing.Stop
+	if route != nil {
+		sequence = route.StopSequence
+		if len(sequence) == 0 {
+			for _, id := range route.OrderSequence {
+				sequence = append(sequence,
+					routing.Stop{OrderID: id, Kind: routing.Pickup},
+					routing.Stop{OrderID: id, Kind: routing.Dropoff})
+			}
+		}
+	}
+	for _, id := range ids {
+		sequence = append(sequence,
+			routing.Stop{OrderID: int(id), Kind: routing.Pickup},
+			routing.Stop{OrderID: int(id), Kind: routing.Dropoff})
+	}
+
+	type stopKey struct {
+		OrderID int
+		Kind    string
+	}
+	added := map[stopKey]bool{}
+	inRoute := len(sequence) - 2*len(ids)
+	var stops []manifestStop
+	for i, s := range sequence {
+		o, ok := orders[s.OrderID]
+		key := stopKey{s.OrderID, s.Kind}
+		if !ok || added[key] {
+			continue
+		}
+		if s.Kind == routing.Pickup && (!o.needsPickup() || o.PickedUp) {
+			continue
+		}
+		added[key] = true
+
+		ms := manifestStop{
+			Kind:       s.Kind,
+			OrderID:    o.ID,
+			OrderType:  o.Type,
+			ClientName: names[o.ID],
+			Parcels:    parcels[o.ID],
+			OffRoute:   route != nil && i >= inRoute,
+		}
+		if s.Kind == routing.Pickup {
+			_, ms.Address = o.pickupStop()
+		} else {
+			_, ms.Address = o.dropoffStop()
+		}
+		stops = append(stops, ms)
+	}
+	return route, stops, nil
+}
+
+// loadedAtBranch indica si los bultos de la parada se cargan en la sucursal
+// (los demás se recogen en ruta)
+func (s manifestStop) loadedAtBranch() bool {
+	return s.Kind == routing.Dropoff && s.OrderType == models.OrderTypeDelivery
+}
+
+// GetMotoManifest GET /motos/:id/manifest manifiesto de carga de la moto
+func GetMotoManifest(c *gin.Context) {
+	motoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de moto inválido"})
+		return
+	}
+	var plate string
+	var branchCode sql.NullString
+	err = db.QueryRow(`
+		SELECT m.license_plate, b.code FROM motos m LEFT JOIN branches b ON b.id = m.branch_id WHERE m.id = $1
+	`, motoID).Scan(&plate, &branchCode)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Moto no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la moto"})
+		return
+	}
+
+	route, stops, err := loadManifestStops(motoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener las paradas"})
+		return
+	}
+
+	data := renderManifest(manifestHeader{
+		Plate:  plate,
+		Driver: motoDriverName(motoID),
+		Branch: branchCode.String,
+		Route:  route,
+	}, stops)
+	sendPDF(c, fmt.Sprintf("manifiesto-%s-%s.pdf", plate, time.Now().Format("20060102")), data)
+}
+
+type manifestHeader struct {
+	Plate  string
+	Driver string
+	Branch string
+	Route  *models.Route
+}
+
+// Columnas del manifiesto (x en puntos)
+const (
+	colSeq     = 36.0
+	colKind    = 58.0
+	colOrder   = 112.0
+	colClient  = 160.0
+	colAddress = 282.0
+	colParcels = 468.0
+	colLoaded  = 548.0
+	tableRight = pdf.LetterWidth - 36.0
+)
+
+func renderManifest(h manifestHeader, stops []manifestStop) []byte {
+	doc := pdf.New(pdf.LetterWidth, pdf.LetterHeight)
+	now := time.Now()
+	var pages []*pdf.Page
+
+	newPage := func() (*pdf.Page, float64) {
+		pg := doc.AddPage()
+		pages = append(pages, pg)
+		pg.Text(36, 750, 16, true, "MANIFIESTO DE CARGA")
+		pg.TextRight(tableRight, 750, 9, false, "Fecha: "+now.Format("2006-01-02 15:04"))
+		info := fmt.Sprintf("Moto: %s    Motorista: %s    Sucursal: %s", h.Plate, orDash(h.Driver), orDash(h.Branch))
+		pg.Text(36, 732, 10, false, info)
+		routeInfo := "Sin ruta generada: pedidos en orden de asignación"
+		if h.Route != nil {
+			routeInfo = fmt.Sprintf("Ruta #%d generada %s", h.Route.ID, h.Route.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		pg.Text(36, 718, 9, false, routeInfo)
+
+		y := 696.0
+		for _, col := range []struct {
+			x     float64
+			title string
+		}{
+			{colSeq, "#"}, {colKind, "Tipo"}, {colOrder, "Pedido"}, {colClient, "Cliente"},
+			{colAddress, "Dirección"}, {colParcels, "Bultos"}, {colLoaded, "Cargado"},
+		} {
+			pg.Text(col.x, y, 8, true, col.title)
+		}
+		pg.Line(36, y-5, tableRight, y-5, 0.8)
+		return pg, y - 18
+	}
+
+	pg, y := newPage()
+	toLoad, weight, offRoute := 0, 0.0, false
+	for i, s := range stops {
+		addressLines := pdf.Wrap(s.Address, 8, colParcels-colAddress-8, false, 2)
+		rowLines := max(len(addressLines), len(s.Parcels), 1)
+		rowHeight := float64(rowLines)*10 + 8
+		if y-rowHeight < 110 {
+			pg, y = newPage()
+		}
+
+		kind := "Entrega"
+		if s.Kind == routing.Pickup {
+			kind = "Recogida"
+		}
+		if s.OffRoute {
+			kind += "*"
+			offRoute = true
+		}
+		pg.Text(colSeq, y, 8, false, strconv.Itoa(i+1))
+		pg.Text(colKind, y, 8, false, kind)
+		pg.Text(colOrder, y, 8, false, strconv.Itoa(s.OrderID))
+		pg.Text(colClient, y, 8, false, pdf.Truncate(s.ClientName, 8, colAddress-colClient-8, false))
+		for j, line := range addressLines {
+			pg.Text(colAddress, y-float64(j)*10, 8, false, line)
+		}
+		for j, p := range s.Parcels {
+			pg.Text(colParcels, y-float64(j)*10, 7, false, p.Barcode)
+		}
+		if s.loadedAtBranch() {
+			pg.Rect(colLoaded+10, y-2, 9, 9, false)
+			toLoad += len(s.Parcels)
+			for _, p := range s.Parcels {
+				if p.WeightKg != nil {
+					weight += *p.WeightKg
+				}
+			}
+		} else {
+			pg.Text(colLoaded+12, y, 8, false, "-")
+		}
+		y -= rowHeight
+		pg.Line(36, y+10, tableRight, y+10, 0.3)
+	}
+	if len(stops) == 0 {
+		pg.Text(36, y, 9, false, "La moto no tiene pedidos pendientes.")
+	}
+
+	// Totales y firmas en la última página
+	pg.Text(36, 92, 9, true, fmt.Sprintf("Paradas: %d    Bultos a cargar en sucursal: %d    Peso: %.2f kg",
+		len(stops), toLoad, weight))
+	if offRoute {
+		pg.Text(36, 80, 7, false, "* Pedido asignado después de generar la ruta")
+	}
+	pg.Line(36, 48, 250, 48, 0.6)
+	pg.Text(36, 38, 8, false, "Entregado por (bodega)")
+	pg.Line(330, 48, tableRight, 48, 0.6)
+	pg.Text(330, 38, 8, false, "Recibido por (motorista)")
+
+	for i, p := range pages {
+		p.TextRight(tableRight, 20, 7, false, fmt.Sprintf("Página %d de %d", i+1, len(pages)))
+	}
+	return doc.Bytes()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
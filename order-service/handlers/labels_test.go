@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/logitrack/order-service/barcode"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/routing"
+)
+
+// pdfPageCount lee /Count del árbol de páginas
+func pdfPageCount(t *testing.T, data []byte) int {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("no es un PDF completo")
+	}
+	m := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("falta el árbol de páginas")
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	return n
+}
+
+func testParcels(orderID, n int) []models.Parcel {
+	weight := 1.5
+	parcels := make([]models.Parcel, n)
+	for i := range parcels {
+		id := orderID*10 + i
+		parcels[i] = models.Parcel{ID: id, OrderID: orderID, Barcode: barcode.ForParcel(id), WeightKg: &weight}
+	}
+	return parcels
+}
+
+func TestRenderLabelsOnePagePerParcel(t *testing.T) {
+	orders := []labelOrder{
+		{ID: 1, ClientName: "Ana Pérez", Address: "Av. Corrientes 1234 (local 2)", OrderType: models.OrderTypeDelivery,
+			BranchCode: "NOR", Parcels: testParcels(1, 1)},
+		{ID: 2, ClientName: "Juan Gómez", Address: "Calle Falsa 123", OrderType: models.OrderTypePickupDelivery,
+			PickupAddress: "Depósito Central, Ruta 8 km 50", BranchCode: "NOR", Parcels: testParcels(2, 2)},
+		{ID: 3, ClientName: "Marta Díaz", Address: "San Martín 900", OrderType: models.OrderTypeReturn,
+			BranchCode: "SUR", BranchAddress: "Belgrano 10", Parcels: testParcels(3, 3)},
+	}
+
+	data := renderLabels(orders)
+	if got := pdfPageCount(t, data); got != 6 {
+		t.Errorf("%d páginas, se esperaban 6 (una por bulto)", got)
+	}
+	for _, text := range []string{"(Bulto 3 de 3)", "(DEVOLVER A SUCURSAL)", "(RECOGER EN)", "(" + orders[2].Parcels[2].Barcode + ")"} {
+		if !bytes.Contains(data, []byte(text)) {
+			t.Errorf("falta %s en el PDF", text)
+		}
+	}
+}
+
+func TestRenderManifestMultiPage(t *testing.T) {
+	var stops []manifestStop
+	for i := 1; i <= 60; i++ {
+		kind, orderType := routing.Dropoff, models.OrderTypeDelivery
+		if i%3 == 0 {
+			kind, orderType = routing.Pickup, models.OrderTypePickupDelivery
+		}
+		stops = append(stops, manifestStop{
+			Kind:       kind,
+			OrderID:    i,
+			OrderType:  orderType,
+			ClientName: fmt.Sprintf("Cliente %d con un nombre bastante largo", i),
+			Address:    "Avenida Libertador General San Martín 1234, Piso 5, Departamento B, Vicente López",
+			Parcels:    testParcels(i, 1+i%3),
+			OffRoute:   i == 60,
+		})
+	}
+	h := manifestHeader{Plate: "AB123CD", Driver: "Luis", Branch: "NOR",
+		Route: &models.Route{ID: 9, CreatedAt: time.Now()}}
+
+	data := renderManifest(h, stops)
+	pages := pdfPageCount(t, data)
+	if pages < 2 {
+		t.Fatalf("60 paradas deberían ocupar varias páginas, hay %d", pages)
+	}
+	// Numeración en todas las páginas (á en WinAnsi es 0xE1)
+	for i := 1; i <= pages; i++ {
+		if label := fmt.Sprintf("(P\xe1gina %d de %d)", i, pages); !bytes.Contains(data, []byte(label)) {
+			t.Errorf("falta %q", label)
+		}
+	}
+	if !bytes.Contains(data, []byte("Paradas: 60")) {
+		t.Errorf("faltan los totales en la última página")
+	}
+}
+
+func TestRenderManifestWithoutStops(t *testing.T) {
+	data := renderManifest(manifestHeader{Plate: "AB123CD"}, nil)
+	if got := pdfPageCount(t, data); got != 1 {
+		t.Errorf("%d páginas, se esperaba 1", got)
+	}
+	if !bytes.Contains(data, []byte("La moto no tiene pedidos pendientes.")) {
+		t.Errorf("falta el aviso de manifiesto vacío")
+	}
+}
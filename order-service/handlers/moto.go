@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/positions"
+	"github.com/logitrack/order-service/sqlbuilder"
 )
 
 // ========================================
@@ -18,36 +22,35 @@ func GetMotos(c *gin.Context) {
 	status := c.Query("status")
 	userBranch := c.GetHeader("X-User-Branch")
 	userRole := c.GetHeader("X-User-Role")
+	userID, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
 
 	// Query actualizada para usar current_branch_id (sucursal efectiva)
-	query := `SELECT m.id, m.license_plate, m.driver_id, m.branch_id, 
+	q := sqlbuilder.New(`m.id, m.license_plate, m.driver_id, m.branch_id, 
 	          COALESCE(m.current_branch_id, m.branch_id) as effective_branch_id,
-	          m.status, m.latitude, m.longitude, m.max_orders_capacity, m.current_orders_count,
-	          m.transfer_expires_at, m.transfer_reason,
-	          CASE WHEN m.current_branch_id != m.branch_id AND m.current_branch_id IS NOT NULL THEN true ELSE false END as is_transferred
-	          FROM motos m WHERE 1=1`
-	args := []interface{}{}
-	argCount := 0
+	          m.status, m.latitude, m.longitude, m.last_location_update, m.max_orders_capacity, m.current_orders_count,
+	          m.max_weight_kg, m.max_volume_l, m.transfer_expires_at, m.transfer_reason,
+	          CASE WHEN m.current_branch_id != m.branch_id AND m.current_branch_id IS NOT NULL THEN true ELSE false END as is_transferred`,
+		"motos m")
 
 	// Filtro explícito por branch_id del query param
 	if branchID != "" {
-		argCount++
-		query += " AND COALESCE(m.current_branch_id, m.branch_id) = $" + strconv.Itoa(argCount)
-		args = append(args, branchID)
-	} else if userRole != "admin" && userRole != "manager" && userRole != "coordinator" && userBranch != "" {
+		q.Where("COALESCE(m.current_branch_id, m.branch_id) = ?", branchID)
+	} else if userRole != "admin" && userRole != "manager" && userRole != "coordinator" {
 		// Si no es admin/manager/coordinator, filtrar por sucursal del usuario
-		argCount++
-		query += " AND COALESCE(m.current_branch_id, m.branch_id) = (SELECT id FROM branches WHERE code = $" + strconv.Itoa(argCount) + ")"
-		args = append(args, userBranch)
+		if userBranch != "" {
+			q.Where("COALESCE(m.current_branch_id, m.branch_id) = (SELECT id FROM branches WHERE code = ?)", userBranch)
+		} else if userID > 0 {
+			q.Where(`COALESCE(m.current_branch_id, m.branch_id) =
+				COALESCE((SELECT branch_id FROM users WHERE id = ?), COALESCE(m.current_branch_id, m.branch_id))`, userID)
+		}
 	}
 
 	if status != "" {
-		argCount++
-		query += " AND m.status = $" + strconv.Itoa(argCount)
-		args = append(args, status)
+		q.Where("m.status = ?", status)
 	}
 
-	query += " ORDER BY m.license_plate"
+	q.OrderBy("m.license_plate")
+	query, args := q.SQL()
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -61,14 +64,15 @@ func GetMotos(c *gin.Context) {
 		var id, maxCapacity, currentCount int
 		var licensePlate, status string
 		var driverID, branchID, effectiveBranchID sql.NullInt64
-		var lat, lng sql.NullFloat64
+		var lat, lng, maxWeight, maxVolume sql.NullFloat64
+		var locationUpdated sql.NullTime
 		var transferExpires sql.NullTime
 		var transferReason sql.NullString
 		var isTransferred bool
 
 		if err := rows.Scan(&id, &licensePlate, &driverID, &branchID, &effectiveBranchID,
-			&status, &lat, &lng, &maxCapacity, &currentCount,
-			&transferExpires, &transferReason, &isTransferred); err != nil {
+			&status, &lat, &lng, &locationUpdated, &maxCapacity, &currentCount,
+			&maxWeight, &maxVolume, &transferExpires, &transferReason, &isTransferred); err != nil {
 			continue
 		}
 
@@ -95,6 +99,15 @@ func GetMotos(c *gin.Context) {
 		if lng.Valid {
 			moto["longitude"] = lng.Float64
 		}
+		if maxWeight.Valid {
+			moto["max_weight_kg"] = maxWeight.Float64
+		}
+		if maxVolume.Valid {
+			moto["max_volume_l"] = maxVolume.Float64
+		}
+		if locationUpdated.Valid {
+			moto["location_updated_at"] = locationUpdated.Time
+		}
 		if transferExpires.Valid {
 			moto["transfer_expires_at"] = transferExpires.Time
 		}
@@ -117,12 +130,13 @@ func GetMotoByID(c *gin.Context) {
 
 	var m models.Moto
 	var driverID, branchID sql.NullInt64
-	var lat, lng sql.NullFloat64
+	var lat, lng, maxWeight, maxVolume sql.NullFloat64
 	err = db.QueryRow(`SELECT id, license_plate, driver_id, branch_id, status, 
-	                   latitude, longitude, max_orders_capacity, current_orders_count 
+	                   latitude, longitude, max_orders_capacity, current_orders_count,
+	                   max_weight_kg, max_volume_l
 	                   FROM motos WHERE id = $1`, id).
 		Scan(&m.ID, &m.LicensePlate, &driverID, &branchID, &m.Status,
-			&lat, &lng, &m.MaxOrdersCapacity, &m.CurrentOrdersCount)
+			&lat, &lng, &m.MaxOrdersCapacity, &m.CurrentOrdersCount, &maxWeight, &maxVolume)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Moto not found"})
 		return
@@ -145,6 +159,12 @@ func GetMotoByID(c *gin.Context) {
 	if lng.Valid {
 		m.Longitude = &lng.Float64
 	}
+	if maxWeight.Valid {
+		m.MaxWeightKg = &maxWeight.Float64
+	}
+	if maxVolume.Valid {
+		m.MaxVolumeL = &maxVolume.Float64
+	}
 
 	c.JSON(http.StatusOK, m)
 }
@@ -157,6 +177,8 @@ type CreateMotoRequest struct {
 	Latitude          *float64 `json:"latitude"`
 	Longitude         *float64 `json:"longitude"`
 	MaxOrdersCapacity int      `json:"max_orders_capacity"`
+	MaxWeightKg       *float64 `json:"max_weight_kg"`
+	MaxVolumeL        *float64 `json:"max_volume_l"`
 }
 
 func CreateMoto(c *gin.Context) {
@@ -171,11 +193,16 @@ func CreateMoto(c *gin.Context) {
 	if req.MaxOrdersCapacity <= 0 {
 		req.MaxOrdersCapacity = 5
 	}
+	if (req.MaxWeightKg != nil && *req.MaxWeightKg <= 0) || (req.MaxVolumeL != nil && *req.MaxVolumeL <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_weight_kg y max_volume_l deben ser mayores a 0"})
+		return
+	}
 
 	var m models.Moto
-	err := db.QueryRow(`INSERT INTO motos (license_plate, driver_id, branch_id, status, latitude, longitude, max_orders_capacity, current_orders_count) 
-	                    VALUES ($1, $2, $3, $4, $5, $6, $7, 0) RETURNING id`,
+	err := db.QueryRow(`INSERT INTO motos (license_plate, driver_id, branch_id, status, latitude, longitude, max_orders_capacity, current_orders_count, max_weight_kg, max_volume_l) 
+	                    VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8, $9) RETURNING id`,
 		req.LicensePlate, req.DriverID, req.BranchID, req.Status, req.Latitude, req.Longitude, req.MaxOrdersCapacity,
+		req.MaxWeightKg, req.MaxVolumeL,
 	).Scan(&m.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create moto: " + err.Error()})
@@ -190,6 +217,8 @@ func CreateMoto(c *gin.Context) {
 	m.Longitude = req.Longitude
 	m.MaxOrdersCapacity = req.MaxOrdersCapacity
 	m.CurrentOrdersCount = 0
+	m.MaxWeightKg = req.MaxWeightKg
+	m.MaxVolumeL = req.MaxVolumeL
 
 	c.JSON(http.StatusCreated, m)
 }
@@ -202,6 +231,9 @@ type UpdateMotoRequest struct {
 	Latitude          *float64 `json:"latitude"`
 	Longitude         *float64 `json:"longitude"`
 	MaxOrdersCapacity *int     `json:"max_orders_capacity"`
+	// 0 quita el límite de peso o volumen
+	MaxWeightKg *float64 `json:"max_weight_kg"`
+	MaxVolumeL  *float64 `json:"max_volume_l"`
 }
 
 func UpdateMoto(c *gin.Context) {
@@ -256,6 +288,24 @@ func UpdateMoto(c *gin.Context) {
 		args = append(args, *req.MaxOrdersCapacity)
 		argNum++
 	}
+	if req.MaxWeightKg != nil {
+		if *req.MaxWeightKg < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_weight_kg no puede ser negativo"})
+			return
+		}
+		updates = append(updates, "max_weight_kg = NULLIF($"+strconv.Itoa(argNum)+"::double precision, 0)")
+		args = append(args, *req.MaxWeightKg)
+		argNum++
+	}
+	if req.MaxVolumeL != nil {
+		if *req.MaxVolumeL < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_volume_l no puede ser negativo"})
+			return
+		}
+		updates = append(updates, "max_volume_l = NULLIF($"+strconv.Itoa(argNum)+"::double precision, 0)")
+		args = append(args, *req.MaxVolumeL)
+		argNum++
+	}
 
 	if len(updates) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
@@ -325,17 +375,19 @@ func UpdateMotoLocation(c *gin.Context) {
 		return
 	}
 
-	res, err := db.Exec(`UPDATE motos SET latitude = $1, longitude = $2, last_location_update = CURRENT_TIMESTAMP WHERE id = $3`,
-		req.Latitude, req.Longitude, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update moto location"})
+	// La posición se registra en geolocation-service (única vía de escritura)
+	err = positions.Ingest(positions.Report{MotoID: id, Latitude: req.Latitude, Longitude: req.Longitude})
+	var apiErr *positions.APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Moto not found"})
 		return
 	}
-	affected, _ := res.RowsAffected()
-	if affected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Moto not found"})
+	if err != nil {
+		log.Printf("UpdateMotoLocation: error registrando posición de moto %d: %v", id, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update moto location"})
 		return
 	}
+	indexMotoPosition(id, req.Latitude, req.Longitude)
 	c.JSON(http.StatusOK, gin.H{"message": "Location updated"})
 }
 
@@ -406,6 +458,7 @@ func CreateBranch(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create branch: " + err.Error()})
 		return
 	}
+	invalidateBranchCache()
 
 	c.JSON(http.StatusCreated, b)
 }
@@ -440,7 +493,14 @@ func UpdateBranch(c *gin.Context) {
 		return
 	}
 
-	_, err := db.Exec(`UPDATE branches SET name = $1, code = $2, address = $3, 
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update branch"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE branches SET name = $1, code = $2, address = $3, 
 	                   latitude = $4, longitude = $5, radius_km = $6, is_active = $7
 	                   WHERE id = $8`,
 		b.Name, b.Code, b.Address, b.Latitude, b.Longitude, b.RadiusKm, b.IsActive, id)
@@ -449,6 +509,18 @@ func UpdateBranch(c *gin.Context) {
 		return
 	}
 
+	// Mantener el código denormalizado de los pedidos de esta sucursal
+	_, err = tx.Exec(`UPDATE orders SET branch = $1 WHERE branch_id = $2 AND branch IS DISTINCT FROM $1`, b.Code, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update branch orders"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update branch"})
+		return
+	}
+	invalidateBranchCache()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Branch updated"})
 }
 
@@ -462,6 +534,7 @@ func DeleteBranch(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete branch: " + err.Error()})
 		return
 	}
+	invalidateBranchCache()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Branch deactivated"})
 }
@@ -475,6 +548,7 @@ func ToggleBranchActive(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to toggle branch status: " + err.Error()})
 		return
 	}
+	invalidateBranchCache()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Branch status toggled"})
 }
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ========================================
+// CONCILIACIÓN DEL CONTADOR DE PEDIDOS DE LAS MOTOS
+// ========================================
+// motos.current_orders_count lo mantiene el trigger orders_sync_moto_order_count
+// (migración 019). La conciliación lo recalcula desde orders por si algún
+// cambio fuera de la aplicación lo desincronizó, y reporta las diferencias.
+
+// MotoLoadDiscrepancy moto cuyo contador no coincide con sus pedidos activos
+type MotoLoadDiscrepancy struct {
+	MotoID       int    `json:"moto_id"`
+	LicensePlate string `json:"license_plate"`
+	Stored       int    `json:"stored_count"`
+	Actual       int    `json:"actual_count"`
+	Difference   int    `json:"difference"` // stored - actual
+}
+
+// reconcileMotoLoads compara el contador de cada moto con sus pedidos
+// ofrecidos, asignados o en ruta. Con fix corrige los contadores.
+func reconcileMotoLoads(fix bool) ([]MotoLoadDiscrepancy, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	lock := ""
+	if fix {
+		lock = " FOR UPDATE OF m"
+	}
+	rows, err := tx.Query(`
+		SELECT m.id, m.license_plate, m.current_orders_count, moto_active_order_count(m.id)
+		FROM motos m
+		WHERE m.current_orders_count IS DISTINCT FROM moto_active_order_count(m.id)
+		ORDER BY m.id` + lock)
+	if err != nil {
+		return nil, err
+	}
+	discrepancies := []MotoLoadDiscrepancy{}
+	for rows.Next() {
+		var d MotoLoadDiscrepancy
+		if err := rows.Scan(&d.MotoID, &d.LicensePlate, &d.Stored, &d.Actual); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Difference = d.Stored - d.Actual
+		discrepancies = append(discrepancies, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !fix || len(discrepancies) == 0 {
+		return discrepancies, nil
+	}
+	for _, d := range discrepancies {
+		if _, err := tx.Exec("UPDATE motos SET current_orders_count = moto_active_order_count(id) WHERE id = $1", d.MotoID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return discrepancies, nil
+}
+
+// InitMotoLoadReconciliation concilia los contadores periódicamente
+// (MOTO_LOAD_RECONCILE_MINUTES, por defecto 15)
+func InitMotoLoadReconciliation() {
+	interval := 15 * time.Minute
+	if v, err := strconv.Atoi(os.Getenv("MOTO_LOAD_RECONCILE_MINUTES")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			fixed, err := reconcileMotoLoads(true)
+			if err != nil {
+				log.Printf("Conciliación de carga: error: %v", err)
+				continue
+			}
+			for _, d := range fixed {
+				log.Printf("Conciliación de carga: moto %d (%s) tenía %d pedidos, corregido a %d",
+					d.MotoID, d.LicensePlate, d.Stored, d.Actual)
+			}
+		}
+	}()
+}
+
+// canReconcileMotoLoads solo admin, manager y supervisores
+func canReconcileMotoLoads(c *gin.Context) bool {
+	role := c.GetHeader("X-User-Role")
+	if role != "admin" && role != "manager" && role != "supervisor" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para conciliar la carga de las motos"})
+		return false
+	}
+	return true
+}
+
+// GetMotoLoadDiscrepancies GET /motos/load-reconciliation
+// Reporta las motos con contador desincronizado sin modificarlas
+func GetMotoLoadDiscrepancies(c *gin.Context) {
+	if !canReconcileMotoLoads(c) {
+		return
+	}
+	discrepancies, err := reconcileMotoLoads(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"discrepancies": discrepancies, "count": len(discrepancies)})
+}
+
+// ReconcileMotoLoads POST /motos/load-reconciliation
+// Recalcula los contadores desde orders y devuelve lo corregido
+func ReconcileMotoLoads(c *gin.Context) {
+	if !canReconcileMotoLoads(c) {
+		return
+	}
+	fixed, err := reconcileMotoLoads(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"fixed": fixed, "count": len(fixed)})
+}
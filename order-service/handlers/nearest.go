@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/positions"
+	"github.com/logitrack/order-service/spatial"
+)
+
+// ========================================
+// MOTOS MÁS CERCANAS (índice espacial en memoria)
+// ========================================
+
+// Límites de la consulta de motos cercanas
+const (
+	defaultNearestK = 5
+	maxNearestK     = 50
+)
+
+// motoIndex posiciones de todas las motos, refrescadas desde
+// geolocation-service y actualizadas al registrar cada ubicación
+var (
+	motoIndex = spatial.NewGrid(0.01)
+
+	motoIndexMu        sync.RWMutex
+	motoIndexPositions = map[int]positions.Position{}
+	motoIndexRefreshed time.Time
+	motoIndexSource    string // "positions" o "database" (si geolocation-service no responde)
+)
+
+// NearestQuery parámetros de búsqueda de motos cercanas
+type NearestQuery struct {
+	Latitude  float64
+	Longitude float64
+	K         int     // Cantidad máxima de motos
+	RadiusKm  float64 // 0 = sin límite
+	BranchID  *int
+	FreshOnly bool // Excluir motos con posición desactualizada
+}
+
+// NearestMoto moto disponible con su distancia y carga
+type NearestMoto struct {
+	models.Moto
+	DistanceMeters    float64    `json:"distance_meters"`
+	DistanceKm        float64    `json:"distance_km"`
+	AvailableCapacity int        `json:"available_capacity"`
+	LoadRatio         float64    `json:"load_ratio"` // current_orders_count / max_orders_capacity
+	PositionUpdatedAt *time.Time `json:"position_updated_at,omitempty"`
+	PositionStale     bool       `json:"position_stale"`
+}
+
+// InitMotoIndex carga el índice y lo refresca cada NEAREST_INDEX_REFRESH_SECONDS
+// segundos (por defecto 15)
+func InitMotoIndex() {
+	interval := 15 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("NEAREST_INDEX_REFRESH_SECONDS")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Second
+	}
+
+	go func() {
+		refreshMotoIndex()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			refreshMotoIndex()
+		}
+	}()
+}
+
+// refreshMotoIndex reconstruye el índice con la última posición de cada moto
+func refreshMotoIndex() {
+	source := "positions"
+	latest, err := positions.Latest(nil)
+	if err != nil {
+		log.Printf("Índice de motos: geolocation-service no disponible, usando la BD: %v", err)
+		source = "database"
+		latest, err = motoPositionsFromDB()
+		if err != nil {
+			log.Printf("Índice de motos: error leyendo posiciones: %v", err)
+			return
+		}
+	}
+
+	items := make([]spatial.Item, 0, len(latest))
+	for id, p := range latest {
+		items = append(items, spatial.Item{ID: id, Latitude: p.Latitude, Longitude: p.Longitude})
+	}
+	motoIndex.Replace(items)
+
+	motoIndexMu.Lock()
+	motoIndexPositions = latest
+	motoIndexRefreshed = time.Now()
+	motoIndexSource = source
+	motoIndexMu.Unlock()
+}
+
+// motoPositionsFromDB respaldo con la posición guardada en motos
+func motoPositionsFromDB() (map[int]positions.Position, error) {
+	rows, err := db.Query(`
+		SELECT id, branch_id, latitude, longitude, last_location_update
+		FROM motos
+		WHERE latitude IS NOT NULL AND longitude IS NOT NULL
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := map[int]positions.Position{}
+	for rows.Next() {
+		var p positions.Position
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&p.MotoID, &p.BranchID, &p.Latitude, &p.Longitude, &updatedAt); err != nil {
+			continue
+		}
+		if updatedAt.Valid {
+			p.UpdatedAt = &updatedAt.Time
+		}
+		p.Stale = !updatedAt.Valid
+		latest[p.MotoID] = p
+	}
+	return latest, rows.Err()
+}
+
+// indexMotoPosition actualiza el índice apenas se registra una ubicación
+func indexMotoPosition(motoID int, lat, lng float64) {
+	motoIndex.Upsert(spatial.Item{ID: motoID, Latitude: lat, Longitude: lng})
+
+	now := time.Now()
+	motoIndexMu.Lock()
+	p := motoIndexPositions[motoID]
+	p.MotoID, p.Latitude, p.Longitude = motoID, lat, lng
+	p.UpdatedAt, p.Stale = &now, false
+	motoIndexPositions[motoID] = p
+	motoIndexMu.Unlock()
+}
+
+// FindNearestMotos devuelve las motos disponibles con capacidad más cercanas
+// al punto, ordenadas por distancia
+func FindNearestMotos(q NearestQuery) ([]NearestMoto, error) {
+	query := `SELECT id, license_plate, driver_id, branch_id, status,
+	          latitude, longitude, max_orders_capacity, current_orders_count
+	          FROM motos
+	          WHERE status = 'available'
+	          AND current_orders_count < max_orders_capacity`
+	args := []interface{}{}
+	if q.BranchID != nil {
+		query += " AND branch_id = $1"
+		args = append(args, *q.BranchID)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	candidates := map[int]models.Moto{}
+	for rows.Next() {
+		var m models.Moto
+		if err := rows.Scan(&m.ID, &m.LicensePlate, &m.DriverID, &m.BranchID, &m.Status,
+			&m.Latitude, &m.Longitude, &m.MaxOrdersCapacity, &m.CurrentOrdersCount); err != nil {
+			continue
+		}
+		candidates[m.ID] = m
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	motoIndexMu.RLock()
+	defer motoIndexMu.RUnlock()
+
+	neighbors := motoIndex.Nearest(q.Latitude, q.Longitude, q.K, q.RadiusKm*1000, func(it spatial.Item) bool {
+		if _, ok := candidates[it.ID]; !ok {
+			return false
+		}
+		return !q.FreshOnly || !motoIndexPositions[it.ID].Stale
+	})
+
+	result := make([]NearestMoto, 0, len(neighbors))
+	for _, n := range neighbors {
+		m := candidates[n.ID]
+		pos := motoIndexPositions[n.ID]
+		lat, lng := n.Latitude, n.Longitude
+		m.Latitude, m.Longitude = &lat, &lng
+
+		nm := NearestMoto{
+			Moto:              m,
+			DistanceMeters:    n.DistanceMeters,
+			DistanceKm:        n.DistanceMeters / 1000,
+			AvailableCapacity: m.MaxOrdersCapacity - m.CurrentOrdersCount,
+			PositionUpdatedAt: pos.UpdatedAt,
+			PositionStale:     pos.Stale,
+		}
+		if m.MaxOrdersCapacity > 0 {
+			nm.LoadRatio = float64(m.CurrentOrdersCount) / float64(m.MaxOrdersCapacity)
+		}
+		result = append(result, nm)
+	}
+	return result, nil
+}
+
+// GetNearestMotos GET /motos/nearest?lat=&lng=&k=&radius_km=&branch_id=&fresh_only=
+func GetNearestMotos(c *gin.Context) {
+	lat, errLat := strconv.ParseFloat(c.Query("lat"), 64)
+	lng, errLng := strconv.ParseFloat(c.Query("lng"), 64)
+	if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat y lng son requeridos y deben ser coordenadas válidas"})
+		return
+	}
+
+	q := NearestQuery{Latitude: lat, Longitude: lng, K: defaultNearestK}
+	if v := c.Query("k"); v != "" {
+		k, err := strconv.Atoi(v)
+		if err != nil || k <= 0 || k > maxNearestK {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "k debe estar entre 1 y 50"})
+			return
+		}
+		q.K = k
+	}
+	if v := c.Query("radius_km"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "radius_km debe ser mayor a cero"})
+			return
+		}
+		q.RadiusKm = r
+	}
+	if v := c.Query("branch_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "branch_id inválido"})
+			return
+		}
+		q.BranchID = &id
+	}
+	q.FreshOnly = c.Query("fresh_only") == "true"
+
+	motos, err := FindNearestMotos(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar motos cercanas"})
+		return
+	}
+
+	motoIndexMu.RLock()
+	indexInfo := gin.H{
+		"size":         motoIndex.Len(),
+		"refreshed_at": motoIndexRefreshed,
+		"source":       motoIndexSource,
+	}
+	motoIndexMu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"motos": motos,
+		"count": len(motos),
+		"index": indexInfo,
+	})
+}
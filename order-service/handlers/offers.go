@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/dispatch"
+	"github.com/logitrack/order-service/geo"
+	"github.com/logitrack/order-service/positions"
+)
+
+// ========================================
+// OFERTAS DE ASIGNACIÓN (aceptar / rechazar)
+// ========================================
+
+// Estados de una oferta
+const (
+	OfferPending   = "pending"
+	OfferAccepted  = "accepted"
+	OfferRejected  = "rejected"
+	OfferExpired   = "expired"
+	OfferCancelled = "cancelled"
+)
+
+// Origen de una oferta
+const (
+	OfferSourceManual       = "manual"
+	OfferSourceOptimization = "optimization"
+	OfferSourceDispatch     = "dispatch"
+	OfferSourceReassignment = "reassignment"
+)
+
+var (
+	errOfferConflict   = errors.New("el pedido ya no está pendiente de asignar")
+	errMotoUnavailable = errors.New("la moto no está disponible")
+)
+
+// Configuración (OFFER_TIMEOUT_SECONDS, OFFER_MAX_ATTEMPTS)
+var (
+	offerTimeout     = 60 * time.Second
+	offerMaxAttempts = 3
+)
+
+// AssignmentOffer oferta de un pedido a una moto
+type AssignmentOffer struct {
+	ID           int        `json:"id"`
+	OrderID      int        `json:"order_id"`
+	MotoID       int        `json:"moto_id"`
+	MotoPlate    string     `json:"moto_plate,omitempty"`
+	DriverID     *int       `json:"driver_id"`
+	Status       string     `json:"status"`
+	Source       string     `json:"source"`
+	Attempt      int        `json:"attempt"`
+	Score        *float64   `json:"score,omitempty"`
+	RejectReason *string    `json:"reject_reason,omitempty"`
+	OfferedAt    time.Time  `json:"offered_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RespondedAt  *time.Time `json:"responded_at,omitempty"`
+	RespondedBy  *int       `json:"responded_by,omitempty"`
+	Address      string     `json:"address,omitempty"`
+	ClientName   string     `json:"client_name,omitempty"`
+}
+
+// RejectOfferRequest motivo del rechazo
+type RejectOfferRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// InitOffers lee la configuración y revisa cada 10 segundos las ofertas
+// vencidas para ofrecer el pedido a la siguiente moto
+func InitOffers() {
+	if v, err := strconv.Atoi(os.Getenv("OFFER_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		offerTimeout = time.Duration(v) * time.Second
+	}
+	if v, err := strconv.Atoi(os.Getenv("OFFER_MAX_ATTEMPTS")); err == nil && v > 0 {
+		offerMaxAttempts = v
+	}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			expireOffers()
+		}
+	}()
+}
+
+// offerOrderTx ofrece un pedido pendiente a la moto y le reserva capacidad.
+// Devuelve *capacity.ExceededError si el pedido no cabe en la moto.
+func offerOrderTx(tx *sql.Tx, orderID, motoID int, source string, score *float64) (int, error) {
+	res, err := tx.Exec(`
+		UPDATE orders SET assigned_moto_id = $1, status = 'offered', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = 'pending' AND assigned_moto_id IS NULL
+	`, motoID, orderID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, errOfferConflict
+	}
+
+	// El contador de pedidos de la moto lo actualiza el trigger de orders
+	if err := checkCapacityTx(tx, motoID, orderID, true); err != nil {
+		return 0, err
+	}
+
+	var offerID int
+	err = tx.QueryRow(`
+		INSERT INTO assignment_offers (order_id, moto_id, driver_id, source, attempt, score, expires_at)
+		VALUES ($1, $2, (SELECT driver_id FROM motos WHERE id = $2), $3,
+		        (SELECT COUNT(*) + 1 FROM assignment_offers WHERE order_id = $1), $4,
+		        CURRENT_TIMESTAMP + make_interval(secs => $5))
+		RETURNING id
+	`, orderID, motoID, source, score, offerTimeout.Seconds()).Scan(&offerID)
+	return offerID, err
+}
+
+// releaseOfferTx cierra una oferta abierta y devuelve el pedido a 'pending'
+// liberando la capacidad reservada. Devuelve false si ya no estaba abierta.
+func releaseOfferTx(tx *sql.Tx, offerID int, status string, reason *string, respondedBy *int) (bool, error) {
+	var orderID, motoID int
+	err := tx.QueryRow(`
+		UPDATE assignment_offers
+		SET status = $2, reject_reason = $3, responded_at = CURRENT_TIMESTAMP, responded_by = $4
+		WHERE id = $1 AND status = 'pending'
+		RETURNING order_id, moto_id
+	`, offerID, status, reason, respondedBy).Scan(&orderID, &motoID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE orders SET assigned_moto_id = NULL, status = 'pending', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'offered' AND assigned_moto_id = $2
+	`, orderID, motoID)
+	return true, err
+}
+
+// reofferOrder ofrece el pedido a la siguiente mejor moto de su sucursal,
+// excluyendo las que ya lo rechazaron o dejaron vencer
+func reofferOrder(orderID int) {
+	var branchID sql.NullInt64
+	var lat, lng sql.NullFloat64
+	var attempts int
+	err := db.QueryRow(`
+		SELECT o.branch_id, `+firstStopLatSQL+`, `+firstStopLngSQL+`,
+		       (SELECT COUNT(*) FROM assignment_offers ao WHERE ao.order_id = o.id)
+		FROM orders o
+		WHERE o.id = $1 AND o.status = 'pending' AND o.assigned_moto_id IS NULL AND NOT o.needs_pinning
+		  AND (o.window_start IS NULL OR o.window_start <= CURRENT_TIMESTAMP)
+	`, orderID).Scan(&branchID, &lat, &lng, &attempts)
+	if err != nil || !branchID.Valid || !lat.Valid || !lng.Valid {
+		return
+	}
+	if attempts >= offerMaxAttempts {
+		log.Printf("Ofertas: pedido %d agotó %d ofertas, queda para asignación manual", orderID, attempts)
+		return
+	}
+
+	branch := int(branchID.Int64)
+	lock := branchDispatchLock(branch)
+	lock.Lock()
+	defer lock.Unlock()
+
+	settings, err := loadDispatchSettings(branch)
+	if err != nil {
+		return
+	}
+	orders := []dispatch.Order{{ID: orderID, Latitude: lat.Float64, Longitude: lng.Float64}}
+	if err := loadOfferExclusions(orders); err != nil {
+		return
+	}
+	if err := applyOrderLoads(orders); err != nil {
+		return
+	}
+	motos, _, err := loadDispatchFleet(branch, lat.Float64, lng.Float64)
+	if err != nil {
+		log.Printf("Ofertas: error buscando motos para el pedido %d: %v", orderID, err)
+		return
+	}
+
+	plan := dispatch.Plan(orders, motos, dispatch.Config{
+		Weights:       settings.Weights,
+		MaxDistanceKm: settings.MaxDistanceKm,
+	})
+	if len(plan.Assignments) == 0 {
+		log.Printf("Ofertas: sin moto para reofrecer el pedido %d (%s)", orderID, plan.Unassigned[0].Reason)
+		return
+	}
+	a := plan.Assignments[0]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if _, err := offerOrderTx(tx, orderID, a.MotoID, OfferSourceReassignment, &a.Score); err != nil {
+		log.Printf("Ofertas: no se pudo reofrecer el pedido %d a la moto %d: %v", orderID, a.MotoID, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Ofertas: error reofreciendo el pedido %d: %v", orderID, err)
+	}
+}
+
+// expireOffers vence las ofertas sin respuesta y reofrece los pedidos
+func expireOffers() {
+	rows, err := db.Query(`
+		SELECT id, order_id FROM assignment_offers
+		WHERE status = 'pending' AND expires_at < CURRENT_TIMESTAMP
+		ORDER BY expires_at
+	`)
+	if err != nil {
+		log.Printf("Ofertas: error buscando ofertas vencidas: %v", err)
+		return
+	}
+	type expired struct{ offerID, orderID int }
+	var list []expired
+	for rows.Next() {
+		var e expired
+		if rows.Scan(&e.offerID, &e.orderID) == nil {
+			list = append(list, e)
+		}
+	}
+	rows.Close()
+
+	for _, e := range list {
+		tx, err := db.Begin()
+		if err != nil {
+			return
+		}
+		released, err := releaseOfferTx(tx, e.offerID, OfferExpired, nil, nil)
+		if err != nil || !released {
+			tx.Rollback()
+			continue
+		}
+		if err := tx.Commit(); err != nil {
+			continue
+		}
+		reofferOrder(e.orderID)
+	}
+}
+
+// loadOffer obtiene una oferta por ID
+func loadOffer(q queryRower, offerID int, forUpdate bool) (*AssignmentOffer, error) {
+	query := `
+		SELECT ao.id, ao.order_id, ao.moto_id, ao.driver_id, ao.status, ao.source, ao.attempt,
+		       ao.score, ao.reject_reason, ao.offered_at, ao.expires_at, ao.responded_at, ao.responded_by,
+		       ao.expires_at < CURRENT_TIMESTAMP
+		FROM assignment_offers ao
+		WHERE ao.id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var o AssignmentOffer
+	var expired bool
+	err := q.QueryRow(query, offerID).Scan(&o.ID, &o.OrderID, &o.MotoID, &o.DriverID, &o.Status, &o.Source,
+		&o.Attempt, &o.Score, &o.RejectReason, &o.OfferedAt, &o.ExpiresAt, &o.RespondedAt, &o.RespondedBy, &expired)
+	if err != nil {
+		return nil, err
+	}
+	if expired && o.Status == OfferPending {
+		o.Status = OfferExpired
+	}
+	return &o, nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// checkOfferOwner los motoristas solo pueden responder las ofertas de su moto
+func checkOfferOwner(c *gin.Context, motoID int) (*int, bool) {
+	userID, err := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return nil, false
+	}
+	if c.GetHeader("X-User-Role") == "driver" {
+		var driverID sql.NullInt64
+		db.QueryRow("SELECT driver_id FROM motos WHERE id = $1", motoID).Scan(&driverID)
+		if !driverID.Valid || int(driverID.Int64) != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "La oferta no corresponde a tu moto"})
+			return nil, false
+		}
+	}
+	return &userID, true
+}
+
+// GetOffers GET /offers?status=&moto_id=&driver_id=&order_id=&limit=
+func GetOffers(c *gin.Context) {
+	query := `
+		SELECT ao.id, ao.order_id, ao.moto_id, m.license_plate, ao.driver_id,
+		       CASE WHEN ao.status = 'pending' AND ao.expires_at < CURRENT_TIMESTAMP THEN 'expired' ELSE ao.status END,
+		       ao.source, ao.attempt, ao.score, ao.reject_reason, ao.offered_at, ao.expires_at,
+		       ao.responded_at, ao.responded_by, COALESCE(o.address, ''), COALESCE(o.client_name, '')
+		FROM assignment_offers ao
+		JOIN motos m ON m.id = ao.moto_id
+		JOIN orders o ON o.id = ao.order_id
+		WHERE 1=1`
+	args := []interface{}{}
+	for _, f := range [][2]string{
+		{"status", "ao.status"},
+		{"moto_id", "ao.moto_id"},
+		{"driver_id", "ao.driver_id"},
+		{"order_id", "ao.order_id"},
+	} {
+		if v := c.Query(f[0]); v != "" {
+			args = append(args, v)
+			query += " AND " + f[1] + " = $" + strconv.Itoa(len(args))
+		}
+	}
+	args = append(args, c.DefaultQuery("limit", "100"))
+	query += " ORDER BY ao.offered_at DESC, ao.id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener ofertas"})
+		return
+	}
+	defer rows.Close()
+
+	offers := []AssignmentOffer{}
+	for rows.Next() {
+		var o AssignmentOffer
+		if err := rows.Scan(&o.ID, &o.OrderID, &o.MotoID, &o.MotoPlate, &o.DriverID, &o.Status, &o.Source,
+			&o.Attempt, &o.Score, &o.RejectReason, &o.OfferedAt, &o.ExpiresAt, &o.RespondedAt,
+			&o.RespondedBy, &o.Address, &o.ClientName); err != nil {
+			continue
+		}
+		offers = append(offers, o)
+	}
+	c.JSON(http.StatusOK, offers)
+}
+
+// AcceptOffer PUT /offers/:id/accept (motorista de la moto)
+func AcceptOffer(c *gin.Context) {
+	offerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de oferta inválido"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al aceptar la oferta"})
+		return
+	}
+	defer tx.Rollback()
+
+	offer, err := loadOffer(tx, offerID, true)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Oferta no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al aceptar la oferta"})
+		return
+	}
+	userID, ok := checkOfferOwner(c, offer.MotoID)
+	if !ok {
+		return
+	}
+	if offer.Status != OfferPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "La oferta ya no está abierta", "status": offer.Status})
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE assignment_offers SET status = 'accepted', responded_at = CURRENT_TIMESTAMP, responded_by = $2
+		WHERE id = $1
+	`, offerID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al aceptar la oferta"})
+		return
+	}
+	res, err := tx.Exec(`
+		UPDATE orders SET status = 'assigned', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'offered' AND assigned_moto_id = $2
+	`, offer.OrderID, offer.MotoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al aceptar la oferta"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido ya no está ofrecido a esta moto"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al aceptar la oferta"})
+		return
+	}
+
+	// Notificar la asignación con un ETA en línea recta desde la moto
+	go notifyOfferAccepted(offer.OrderID, offer.MotoID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Oferta aceptada", "order_id": offer.OrderID, "moto_id": offer.MotoID})
+}
+
+// notifyOfferAccepted notifica la asignación con un ETA en línea recta desde la
+// última posición de la moto (geolocation-service); sin posición el ETA es 0
+func notifyOfferAccepted(orderID, motoID int) {
+	var plate string
+	var orderLat, orderLng sql.NullFloat64
+	db.QueryRow(`
+		SELECT m.license_plate, o.latitude, o.longitude
+		FROM motos m, orders o WHERE m.id = $1 AND o.id = $2
+	`, motoID, orderID).Scan(&plate, &orderLat, &orderLng)
+
+	etaMin := 0
+	if orderLat.Valid && orderLng.Valid {
+		if pos, err := positions.Get(motoID); err == nil {
+			km := geo.HaversineMeters(pos.Latitude, pos.Longitude, orderLat.Float64, orderLng.Float64) / 1000
+			etaMin = int(km / dispatchSpeedKmh * 60)
+		}
+	}
+	NotifyMotoAssigned(orderID, "", plate, motoDriverName(motoID), etaMin)
+}
+
+// RejectOffer PUT /offers/:id/reject (motorista de la moto). El pedido se
+// ofrece a la siguiente mejor moto.
+func RejectOffer(c *gin.Context) {
+	offerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de oferta inválido"})
+		return
+	}
+	var req RejectOfferRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El motivo del rechazo es requerido"})
+		return
+	}
+	// VARCHAR(255) cuenta caracteres: recortar por runas para no partir UTF-8
+	reason := strings.TrimSpace(req.Reason)
+	if runes := []rune(reason); len(runes) > 255 {
+		reason = string(runes[:255])
+	}
+
+	offer, err := loadOffer(db, offerID, false)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Oferta no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al rechazar la oferta"})
+		return
+	}
+	userID, ok := checkOfferOwner(c, offer.MotoID)
+	if !ok {
+		return
+	}
+	if offer.Status != OfferPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "La oferta ya no está abierta", "status": offer.Status})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al rechazar la oferta"})
+		return
+	}
+	defer tx.Rollback()
+	released, err := releaseOfferTx(tx, offerID, OfferRejected, &reason, userID)
+	if err != nil || (released && tx.Commit() != nil) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al rechazar la oferta"})
+		return
+	}
+	if !released {
+		c.JSON(http.StatusConflict, gin.H{"error": "La oferta ya no está abierta"})
+		return
+	}
+
+	go reofferOrder(offer.OrderID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Oferta rechazada", "order_id": offer.OrderID})
+}
+
+// DriverOfferKPI tasa de aceptación de ofertas por motorista
+type DriverOfferKPI struct {
+	DriverID           int      `json:"driver_id"`
+	DriverName         string   `json:"driver_name"`
+	OffersReceived     int      `json:"offers_received"`
+	Accepted           int      `json:"accepted"`
+	Rejected           int      `json:"rejected"`
+	Expired            int      `json:"expired"`
+	AcceptanceRate     *float64 `json:"acceptance_rate"` // Aceptadas / respondidas o vencidas
+	AvgResponseSeconds *float64 `json:"avg_response_seconds"`
+}
+
+// GetDriverOfferKPIs GET /kpis/drivers?from=YYYY-MM-DD&to=YYYY-MM-DD&branch_id=
+// (por defecto los últimos 30 días)
+func GetDriverOfferKPIs(c *gin.Context) {
+	to := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	from := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	if v := c.Query("from"); v != "" {
+		from = v
+	}
+	if v := c.Query("to"); v != "" {
+		to = v
+	}
+	if _, err := time.Parse("2006-01-02", from); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from debe tener formato YYYY-MM-DD"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to debe tener formato YYYY-MM-DD"})
+		return
+	}
+
+	query := `
+		SELECT u.id, u.name,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE ao.status = 'accepted'),
+		       COUNT(*) FILTER (WHERE ao.status = 'rejected'),
+		       COUNT(*) FILTER (WHERE ao.status = 'expired'),
+		       AVG(EXTRACT(EPOCH FROM (ao.responded_at - ao.offered_at)))
+		           FILTER (WHERE ao.status IN ('accepted', 'rejected'))
+		FROM assignment_offers ao
+		JOIN users u ON u.id = ao.driver_id
+		WHERE ao.offered_at >= $1 AND ao.offered_at < $2`
+	args := []interface{}{from, to}
+	if v := c.Query("branch_id"); v != "" {
+		args = append(args, v)
+		query += " AND ao.moto_id IN (SELECT id FROM motos WHERE branch_id = $3)"
+	}
+	query += " GROUP BY u.id, u.name ORDER BY u.name"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener KPIs de motoristas"})
+		return
+	}
+	defer rows.Close()
+
+	kpis := []DriverOfferKPI{}
+	for rows.Next() {
+		var k DriverOfferKPI
+		if err := rows.Scan(&k.DriverID, &k.DriverName, &k.OffersReceived, &k.Accepted, &k.Rejected,
+			&k.Expired, &k.AvgResponseSeconds); err != nil {
+			continue
+		}
+		if closed := k.Accepted + k.Rejected + k.Expired; closed > 0 {
+			rate := float64(k.Accepted) / float64(closed)
+			k.AcceptanceRate = &rate
+		}
+		kpis = append(kpis, k)
+	}
+	c.JSON(http.StatusOK, kpis)
+}
@@ -4,19 +4,29 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/capacity"
+	"github.com/logitrack/order-service/positions"
+	"github.com/logitrack/order-service/routing"
 )
 
 type simpleMoto struct {
-	ID                 int      `json:"id"`
-	LicensePlate       string   `json:"license_plate"`
-	Latitude           *float64 `json:"latitude,omitempty"`
-	Longitude          *float64 `json:"longitude,omitempty"`
-	MaxOrdersCapacity  int      `json:"max_orders_capacity"`
-	CurrentOrdersCount int      `json:"current_orders_count"`
+	ID                 int        `json:"id"`
+	LicensePlate       string     `json:"license_plate"`
+	Latitude           *float64   `json:"latitude,omitempty"`
+	Longitude          *float64   `json:"longitude,omitempty"`
+	PositionUpdatedAt  *time.Time `json:"position_updated_at,omitempty"`
+	MaxOrdersCapacity  int        `json:"max_orders_capacity"`
+	CurrentOrdersCount int        `json:"current_orders_count"`
+	// Límites y carga actual de peso/volumen (límite 0 = sin límite)
+	MaxWeightKg     float64 `json:"max_weight_kg"`
+	MaxVolumeL      float64 `json:"max_volume_l"`
+	CurrentWeightKg float64 `json:"current_weight_kg"`
+	CurrentVolumeL  float64 `json:"current_volume_l"`
 }
 
 type simpleOrder struct {
@@ -24,6 +34,8 @@ type simpleOrder struct {
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
 	Address   string  `json:"address"`
+	WeightKg  float64 `json:"weight_kg"`
+	VolumeL   float64 `json:"volume_l"`
 }
 
 type optimizeRequest struct {
@@ -64,10 +76,11 @@ func OptimizeAssignments(c *gin.Context) {
 	branchID := c.Query("branch_id")
 
 	// Collect pending orders
-	orderQuery := "SELECT id, latitude, longitude, address FROM orders WHERE status = 'pending'"
+	orderQuery := "SELECT id, latitude, longitude, address FROM orders WHERE status = 'pending' AND NOT needs_pinning " +
+		"AND (window_start IS NULL OR window_start <= CURRENT_TIMESTAMP)"
 	orderArgs := []interface{}{}
 	if branchID != "" {
-		orderQuery += " AND branch = $1"
+		orderQuery += " AND branch_id = (SELECT id FROM branches WHERE code = $1)"
 		orderArgs = append(orderArgs, branchID)
 	}
 
@@ -91,8 +104,25 @@ func OptimizeAssignments(c *gin.Context) {
 		orders = append(orders, o)
 	}
 
+	// Peso y volumen de cada pedido según sus bultos
+	if len(orders) > 0 {
+		ids := make([]int64, len(orders))
+		for i, o := range orders {
+			ids[i] = int64(o.ID)
+		}
+		loads, err := loadOrderLoads(db, ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range orders {
+			l := loads[orders[i].ID]
+			orders[i].WeightKg, orders[i].VolumeL = l.WeightKg, l.VolumeL
+		}
+	}
+
 	// Collect available motos with capacity
-	motoQuery := `SELECT id, license_plate, latitude, longitude, max_orders_capacity, current_orders_count 
+	motoQuery := `SELECT id, license_plate, max_orders_capacity, current_orders_count 
 	              FROM motos 
 	              WHERE status = 'available' 
 	              AND current_orders_count < max_orders_capacity`
@@ -111,19 +141,49 @@ func OptimizeAssignments(c *gin.Context) {
 	defer mrows.Close()
 
 	motos := []simpleMoto{}
+	motoIDs := []int{}
 	for mrows.Next() {
 		var m simpleMoto
-		var lat, lng sql.NullFloat64
-		if err := mrows.Scan(&m.ID, &m.LicensePlate, &lat, &lng, &m.MaxOrdersCapacity, &m.CurrentOrdersCount); err != nil {
+		if err := mrows.Scan(&m.ID, &m.LicensePlate, &m.MaxOrdersCapacity, &m.CurrentOrdersCount); err != nil {
 			continue
 		}
-		if lat.Valid {
-			m.Latitude = &lat.Float64
+		motos = append(motos, m)
+		motoIDs = append(motoIDs, m.ID)
+	}
+
+	// Límites y carga de peso/volumen de cada moto
+	if len(motoIDs) > 0 {
+		ids := make([]int64, len(motoIDs))
+		for i, id := range motoIDs {
+			ids[i] = int64(id)
+		}
+		caps, err := loadMotoCapacities(db, ids, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-		if lng.Valid {
-			m.Longitude = &lng.Float64
+		for i := range motos {
+			mc := caps[motos[i].ID]
+			motos[i].MaxWeightKg, motos[i].MaxVolumeL = mc.Limits.MaxWeightKg, mc.Limits.MaxVolumeL
+			motos[i].CurrentWeightKg, motos[i].CurrentVolumeL = mc.Load.WeightKg, mc.Load.VolumeL
+		}
+	}
+
+	// Posiciones actuales desde geolocation-service (sin posición el ai-service usa el depósito)
+	if len(motoIDs) > 0 {
+		latest, err := positions.Latest(motoIDs)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to get moto positions: " + err.Error()})
+			return
+		}
+		for i := range motos {
+			if p, ok := latest[motos[i].ID]; ok {
+				lat, lng := p.Latitude, p.Longitude
+				motos[i].Latitude = &lat
+				motos[i].Longitude = &lng
+				motos[i].PositionUpdatedAt = p.UpdatedAt
+			}
 		}
-		motos = append(motos, m)
 	}
 
 	if len(orders) == 0 {
@@ -198,24 +258,26 @@ func ApplyOptimizedAssignments(c *gin.Context) {
 		return
 	}
 
-	routeRepo := models.NewRouteRepository(db)
-
 	// Group assignments by moto for route creation
 	byMoto := make(map[int][]int)
 	appliedCount := 0
+	skipped := []skippedAssignment{}
 
 	for _, a := range req.Assignments {
-		// Assign order to moto and update status
-		_, err := db.Exec(`UPDATE orders SET assigned_moto_id = $1, status = 'assigned', updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
-			a.MotoID, a.OrderID)
+		// Offer order to the moto's driver (reserves the moto's capacity)
+		tx, err := db.Begin()
 		if err != nil {
-			continue // Log but don't fail entire operation
+			skipped = append(skipped, newSkippedAssignment(a, err))
+			continue
 		}
-
-		// Increment moto's current order count
-		_, err = db.Exec(`UPDATE motos SET current_orders_count = current_orders_count + 1 WHERE id = $1`, a.MotoID)
-		if err != nil {
-			// Log error but continue
+		if _, err := offerOrderTx(tx, a.OrderID, a.MotoID, OfferSourceOptimization, nil); err != nil {
+			tx.Rollback()
+			skipped = append(skipped, newSkippedAssignment(a, err))
+			continue // No falla toda la operación, se informa en skipped
+		}
+		if err := tx.Commit(); err != nil {
+			skipped = append(skipped, newSkippedAssignment(a, err))
+			continue
 		}
 
 		byMoto[a.MotoID] = append(byMoto[a.MotoID], a.OrderID)
@@ -226,7 +288,7 @@ func ApplyOptimizedAssignments(c *gin.Context) {
 	routesCreated := 0
 	for motoID, orderSeq := range byMoto {
 		// Get depot coordinates
-		var depotLat, depotLng float64 = 14.6349, -90.5069
+		var depotLat, depotLng float64 = defaultDepotLat, defaultDepotLng
 
 		// Try to get moto's branch depot
 		err := db.QueryRow(`
@@ -238,26 +300,35 @@ func ApplyOptimizedAssignments(c *gin.Context) {
 			// Use default if no branch found
 		}
 
-		// Build path: depot + order coordinates
-		var path []float64
-		path = append(path, depotLat, depotLng)
-		for _, oid := range orderSeq {
-			var lat, lng float64
-			err := db.QueryRow("SELECT latitude, longitude FROM orders WHERE id = $1", oid).Scan(&lat, &lng)
-			if err == nil {
-				path = append(path, lat, lng)
-			}
-		}
-
-		if err := routeRepo.CreateRoute(motoID, orderSeq, path); err == nil {
+		// Sequence stops from the depot (pickups before their dropoffs)
+		depot := routing.Point{Latitude: depotLat, Longitude: depotLng}
+		if _, err := saveSequencedRoute(motoID, depot, orderSeq); err == nil {
 			routesCreated++
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":         "Assignments applied successfully",
+		"message":         "Assignments offered to drivers",
 		"orders_assigned": appliedCount,
 		"routes_created":  routesCreated,
 		"motos_involved":  len(byMoto),
+		"skipped":         skipped,
 	})
 }
+
+// skippedAssignment asignación sugerida que no se pudo aplicar
+type skippedAssignment struct {
+	OrderID   int      `json:"order_id"`
+	MotoID    int      `json:"moto_id"`
+	Reason    string   `json:"reason"`
+	BlockedBy []string `json:"blocked_by,omitempty"`
+}
+
+func newSkippedAssignment(a Assignment, err error) skippedAssignment {
+	s := skippedAssignment{OrderID: a.OrderID, MotoID: a.MotoID, Reason: err.Error()}
+	var exceeded *capacity.ExceededError
+	if errors.As(err, &exceeded) {
+		s.BlockedBy = exceeded.Dimensions()
+	}
+	return s
+}
@@ -4,16 +4,44 @@ import (
 	"database/sql"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/pricing"
+	"github.com/logitrack/order-service/sqlbuilder"
 	"github.com/logitrack/order-service/validation"
 )
 
 var db *sql.DB
 
+// orderColumns columnas de models.Order en el orden que lee scanOrder
+const orderColumns = `id, client_name, client_email, address, latitude, longitude, status, assigned_moto_id,
+	branch_id, branch, delivery_attempts_count, max_delivery_attempts, window_start, window_end,
+	order_type, pickup_address, pickup_latitude, pickup_longitude, pickup_contact, picked_up_at, created_at, updated_at,
+	customer_id, address_id, needs_pinning, geocode_confidence, geocode_provider,
+	express, pricing_contract_id, delivery_fee_cents, fee_currency, fee_breakdown, cod_amount_cents,
+	(SELECT weight_kg FROM order_loads l WHERE l.order_id = orders.id),
+	(SELECT volume_l FROM order_loads l WHERE l.order_id = orders.id)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrder(row rowScanner, o *models.Order) error {
+	return row.Scan(&o.ID, &o.ClientName, &o.ClientEmail, &o.Address, &o.Latitude, &o.Longitude, &o.Status,
+		&o.AssignedMotoID, &o.BranchID, &o.Branch, &o.DeliveryAttempts, &o.MaxDeliveryAttempts, &o.WindowStart,
+		&o.WindowEnd, &o.OrderType, &o.PickupAddress, &o.PickupLatitude, &o.PickupLongitude, &o.PickupContact,
+		&o.PickedUpAt, &o.CreatedAt, &o.UpdatedAt, &o.CustomerID, &o.AddressID, &o.NeedsPinning,
+		&o.GeocodeConfidence, &o.GeocodeProvider, &o.Express, &o.PricingContractID, &o.DeliveryFeeCents,
+		&o.FeeCurrency, &o.FeeBreakdown, &o.CODAmountCents, &o.WeightKg, &o.VolumeL)
+}
+
 func SetDB(database *sql.DB) {
 	db = database
+	validation.SetBranchCheckers(isActiveBranchCode, isActiveBranchID)
 }
 
 func CreateOrder(c *gin.Context) {
@@ -23,20 +51,112 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// Datos del cliente y dirección de la libreta (customer_id / address_id)
+	bookPoint, ok := resolveOrderCustomer(c, &req)
+	if !ok {
+		return
+	}
+
 	// Validar con go-playground/validator
 	if err := validation.Validate(req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Coordenadas: de la libreta, las enviadas o geocodificadas
+	var point geoPoint
+	if bookPoint != nil {
+		point = *bookPoint
+	} else if point, ok = locateAddress(c, req.Address, req.Latitude, req.Longitude); !ok {
+		return
+	}
+
+	// Asignar sucursal automáticamente según la zona de entrega
+	branch, zone, ok := resolveDeliveryBranch(c, point, req.BranchID, req.Branch)
+	if !ok {
+		return
+	}
+	if err := validateWindow(req.WindowStart, req.WindowEnd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orderType := req.OrderType
+	if orderType == "" {
+		orderType = models.OrderTypeDelivery
+	}
+	if orderType == models.OrderTypePickupDelivery && (req.PickupLatitude == nil || req.PickupLongitude == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pickup_latitude y pickup_longitude son requeridos para pedidos pickup_delivery"})
+		return
+	}
+	if orderType != models.OrderTypePickupDelivery {
+		req.PickupAddress, req.PickupLatitude, req.PickupLongitude = nil, nil, nil
+	}
+
+	maxAttempts := defaultMaxDeliveryAttempts
+	if req.MaxDeliveryAttempts != nil {
+		maxAttempts = *req.MaxDeliveryAttempts
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+	defer tx.Rollback()
+
+	if req.CustomerID == nil {
+		if req.CustomerID, err = linkOrderCustomerTx(tx, req.ClientName, req.ClientEmail); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link customer"})
+			return
+		}
+	}
+
+	// Tarifa con el contrato del cliente (o la general); sin contratos queda sin tarifa
+	contract, ok := pricingContractFor(c, req.ContractID, req.CustomerID)
+	if !ok {
+		return
+	}
+	var contractID *int
+	var fee *pricing.Quote
+	var feeCents *int64
+	var feeCurrency *string
+	if contract != nil {
+		quote, _, err := quoteDelivery(contract, branch, zone, point, parcelRequestsWeight(req.Parcels), req.WindowStart, req.Express)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate delivery fee"})
+			return
+		}
+		contractID, fee = &contract.ID, &quote
+		feeCents, feeCurrency = &quote.TotalCents, &quote.Currency
+	}
+
 	var orderID int
-	err := db.QueryRow(`
-		INSERT INTO orders (client_name, client_email, address, latitude, longitude, status, branch) 
-		VALUES ($1, $2, $3, $4, $5, 'pending', $6) 
-		RETURNING id
-	`, req.ClientName, req.ClientEmail, req.Address, req.Latitude, req.Longitude, req.Branch).Scan(&orderID)
+	var createdAt, updatedAt time.Time
+	err = tx.QueryRow(`
+		INSERT INTO orders (client_name, client_email, address, latitude, longitude, status, branch, branch_id, max_delivery_attempts,
+		                    order_type, pickup_address, pickup_latitude, pickup_longitude, pickup_contact, customer_id, address_id,
+		                    needs_pinning, geocode_confidence, geocode_provider, window_start, window_end, express,
+		                    pricing_contract_id, fee_breakdown, delivery_fee_cents, fee_currency, cod_amount_cents) 
+		VALUES ($1, $2, $3, $4, $5, 'pending', $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), $14, $15, $16, $17, $18,
+		        $19, $20, $21, $22, $23, $24, $25, $26) 
+		RETURNING id, created_at, updated_at
+	`, req.ClientName, req.ClientEmail, req.Address, point.Latitude, point.Longitude, branch.Code, branch.ID, maxAttempts,
+		orderType, req.PickupAddress, req.PickupLatitude, req.PickupLongitude, req.PickupContact, req.CustomerID, req.AddressID,
+		point.NeedsPinning, point.Confidence, point.Provider, req.WindowStart, req.WindowEnd, req.Express,
+		contractID, fee, feeCents, feeCurrency, req.CODAmountCents).Scan(&orderID, &createdAt, &updatedAt)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
 
+	parcels, err := createParcelsTx(tx, orderID, req.Parcels)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order parcels"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 		return
 	}
@@ -47,56 +167,226 @@ func CreateOrder(c *gin.Context) {
 		ClientName:  req.ClientName,
 		ClientEmail: req.ClientEmail,
 		Address:     req.Address,
-		Latitude:    req.Latitude,
-		Longitude:   req.Longitude,
+		Latitude:    point.Latitude,
+		Longitude:   point.Longitude,
 		Status:      "pending",
-		Branch:      req.Branch,
+		BranchID:    &branch.ID,
+		Branch:      branch.Code,
+		CreatedAt:   createdAt,
+		UpdatedAt:   updatedAt,
+		CustomerID:  req.CustomerID,
+		AddressID:   req.AddressID,
+
+		NeedsPinning:      point.NeedsPinning,
+		GeocodeConfidence: point.Confidence,
+		GeocodeProvider:   point.Provider,
+
+		Express:           req.Express,
+		PricingContractID: contractID,
+		DeliveryFeeCents:  feeCents,
+		FeeCurrency:       feeCurrency,
+		FeeBreakdown:      fee,
+		CODAmountCents:    req.CODAmountCents,
+
+		MaxDeliveryAttempts: maxAttempts,
+		WindowStart:         req.WindowStart,
+		WindowEnd:           req.WindowEnd,
+
+		OrderType:       orderType,
+		PickupAddress:   req.PickupAddress,
+		PickupLatitude:  req.PickupLatitude,
+		PickupLongitude: req.PickupLongitude,
+
+		Parcels: parcels,
 	}
+	order.WeightKg, order.VolumeL = parcelsLoad(parcels)
+
+	// Despacho automático (si la sucursal lo tiene activo)
+	ScheduleDispatch(branch.ID)
 
 	c.JSON(http.StatusCreated, order)
 }
 
+// orderSort columna por la que se puede ordenar GET /orders
+type orderSort struct {
+	expr  string // expresión SQL
+	cast  string // tipo SQL del valor en el cursor
+	value func(o models.Order) string
+}
+
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
+
+var orderSorts = map[string]orderSort{
+	"id":          {"id", "integer", func(o models.Order) string { return strconv.Itoa(o.ID) }},
+	"created_at":  {"created_at", "timestamp", func(o models.Order) string { return o.CreatedAt.Format(cursorTimeLayout) }},
+	"updated_at":  {"updated_at", "timestamp", func(o models.Order) string { return o.UpdatedAt.Format(cursorTimeLayout) }},
+	"client_name": {"COALESCE(client_name, '')", "text", func(o models.Order) string { return o.ClientName }},
+	"status":      {"status", "text", func(o models.Order) string { return o.Status }},
+}
+
+const (
+	defaultOrdersPageSize = 50
+	maxOrdersPageSize     = 500
+)
+
+// GetOrders GET /orders
+// Filtros: status (lista separada por comas), branch / branch_id,
+// assigned_moto_id, customer_id, created_from / created_to (fecha o RFC3339), client
+// (nombre o email), address, needs_pinning (true|false). Orden: sort=created_at|-created_at|updated_at|
+// id|client_name|status (por defecto -created_at). Paginación con limit y
+// cursor (X-Next-Cursor); sin limit ni cursor devuelve todos. X-Total-Count
+// es el total de pedidos que cumplen los filtros.
 func GetOrders(c *gin.Context) {
-	branch := c.Query("branch")
-	assignedMotoIDStr := c.Query("assigned_moto_id")
-	var rows *sql.Rows
-	var err error
-
-	if assignedMotoIDStr != "" {
-		motoID, convErr := strconv.Atoi(assignedMotoIDStr)
-		if convErr != nil {
+	q := sqlbuilder.New(orderColumns, "orders")
+	if v := c.Query("customer_id"); v != "" {
+		customerID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer_id"})
+			return
+		}
+		q.Where("customer_id = ?", customerID)
+	}
+	listOrders(c, q)
+}
+
+// listOrders aplica los filtros, el orden y la paginación de GET /orders
+// sobre q y responde con la página
+func listOrders(c *gin.Context, q *sqlbuilder.Select) {
+	if v := c.Query("status"); v != "" {
+		q.Where("status = ANY(?)", pq.Array(strings.Split(v, ",")))
+	}
+	if v := c.Query("needs_pinning"); v != "" {
+		pinning, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid needs_pinning"})
+			return
+		}
+		q.Where("needs_pinning = ?", pinning)
+	}
+	if v := c.Query("assigned_moto_id"); v != "" {
+		motoID, err := strconv.Atoi(v)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assigned_moto_id"})
 			return
 		}
-		if branch != "" {
-			rows, err = db.Query("SELECT id, client_name, client_email, address, latitude, longitude, status, assigned_moto_id, branch FROM orders WHERE branch = $1 AND assigned_moto_id = $2", branch, motoID)
+		q.Where("assigned_moto_id = ?", motoID)
+	}
+	if v := c.Query("branch_id"); v != "" {
+		branchID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch_id"})
+			return
+		}
+		q.Where("branch_id = ?", branchID)
+	} else if v := c.Query("branch"); v != "" {
+		q.Where("branch_id = (SELECT id FROM branches WHERE code = ?)", v)
+	}
+	if v := c.Query("created_from"); v != "" {
+		from, _, err := parseDateParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid created_from"})
+			return
+		}
+		q.Where("created_at >= ?", from)
+	}
+	if v := c.Query("created_to"); v != "" {
+		to, dateOnly, err := parseDateParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid created_to"})
+			return
+		}
+		if dateOnly {
+			// Fecha sin hora: incluye todo el día
+			q.Where("created_at < ?", to.AddDate(0, 0, 1))
 		} else {
-			rows, err = db.Query("SELECT id, client_name, client_email, address, latitude, longitude, status, assigned_moto_id, branch FROM orders WHERE assigned_moto_id = $1", motoID)
+			q.Where("created_at <= ?", to)
 		}
-	} else if branch != "" {
-		rows, err = db.Query("SELECT id, client_name, client_email, address, latitude, longitude, status, assigned_moto_id, branch FROM orders WHERE branch = $1", branch)
-	} else {
-		rows, err = db.Query("SELECT id, client_name, client_email, address, latitude, longitude, status, assigned_moto_id, branch FROM orders")
+	}
+	if v := strings.TrimSpace(c.Query("client")); v != "" {
+		pattern := sqlbuilder.Contains(v)
+		q.Where("(client_name ILIKE ? OR client_email ILIKE ?)", pattern, pattern)
+	}
+	if v := strings.TrimSpace(c.Query("address")); v != "" {
+		q.Where("address ILIKE ?", sqlbuilder.Contains(v))
 	}
 
+	// Total con los filtros (antes del cursor)
+	var total int
+	countSQL, countArgs := q.CountSQL()
+	if err := db.QueryRow(countSQL, countArgs...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	sortParam := c.DefaultQuery("sort", "-created_at")
+	desc := strings.HasPrefix(sortParam, "-")
+	sort, ok := orderSorts[strings.TrimPrefix(sortParam, "-")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort: use id, created_at, updated_at, client_name or status (prefix - for descending)"})
+		return
+	}
+
+	var after *sqlbuilder.Cursor
+	if v := c.Query("cursor"); v != "" {
+		cur, err := sqlbuilder.DecodeCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		after = &cur
+	}
+	q.Seek(sort.expr, "id", sort.cast, desc, after)
+
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, maxOrdersPageSize)
+	} else if after != nil {
+		limit = defaultOrdersPageSize
+	}
+	if limit > 0 {
+		q.Limit(limit + 1) // una fila extra para saber si hay otra página
+	}
+
+	query, args := q.SQL()
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	defer rows.Close()
 
-	var orders []models.Order
+	orders := []models.Order{}
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.ID, &order.ClientName, &order.ClientEmail, &order.Address, &order.Latitude, &order.Longitude, &order.Status, &order.AssignedMotoID, &order.Branch)
-		if err != nil {
+		if err := scanOrder(rows, &order); err != nil {
 			continue
 		}
 		orders = append(orders, order)
 	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if limit > 0 && len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		c.Header("X-Next-Cursor", sqlbuilder.Cursor{Value: sort.value(last), ID: last.ID}.Encode())
+	}
 	c.JSON(http.StatusOK, orders)
 }
 
+// parseDateParam acepta YYYY-MM-DD o RFC3339; dateOnly indica el primer formato
+func parseDateParam(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
 func GetOrderByID(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -104,8 +394,7 @@ func GetOrderByID(c *gin.Context) {
 		return
 	}
 	var order models.Order
-	err = db.QueryRow("SELECT id, client_name, client_email, address, latitude, longitude, status, assigned_moto_id, branch FROM orders WHERE id = $1", id).
-		Scan(&order.ID, &order.ClientName, &order.ClientEmail, &order.Address, &order.Latitude, &order.Longitude, &order.Status, &order.AssignedMotoID, &order.Branch)
+	err = scanOrder(db.QueryRow("SELECT "+orderColumns+" FROM orders WHERE id = $1", id), &order)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
@@ -114,6 +403,7 @@ func GetOrderByID(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order"})
 		return
 	}
+	order.Parcels, _ = loadOrderParcels(db, order.ID)
 	c.JSON(http.StatusOK, order)
 }
 
@@ -136,7 +426,9 @@ func UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE orders SET status = $1 WHERE id = $2", req.Status, id)
+	_, err = db.Exec(`UPDATE orders SET status = $1,
+		delivered_at = CASE WHEN $1 = 'delivered' THEN COALESCE(delivered_at, CURRENT_TIMESTAMP) ELSE delivered_at END
+		WHERE id = $2`, req.Status, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
 		return
@@ -176,17 +468,77 @@ func AssignOrderToMoto(c *gin.Context) {
 		return
 	}
 
-	// Asignar moto al pedido
-	res, err := db.Exec("UPDATE orders SET assigned_moto_id = $1, status = CASE WHEN status = 'pending' THEN 'assigned' ELSE status END WHERE id = $2", req.MotoID, id)
+	var status string
+	err = db.QueryRow("SELECT status FROM orders WHERE id = $1", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
 		return
 	}
-	affected, _ := res.RowsAffected()
-	if affected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Pedidos ya aceptados o en ruta: cambio directo de moto si la carga cabe.
+	// Entregados, cancelados o fallidos no se reasignan
+	if status != "pending" && status != "offered" {
+		var currentMoto sql.NullInt64
+		if err := tx.QueryRow("SELECT status, assigned_moto_id FROM orders WHERE id = $1 FOR UPDATE", id).Scan(&status, &currentMoto); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
+			return
+		}
+		if status != "assigned" && status != "in_route" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Order cannot be reassigned in status " + status})
+			return
+		}
+		if !currentMoto.Valid || int(currentMoto.Int64) != req.MotoID {
+			if err := checkCapacityTx(tx, req.MotoID, id, false); err != nil {
+				if !respondCapacityError(c, err) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
+				}
+				return
+			}
+		}
+		if _, err := tx.Exec("UPDATE orders SET assigned_moto_id = $1 WHERE id = $2", req.MotoID, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Order assigned"})
+		return
+	}
+
+	// Pedidos sin aceptar: se ofrecen al motorista (cancelando la oferta abierta)
+	var openOffer int
+	if err := tx.QueryRow("SELECT id FROM assignment_offers WHERE order_id = $1 AND status = 'pending'", id).Scan(&openOffer); err == nil {
+		if _, err := releaseOfferTx(tx, openOffer, OfferCancelled, nil, nil); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
+			return
+		}
+	}
+
+	offerID, err := offerOrderTx(tx, id, req.MotoID, OfferSourceManual, nil)
+	if err == errOfferConflict || err == errMotoUnavailable {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if respondCapacityError(c, err) {
+		return
+	}
+	if err != nil || tx.Commit() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign order"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Order assigned"})
+	c.JSON(http.StatusOK, gin.H{"message": "Order offered", "offer_id": offerID, "status": "offered"})
 }
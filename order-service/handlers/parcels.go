@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/barcode"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/validation"
+)
+
+// ========================================
+// BULTOS Y CADENA DE CUSTODIA
+// ========================================
+// Cada pedido tiene uno o más bultos con código de barras (también usado como
+// contenido del QR). Los escaneos (POST /scans) registran quién tuvo el bulto,
+// dónde y cuándo: recibido en sucursal, cargado en moto, entregado o devuelto.
+
+const parcelColumns = `id, order_id, barcode, weight_kg, length_cm, width_cm, height_cm, description, status,
+	created_at, updated_at`
+
+func scanParcel(row rowScanner, p *models.Parcel) error {
+	return row.Scan(&p.ID, &p.OrderID, &p.Barcode, &p.WeightKg, &p.LengthCm, &p.WidthCm, &p.HeightCm,
+		&p.Description, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// rowsQueryer consultas dentro o fuera de una transacción
+type rowsQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// createParcelsTx crea los bultos del pedido con su código; sin bultos en la
+// solicitud se crea uno sin medidas
+func createParcelsTx(tx *sql.Tx, orderID int, reqs []validation.ParcelRequest) ([]models.Parcel, error) {
+	if len(reqs) == 0 {
+		reqs = []validation.ParcelRequest{{}}
+	}
+	parcels := make([]models.Parcel, 0, len(reqs))
+	for _, r := range reqs {
+		// El código depende del ID, por eso se reserva antes de insertar
+		var id int
+		if err := tx.QueryRow("SELECT nextval(pg_get_serial_sequence('parcels', 'id'))").Scan(&id); err != nil {
+			return nil, err
+		}
+		var p models.Parcel
+		err := scanParcel(tx.QueryRow(`
+			INSERT INTO parcels (id, order_id, barcode, weight_kg, length_cm, width_cm, height_cm, description)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+			RETURNING `+parcelColumns,
+			id, orderID, barcode.ForParcel(id), r.WeightKg, r.LengthCm, r.WidthCm, r.HeightCm, r.Description,
+		), &p)
+		if err != nil {
+			return nil, err
+		}
+		parcels = append(parcels, p)
+	}
+	return parcels, nil
+}
+
+// loadOrderParcels bultos del pedido
+func loadOrderParcels(q rowsQueryer, orderID int) ([]models.Parcel, error) {
+	rows, err := q.Query("SELECT "+parcelColumns+" FROM parcels WHERE order_id = $1 ORDER BY id", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parcels := []models.Parcel{}
+	for rows.Next() {
+		var p models.Parcel
+		if err := scanParcel(rows, &p); err != nil {
+			continue
+		}
+		parcels = append(parcels, p)
+	}
+	return parcels, rows.Err()
+}
+
+// GetOrderParcels GET /orders/:id/parcels
+func GetOrderParcels(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
+		return
+	}
+	parcels, err := loadOrderParcels(db, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener bultos"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "parcels": parcels, "total": len(parcels)})
+}
+
+// AddOrderParcel POST /orders/:id/parcels agrega un bulto a un pedido abierto
+func AddOrderParcel(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
+		return
+	}
+	var req validation.ParcelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Orden no encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la orden"})
+		return
+	}
+	if status == "delivered" || status == "cancelled" || status == "failed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido ya está cerrado", "status": status})
+		return
+	}
+
+	parcels, err := createParcelsTx(tx, orderID, []validation.ParcelRequest{req})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear el bulto"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear el bulto"})
+		return
+	}
+	c.JSON(http.StatusCreated, parcels[0])
+}
+
+// GetParcel GET /parcels/:barcode con su historial de escaneos
+func GetParcel(c *gin.Context) {
+	code := barcode.Normalize(c.Param("barcode"))
+	var p models.Parcel
+	err := scanParcel(db.QueryRow("SELECT "+parcelColumns+" FROM parcels WHERE barcode = $1", code), &p)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bulto no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el bulto"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, parcel_id, order_id, event, previous_status, actor_id, actor_role, branch_id, moto_id,
+		       latitude, longitude, notes, scanned_at
+		FROM parcel_scans
+		WHERE parcel_id = $1
+		ORDER BY scanned_at ASC, id ASC
+	`, p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener escaneos"})
+		return
+	}
+	defer rows.Close()
+
+	scans := []models.ParcelScan{}
+	for rows.Next() {
+		var s models.ParcelScan
+		if err := rows.Scan(&s.ID, &s.ParcelID, &s.OrderID, &s.Event, &s.PreviousStatus, &s.ActorID, &s.ActorRole,
+			&s.BranchID, &s.MotoID, &s.Latitude, &s.Longitude, &s.Notes, &s.ScannedAt); err != nil {
+			continue
+		}
+		scans = append(scans, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"parcel": p, "scans": scans})
+}
+
+// CreateScan POST /scans registra un evento de custodia del bulto
+func CreateScan(c *gin.Context) {
+	var req validation.ScanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	code := barcode.Normalize(req.Barcode)
+	if !barcode.Valid(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Código de bulto inválido", "barcode": code})
+		return
+	}
+	actorID, err := strconv.Atoi(c.GetHeader("X-User-ID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+	actorRole := c.GetHeader("X-User-Role")
+
+	// Los motoristas escanean siempre con su propia moto
+	if actorRole == "driver" {
+		var driverMoto int
+		err := db.QueryRow("SELECT id FROM motos WHERE driver_id = $1 ORDER BY id LIMIT 1", actorID).Scan(&driverMoto)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tienes una moto asignada"})
+			return
+		}
+		if req.MotoID != nil && *req.MotoID != driverMoto {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Solo puedes escanear con tu moto"})
+			return
+		}
+		req.MotoID = &driverMoto
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error de base de datos"})
+		return
+	}
+	defer tx.Rollback()
+
+	var parcelID, orderID int
+	var status string
+	var assignedMoto, orderBranch sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT p.id, p.order_id, p.status, o.assigned_moto_id, o.branch_id
+		FROM parcels p
+		JOIN orders o ON o.id = p.order_id
+		WHERE p.barcode = $1
+		FOR UPDATE OF p
+	`, code).Scan(&parcelID, &orderID, &status, &assignedMoto, &orderBranch)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bulto no encontrado", "barcode": code})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener el bulto"})
+		return
+	}
+
+	// Lectura repetida del mismo evento: no se registra de nuevo
+	if status == req.Event {
+		c.JSON(http.StatusOK, gin.H{"message": "Escaneo ya registrado", "duplicate": true,
+			"parcel_id": parcelID, "order_id": orderID, "status": status})
+		return
+	}
+	allowed := models.ParcelTransitions[req.Event]
+	valid := false
+	for _, s := range allowed {
+		if s == status {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "El bulto no admite este escaneo en su estado actual",
+			"status":   status,
+			"expected": allowed,
+		})
+		return
+	}
+
+	// Moto y sucursal por defecto según el pedido
+	if req.Event != models.ParcelReceivedAtBranch && req.MotoID == nil && assignedMoto.Valid {
+		m := int(assignedMoto.Int64)
+		req.MotoID = &m
+	}
+	if req.Event == models.ParcelLoadedOnMoto {
+		if req.MotoID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "moto_id es requerido para cargar el bulto"})
+			return
+		}
+		if assignedMoto.Valid && int(assignedMoto.Int64) != *req.MotoID {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":            "El pedido está asignado a otra moto",
+				"assigned_moto_id": assignedMoto.Int64,
+			})
+			return
+		}
+	}
+	if req.BranchID == nil && orderBranch.Valid && req.Event != models.ParcelDelivered {
+		b := int(orderBranch.Int64)
+		req.BranchID = &b
+	}
+	scannedAt := time.Now()
+	if req.ScannedAt != nil {
+		if req.ScannedAt.After(scannedAt.Add(5 * time.Minute)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "scanned_at no puede estar en el futuro"})
+			return
+		}
+		scannedAt = *req.ScannedAt
+	}
+
+	scan := models.ParcelScan{
+		ParcelID:       parcelID,
+		OrderID:        orderID,
+		Barcode:        code,
+		Event:          req.Event,
+		PreviousStatus: status,
+		ActorID:        &actorID,
+		BranchID:       req.BranchID,
+		MotoID:         req.MotoID,
+		Latitude:       req.Latitude,
+		Longitude:      req.Longitude,
+		ScannedAt:      scannedAt,
+	}
+	if actorRole != "" {
+		scan.ActorRole = &actorRole
+	}
+	if req.Notes != "" {
+		scan.Notes = &req.Notes
+	}
+	err = tx.QueryRow(`
+		INSERT INTO parcel_scans (parcel_id, order_id, event, previous_status, actor_id, actor_role, branch_id, moto_id,
+		                          latitude, longitude, notes, scanned_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`, scan.ParcelID, scan.OrderID, scan.Event, scan.PreviousStatus, scan.ActorID, scan.ActorRole, scan.BranchID,
+		scan.MotoID, scan.Latitude, scan.Longitude, scan.Notes, scan.ScannedAt).Scan(&scan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar el escaneo"})
+		return
+	}
+	if _, err := tx.Exec(
+		"UPDATE parcels SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", req.Event, parcelID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar el bulto"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar el escaneo"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, scan)
+}
+
+// Discrepancias de la conciliación
+const (
+	DiscrepancyNotReturned        = "not_returned"         // Cargado y sin escaneo de entrega ni devolución
+	DiscrepancyOrderNotDelivered  = "order_not_delivered"  // Bulto entregado, pedido abierto
+	DiscrepancyParcelNotDelivered = "parcel_not_delivered" // Pedido entregado sin escanear el bulto
+)
+
+// ReconciliationItem bulto con discrepancia
+type ReconciliationItem struct {
+	ParcelID    int    `json:"parcel_id"`
+	Barcode     string `json:"barcode"`
+	OrderID     int    `json:"order_id"`
+	OrderStatus string `json:"order_status"`
+	Status      string `json:"status"`
+	MotoID      int    `json:"moto_id"`
+	Issue       string `json:"issue"`
+}
+
+// ReconciliationMoto resumen por moto de lo que salió y lo que volvió
+type ReconciliationMoto struct {
+	MotoID      int    `json:"moto_id"`
+	MotoPlate   string `json:"moto_plate"`
+	Loaded      int    `json:"loaded"`
+	Delivered   int    `json:"delivered"`
+	Returned    int    `json:"returned"`
+	Outstanding int    `json:"outstanding"`
+}
+
+// GetParcelReconciliation GET /scans/reconciliation?branch_id=&date=YYYY-MM-DD
+// compara los bultos cargados en el día con los entregados o devueltos
+func GetParcelReconciliation(c *gin.Context) {
+	branchID, err := strconv.Atoi(c.Query("branch_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branch_id es requerido"})
+		return
+	}
+	day := time.Now()
+	if d := c.Query("date"); d != "" {
+		day, err = time.Parse("2006-01-02", d)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date debe tener formato YYYY-MM-DD"})
+			return
+		}
+	}
+	date := day.Format("2006-01-02")
+
+	// Bultos cargados en el día con la moto de su última carga
+	rows, err := db.Query(`
+		SELECT p.id, p.barcode, p.order_id, p.status, o.status, l.moto_id, COALESCE(m.license_plate, '')
+		FROM parcels p
+		JOIN orders o ON o.id = p.order_id
+		JOIN LATERAL (
+			SELECT ps.moto_id FROM parcel_scans ps
+			WHERE ps.parcel_id = p.id AND ps.event = 'loaded_on_moto'
+			  AND ps.scanned_at >= $2::date AND ps.scanned_at < $2::date + INTERVAL '1 day'
+			ORDER BY ps.scanned_at DESC, ps.id DESC
+			LIMIT 1
+		) l ON true
+		LEFT JOIN motos m ON m.id = l.moto_id
+		WHERE o.branch_id = $1
+		ORDER BY l.moto_id, p.id
+	`, branchID, date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al conciliar bultos"})
+		return
+	}
+	defer rows.Close()
+
+	motos := []*ReconciliationMoto{}
+	byMoto := map[int]*ReconciliationMoto{}
+	issues := []ReconciliationItem{}
+	for rows.Next() {
+		var it ReconciliationItem
+		var plate string
+		if err := rows.Scan(&it.ParcelID, &it.Barcode, &it.OrderID, &it.Status, &it.OrderStatus, &it.MotoID, &plate); err != nil {
+			continue
+		}
+		m, ok := byMoto[it.MotoID]
+		if !ok {
+			m = &ReconciliationMoto{MotoID: it.MotoID, MotoPlate: plate}
+			byMoto[it.MotoID] = m
+			motos = append(motos, m)
+		}
+		m.Loaded++
+
+		switch it.Status {
+		case models.ParcelDelivered:
+			m.Delivered++
+			if it.OrderStatus != "delivered" {
+				it.Issue = DiscrepancyOrderNotDelivered
+			}
+		case models.ParcelReturned, models.ParcelReceivedAtBranch:
+			m.Returned++
+		default:
+			m.Outstanding++
+			it.Issue = DiscrepancyNotReturned
+			if it.OrderStatus == "delivered" {
+				it.Issue = DiscrepancyParcelNotDelivered
+			}
+		}
+		if it.Issue != "" {
+			issues = append(issues, it)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"branch_id":     branchID,
+		"date":          date,
+		"motos":         motos,
+		"discrepancies": issues,
+		"balanced":      len(issues) == 0,
+	})
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/routing"
+)
+
+// ========================================
+// PEDIDOS CON RECOGIDA Y DEVOLUCIONES
+// ========================================
+// Paradas de cada tipo de pedido:
+//   delivery         entrega en la dirección del cliente (la carga sale de la sucursal)
+//   pickup_delivery  recogida en pickup_* y entrega en la dirección del cliente
+//   return           recogida en la dirección del cliente y entrega en la sucursal
+
+// Punto de partida por defecto cuando la sucursal no tiene coordenadas (Guatemala)
+const (
+	defaultDepotLat = 14.6349
+	defaultDepotLng = -90.5069
+)
+
+// Primer punto a visitar de un pedido: la recogida de los pickup_delivery aún
+// no recogidos (las devoluciones se recogen en la dirección del cliente)
+const (
+	firstStopLatSQL = "CASE WHEN o.order_type = 'pickup_delivery' AND o.picked_up_at IS NULL THEN o.pickup_latitude ELSE o.latitude END"
+	firstStopLngSQL = "CASE WHEN o.order_type = 'pickup_delivery' AND o.picked_up_at IS NULL THEN o.pickup_longitude ELSE o.longitude END"
+)
+
+var errPickupConflict = errors.New("el pedido no requiere recogida o ya fue recogido")
+
+// routeOrder datos de un pedido necesarios para armar sus paradas
+type routeOrder struct {
+	ID            int
+	Type          string
+	Address       string
+	Latitude      float64
+	Longitude     float64
+	PickupAddress string
+	PickupLat     sql.NullFloat64
+	PickupLng     sql.NullFloat64
+	PickedUp      bool
+	DepotAddress  string
+	DepotLat      float64
+	DepotLng      float64
+}
+
+func (o routeOrder) needsPickup() bool {
+	return models.Order{OrderType: o.Type}.NeedsPickup()
+}
+
+// pickupStop punto y dirección de la recogida
+func (o routeOrder) pickupStop() (routing.Point, string) {
+	if o.Type == models.OrderTypeReturn {
+		return routing.Point{Latitude: o.Latitude, Longitude: o.Longitude}, o.Address
+	}
+	return routing.Point{Latitude: o.PickupLat.Float64, Longitude: o.PickupLng.Float64}, o.PickupAddress
+}
+
+// dropoffStop punto y dirección de la entrega
+func (o routeOrder) dropoffStop() (routing.Point, string) {
+	if o.Type == models.OrderTypeReturn {
+		return routing.Point{Latitude: o.DepotLat, Longitude: o.DepotLng}, o.DepotAddress
+	}
+	return routing.Point{Latitude: o.Latitude, Longitude: o.Longitude}, o.Address
+}
+
+// job pedido a secuenciar; sin recogida si ya se recogió
+func (o routeOrder) job() routing.Job {
+	dropoff, _ := o.dropoffStop()
+	j := routing.Job{OrderID: o.ID, Dropoff: dropoff}
+	if o.needsPickup() && !o.PickedUp {
+		pickup, _ := o.pickupStop()
+		j.Pickup = &pickup
+	}
+	return j
+}
+
+// loadRouteOrders carga los pedidos con la sucursal a la que vuelven las devoluciones
+func loadRouteOrders(ids []int64) (map[int]routeOrder, error) {
+	rows, err := db.Query(`
+		SELECT o.id, o.order_type, o.address, o.latitude, o.longitude,
+		       COALESCE(o.pickup_address, ''), o.pickup_latitude, o.pickup_longitude,
+		       o.picked_up_at IS NOT NULL,
+		       COALESCE(b.address, ''), b.latitude, b.longitude
+		FROM orders o
+		LEFT JOIN branches b ON b.id = o.branch_id
+		WHERE o.id = ANY($1) AND o.latitude IS NOT NULL AND o.longitude IS NOT NULL
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make(map[int]routeOrder, len(ids))
+	for rows.Next() {
+		var o routeOrder
+		var depotLat, depotLng sql.NullFloat64
+		if err := rows.Scan(&o.ID, &o.Type, &o.Address, &o.Latitude, &o.Longitude,
+			&o.PickupAddress, &o.PickupLat, &o.PickupLng, &o.PickedUp,
+			&o.DepotAddress, &depotLat, &depotLng); err != nil {
+			continue
+		}
+		o.DepotLat, o.DepotLng = defaultDepotLat, defaultDepotLng
+		if depotLat.Valid && depotLng.Valid {
+			o.DepotLat, o.DepotLng = depotLat.Float64, depotLng.Float64
+		}
+		orders[o.ID] = o
+	}
+	return orders, rows.Err()
+}
+
+// saveSequencedRoute secuencia los pedidos desde start (recogidas antes de sus
+// entregas) y guarda la ruta de la moto
+func saveSequencedRoute(motoID int, start routing.Point, orderIDs []int) ([]routing.Stop, error) {
+	ids := make([]int64, len(orderIDs))
+	for i, id := range orderIDs {
+		ids[i] = int64(id)
+	}
+	orders, err := loadRouteOrders(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]routing.Job, 0, len(orderIDs))
+	for _, id := range orderIDs {
+		if o, ok := orders[id]; ok {
+			jobs = append(jobs, o.job())
+		}
+	}
+	stops := routing.Sequence(start, jobs)
+	if err := models.NewRouteRepository(db).CreateRouteWithStops(motoID, start, stops); err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// pickupState tipo de pedido y si ya fue recogido
+func pickupState(orderID int) (orderType string, pickedUpAt *time.Time, err error) {
+	err = db.QueryRow("SELECT order_type, picked_up_at FROM orders WHERE id = $1", orderID).
+		Scan(&orderType, &pickedUpAt)
+	return orderType, pickedUpAt, err
+}
+
+// markPickedUp registra la recogida con su prueba. Devuelve errPickupConflict
+// si el pedido no requiere recogida o ya fue recogido.
+func markPickedUp(orderID int, req DeliveryProofRequest) (gin.H, error) {
+	orderType, pickedUpAt, err := pickupState(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if !(models.Order{OrderType: orderType}).NeedsPickup() || pickedUpAt != nil {
+		return nil, errPickupConflict
+	}
+
+	result, err := storeDeliveryProof(orderID, ProofStagePickup, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var at time.Time
+	err = db.QueryRow(`
+		UPDATE orders SET picked_up_at = COALESCE(picked_up_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING picked_up_at
+	`, orderID).Scan(&at)
+	if err != nil {
+		return nil, err
+	}
+	result["order_id"] = orderID
+	result["picked_up_at"] = at
+	return result, nil
+}
+
+// PickupMyStop POST /me/stops/:id/pickup con la prueba de recogida
+func PickupMyStop(c *gin.Context) {
+	d, ok := currentDriver(c)
+	if !ok {
+		return
+	}
+	orderID, ok := driverOrder(c, d, "in_route")
+	if !ok {
+		return
+	}
+
+	var req DeliveryProofRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := markPickedUp(orderID, req)
+	if err == errPickupConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido no requiere recogida o ya fue recogido"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar la recogida: " + err.Error()})
+		return
+	}
+	result["message"] = "Recogida completada"
+	c.JSON(http.StatusOK, result)
+}
+
+// SavePickupProof POST /orders/:id/pickup-proof (operaciones)
+func SavePickupProof(c *gin.Context) {
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de orden inválido"})
+		return
+	}
+
+	var req DeliveryProofRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := markPickedUp(orderID, req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Orden no encontrada"})
+		return
+	}
+	if err == errPickupConflict {
+		c.JSON(http.StatusConflict, gin.H{"error": "El pedido no requiere recogida o ya fue recogido"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar prueba: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/logitrack/order-service/geo"
+	"github.com/logitrack/order-service/models"
+	"github.com/logitrack/order-service/pricing"
+	"github.com/logitrack/order-service/sqlbuilder"
+	"github.com/logitrack/order-service/validation"
+)
+
+// ========================================
+// TARIFA DE ENTREGA
+// ========================================
+// La tarifa la calcula el paquete pricing con el contrato que aplica al
+// pedido (el indicado, el del cliente o la tarifa general): base por zona o
+// sucursal, distancia desde la sucursal, peso, ventana horaria y recargos por
+// lluvia (branches.raining), horario nocturno y entrega exprés.
+
+// pricingLocation zona horaria del recargo nocturno (PRICING_TIMEZONE)
+var pricingLocation = time.FixedZone("America/Guatemala", -6*60*60)
+
+// InitPricing carga la zona horaria configurada (por defecto America/Guatemala)
+func InitPricing() {
+	name := getEnvDefault("PRICING_TIMEZONE", "America/Guatemala")
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Tarifas: zona horaria %s no disponible, se usa UTC-6: %v", name, err)
+		return
+	}
+	pricingLocation = loc
+}
+
+const pricingContractColumns = `id, name, customer_id, is_active, currency, base_fee_cents, zone_rates,
+	included_km, per_km_cents, included_kg, per_kg_cents, window_fee_cents, rain_bps, night_bps, express_bps,
+	night_start_hour, night_end_hour, min_fee_cents, created_at, updated_at`
+
+func scanPricingContract(row rowScanner, pc *models.PricingContract) error {
+	var zoneRates []byte
+	err := row.Scan(&pc.ID, &pc.Name, &pc.CustomerID, &pc.IsActive, &pc.Currency, &pc.BaseFeeCents, &zoneRates,
+		&pc.IncludedKm, &pc.PerKmCents, &pc.IncludedKg, &pc.PerKgCents, &pc.WindowFeeCents, &pc.RainBps,
+		&pc.NightBps, &pc.ExpressBps, &pc.NightStartHour, &pc.NightEndHour, &pc.MinFeeCents, &pc.CreatedAt,
+		&pc.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(zoneRates, &pc.ZoneRates)
+}
+
+// pricingContractFor contrato que aplica: contractID (activo y del mismo
+// cliente, o general), si no el activo del cliente, si no la tarifa general.
+// Devuelve nil sin contratos. Responde y devuelve false ante un error.
+func pricingContractFor(c *gin.Context, contractID, customerID *int) (*models.PricingContract, bool) {
+	var pc models.PricingContract
+	var err error
+	if contractID != nil {
+		err = scanPricingContract(db.QueryRow("SELECT "+pricingContractColumns+
+			" FROM pricing_contracts WHERE id = $1 AND is_active", *contractID), &pc)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "contract_id no existe o no está activo"})
+			return nil, false
+		}
+		if err == nil && pc.CustomerID != nil && (customerID == nil || *pc.CustomerID != *customerID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El contrato pertenece a otro cliente"})
+			return nil, false
+		}
+	} else {
+		// customer_id = NULL no coincide con nada: sin cliente queda la general
+		err = scanPricingContract(db.QueryRow("SELECT "+pricingContractColumns+`
+			FROM pricing_contracts
+			WHERE is_active AND (customer_id = $1 OR customer_id IS NULL)
+			ORDER BY customer_id IS NULL
+			LIMIT 1`, customerID), &pc)
+		if err == sql.ErrNoRows {
+			return nil, true
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load pricing contract"})
+		return nil, false
+	}
+	return &pc, true
+}
+
+// parcelRequestsWeight peso total declarado de los bultos
+func parcelRequestsWeight(parcels []validation.ParcelRequest) float64 {
+	var total float64
+	for _, p := range parcels {
+		if p.WeightKg != nil {
+			total += *p.WeightKg
+		}
+	}
+	return total
+}
+
+// quoteDelivery calcula la tarifa de entregar en point desde la sucursal.
+// La hora del recargo nocturno es el inicio de la ventana o, sin ventana, ahora.
+func quoteDelivery(pc *models.PricingContract, branch models.Branch, zone *zoneMatch, point geoPoint,
+	weightKg float64, windowStart *time.Time, express bool) (pricing.Quote, pricing.Input, error) {
+	var raining bool
+	if err := db.QueryRow("SELECT raining FROM branches WHERE id = $1", branch.ID).Scan(&raining); err != nil {
+		return pricing.Quote{}, pricing.Input{}, err
+	}
+	when := time.Now()
+	if windowStart != nil {
+		when = *windowStart
+	}
+	in := pricing.Input{
+		BranchID:       branch.ID,
+		DistanceMeters: int64(math.Round(geo.HaversineMeters(branch.Latitude, branch.Longitude, point.Latitude, point.Longitude))),
+		WeightGrams:    int64(math.Round(weightKg * 1000)),
+		HasWindow:      windowStart != nil,
+		LocalHour:      when.In(pricingLocation).Hour(),
+		Rain:           raining,
+		Express:        express,
+	}
+	if zone != nil {
+		in.ZoneID = zone.ZoneID
+	}
+	return pricing.Calculate(pc.Tariff, in), in, nil
+}
+
+// CreateQuote POST /quotes cotiza una entrega con las mismas reglas que se
+// aplicarán al crear el pedido (la cotización no se guarda)
+func CreateQuote(c *gin.Context) {
+	var req validation.QuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateWindow(req.WindowStart, req.WindowEnd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	customerID := req.CustomerID
+	if customerID != nil {
+		id, err := activeCustomerID(*customerID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id no existe"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+			return
+		}
+		customerID = &id
+	}
+
+	point, ok := locateAddress(c, req.Address, req.Latitude, req.Longitude)
+	if !ok {
+		return
+	}
+	branch, zone, ok := resolveDeliveryBranch(c, point, req.BranchID, "")
+	if !ok {
+		return
+	}
+	pc, ok := pricingContractFor(c, req.ContractID, customerID)
+	if !ok {
+		return
+	}
+	if pc == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No hay un contrato de precios activo", "code": "no_pricing_contract"})
+		return
+	}
+
+	weight := parcelRequestsWeight(req.Parcels)
+	if req.WeightKg != nil {
+		weight = *req.WeightKg
+	}
+	quote, in, err := quoteDelivery(pc, branch, zone, point, weight, req.WindowStart, req.Express)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate delivery fee"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quote":         quote,
+		"input":         in,
+		"contract_id":   pc.ID,
+		"contract_name": pc.Name,
+		"branch_code":   branch.Code,
+		"latitude":      point.Latitude,
+		"longitude":     point.Longitude,
+		"needs_pinning": point.NeedsPinning,
+	})
+}
+
+// ========================================
+// CONTRATOS DE PRECIOS
+// ========================================
+
+// canManagePricing solo admin y manager modifican contratos y recargos
+func canManagePricing(c *gin.Context) bool {
+	role := c.GetHeader("X-User-Role")
+	if role != "admin" && role != "manager" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes permiso para modificar las tarifas"})
+		return false
+	}
+	return true
+}
+
+// GetPricingContracts GET /pricing/contracts (filtros: customer_id, active)
+func GetPricingContracts(c *gin.Context) {
+	q := sqlbuilder.New(pricingContractColumns, "pricing_contracts")
+	if v := c.Query("customer_id"); v != "" {
+		customerID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer_id"})
+			return
+		}
+		q.Where("customer_id = ?", customerID)
+	}
+	if c.Query("active") == "true" {
+		q.Where("is_active")
+	}
+	query, args := q.OrderBy("customer_id NULLS FIRST", "id").SQL()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pricing contracts"})
+		return
+	}
+	defer rows.Close()
+
+	contracts := []models.PricingContract{}
+	for rows.Next() {
+		var pc models.PricingContract
+		if err := scanPricingContract(rows, &pc); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read pricing contract"})
+			return
+		}
+		contracts = append(contracts, pc)
+	}
+	c.JSON(http.StatusOK, contracts)
+}
+
+// bindPricingContract lee y valida el contrato del body
+func bindPricingContract(c *gin.Context) (validation.PricingContractRequest, bool) {
+	var req validation.PricingContractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return req, false
+	}
+	if err := validation.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	if err := req.Tariff.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.ZoneRates == nil {
+		req.ZoneRates = []pricing.ZoneRate{}
+	}
+	return req, true
+}
+
+// savePricingContract ejecuta el INSERT/UPDATE del contrato y responde
+func savePricingContract(c *gin.Context, status int, query string, args ...interface{}) {
+	var pc models.PricingContract
+	err := scanPricingContract(db.QueryRow(query, args...), &pc)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contrato no encontrado"})
+		return
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ya existe un contrato activo para ese cliente (o una tarifa general activa)"})
+		return
+	}
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_id no existe"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save pricing contract"})
+		return
+	}
+	c.JSON(status, pc)
+}
+
+// CreatePricingContract POST /pricing/contracts
+func CreatePricingContract(c *gin.Context) {
+	if !canManagePricing(c) {
+		return
+	}
+	req, ok := bindPricingContract(c)
+	if !ok {
+		return
+	}
+	zoneRates, _ := json.Marshal(req.ZoneRates)
+	isActive := req.IsActive == nil || *req.IsActive
+
+	savePricingContract(c, http.StatusCreated, `
+		INSERT INTO pricing_contracts (name, customer_id, is_active, currency, base_fee_cents, zone_rates,
+		                               included_km, per_km_cents, included_kg, per_kg_cents, window_fee_cents,
+		                               rain_bps, night_bps, express_bps, night_start_hour, night_end_hour, min_fee_cents)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING `+pricingContractColumns,
+		req.Name, req.CustomerID, isActive, req.Currency, req.BaseFeeCents, zoneRates,
+		req.IncludedKm, req.PerKmCents, req.IncludedKg, req.PerKgCents, req.WindowFeeCents,
+		req.RainBps, req.NightBps, req.ExpressBps, req.NightStartHour, req.NightEndHour, req.MinFeeCents)
+}
+
+// UpdatePricingContract PUT /pricing/contracts/:id reemplaza la tarifa. Los
+// pedidos ya creados conservan la tarifa calculada.
+func UpdatePricingContract(c *gin.Context) {
+	if !canManagePricing(c) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contract id"})
+		return
+	}
+	req, ok := bindPricingContract(c)
+	if !ok {
+		return
+	}
+	zoneRates, _ := json.Marshal(req.ZoneRates)
+
+	savePricingContract(c, http.StatusOK, `
+		UPDATE pricing_contracts
+		SET name = $2, customer_id = $3, is_active = COALESCE($4, is_active), currency = $5, base_fee_cents = $6,
+		    zone_rates = $7, included_km = $8, per_km_cents = $9, included_kg = $10, per_kg_cents = $11,
+		    window_fee_cents = $12, rain_bps = $13, night_bps = $14, express_bps = $15, night_start_hour = $16,
+		    night_end_hour = $17, min_fee_cents = $18, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+pricingContractColumns,
+		id, req.Name, req.CustomerID, req.IsActive, req.Currency, req.BaseFeeCents, zoneRates,
+		req.IncludedKm, req.PerKmCents, req.IncludedKg, req.PerKgCents, req.WindowFeeCents,
+		req.RainBps, req.NightBps, req.ExpressBps, req.NightStartHour, req.NightEndHour, req.MinFeeCents)
+}
+
+// SetBranchRainRequest activa o desactiva el recargo por lluvia
+type SetBranchRainRequest struct {
+	Raining *bool `json:"raining" binding:"required"`
+}
+
+// SetBranchRain PUT /branches/:id/rain recargo por lluvia de la sucursal;
+// aplica a las tarifas calculadas desde ese momento
+func SetBranchRain(c *gin.Context) {
+	if !canManagePricing(c) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch id"})
+		return
+	}
+	var req SetBranchRainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := db.Exec("UPDATE branches SET raining = $2 WHERE id = $1", id, *req.Raining)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update branch"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branch_id": id, "raining": *req.Raining})
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/sqlbuilder"
 )
 
 // TransferRequest representa una solicitud de transferencia
@@ -183,34 +184,27 @@ func GetTransferredMotos(c *gin.Context) {
 func GetTransferHistory(c *gin.Context) {
 	motoID := c.Query("moto_id")
 	branchID := c.Query("branch_id")
-	limit := c.DefaultQuery("limit", "50")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
 
-	query := `
-		SELECT mt.id, mt.moto_id, m.license_plate, 
+	q := sqlbuilder.New(`mt.id, mt.moto_id, m.license_plate, 
 		       mt.from_branch_id, fb.name, mt.to_branch_id, tb.name,
-		       mt.reason, mt.transfer_type, mt.started_at, mt.expires_at, mt.ended_at, mt.status
-		FROM moto_transfers mt
+		       mt.reason, mt.transfer_type, mt.started_at, mt.expires_at, mt.ended_at, mt.status`, `moto_transfers mt
 		JOIN motos m ON m.id = mt.moto_id
 		LEFT JOIN branches fb ON fb.id = mt.from_branch_id
-		LEFT JOIN branches tb ON tb.id = mt.to_branch_id
-		WHERE 1=1`
-
-	args := []interface{}{}
-	argNum := 1
+		LEFT JOIN branches tb ON tb.id = mt.to_branch_id`)
 
 	if motoID != "" {
-		query += " AND mt.moto_id = $" + strconv.Itoa(argNum)
-		args = append(args, motoID)
-		argNum++
+		q.Where("mt.moto_id = ?", motoID)
 	}
 	if branchID != "" {
-		query += " AND (mt.from_branch_id = $" + strconv.Itoa(argNum) + " OR mt.to_branch_id = $" + strconv.Itoa(argNum) + ")"
-		args = append(args, branchID)
-		argNum++
+		q.Where("(mt.from_branch_id = ? OR mt.to_branch_id = ?)", branchID, branchID)
 	}
 
-	query += " ORDER BY mt.started_at DESC LIMIT $" + strconv.Itoa(argNum)
-	args = append(args, limit)
+	query, args := q.OrderBy("mt.started_at DESC").Limit(limit).SQL()
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
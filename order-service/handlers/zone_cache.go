@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/logitrack/order-service/geo"
+)
+
+// ========================================
+// CACHÉ DE ZONAS DE ENTREGA
+// ========================================
+
+// zoneCacheTTL limita cuánto puede quedar desactualizada la caché si las
+// zonas se modifican fuera de este servicio
+const zoneCacheTTL = 5 * time.Minute
+
+// cachedZone zona activa con su geometría ya interpretada
+type cachedZone struct {
+	match    zoneMatch
+	geometry *geo.Geometry
+}
+
+type zoneCache struct {
+	mu         sync.RWMutex
+	zones      []cachedZone // Por prioridad descendente e id
+	configured bool         // Hay al menos una zona activa, aunque su geometría sea inválida
+	loadedAt   time.Time
+	generation uint64 // Se incrementa en cada invalidación
+}
+
+var activeZones = &zoneCache{}
+
+// invalidateZoneCache fuerza la recarga en la próxima consulta.
+// Se llama desde los handlers que crean, modifican o eliminan zonas y al
+// invalidar la caché de sucursales (las zonas guardan su código y estado).
+func invalidateZoneCache() {
+	activeZones.mu.Lock()
+	activeZones.loadedAt = time.Time{}
+	activeZones.generation++
+	activeZones.mu.Unlock()
+}
+
+func (zc *zoneCache) ensureLoaded() error {
+	zc.mu.RLock()
+	fresh := !zc.loadedAt.IsZero() && time.Since(zc.loadedAt) < zoneCacheTTL
+	generation := zc.generation
+	zc.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT z.id, z.name, z.geometry, b.id, b.code, b.name
+		FROM branch_zones z
+		JOIN branches b ON b.id = z.branch_id
+		WHERE z.is_active = true AND b.is_active = true
+		ORDER BY z.priority DESC, z.id ASC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var zones []cachedZone
+	configured := false
+	for rows.Next() {
+		var z cachedZone
+		var geometry []byte
+		if err := rows.Scan(&z.match.ZoneID, &z.match.ZoneName, &geometry,
+			&z.match.BranchID, &z.match.BranchCode, &z.match.BranchName); err != nil {
+			continue
+		}
+		configured = true
+		if z.geometry, err = geo.ParseGeometry(geometry); err != nil {
+			continue // Geometrías inválidas se rechazan al guardar
+		}
+		zones = append(zones, z)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	// Si se invalidó durante la carga, el resultado puede ser anterior al
+	// cambio: se descarta y la próxima consulta vuelve a cargar
+	if zc.generation != generation {
+		return nil
+	}
+	zc.zones = zones
+	zc.configured = configured
+	zc.loadedAt = time.Now()
+	return nil
+}
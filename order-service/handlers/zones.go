@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/logitrack/order-service/geo"
+	"github.com/logitrack/order-service/models"
+)
+
+// ========================================
+// ZONAS DE ENTREGA (polígonos por sucursal)
+// ========================================
+
+// BranchZoneRequest representa la creación/actualización de una zona
+type BranchZoneRequest struct {
+	Name     string          `json:"name" binding:"required"`
+	Geometry json.RawMessage `json:"geometry" binding:"required"`
+	Priority int             `json:"priority"`
+	IsActive *bool           `json:"is_active"`
+}
+
+// zoneMatch es el resultado de ubicar un punto dentro de las zonas
+type zoneMatch struct {
+	ZoneID     int    `json:"zone_id"`
+	ZoneName   string `json:"zone_name"`
+	BranchID   int    `json:"branch_id"`
+	BranchCode string `json:"branch_code"`
+	BranchName string `json:"branch_name"`
+}
+
+// findBranchForPoint busca la zona activa que contiene el punto. Devuelve
+// también si existe al menos una zona configurada: sin zonas no se puede
+// asignar sucursal automáticamente.
+func findBranchForPoint(lat, lng float64) (*zoneMatch, bool, error) {
+	if err := activeZones.ensureLoaded(); err != nil {
+		return nil, false, err
+	}
+	activeZones.mu.RLock()
+	defer activeZones.mu.RUnlock()
+
+	for _, z := range activeZones.zones {
+		if z.geometry.Contains(lat, lng) {
+			m := z.match
+			return &m, true, nil
+		}
+	}
+	return nil, activeZones.configured, nil
+}
+
+// resolveDeliveryBranch sucursal que entrega en el punto: la de su zona o,
+// si no hay zonas configuradas, la indicada por id o código. Responde y
+// devuelve false si el punto queda fuera de las zonas o no hay sucursal.
+func resolveDeliveryBranch(c *gin.Context, point geoPoint, branchID *int, branchCode string) (models.Branch, *zoneMatch, bool) {
+	match, zonesConfigured, err := findBranchForPoint(point.Latitude, point.Longitude)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve delivery zone"})
+		return models.Branch{}, nil, false
+	}
+
+	var branch models.Branch
+	switch {
+	case zonesConfigured && match == nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "La dirección está fuera de todas las zonas de entrega",
+			"code":  "outside_delivery_zones",
+		})
+		return models.Branch{}, nil, false
+	case match != nil:
+		branch, _ = activeBranchByID(match.BranchID)
+	case branchID != nil:
+		branch, _ = activeBranchByID(*branchID)
+	case branchCode != "":
+		branch, _ = activeBranchByCode(branchCode)
+	}
+	if branch.ID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branch_id is required and must reference an active branch"})
+		return models.Branch{}, nil, false
+	}
+	return branch, match, true
+}
+
+// GetBranchZones lista las zonas de una sucursal
+func GetBranchZones(c *gin.Context) {
+	branchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch id"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, branch_id, name, geometry, priority, is_active, created_at
+		FROM branch_zones
+		WHERE branch_id = $1
+		ORDER BY priority DESC, id ASC`, branchID)
+	if err != nil {
+		log.Printf("Error listing zones of branch %d: %v", branchID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list zones"})
+		return
+	}
+	defer rows.Close()
+
+	zones := []models.BranchZone{}
+	for rows.Next() {
+		var z models.BranchZone
+		var geometry []byte
+		if err := rows.Scan(&z.ID, &z.BranchID, &z.Name, &geometry, &z.Priority, &z.IsActive, &z.CreatedAt); err != nil {
+			continue
+		}
+		z.Geometry = geometry
+		zones = append(zones, z)
+	}
+
+	c.JSON(http.StatusOK, zones)
+}
+
+// CreateBranchZone agrega una zona de entrega a una sucursal
+func CreateBranchZone(c *gin.Context) {
+	branchID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch id"})
+		return
+	}
+
+	var req BranchZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := geo.ParseGeometry(req.Geometry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM branches WHERE id = $1)", branchID).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+
+	z := models.BranchZone{
+		BranchID: branchID,
+		Name:     req.Name,
+		Geometry: req.Geometry,
+		Priority: req.Priority,
+		IsActive: true,
+	}
+	if req.IsActive != nil {
+		z.IsActive = *req.IsActive
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO branch_zones (branch_id, name, geometry, priority, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		z.BranchID, z.Name, []byte(z.Geometry), z.Priority, z.IsActive,
+	).Scan(&z.ID, &z.CreatedAt)
+	if err != nil {
+		log.Printf("Error creating zone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create zone"})
+		return
+	}
+	invalidateZoneCache()
+
+	c.JSON(http.StatusCreated, z)
+}
+
+// UpdateBranchZone reemplaza nombre, geometría, prioridad y estado de una zona
+func UpdateBranchZone(c *gin.Context) {
+	zoneID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone id"})
+		return
+	}
+
+	var req BranchZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := geo.ParseGeometry(req.Geometry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	res, err := db.Exec(`
+		UPDATE branch_zones
+		SET name = $1, geometry = $2, priority = $3, is_active = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5`,
+		req.Name, []byte(req.Geometry), req.Priority, isActive, zoneID)
+	if err != nil {
+		log.Printf("Error updating zone %d: %v", zoneID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update zone"})
+		return
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Zone not found"})
+		return
+	}
+	invalidateZoneCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Zone updated"})
+}
+
+// DeleteBranchZone elimina una zona de entrega
+func DeleteBranchZone(c *gin.Context) {
+	zoneID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone id"})
+		return
+	}
+
+	res, err := db.Exec("DELETE FROM branch_zones WHERE id = $1", zoneID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete zone"})
+		return
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Zone not found"})
+		return
+	}
+	invalidateZoneCache()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Zone deleted"})
+}
+
+// LookupZone devuelve la sucursal cuya zona contiene las coordenadas dadas
+func LookupZone(c *gin.Context) {
+	lat, errLat := strconv.ParseFloat(c.Query("lat"), 64)
+	lng, errLng := strconv.ParseFloat(c.Query("lng"), 64)
+	if errLat != nil || errLng != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat and lng are required"})
+		return
+	}
+
+	match, configured, err := findBranchForPoint(lat, lng)
+	if err != nil {
+		log.Printf("Error looking up zone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up zone"})
+		return
+	}
+	if !configured {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No delivery zones configured"})
+		return
+	}
+	if match == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "La dirección está fuera de todas las zonas de entrega",
+			"code":  "outside_delivery_zones",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, match)
+}
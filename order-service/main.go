@@ -39,6 +39,12 @@ func seedMotos() {
 
 func main() {
 	initDB()
+	handlers.InitMotoIndex()              // Índice espacial de motos (GET /motos/nearest)
+	handlers.InitDispatcher()             // Barrido del despacho automático
+	handlers.InitOffers()                 // Vencimiento de ofertas de asignación
+	handlers.InitMotoLoadReconciliation() // Conciliación de current_orders_count
+	handlers.InitGeocoding()              // Proveedores de geocodificación
+	handlers.InitPricing()                // Zona horaria del recargo nocturno
 
 	// Inicializar logger estructurado
 	logging.InitLogger("order-service")
@@ -58,6 +64,7 @@ func main() {
 	r.GET("/orders/:id/eta", handlers.GetOrderETA)
 	r.PUT("/orders/:id/status", handlers.UpdateOrderStatus)
 	r.PUT("/orders/:id/assign", handlers.AssignOrderToMoto)
+	r.PUT("/orders/:id/pin", handlers.PinOrder) // Fijar coordenadas a mano
 
 	// Motos
 	r.GET("/motos", handlers.GetMotos)
@@ -68,6 +75,9 @@ func main() {
 	r.PUT("/motos/:id/location", handlers.UpdateMotoLocation) // Nueva ruta
 	r.DELETE("/motos/:id", handlers.DeleteMoto)
 	r.GET("/motos/available", handlers.GetMotosAvailableForAssignment) // Nueva ruta
+	r.GET("/motos/nearest", handlers.GetNearestMotos)                  // k motos disponibles más cercanas
+	r.GET("/motos/load-reconciliation", handlers.GetMotoLoadDiscrepancies)
+	r.POST("/motos/load-reconciliation", handlers.ReconcileMotoLoads)
 
 	// Branches (Sucursales) - CRUD completo
 	r.GET("/branches", handlers.GetBranches)        // Solo activas
@@ -77,11 +87,46 @@ func main() {
 	r.DELETE("/branches/:id", handlers.DeleteBranch)
 	r.PUT("/branches/:id/toggle", handlers.ToggleBranchActive)
 
+	// Zonas de entrega (polígonos GeoJSON por sucursal)
+	r.GET("/branches/:id/zones", handlers.GetBranchZones)
+	r.POST("/branches/:id/zones", handlers.CreateBranchZone)
+	r.PUT("/zones/:id", handlers.UpdateBranchZone)
+	r.DELETE("/zones/:id", handlers.DeleteBranchZone)
+	r.GET("/zones/lookup", handlers.LookupZone)
+	r.GET("/geocode", handlers.Geocode)
+
+	// Tarifas de entrega
+	r.POST("/quotes", handlers.CreateQuote)
+	r.GET("/pricing/contracts", handlers.GetPricingContracts)
+	r.POST("/pricing/contracts", handlers.CreatePricingContract)
+	r.PUT("/pricing/contracts/:id", handlers.UpdatePricingContract)
+	r.PUT("/branches/:id/rain", handlers.SetBranchRain) // Recargo por lluvia
+
+	// Cobro contra entrega y entrega de efectivo por turno
+	r.GET("/shifts/:id/cash", handlers.GetShiftCash)
+	r.POST("/shifts/:id/cash-handover", handlers.CreateCashHandover)
+	r.GET("/cash-handovers", handlers.GetCashHandovers)
+	r.POST("/cash-handovers/:id/confirm", handlers.ConfirmCashHandover)
+
 	// Optimization & KPIs
 	r.GET("/optimization/assignments", handlers.OptimizeAssignments)
 	r.POST("/optimization/apply", handlers.ApplyOptimizedAssignments)
+
+	// Despacho automático por sucursal
+	r.GET("/dispatch/settings", handlers.GetDispatchSettings)
+	r.PUT("/dispatch/settings/:branch_id", handlers.UpdateDispatchSettings)
+	r.POST("/dispatch/run", handlers.RunDispatch) // ?branch_id=
+	r.GET("/dispatch/decisions", handlers.GetDispatchDecisions)
+
+	// Ofertas de asignación (el motorista acepta o rechaza)
+	r.GET("/offers", handlers.GetOffers)
+	r.PUT("/offers/:id/accept", handlers.AcceptOffer)
+	r.PUT("/offers/:id/reject", handlers.RejectOffer)
+
+	// KPIs
 	r.GET("/kpis/motos", handlers.GetMotosKPIs)
-	r.GET("/kpis/branches", handlers.GetBranchKPIs) // Dashboard Gerencial
+	r.GET("/kpis/branches", handlers.GetBranchKPIs)     // Dashboard Gerencial
+	r.GET("/kpis/drivers", handlers.GetDriverOfferKPIs) // Aceptación de ofertas
 
 	// Coordinadores - Visitas
 	r.POST("/visits/check-in", handlers.CheckIn)
@@ -105,6 +150,47 @@ func main() {
 	// Prueba de Entrega (firma + foto)
 	r.POST("/orders/:id/delivery-proof", handlers.SaveDeliveryProof)
 	r.GET("/orders/:id/delivery-proof", handlers.GetDeliveryProof)
+	r.POST("/orders/:id/pickup-proof", handlers.SavePickupProof)
+	r.GET("/orders/:id/pickup-proof", handlers.GetPickupProof)
+	r.GET("/orders/:id/attempts", handlers.GetOrderAttempts)
+	r.PUT("/orders/:id/reschedule", handlers.RescheduleOrder)
+	r.GET("/attempt-reasons", handlers.GetAttemptReasons)
+
+	// Bultos y cadena de custodia
+	r.GET("/orders/:id/parcels", handlers.GetOrderParcels)
+	r.POST("/orders/:id/parcels", handlers.AddOrderParcel)
+	r.GET("/parcels/:barcode", handlers.GetParcel)
+	r.GET("/orders/:id/label", handlers.GetOrderLabel)
+	r.GET("/labels", handlers.GetLabels)
+	r.GET("/motos/:id/manifest", handlers.GetMotoManifest)
+	r.POST("/scans", handlers.CreateScan)
+	r.GET("/scans/reconciliation", handlers.GetParcelReconciliation)
+
+	// Clientes y libreta de direcciones
+	r.GET("/customers", handlers.GetCustomers)
+	r.POST("/customers", handlers.CreateCustomer)
+	r.GET("/customers/duplicates", handlers.GetCustomerDuplicates)
+	r.GET("/customers/:id", handlers.GetCustomer)
+	r.PUT("/customers/:id", handlers.UpdateCustomer)
+	r.POST("/customers/:id/merge", handlers.MergeCustomers)
+	r.GET("/customers/:id/orders", handlers.GetCustomerOrders)
+	r.GET("/customers/:id/addresses", handlers.GetCustomerAddresses)
+	r.POST("/customers/:id/addresses", handlers.AddCustomerAddress)
+	r.PUT("/addresses/:id", handlers.UpdateCustomerAddress)
+	r.DELETE("/addresses/:id", handlers.DeleteCustomerAddress)
+
+	// Motorista autenticado: paradas del día y flujo de entrega
+	r.GET("/me/stops", handlers.GetMyStops)
+	r.GET("/me/next-stop", handlers.GetMyNextStop)
+	r.POST("/me/stops/:id/start", handlers.StartMyStop)
+	r.POST("/me/stops/:id/arrived", handlers.ArriveMyStop)
+	r.POST("/me/stops/:id/pickup", handlers.PickupMyStop)
+	r.POST("/me/stops/:id/complete", handlers.CompleteMyStop)
+	r.POST("/me/stops/:id/failed", handlers.FailMyStop)
+
+	// Revisión de lecturas GPS sospechosas (turnos, check-ins y pruebas de entrega)
+	r.GET("/gps-reviews", handlers.GetGPSReviews)
+	r.PUT("/gps-reviews/:id", handlers.ResolveGPSReview)
 
 	// Endpoint de métricas Prometheus
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
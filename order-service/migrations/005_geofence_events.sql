@@ -0,0 +1,34 @@
+-- =====================================================
+-- MIGRACIÓN: Eventos de geocercas (geolocation-service)
+-- =====================================================
+
+-- 1. Tabla de eventos de entrada/salida/permanencia en zonas
+CREATE TABLE IF NOT EXISTS geofence_events (
+    id SERIAL PRIMARY KEY,
+    shift_id INTEGER NOT NULL REFERENCES shifts(id) ON DELETE CASCADE,
+    driver_id INTEGER NOT NULL REFERENCES users(id),
+
+    -- Zona evaluada: sucursal (branch_id) o destino de pedido (order_id)
+    zone_type VARCHAR(20) NOT NULL CHECK (zone_type IN ('branch', 'delivery')),
+    zone_id INTEGER NOT NULL,
+    zone_latitude DECIMAL(10, 8) NOT NULL,
+    zone_longitude DECIMAL(11, 8) NOT NULL,
+    zone_radius_m DECIMAL(10, 2) NOT NULL,
+
+    event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('entered', 'exited', 'dwell')),
+    latitude DECIMAL(10, 8) NOT NULL,
+    longitude DECIMAL(11, 8) NOT NULL,
+
+    -- Segundos dentro de la zona (solo dwell y exited)
+    dwell_seconds INTEGER,
+
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2. Índices para auditoría y KPIs
+CREATE INDEX IF NOT EXISTS idx_geofence_events_shift ON geofence_events(shift_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_geofence_events_driver ON geofence_events(driver_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_geofence_events_zone ON geofence_events(zone_type, zone_id);
+
+SELECT 'Migración de geocercas completada' as resultado;
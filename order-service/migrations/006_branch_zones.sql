@@ -0,0 +1,23 @@
+-- =====================================================
+-- MIGRACIÓN: Zonas de entrega poligonales por sucursal
+-- =====================================================
+
+-- 1. Tabla de zonas (Polygon / MultiPolygon GeoJSON, coordenadas [lng, lat])
+CREATE TABLE IF NOT EXISTS branch_zones (
+    id SERIAL PRIMARY KEY,
+    branch_id INTEGER NOT NULL REFERENCES branches(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    geometry JSONB NOT NULL,
+
+    -- Si dos zonas se solapan gana la de mayor prioridad
+    priority INTEGER DEFAULT 0,
+    is_active BOOLEAN DEFAULT true,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_branch_zones_branch ON branch_zones(branch_id);
+CREATE INDEX IF NOT EXISTS idx_branch_zones_active ON branch_zones(is_active);
+
+SELECT 'Migración de zonas de entrega completada' as resultado;
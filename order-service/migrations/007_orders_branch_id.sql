@@ -0,0 +1,81 @@
+-- =====================================================
+-- MIGRACIÓN: Pedidos referencian branch_id en lugar de
+-- un código de sucursal en texto libre
+-- =====================================================
+
+-- 1. Nueva columna con FK a branches
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS branch_id INTEGER REFERENCES branches(id);
+
+-- 2. Crear sucursales inactivas para códigos existentes en pedidos que no
+--    tienen fila en branches (p. ej. 'este' y 'oeste', permitidos por la
+--    validación anterior pero nunca sembrados). Se ubican en la sucursal
+--    central para que un administrador las revise y active o reasigne.
+INSERT INTO branches (name, code, address, latitude, longitude, radius_km, is_active)
+SELECT 'Sucursal ' || o.branch, o.branch, 'Creada por migración 007 - revisar',
+       ref.latitude, ref.longitude, 10.0, false
+FROM (SELECT DISTINCT branch FROM orders WHERE branch IS NOT NULL AND branch <> '') o
+CROSS JOIN (
+    SELECT latitude, longitude FROM branches
+    ORDER BY (code = 'central') DESC, id ASC
+    LIMIT 1
+) ref
+WHERE NOT EXISTS (SELECT 1 FROM branches b WHERE b.code = o.branch)
+ON CONFLICT DO NOTHING;
+
+-- 3. Backfill: código → id
+UPDATE orders o
+SET branch_id = b.id
+FROM branches b
+WHERE o.branch_id IS NULL AND b.code = o.branch;
+
+-- 4. Pedidos sin código quedan en la sucursal central
+UPDATE orders
+SET branch_id = (SELECT id FROM branches WHERE code = 'central')
+WHERE branch_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_orders_branch_id ON orders(branch_id);
+
+-- 5. Mantener orders.branch (código) sincronizado para lectores legados
+CREATE OR REPLACE FUNCTION sync_order_branch_code() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.branch_id IS NOT NULL THEN
+        SELECT code INTO NEW.branch FROM branches WHERE id = NEW.branch_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS orders_sync_branch_code ON orders;
+CREATE TRIGGER orders_sync_branch_code
+BEFORE INSERT OR UPDATE OF branch_id ON orders
+FOR EACH ROW
+EXECUTE FUNCTION sync_order_branch_code();
+
+-- 6. Vista de KPIs por sucursal usando branch_id
+CREATE OR REPLACE VIEW v_branch_kpis AS
+SELECT
+    b.id as branch_id,
+    b.name as branch_name,
+    b.code as branch_code,
+    COUNT(DISTINCT m.id) as total_motos,
+    COUNT(DISTINCT CASE WHEN m.status = 'available' THEN m.id END) as motos_available,
+    COUNT(DISTINCT CASE WHEN m.status = 'in_route' THEN m.id END) as motos_in_route,
+    COUNT(DISTINCT o.id) FILTER (WHERE o.status = 'pending') as pending_orders,
+    COUNT(DISTINCT o.id) FILTER (WHERE o.status = 'assigned') as assigned_orders,
+    COUNT(DISTINCT o.id) FILTER (WHERE o.status = 'delivered' AND o.updated_at >= CURRENT_DATE) as delivered_today,
+    COUNT(DISTINCT cv.id) FILTER (WHERE cv.check_in_time >= CURRENT_DATE) as visits_today
+FROM branches b
+LEFT JOIN motos m ON m.branch_id = b.id
+LEFT JOIN orders o ON o.branch_id = b.id
+LEFT JOIN coordinator_visits cv ON cv.branch_id = b.id
+WHERE b.is_active = true
+GROUP BY b.id, b.name, b.code;
+
+-- 7. Reporte de pedidos que quedaron en sucursales creadas por esta migración
+SELECT b.code, b.name, COUNT(o.id) as pedidos
+FROM branches b
+JOIN orders o ON o.branch_id = b.id
+WHERE b.address = 'Creada por migración 007 - revisar'
+GROUP BY b.code, b.name;
+
+SELECT 'Migración de branch_id en pedidos completada' as resultado;
@@ -0,0 +1,27 @@
+-- =====================================================
+-- MIGRACIÓN: Fuente única de posición de motos
+-- geolocation-service (POST /positions) escribe motos,
+-- locations y route_points en la misma transacción
+-- =====================================================
+
+-- 1. Índice para la última posición por moto en el historial
+CREATE INDEX IF NOT EXISTS idx_locations_moto_current
+    ON locations(moto_id, timestamp DESC)
+    WHERE type = 'current';
+
+-- 2. Sincronizar motos con la ubicación más reciente del historial
+--    (antes SaveLocation no actualizaba la tabla motos)
+UPDATE motos m
+SET latitude = l.latitude,
+    longitude = l.longitude,
+    last_location_update = l.timestamp
+FROM (
+    SELECT DISTINCT ON (moto_id) moto_id, latitude, longitude, timestamp
+    FROM locations
+    WHERE moto_id IS NOT NULL AND type = 'current'
+    ORDER BY moto_id, timestamp DESC
+) l
+WHERE m.id = l.moto_id
+  AND (m.last_location_update IS NULL OR m.last_location_update < l.timestamp);
+
+SELECT 'Migración de posiciones unificadas completada' as resultado;
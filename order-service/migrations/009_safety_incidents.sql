@@ -0,0 +1,38 @@
+-- =====================================================
+-- MIGRACIÓN: Reglas de conducción segura e incidentes
+-- =====================================================
+
+-- 1. Umbrales por sucursal (sin fila = valores por defecto del servicio)
+CREATE TABLE IF NOT EXISTS branch_safety_settings (
+    branch_id INTEGER PRIMARY KEY REFERENCES branches(id) ON DELETE CASCADE,
+    speed_limit_kmh DECIMAL(6, 2) NOT NULL DEFAULT 60,
+    harsh_accel_ms2 DECIMAL(5, 2) NOT NULL DEFAULT 3.0,
+    harsh_brake_ms2 DECIMAL(5, 2) NOT NULL DEFAULT 3.5,
+    night_start_hour INTEGER NOT NULL DEFAULT 22 CHECK (night_start_hour BETWEEN 0 AND 23),
+    night_end_hour INTEGER NOT NULL DEFAULT 5 CHECK (night_end_hour BETWEEN 0 AND 23),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2. Incidentes detectados por turno y driver
+CREATE TABLE IF NOT EXISTS safety_incidents (
+    id SERIAL PRIMARY KEY,
+    shift_id INTEGER NOT NULL REFERENCES shifts(id) ON DELETE CASCADE,
+    driver_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    moto_id INTEGER REFERENCES motos(id) ON DELETE SET NULL,
+    branch VARCHAR(50),
+    incident_type VARCHAR(30) NOT NULL
+        CHECK (incident_type IN ('speeding', 'harsh_acceleration', 'harsh_braking', 'night_riding')),
+    severity VARCHAR(10) NOT NULL CHECK (severity IN ('low', 'medium', 'high')),
+    value DECIMAL(10, 2) NOT NULL,
+    threshold DECIMAL(10, 2) NOT NULL,
+    latitude DECIMAL(10, 8) NOT NULL,
+    longitude DECIMAL(11, 8) NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_safety_incidents_shift ON safety_incidents(shift_id);
+CREATE INDEX IF NOT EXISTS idx_safety_incidents_driver_time ON safety_incidents(driver_id, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_safety_incidents_branch_time ON safety_incidents(branch, occurred_at);
+
+SELECT 'Migración de incidentes de seguridad completada' as resultado;
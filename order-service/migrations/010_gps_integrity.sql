@@ -0,0 +1,61 @@
+-- =====================================================
+-- MIGRACIÓN: Detección de GPS falso y revisión de lecturas sospechosas
+-- =====================================================
+
+-- 1. Puntaje de integridad por punto de ruta (0 = limpio, 100 = casi seguro falso)
+ALTER TABLE route_points ADD COLUMN IF NOT EXISTS integrity_score SMALLINT;
+ALTER TABLE route_points ADD COLUMN IF NOT EXISTS integrity_flags TEXT[];
+
+CREATE INDEX IF NOT EXISTS idx_route_points_integrity
+    ON route_points(shift_id, integrity_score) WHERE integrity_score > 0;
+
+-- 2. Resumen por turno
+ALTER TABLE shifts ADD COLUMN IF NOT EXISTS gps_suspicious_points INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE shifts ADD COLUMN IF NOT EXISTS gps_max_score SMALLINT NOT NULL DEFAULT 0;
+
+-- 3. Check-ins de coordinadores
+ALTER TABLE coordinator_visits ADD COLUMN IF NOT EXISTS check_in_accuracy DECIMAL(10, 2);
+ALTER TABLE coordinator_visits ADD COLUMN IF NOT EXISTS gps_score SMALLINT;
+ALTER TABLE coordinator_visits ADD COLUMN IF NOT EXISTS gps_flags TEXT[];
+ALTER TABLE coordinator_visits ADD COLUMN IF NOT EXISTS gps_suspicious BOOLEAN NOT NULL DEFAULT false;
+
+-- 4. Pruebas de entrega (la tabla se creaba desde el handler; se asegura aquí)
+CREATE TABLE IF NOT EXISTS delivery_proofs (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id),
+    signature_url TEXT,
+    photo_url TEXT,
+    recipient_name TEXT,
+    notes TEXT,
+    captured_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION
+);
+
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS accuracy DOUBLE PRECISION;
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS gps_score SMALLINT;
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS gps_flags TEXT[];
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS gps_suspicious BOOLEAN NOT NULL DEFAULT false;
+
+-- 5. Cola de revisión (una fila por turno, visita o prueba sospechosa)
+CREATE TABLE IF NOT EXISTS gps_reviews (
+    id SERIAL PRIMARY KEY,
+    entity_type VARCHAR(20) NOT NULL CHECK (entity_type IN ('shift', 'visit', 'delivery_proof')),
+    entity_id INTEGER NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- driver o coordinador
+    score SMALLINT NOT NULL,
+    flags TEXT[] NOT NULL DEFAULT '{}',
+    suspicious_points INTEGER NOT NULL DEFAULT 1,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'confirmed', 'dismissed')),
+    reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
+    review_notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (entity_type, entity_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_gps_reviews_status ON gps_reviews(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_gps_reviews_user ON gps_reviews(user_id);
+
+SELECT 'Migración de integridad GPS completada' as resultado;
@@ -0,0 +1,157 @@
+-- =====================================================
+-- MIGRACIÓN: Particionado mensual, retención y reducción
+-- de resolución del historial GPS (locations, route_points)
+-- =====================================================
+
+-- 1. Crea (si no existe) la partición mensual de una tabla GPS.
+--    Si la partición por defecto ya tiene filas de ese mes se mueven
+--    antes de adjuntarla.
+CREATE OR REPLACE FUNCTION create_gps_partition(parent TEXT, month DATE) RETURNS TEXT AS $$
+DECLARE
+    start_date DATE := date_trunc('month', month)::date;
+    end_date DATE := (date_trunc('month', month) + INTERVAL '1 month')::date;
+    part_name TEXT := parent || '_' || to_char(date_trunc('month', month), 'YYYYMM');
+BEGIN
+    IF to_regclass(part_name) IS NOT NULL THEN
+        RETURN part_name;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', part_name, parent);
+    IF to_regclass(parent || '_default') IS NOT NULL THEN
+        EXECUTE format(
+            'WITH moved AS (DELETE FROM %I WHERE timestamp >= %L AND timestamp < %L RETURNING *) INSERT INTO %I SELECT * FROM moved',
+            parent || '_default', start_date, end_date, part_name);
+    END IF;
+    EXECUTE format('ALTER TABLE %I ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        parent, part_name, start_date, end_date);
+    RETURN part_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- 2. locations → tabla particionada por mes (se conservan ids y secuencia)
+DO $$
+DECLARE
+    m DATE;
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = 'locations'::regclass) = 'p' THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE locations RENAME TO locations_legacy;
+    ALTER TABLE locations_legacy RENAME CONSTRAINT locations_pkey TO locations_legacy_pkey;
+    ALTER SEQUENCE locations_id_seq OWNED BY NONE;
+
+    CREATE TABLE locations (
+        id INTEGER NOT NULL DEFAULT nextval('locations_id_seq'),
+        order_id INTEGER REFERENCES orders(id),
+        moto_id INTEGER REFERENCES motos(id),
+        latitude DECIMAL(10, 8) NOT NULL,
+        longitude DECIMAL(11, 8) NOT NULL,
+        timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        type VARCHAR(50) CHECK (type IN ('pickup', 'delivery', 'current')),
+        PRIMARY KEY (id, timestamp)
+    ) PARTITION BY RANGE (timestamp);
+    CREATE TABLE locations_default PARTITION OF locations DEFAULT;
+
+    SELECT date_trunc('month', COALESCE(MIN(timestamp), CURRENT_TIMESTAMP))::date INTO m FROM locations_legacy;
+    WHILE m <= (date_trunc('month', CURRENT_DATE) + INTERVAL '3 months')::date LOOP
+        PERFORM create_gps_partition('locations', m);
+        m := (m + INTERVAL '1 month')::date;
+    END LOOP;
+
+    INSERT INTO locations (id, order_id, moto_id, latitude, longitude, timestamp, type)
+    SELECT id, order_id, moto_id, latitude, longitude, COALESCE(timestamp, CURRENT_TIMESTAMP), type
+    FROM locations_legacy;
+
+    DROP TABLE locations_legacy;
+    ALTER SEQUENCE locations_id_seq OWNED BY locations.id;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_locations_order ON locations(order_id);
+CREATE INDEX IF NOT EXISTS idx_locations_moto ON locations(moto_id);
+CREATE INDEX IF NOT EXISTS idx_locations_timestamp ON locations(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_locations_type ON locations(type);
+CREATE INDEX IF NOT EXISTS idx_locations_moto_current
+    ON locations(moto_id, timestamp DESC)
+    WHERE type = 'current';
+
+-- 3. route_points → tabla particionada por mes
+DO $$
+DECLARE
+    m DATE;
+BEGIN
+    IF (SELECT relkind FROM pg_class WHERE oid = 'route_points'::regclass) = 'p' THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE route_points RENAME TO route_points_legacy;
+    ALTER TABLE route_points_legacy RENAME CONSTRAINT route_points_pkey TO route_points_legacy_pkey;
+    ALTER SEQUENCE route_points_id_seq OWNED BY NONE;
+
+    CREATE TABLE route_points (
+        id INTEGER NOT NULL DEFAULT nextval('route_points_id_seq'),
+        shift_id INTEGER NOT NULL REFERENCES shifts(id) ON DELETE CASCADE,
+        latitude DECIMAL(10, 8) NOT NULL,
+        longitude DECIMAL(11, 8) NOT NULL,
+        accuracy DECIMAL(10, 2),
+        speed DECIMAL(10, 2),
+        heading DECIMAL(5, 2),
+        altitude DECIMAL(10, 2),
+        timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        point_type VARCHAR(20) NOT NULL DEFAULT 'TRACKING'
+            CHECK (point_type IN ('START', 'TRACKING', 'DELIVERY', 'PAUSE', 'END')),
+        order_id INTEGER NULL REFERENCES orders(id) ON DELETE SET NULL,
+        address TEXT,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        integrity_score SMALLINT,
+        integrity_flags TEXT[],
+        PRIMARY KEY (id, timestamp)
+    ) PARTITION BY RANGE (timestamp);
+    CREATE TABLE route_points_default PARTITION OF route_points DEFAULT;
+
+    SELECT date_trunc('month', COALESCE(MIN(timestamp), CURRENT_TIMESTAMP))::date INTO m FROM route_points_legacy;
+    WHILE m <= (date_trunc('month', CURRENT_DATE) + INTERVAL '3 months')::date LOOP
+        PERFORM create_gps_partition('route_points', m);
+        m := (m + INTERVAL '1 month')::date;
+    END LOOP;
+
+    INSERT INTO route_points (
+        id, shift_id, latitude, longitude, accuracy, speed, heading, altitude, timestamp,
+        point_type, order_id, address, created_at, integrity_score, integrity_flags
+    )
+    SELECT id, shift_id, latitude, longitude, accuracy, speed, heading, altitude, timestamp,
+           point_type, order_id, address, created_at, integrity_score, integrity_flags
+    FROM route_points_legacy;
+
+    DROP TABLE route_points_legacy;
+    ALTER SEQUENCE route_points_id_seq OWNED BY route_points.id;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_route_points_shift_id ON route_points(shift_id);
+CREATE INDEX IF NOT EXISTS idx_route_points_timestamp ON route_points(timestamp);
+CREATE INDEX IF NOT EXISTS idx_route_points_type ON route_points(point_type);
+CREATE INDEX IF NOT EXISTS idx_route_points_order_id ON route_points(order_id);
+CREATE INDEX IF NOT EXISTS idx_route_points_integrity
+    ON route_points(shift_id, integrity_score) WHERE integrity_score > 0;
+
+-- 4. Políticas de retención y reducción de resolución por tabla
+CREATE TABLE IF NOT EXISTS gps_retention_policies (
+    table_name VARCHAR(50) PRIMARY KEY CHECK (table_name IN ('locations', 'route_points')),
+    retention_days INTEGER NOT NULL CHECK (retention_days > 0),          -- Se eliminan los datos más antiguos
+    downsample_after_days INTEGER NOT NULL CHECK (downsample_after_days > 0),
+    downsample_interval_seconds INTEGER NOT NULL CHECK (downsample_interval_seconds > 0), -- Un punto por intervalo
+    downsampled_until TIMESTAMP,                                        -- Hasta dónde ya se redujo
+    last_run_at TIMESTAMP,
+    last_deleted_rows BIGINT NOT NULL DEFAULT 0,
+    last_downsampled_rows BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (downsample_after_days < retention_days)
+);
+
+INSERT INTO gps_retention_policies (table_name, retention_days, downsample_after_days, downsample_interval_seconds)
+VALUES
+    ('locations', 180, 30, 60),
+    ('route_points', 365, 30, 30)
+ON CONFLICT (table_name) DO NOTHING;
+
+SELECT 'Migración de particionado y retención GPS completada' as resultado;
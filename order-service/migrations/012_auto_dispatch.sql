@@ -0,0 +1,37 @@
+-- =====================================================
+-- MIGRACIÓN: Despacho automático de pedidos por sucursal
+-- =====================================================
+
+-- 1. Configuración por sucursal (sin fila = despacho manual)
+CREATE TABLE IF NOT EXISTS branch_dispatch_settings (
+    branch_id INTEGER PRIMARY KEY REFERENCES branches(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    -- Segundos que se espera para agrupar pedidos que llegan juntos (0 = inmediato)
+    holding_window_seconds INTEGER NOT NULL DEFAULT 30 CHECK (holding_window_seconds >= 0),
+    max_distance_km DECIMAL(6, 2) NOT NULL DEFAULT 10 CHECK (max_distance_km >= 0), -- 0 = sin límite
+    -- Pesos del puntaje (km, km equivalentes de una moto llena, km extra de ruta)
+    weight_distance DECIMAL(6, 2) NOT NULL DEFAULT 1,
+    weight_load DECIMAL(6, 2) NOT NULL DEFAULT 5,
+    weight_insertion DECIMAL(6, 2) NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 2. Decisiones del despacho automático (auditoría)
+CREATE TABLE IF NOT EXISTS dispatch_decisions (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    branch_id INTEGER REFERENCES branches(id) ON DELETE SET NULL,
+    moto_id INTEGER REFERENCES motos(id) ON DELETE SET NULL, -- NULL si quedó sin asignar
+    score DECIMAL(10, 3),
+    distance_km DECIMAL(10, 3),
+    insertion_km DECIMAL(10, 3),
+    load_ratio DECIMAL(5, 3),
+    reason VARCHAR(30), -- Motivo si quedó sin asignar
+    batch_size INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dispatch_decisions_order ON dispatch_decisions(order_id);
+CREATE INDEX IF NOT EXISTS idx_dispatch_decisions_branch_time ON dispatch_decisions(branch_id, created_at);
+
+SELECT 'Migración de despacho automático completada' as resultado;
@@ -0,0 +1,41 @@
+-- =====================================================
+-- MIGRACIÓN: Ofertas de asignación a motoristas
+-- =====================================================
+-- Las asignaciones (manuales, optimización y despacho automático) se ofrecen
+-- primero al motorista: el pedido queda en estado 'offered' con la moto
+-- reservada hasta que la oferta se acepta ('assigned'), se rechaza o vence
+-- (vuelve a 'pending' y se ofrece a la siguiente mejor moto).
+
+-- 1. Estado 'offered' en los pedidos (el CHECK original no lo admite)
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'offered', 'assigned', 'in_route', 'delivered', 'cancelled'));
+
+-- 2. Ofertas
+CREATE TABLE IF NOT EXISTS assignment_offers (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    moto_id INTEGER NOT NULL REFERENCES motos(id) ON DELETE CASCADE,
+    driver_id INTEGER REFERENCES users(id) ON DELETE SET NULL, -- Motorista al momento de ofrecer
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'rejected', 'expired', 'cancelled')),
+    source VARCHAR(20) NOT NULL DEFAULT 'manual'
+        CHECK (source IN ('manual', 'optimization', 'dispatch', 'reassignment')),
+    attempt INTEGER NOT NULL DEFAULT 1, -- Número de oferta del pedido
+    score DECIMAL(10, 3),               -- Puntaje del despacho, si aplica
+    reject_reason VARCHAR(255),
+    offered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    responded_at TIMESTAMP,
+    responded_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Una sola oferta abierta por pedido
+CREATE UNIQUE INDEX IF NOT EXISTS idx_assignment_offers_open
+    ON assignment_offers(order_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_assignment_offers_expiry
+    ON assignment_offers(expires_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_assignment_offers_moto ON assignment_offers(moto_id, offered_at);
+CREATE INDEX IF NOT EXISTS idx_assignment_offers_driver ON assignment_offers(driver_id, offered_at);
+
+SELECT 'Migración de ofertas de asignación completada' as resultado;
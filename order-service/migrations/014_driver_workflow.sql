@@ -0,0 +1,26 @@
+-- =====================================================
+-- MIGRACIÓN: Flujo de trabajo del motorista (/me)
+-- =====================================================
+
+-- 1. Hitos de la entrega
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS started_at TIMESTAMP;   -- Salió hacia el destino (in_route)
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS arrived_at TIMESTAMP;   -- Llegó al destino
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP; -- Entregado con prueba
+
+-- 2. Intentos de entrega fallidos reportados por el motorista
+CREATE TABLE IF NOT EXISTS delivery_attempts (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    moto_id INTEGER REFERENCES motos(id) ON DELETE SET NULL,
+    driver_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reason VARCHAR(255) NOT NULL,
+    notes TEXT,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_attempts_order ON delivery_attempts(order_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_delivery_attempts_moto ON delivery_attempts(moto_id, created_at);
+
+SELECT 'Migración de flujo del motorista completada' as resultado;
@@ -0,0 +1,59 @@
+-- =====================================================
+-- MIGRACIÓN: Intentos fallidos y reprogramación de entregas
+-- =====================================================
+-- Cada intento fallido devuelve el pedido al pool de pendientes o lo
+-- reprograma para una nueva ventana horaria. Al alcanzar el máximo de
+-- intentos el pedido queda en estado 'failed'.
+
+-- 1. Intentos y ventana de entrega en el pedido
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS max_delivery_attempts INTEGER NOT NULL DEFAULT 3;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_attempts_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS window_start TIMESTAMP; -- No se despacha antes de esta hora
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS window_end TIMESTAMP;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_max_delivery_attempts_check') THEN
+        ALTER TABLE orders ADD CONSTRAINT orders_max_delivery_attempts_check CHECK (max_delivery_attempts >= 1);
+    END IF;
+END $$;
+
+UPDATE orders o SET delivery_attempts_count = a.total
+FROM (SELECT order_id, COUNT(*) AS total FROM delivery_attempts GROUP BY order_id) a
+WHERE a.order_id = o.id;
+
+-- Estado 'failed' al agotar los intentos
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('pending', 'offered', 'assigned', 'in_route', 'delivered', 'failed', 'cancelled'));
+
+CREATE INDEX IF NOT EXISTS idx_orders_pending_window ON orders(window_start) WHERE status = 'pending';
+
+-- 2. Detalle del intento: código de motivo, foto, GPS y resultado
+ALTER TABLE delivery_attempts ALTER COLUMN reason DROP NOT NULL; -- Ahora es la descripción libre
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS reason_code VARCHAR(30) NOT NULL DEFAULT 'other';
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS attempt_number INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS photo_url TEXT;
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS accuracy DOUBLE PRECISION;
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS captured_at TIMESTAMP;
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS outcome VARCHAR(20) NOT NULL DEFAULT 'returned';
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS window_start TIMESTAMP;
+ALTER TABLE delivery_attempts ADD COLUMN IF NOT EXISTS window_end TIMESTAMP;
+
+UPDATE delivery_attempts d SET attempt_number = n.rn
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY order_id ORDER BY created_at, id) AS rn FROM delivery_attempts) n
+WHERE n.id = d.id;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'delivery_attempts_reason_code_check') THEN
+        ALTER TABLE delivery_attempts ADD CONSTRAINT delivery_attempts_reason_code_check CHECK (reason_code IN
+            ('customer_absent', 'wrong_address', 'refused', 'unreachable', 'business_closed', 'damaged', 'other'));
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'delivery_attempts_outcome_check') THEN
+        ALTER TABLE delivery_attempts ADD CONSTRAINT delivery_attempts_outcome_check
+            CHECK (outcome IN ('returned', 'rescheduled', 'failed'));
+    END IF;
+END $$;
+
+SELECT 'Migración de intentos fallidos completada' as resultado;
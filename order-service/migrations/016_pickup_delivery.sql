@@ -0,0 +1,44 @@
+-- =====================================================
+-- MIGRACIÓN: Pedidos con recogida y devoluciones
+-- =====================================================
+-- Tipos de pedido:
+--   delivery         la carga sale de la sucursal y se entrega en la dirección del cliente
+--   pickup_delivery  se recoge en pickup_* (cliente o tercero) y se entrega en la dirección del cliente
+--   return           se recoge en la dirección del cliente y se devuelve a la sucursal
+
+-- 1. Tipo de pedido y punto de recogida
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS order_type VARCHAR(20) NOT NULL DEFAULT 'delivery';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS pickup_address TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS pickup_latitude DOUBLE PRECISION;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS pickup_longitude DOUBLE PRECISION;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS pickup_contact VARCHAR(100);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS picked_up_at TIMESTAMP;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_order_type_check') THEN
+        ALTER TABLE orders ADD CONSTRAINT orders_order_type_check
+            CHECK (order_type IN ('delivery', 'pickup_delivery', 'return'));
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'orders_pickup_point_check') THEN
+        ALTER TABLE orders ADD CONSTRAINT orders_pickup_point_check CHECK (
+            order_type <> 'pickup_delivery' OR (pickup_latitude IS NOT NULL AND pickup_longitude IS NOT NULL));
+    END IF;
+END $$;
+
+-- 2. Paradas de la ruta con su tipo ([{order_id, kind, latitude, longitude}, ...])
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS stop_sequence JSONB;
+
+-- 3. Pruebas separadas de recogida y de entrega
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS stage VARCHAR(20) NOT NULL DEFAULT 'delivery';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'delivery_proofs_stage_check') THEN
+        ALTER TABLE delivery_proofs ADD CONSTRAINT delivery_proofs_stage_check CHECK (stage IN ('pickup', 'delivery'));
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_delivery_proofs_order_stage ON delivery_proofs(order_id, stage);
+
+SELECT 'Migración de recogidas y devoluciones completada' as resultado;
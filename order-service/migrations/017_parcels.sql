@@ -0,0 +1,80 @@
+-- =====================================================
+-- MIGRACIÓN: Bultos con código de barras y escaneos
+-- =====================================================
+-- Cada pedido tiene uno o más bultos con código LT + 10 dígitos + dígito
+-- verificador. Los escaneos registran la cadena de custodia de cada bulto.
+
+-- 1. Bultos
+CREATE TABLE IF NOT EXISTS parcels (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    barcode VARCHAR(20) NOT NULL UNIQUE,
+    weight_kg DOUBLE PRECISION,
+    length_cm DOUBLE PRECISION,
+    width_cm DOUBLE PRECISION,
+    height_cm DOUBLE PRECISION,
+    description VARCHAR(200),
+    status VARCHAR(30) NOT NULL DEFAULT 'created',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT parcels_status_check CHECK (status IN
+        ('created', 'received_at_branch', 'loaded_on_moto', 'delivered', 'returned')),
+    CONSTRAINT parcels_dimensions_check CHECK (
+        (weight_kg IS NULL OR weight_kg >= 0) AND (length_cm IS NULL OR length_cm >= 0) AND
+        (width_cm IS NULL OR width_cm >= 0) AND (height_cm IS NULL OR height_cm >= 0))
+);
+
+CREATE INDEX IF NOT EXISTS idx_parcels_order ON parcels(order_id);
+
+-- Un bulto por cada pedido existente
+INSERT INTO parcels (order_id, barcode, status)
+SELECT o.id, 'TMP' || o.id, CASE WHEN o.status = 'delivered' THEN 'delivered' ELSE 'created' END
+FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM parcels p WHERE p.order_id = o.id);
+
+-- Código definitivo (mismo algoritmo que barcode.ForParcel)
+CREATE OR REPLACE FUNCTION parcel_barcode(parcel_id INTEGER) RETURNS VARCHAR AS $$
+DECLARE
+    digits TEXT := LPAD(parcel_id::TEXT, 10, '0');
+    total INTEGER := 0;
+    d INTEGER;
+    i INTEGER;
+BEGIN
+    FOR i IN 1..10 LOOP
+        d := SUBSTRING(digits FROM 11 - i FOR 1)::INTEGER;
+        IF i % 2 = 1 THEN
+            d := d * 2;
+            IF d > 9 THEN d := d - 9; END IF;
+        END IF;
+        total := total + d;
+    END LOOP;
+    RETURN 'LT' || digits || ((10 - total % 10) % 10)::TEXT;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+UPDATE parcels SET barcode = parcel_barcode(id) WHERE barcode LIKE 'TMP%';
+
+-- 2. Escaneos (cadena de custodia)
+CREATE TABLE IF NOT EXISTS parcel_scans (
+    id SERIAL PRIMARY KEY,
+    parcel_id INTEGER NOT NULL REFERENCES parcels(id) ON DELETE CASCADE,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    event VARCHAR(30) NOT NULL,
+    previous_status VARCHAR(30) NOT NULL,
+    actor_id INTEGER,
+    actor_role VARCHAR(20),
+    branch_id INTEGER REFERENCES branches(id),
+    moto_id INTEGER REFERENCES motos(id),
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    notes TEXT,
+    scanned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT parcel_scans_event_check CHECK (event IN
+        ('received_at_branch', 'loaded_on_moto', 'delivered', 'returned'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_parcel_scans_parcel ON parcel_scans(parcel_id, scanned_at);
+CREATE INDEX IF NOT EXISTS idx_parcel_scans_event_time ON parcel_scans(event, scanned_at);
+
+SELECT 'Migración de bultos y escaneos completada' as resultado;
@@ -0,0 +1,28 @@
+-- =====================================================
+-- MIGRACIÓN: Capacidad de peso y volumen de las motos
+-- =====================================================
+-- Además de la cantidad de pedidos, cada moto puede tener límite de peso (kg)
+-- y de volumen (litros). NULL = sin límite. La carga de un pedido es la suma
+-- de sus bultos (volumen = largo × ancho × alto / 1000).
+
+ALTER TABLE motos ADD COLUMN IF NOT EXISTS max_weight_kg DOUBLE PRECISION;
+ALTER TABLE motos ADD COLUMN IF NOT EXISTS max_volume_l DOUBLE PRECISION;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'motos_weight_volume_check') THEN
+        ALTER TABLE motos ADD CONSTRAINT motos_weight_volume_check CHECK (
+            (max_weight_kg IS NULL OR max_weight_kg > 0) AND (max_volume_l IS NULL OR max_volume_l > 0));
+    END IF;
+END $$;
+
+-- Carga de cada pedido según sus bultos
+CREATE OR REPLACE VIEW order_loads AS
+SELECT o.id AS order_id,
+       COALESCE(SUM(p.weight_kg), 0) AS weight_kg,
+       COALESCE(SUM(p.length_cm * p.width_cm * p.height_cm) / 1000, 0) AS volume_l
+FROM orders o
+LEFT JOIN parcels p ON p.order_id = o.id
+GROUP BY o.id;
+
+SELECT 'Migración de capacidad por peso y volumen completada' as resultado;
@@ -0,0 +1,42 @@
+-- =====================================================
+-- MIGRACIÓN: Contador de pedidos de las motos derivado de orders
+-- =====================================================
+-- motos.current_orders_count se incrementaba y decrementaba a mano y se
+-- desincronizaba (entregas y cancelaciones nunca lo bajaban). Ahora lo
+-- mantiene un trigger sobre orders en la misma transacción: cuenta los
+-- pedidos ofrecidos, asignados o en ruta de cada moto.
+
+CREATE OR REPLACE FUNCTION moto_active_order_count(p_moto_id INTEGER) RETURNS INTEGER AS $$
+    SELECT COUNT(*)::INTEGER FROM orders
+    WHERE assigned_moto_id = p_moto_id AND status IN ('offered', 'assigned', 'in_route');
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION sync_moto_order_count() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.assigned_moto_id IS NOT NULL THEN
+        UPDATE motos SET current_orders_count = moto_active_order_count(OLD.assigned_moto_id)
+        WHERE id = OLD.assigned_moto_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.assigned_moto_id IS NOT NULL
+       AND (TG_OP = 'INSERT' OR NEW.assigned_moto_id IS DISTINCT FROM OLD.assigned_moto_id
+            OR NEW.status IS DISTINCT FROM OLD.status) THEN
+        UPDATE motos SET current_orders_count = moto_active_order_count(NEW.assigned_moto_id)
+        WHERE id = NEW.assigned_moto_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS orders_sync_moto_order_count ON orders;
+CREATE TRIGGER orders_sync_moto_order_count
+AFTER INSERT OR UPDATE OF status, assigned_moto_id OR DELETE ON orders
+FOR EACH ROW
+EXECUTE FUNCTION sync_moto_order_count();
+
+CREATE INDEX IF NOT EXISTS idx_orders_assigned_moto_status ON orders(assigned_moto_id, status);
+
+-- Recalcular los contadores existentes
+UPDATE motos m SET current_orders_count = moto_active_order_count(m.id)
+WHERE current_orders_count IS DISTINCT FROM moto_active_order_count(m.id);
+
+SELECT 'Migración de contador de pedidos de motos completada' as resultado;
@@ -0,0 +1,16 @@
+-- =====================================================
+-- MIGRACIÓN: Búsqueda y paginación de pedidos
+-- =====================================================
+-- GET /orders pagina por cursor ordenando por (columna, id). created_at y
+-- updated_at pasan a NOT NULL para que el orden sea estable.
+
+UPDATE orders SET created_at = COALESCE(updated_at, CURRENT_TIMESTAMP) WHERE created_at IS NULL;
+UPDATE orders SET updated_at = created_at WHERE updated_at IS NULL;
+
+ALTER TABLE orders ALTER COLUMN created_at SET NOT NULL;
+ALTER TABLE orders ALTER COLUMN updated_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_orders_created_id ON orders(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_orders_updated_id ON orders(updated_at, id);
+
+SELECT 'Migración de búsqueda de pedidos completada' as resultado;
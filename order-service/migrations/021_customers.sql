@@ -0,0 +1,60 @@
+-- =====================================================
+-- MIGRACIÓN: Clientes y libreta de direcciones
+-- =====================================================
+-- Los pedidos guardaban client_name/client_email como texto libre. Ahora cada
+-- cliente es una entidad con sus direcciones geocodificadas; el email
+-- (minúsculas) y el teléfono (solo dígitos) identifican al cliente para
+-- deduplicar. Un cliente fusionado apunta al que lo absorbió (merged_into_id).
+
+-- 1. Clientes
+CREATE TABLE IF NOT EXISTS customers (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    email VARCHAR(255),
+    phone VARCHAR(20),
+    notes TEXT,
+    merged_into_id INTEGER REFERENCES customers(id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Un solo cliente activo por email y por teléfono
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_email ON customers(email) WHERE merged_into_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_phone ON customers(phone) WHERE merged_into_id IS NULL;
+
+-- 2. Direcciones del cliente
+CREATE TABLE IF NOT EXISTS customer_addresses (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    label VARCHAR(50),
+    address TEXT NOT NULL,
+    latitude DECIMAL(10, 8) NOT NULL,
+    longitude DECIMAL(11, 8) NOT NULL,
+    instructions VARCHAR(500),
+    is_default BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_addresses_customer ON customer_addresses(customer_id);
+
+-- 3. Pedidos enlazados al cliente y a la dirección usada
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS customer_id INTEGER REFERENCES customers(id);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS address_id INTEGER REFERENCES customer_addresses(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_orders_customer ON orders(customer_id, created_at);
+
+-- 4. Clientes a partir de los pedidos existentes (uno por email, con el
+--    nombre del pedido más reciente)
+INSERT INTO customers (name, email, created_at)
+SELECT DISTINCT ON (LOWER(TRIM(client_email)))
+       COALESCE(NULLIF(TRIM(client_name), ''), LOWER(TRIM(client_email))), LOWER(TRIM(client_email)), created_at
+FROM orders
+WHERE NULLIF(TRIM(client_email), '') IS NOT NULL
+ORDER BY LOWER(TRIM(client_email)), created_at DESC
+ON CONFLICT DO NOTHING;
+
+UPDATE orders o SET customer_id = c.id
+FROM customers c
+WHERE o.customer_id IS NULL AND c.merged_into_id IS NULL AND c.email = LOWER(TRIM(o.client_email));
+
+SELECT 'Migración de clientes completada' as resultado;
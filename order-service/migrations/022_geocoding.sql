@@ -0,0 +1,30 @@
+-- =====================================================
+-- MIGRACIÓN: Geocodificación de direcciones
+-- =====================================================
+-- Los pedidos y direcciones sin coordenadas se geocodifican (nomenclátor
+-- local y/o Nominatim). Con confianza baja quedan marcados needs_pinning
+-- hasta que alguien fije el punto a mano (PUT /orders/:id/pin); mientras
+-- tanto el despacho automático no los toma.
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS needs_pinning BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS geocode_confidence DOUBLE PRECISION;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS geocode_provider VARCHAR(30);
+
+CREATE INDEX IF NOT EXISTS idx_orders_needs_pinning ON orders(branch_id) WHERE needs_pinning;
+
+ALTER TABLE customer_addresses ADD COLUMN IF NOT EXISTS needs_pinning BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE customer_addresses ADD COLUMN IF NOT EXISTS geocode_confidence DOUBLE PRECISION;
+ALTER TABLE customer_addresses ADD COLUMN IF NOT EXISTS geocode_provider VARCHAR(30);
+
+-- Caché de resultados por dirección normalizada
+CREATE TABLE IF NOT EXISTS geocode_cache (
+    query_key TEXT PRIMARY KEY,
+    latitude DOUBLE PRECISION NOT NULL,
+    longitude DOUBLE PRECISION NOT NULL,
+    display_name TEXT,
+    confidence DOUBLE PRECISION NOT NULL,
+    provider VARCHAR(30) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+SELECT 'Migración de geocodificación completada' as resultado;
@@ -0,0 +1,54 @@
+-- =====================================================
+-- MIGRACIÓN: Tarifa de entrega y contratos de precios
+-- =====================================================
+-- Cada contrato define cómo se calcula la tarifa (montos en centavos,
+-- recargos en puntos básicos). Un contrato con customer_id aplica a ese
+-- cliente; el activo sin customer_id es la tarifa general. La tarifa se
+-- calcula al crear el pedido y queda guardada con su desglose.
+
+-- 1. Contratos
+CREATE TABLE IF NOT EXISTS pricing_contracts (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    customer_id INTEGER REFERENCES customers(id),
+    currency CHAR(3) NOT NULL DEFAULT 'GTQ',
+    base_fee_cents BIGINT NOT NULL DEFAULT 0,
+    zone_rates JSONB NOT NULL DEFAULT '[]', -- [{"zone_id":1,"base_fee_cents":2000}, {"branch_id":2,...}]
+    included_km INTEGER NOT NULL DEFAULT 0,
+    per_km_cents BIGINT NOT NULL DEFAULT 0,
+    included_kg INTEGER NOT NULL DEFAULT 0,
+    per_kg_cents BIGINT NOT NULL DEFAULT 0,
+    window_fee_cents BIGINT NOT NULL DEFAULT 0,
+    rain_bps INTEGER NOT NULL DEFAULT 0,
+    night_bps INTEGER NOT NULL DEFAULT 0,
+    express_bps INTEGER NOT NULL DEFAULT 0,
+    night_start_hour SMALLINT NOT NULL DEFAULT 20,
+    night_end_hour SMALLINT NOT NULL DEFAULT 6,
+    min_fee_cents BIGINT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Un contrato activo por cliente y una sola tarifa general activa
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pricing_contracts_customer ON pricing_contracts(customer_id)
+    WHERE is_active AND customer_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pricing_contracts_general ON pricing_contracts((customer_id IS NULL))
+    WHERE is_active AND customer_id IS NULL;
+
+INSERT INTO pricing_contracts (name, base_fee_cents, included_km, per_km_cents, included_kg, per_kg_cents,
+                               window_fee_cents, rain_bps, night_bps, express_bps, min_fee_cents)
+SELECT 'Tarifa general', 2500, 5, 300, 5, 200, 1000, 2000, 2500, 5000, 2000
+WHERE NOT EXISTS (SELECT 1 FROM pricing_contracts WHERE customer_id IS NULL);
+
+-- 2. Recargo por lluvia activo en la sucursal (lo activa operaciones)
+ALTER TABLE branches ADD COLUMN IF NOT EXISTS raining BOOLEAN NOT NULL DEFAULT false;
+
+-- 3. Tarifa guardada en el pedido
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS express BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS pricing_contract_id INTEGER REFERENCES pricing_contracts(id);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_fee_cents BIGINT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS fee_currency CHAR(3);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS fee_breakdown JSONB;
+
+SELECT 'Migración de tarifas de entrega completada' as resultado;
@@ -0,0 +1,43 @@
+-- =====================================================
+-- MIGRACIÓN: Cobro contra entrega y entrega de efectivo por turno
+-- =====================================================
+-- Un pedido con cod_amount_cents se cobra en la puerta. La prueba de entrega
+-- registra el medio de pago y el monto cobrado, con el turno del motorista.
+-- Al cerrar el turno el motorista entrega el efectivo en la sucursal y el
+-- supervisor confirma lo contado; si falta dinero queda marcado como faltante.
+
+-- 1. Monto a cobrar en el pedido (NULL = ya pagado)
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cod_amount_cents BIGINT CHECK (cod_amount_cents >= 0);
+
+-- 2. Cobro registrado con la prueba de entrega
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS payment_method VARCHAR(20)
+    CHECK (payment_method IN ('cash', 'card', 'transfer'));
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS collected_cents BIGINT CHECK (collected_cents >= 0);
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS driver_id INTEGER;
+ALTER TABLE delivery_proofs ADD COLUMN IF NOT EXISTS shift_id INTEGER REFERENCES shifts(id);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_proofs_shift ON delivery_proofs(shift_id) WHERE shift_id IS NOT NULL;
+
+-- 3. Entrega del efectivo del turno
+CREATE TABLE IF NOT EXISTS cash_handovers (
+    id SERIAL PRIMARY KEY,
+    shift_id INTEGER NOT NULL UNIQUE REFERENCES shifts(id),
+    driver_id INTEGER NOT NULL,
+    branch_id INTEGER REFERENCES branches(id),
+    expected_cents BIGINT NOT NULL,            -- Efectivo cobrado según las pruebas de entrega
+    declared_cents BIGINT NOT NULL,            -- Lo que el motorista dice entregar
+    counted_cents BIGINT,                      -- Lo que cuenta el supervisor
+    difference_cents BIGINT,                   -- counted - expected (negativo = faltante)
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'confirmed', 'shortage')),
+    driver_notes TEXT,
+    supervisor_notes TEXT,
+    handed_over_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    confirmed_by INTEGER,
+    confirmed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_cash_handovers_status ON cash_handovers(status);
+CREATE INDEX IF NOT EXISTS idx_cash_handovers_driver ON cash_handovers(driver_id, handed_over_at DESC);
+
+SELECT 'Migración de cobro contra entrega completada' as resultado;
@@ -59,4 +59,7 @@ type BranchKPI struct {
 	AssignedOrders int    `json:"assigned_orders"`
 	DeliveredToday int    `json:"delivered_today"`
 	VisitsToday    int    `json:"visits_today"`
+
+	FailedAttemptsToday int `json:"failed_attempts_today"`
+	FailedOrders        int `json:"failed_orders"` // Agotaron los intentos de entrega
 }
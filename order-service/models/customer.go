@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Customer es un cliente con sus datos de contacto. Email y teléfono se
+// guardan normalizados y no se repiten entre clientes activos.
+type Customer struct {
+	ID           int               `json:"id"`
+	Name         string            `json:"name"`
+	Email        *string           `json:"email,omitempty"`
+	Phone        *string           `json:"phone,omitempty"`
+	Notes        *string           `json:"notes,omitempty"`
+	MergedIntoID *int              `json:"merged_into_id,omitempty"` // Cliente que absorbió a éste
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	Addresses    []CustomerAddress `json:"addresses,omitempty"`
+}
+
+// CustomerAddress es una dirección geocodificada de la libreta del cliente
+type CustomerAddress struct {
+	ID           int       `json:"id"`
+	CustomerID   int       `json:"customer_id"`
+	Label        *string   `json:"label,omitempty"` // "Casa", "Oficina"...
+	Address      string    `json:"address"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	Instructions *string   `json:"instructions,omitempty"`
+	IsDefault    bool      `json:"is_default"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// Geocodificación (ver Order)
+	NeedsPinning      bool     `json:"needs_pinning"`
+	GeocodeConfidence *float64 `json:"geocode_confidence,omitempty"`
+	GeocodeProvider   *string  `json:"geocode_provider,omitempty"`
+}
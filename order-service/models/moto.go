@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Moto struct {
 	ID                  int      `json:"id"`
 	LicensePlate        string   `json:"license_plate"`
@@ -10,6 +15,10 @@ type Moto struct {
 	Longitude           *float64 `json:"longitude"`
 	MaxOrdersCapacity   int      `json:"max_orders_capacity"`
 	CurrentOrdersCount  int      `json:"current_orders_count"`
+
+	// Límites de carga (nil = sin límite)
+	MaxWeightKg *float64 `json:"max_weight_kg"`
+	MaxVolumeL  *float64 `json:"max_volume_l"`
 }
 
 // Branch representa una sucursal con su zona geográfica
@@ -23,3 +32,14 @@ type Branch struct {
 	RadiusKm  float64 `json:"radius_km"`
 	IsActive  bool    `json:"is_active"`
 }
+
+// BranchZone representa una zona de entrega (polígono GeoJSON) de una sucursal
+type BranchZone struct {
+	ID        int             `json:"id"`
+	BranchID  int             `json:"branch_id"`
+	Name      string          `json:"name"`
+	Geometry  json.RawMessage `json:"geometry"`
+	Priority  int             `json:"priority"`
+	IsActive  bool            `json:"is_active"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -1,5 +1,11 @@
 package models
 
+import (
+	"time"
+
+	"github.com/logitrack/order-service/pricing"
+)
+
 type Order struct {
 	ID             int     `json:"id"`
 	ClientName     string  `json:"client_name"`
@@ -9,5 +15,59 @@ type Order struct {
 	Longitude      float64 `json:"longitude"`
 	Status         string  `json:"status"`
 	AssignedMotoID *int    `json:"assigned_moto_id"`
-	Branch         string  `json:"branch"`
+	BranchID       *int    `json:"branch_id"`
+	Branch         string  `json:"branch"` // Código de la sucursal (denormalizado)
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Geocodificación: con confianza baja el punto debe fijarse a mano
+	NeedsPinning      bool     `json:"needs_pinning"`
+	GeocodeConfidence *float64 `json:"geocode_confidence,omitempty"`
+	GeocodeProvider   *string  `json:"geocode_provider,omitempty"`
+
+	// Tarifa calculada al crear el pedido (nil si no había contrato)
+	Express           bool           `json:"express"`
+	PricingContractID *int           `json:"pricing_contract_id,omitempty"`
+	DeliveryFeeCents  *int64         `json:"delivery_fee_cents,omitempty"`
+	FeeCurrency       *string        `json:"fee_currency,omitempty"`
+	FeeBreakdown      *pricing.Quote `json:"fee_breakdown,omitempty"`
+
+	// Cobro contra entrega en centavos (nil = pedido ya pagado)
+	CODAmountCents *int64 `json:"cod_amount_cents,omitempty"`
+
+	// Cliente y dirección de su libreta (nil en pedidos sin cliente)
+	CustomerID *int `json:"customer_id,omitempty"`
+	AddressID  *int `json:"address_id,omitempty"`
+
+	// Intentos de entrega y ventana reprogramada
+	DeliveryAttempts    int        `json:"delivery_attempts"`
+	MaxDeliveryAttempts int        `json:"max_delivery_attempts"`
+	WindowStart         *time.Time `json:"window_start,omitempty"`
+	WindowEnd           *time.Time `json:"window_end,omitempty"`
+
+	// Tipo de pedido y punto de recogida
+	OrderType       string     `json:"order_type"`
+	PickupAddress   *string    `json:"pickup_address,omitempty"`
+	PickupLatitude  *float64   `json:"pickup_latitude,omitempty"`
+	PickupLongitude *float64   `json:"pickup_longitude,omitempty"`
+	PickupContact   *string    `json:"pickup_contact,omitempty"`
+	PickedUpAt      *time.Time `json:"picked_up_at,omitempty"`
+
+	// Bultos y carga total (suma de los bultos)
+	Parcels  []Parcel `json:"parcels,omitempty"`
+	WeightKg float64  `json:"weight_kg"`
+	VolumeL  float64  `json:"volume_l"`
+}
+
+// Tipos de pedido
+const (
+	OrderTypeDelivery       = "delivery"        // Sale de la sucursal y se entrega al cliente
+	OrderTypePickupDelivery = "pickup_delivery" // Se recoge en pickup_* y se entrega al cliente
+	OrderTypeReturn         = "return"          // Se recoge en el cliente y vuelve a la sucursal
+)
+
+// NeedsPickup indica si el motorista debe recoger el pedido antes de entregarlo
+func (o Order) NeedsPickup() bool {
+	return o.OrderType == OrderTypePickupDelivery || o.OrderType == OrderTypeReturn
 }
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Parcel es un bulto de un pedido
+type Parcel struct {
+	ID          int       `json:"id"`
+	OrderID     int       `json:"order_id"`
+	Barcode     string    `json:"barcode"`
+	WeightKg    *float64  `json:"weight_kg,omitempty"`
+	LengthCm    *float64  `json:"length_cm,omitempty"`
+	WidthCm     *float64  `json:"width_cm,omitempty"`
+	HeightCm    *float64  `json:"height_cm,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ParcelScan es un evento de la cadena de custodia de un bulto
+type ParcelScan struct {
+	ID             int       `json:"id"`
+	ParcelID       int       `json:"parcel_id"`
+	OrderID        int       `json:"order_id"`
+	Barcode        string    `json:"barcode,omitempty"`
+	Event          string    `json:"event"`
+	PreviousStatus string    `json:"previous_status"`
+	ActorID        *int      `json:"actor_id,omitempty"`
+	ActorRole      *string   `json:"actor_role,omitempty"`
+	BranchID       *int      `json:"branch_id,omitempty"`
+	MotoID         *int      `json:"moto_id,omitempty"`
+	Latitude       *float64  `json:"latitude,omitempty"`
+	Longitude      *float64  `json:"longitude,omitempty"`
+	Notes          *string   `json:"notes,omitempty"`
+	ScannedAt      time.Time `json:"scanned_at"`
+}
+
+// Estados del bulto; cada escaneo deja el bulto en el estado de su evento
+const (
+	ParcelCreated          = "created"
+	ParcelReceivedAtBranch = "received_at_branch"
+	ParcelLoadedOnMoto     = "loaded_on_moto"
+	ParcelDelivered        = "delivered"
+	ParcelReturned         = "returned"
+)
+
+// ParcelTransitions estados desde los que se acepta cada evento de escaneo
+var ParcelTransitions = map[string][]string{
+	ParcelReceivedAtBranch: {ParcelCreated, ParcelReturned},
+	ParcelLoadedOnMoto:     {ParcelReceivedAtBranch, ParcelReturned},
+	ParcelDelivered:        {ParcelLoadedOnMoto},
+	ParcelReturned:         {ParcelLoadedOnMoto},
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/logitrack/order-service/pricing"
+)
+
+// PricingContract contrato de precios. Con CustomerID aplica solo a ese
+// cliente; sin él es la tarifa general.
+type PricingContract struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	CustomerID *int   `json:"customer_id,omitempty"`
+	IsActive   bool   `json:"is_active"`
+	pricing.Tariff
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
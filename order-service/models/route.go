@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"time"
+
+	"github.com/logitrack/order-service/routing"
 )
 
 type Route struct {
@@ -12,6 +14,8 @@ type Route struct {
 	OrderSequence []int     `json:"order_sequence"`
 	OptimizedPath []float64 `json:"optimized_path"` // [lat, lng, lat, lng, ...] o GeoJSON simple
 	CreatedAt     time.Time `json:"created_at"`
+
+	StopSequence []routing.Stop `json:"stop_sequence,omitempty"` // Paradas con recogidas y entregas
 }
 
 type RouteRepository struct {
@@ -32,9 +36,22 @@ func (r *RouteRepository) CreateRoute(motoID int, orderSequence []int, optimized
 	return err
 }
 
+// CreateRouteWithStops guarda la ruta con sus paradas secuenciadas (recogidas
+// antes de sus entregas)
+func (r *RouteRepository) CreateRouteWithStops(motoID int, start routing.Point, stops []routing.Stop) error {
+	orderSeqJSON, _ := json.Marshal(routing.OrderSequence(stops))
+	pathJSON, _ := json.Marshal(routing.Path(start, stops))
+	stopsJSON, _ := json.Marshal(stops)
+	_, err := r.db.Exec(
+		"INSERT INTO routes (moto_id, order_sequence, optimized_path, stop_sequence) VALUES ($1, $2, $3, $4)",
+		motoID, orderSeqJSON, pathJSON, stopsJSON,
+	)
+	return err
+}
+
 func (r *RouteRepository) GetRoutesByMoto(motoID int) ([]Route, error) {
 	rows, err := r.db.Query(
-		"SELECT id, moto_id, order_sequence, optimized_path, created_at FROM routes WHERE moto_id = $1 ORDER BY created_at DESC",
+		"SELECT id, moto_id, order_sequence, optimized_path, created_at, stop_sequence FROM routes WHERE moto_id = $1 ORDER BY created_at DESC",
 		motoID,
 	)
 	if err != nil {
@@ -45,13 +62,38 @@ func (r *RouteRepository) GetRoutesByMoto(motoID int) ([]Route, error) {
 	var routes []Route
 	for rows.Next() {
 		var route Route
-		var orderSeqJSON, pathJSON []byte
-		if err := rows.Scan(&route.ID, &route.MotoID, &orderSeqJSON, &pathJSON, &route.CreatedAt); err != nil {
+		var orderSeqJSON, pathJSON, stopsJSON []byte
+		if err := rows.Scan(&route.ID, &route.MotoID, &orderSeqJSON, &pathJSON, &route.CreatedAt, &stopsJSON); err != nil {
 			return nil, err
 		}
 		json.Unmarshal(orderSeqJSON, &route.OrderSequence)
 		json.Unmarshal(pathJSON, &route.OptimizedPath)
+		if stopsJSON != nil {
+			json.Unmarshal(stopsJSON, &route.StopSequence)
+		}
 		routes = append(routes, route)
 	}
 	return routes, nil
 }
+
+// GetLatestRoute última ruta generada para la moto (nil si no tiene)
+func (r *RouteRepository) GetLatestRoute(motoID int) (*Route, error) {
+	var route Route
+	var orderSeqJSON, pathJSON, stopsJSON []byte
+	err := r.db.QueryRow(
+		"SELECT id, moto_id, order_sequence, optimized_path, created_at, stop_sequence FROM routes WHERE moto_id = $1 ORDER BY created_at DESC LIMIT 1",
+		motoID,
+	).Scan(&route.ID, &route.MotoID, &orderSeqJSON, &pathJSON, &route.CreatedAt, &stopsJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal(orderSeqJSON, &route.OrderSequence)
+	json.Unmarshal(pathJSON, &route.OptimizedPath)
+	if stopsJSON != nil {
+		json.Unmarshal(stopsJSON, &route.StopSequence)
+	}
+	return &route, nil
+}
@@ -0,0 +1,104 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkStructure valida cabecera, cantidad de páginas y que cada entrada de
+// la tabla xref apunte al inicio de su objeto
+func checkStructure(t *testing.T, data []byte, pages int) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("cabecera o fin de archivo inválidos")
+	}
+	if !bytes.Contains(data, []byte(fmt.Sprintf("/Count %d >>", pages))) {
+		t.Errorf("se esperaba /Count %d", pages)
+	}
+	if got := bytes.Count(data, []byte("/Type /Page ")); got != pages {
+		t.Errorf("%d objetos de página, se esperaban %d", got, pages)
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("falta startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref no apunta a la tabla xref")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != 4+2*pages {
+		t.Fatalf("%d objetos en xref, se esperaban %d", len(entries), 4+2*pages)
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[off:], []byte(want)) {
+			t.Errorf("el offset del objeto %d no apunta a %q", i+1, want)
+		}
+	}
+}
+
+func TestDocumentMultiPage(t *testing.T) {
+	doc := New(LetterWidth, LetterHeight)
+	for i := 0; i < 3; i++ {
+		pg := doc.AddPage()
+		pg.Text(36, 750, 12, i%2 == 0, fmt.Sprintf("Página %d", i+1))
+		pg.Line(36, 740, 576, 740, 1)
+		pg.Bars(36, 600, 1, 40, []bool{true, false, true, true})
+	}
+	checkStructure(t, doc.Bytes(), 3)
+}
+
+func TestDocumentWithoutPages(t *testing.T) {
+	checkStructure(t, New(LabelWidth, LabelHeight).Bytes(), 0)
+}
+
+func TestTextEscaping(t *testing.T) {
+	doc := New(LabelWidth, LabelHeight)
+	doc.AddPage().Text(10, 10, 10, false, "Peña (local) \\ 5\tB")
+	data := doc.Bytes()
+	// ñ en WinAnsi es 0xF1; paréntesis y barra invertida se escapan
+	if !bytes.Contains(data, []byte("(Pe\xf1a \\(local\\) \\\\ 5 B) Tj")) {
+		t.Errorf("texto mal escapado:\n%s", data)
+	}
+}
+
+func TestBarsMergeAdjacentModules(t *testing.T) {
+	var pg Page
+	pg.Bars(0, 0, 2, 10, []bool{true, true, false, true})
+	if got := strings.Count(pg.content.String(), " re f"); got != 2 {
+		t.Errorf("%d rectángulos, se esperaban 2", got)
+	}
+}
+
+func TestTruncateAndWrap(t *testing.T) {
+	long := "Avenida Libertador General San Martín 1234, Piso 5, Departamento B"
+	if got := Truncate("corto", 10, 100, false); got != "corto" {
+		t.Errorf("Truncate no debería recortar: %q", got)
+	}
+	got := Truncate(long, 10, 100, false)
+	if !strings.HasSuffix(got, "...") || TextWidth(got, 10, false) > 100 {
+		t.Errorf("Truncate = %q (%.1f pt)", got, TextWidth(got, 10, false))
+	}
+
+	lines := Wrap(long, 10, 120, false, 2)
+	if len(lines) != 2 {
+		t.Fatalf("Wrap = %q, se esperaban 2 líneas", lines)
+	}
+	for _, l := range lines {
+		if TextWidth(l, 10, false) > 120 {
+			t.Errorf("línea demasiado ancha: %q", l)
+		}
+	}
+	if !strings.HasSuffix(lines[1], "...") {
+		t.Errorf("la última línea debería recortarse: %q", lines[1])
+	}
+	if got := Wrap("", 10, 120, false, 2); len(got) != 0 {
+		t.Errorf("Wrap de texto vacío = %q", got)
+	}
+}
@@ -0,0 +1,74 @@
+package pdf
+
+import "strings"
+
+// helveticaWidths anchos de Helvetica (milésimas del tamaño) para ASCII 32-126
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // espacio a /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0-9
+	278, 278, 584, 584, 584, 556, 1015, // : a @
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A-M
+	722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N-Z
+	278, 278, 278, 469, 556, 333, // [ a `
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a-m
+	556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n-z
+	334, 260, 334, 584, // { a ~
+}
+
+// TextWidth ancho aproximado del texto en puntos; la negrita se estima un 5%
+// más ancha y los caracteres fuera de ASCII con el ancho de una letra media
+func TextWidth(s string, size float64, bold bool) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	w := float64(total) * size / 1000
+	if bold {
+		w *= 1.05
+	}
+	return w
+}
+
+// Truncate recorta el texto con "..." para que quepa en maxWidth
+func Truncate(s string, size, maxWidth float64, bold bool) string {
+	if TextWidth(s, size, bold) <= maxWidth {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && TextWidth(string(runes)+"...", size, bold) > maxWidth {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// Wrap parte el texto en líneas de maxWidth como máximo; la última línea se
+// recorta si el texto no cabe en maxLines
+func Wrap(s string, size, maxWidth float64, bold bool, maxLines int) []string {
+	var lines []string
+	line := ""
+	words := strings.Fields(s)
+	for i, w := range words {
+		candidate := w
+		if line != "" {
+			candidate = line + " " + w
+		}
+		if TextWidth(candidate, size, bold) <= maxWidth || line == "" {
+			line = candidate
+			continue
+		}
+		lines = append(lines, line)
+		if len(lines) == maxLines {
+			lines[maxLines-1] = Truncate(line+" "+strings.Join(words[i:], " "), size, maxWidth, bold)
+			return lines
+		}
+		line = w
+	}
+	if line != "" {
+		lines = append(lines, Truncate(line, size, maxWidth, bold))
+	}
+	return lines
+}
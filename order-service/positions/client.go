@@ -0,0 +1,133 @@
+package positions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound indica que la moto no tiene posición registrada
+var ErrNotFound = errors.New("la moto no tiene posición registrada")
+
+// Position es la última posición conocida de una moto según geolocation-service
+type Position struct {
+	MotoID     int        `json:"moto_id"`
+	BranchID   *int       `json:"branch_id,omitempty"`
+	Latitude   float64    `json:"latitude"`
+	Longitude  float64    `json:"longitude"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+	AgeSeconds *int       `json:"age_seconds"`
+	Stale      bool       `json:"stale"`
+}
+
+// Report es un reporte GPS enviado a la ingesta unificada
+type Report struct {
+	MotoID    int     `json:"moto_id"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// APIError es una respuesta de error de geolocation-service
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("geolocation-service %d: %s", e.Status, e.Message)
+}
+
+var (
+	baseURL    = getEnv("GEO_SERVICE_URL", "http://geolocation-service:8083")
+	httpClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// Latest obtiene la última posición de las motos indicadas (todas si ids está vacío)
+func Latest(ids []int) (map[int]Position, error) {
+	params := url.Values{}
+	if len(ids) > 0 {
+		parts := make([]string, len(ids))
+		for i, id := range ids {
+			parts[i] = strconv.Itoa(id)
+		}
+		params.Set("ids", strings.Join(parts, ","))
+	}
+
+	var out struct {
+		Positions []Position `json:"positions"`
+	}
+	if err := get("/positions/motos?"+params.Encode(), &out); err != nil {
+		return nil, err
+	}
+
+	byMoto := make(map[int]Position, len(out.Positions))
+	for _, p := range out.Positions {
+		byMoto[p.MotoID] = p
+	}
+	return byMoto, nil
+}
+
+// Get obtiene la última posición de una moto
+func Get(motoID int) (*Position, error) {
+	var p Position
+	err := get("/positions/motos/"+strconv.Itoa(motoID), &p)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Ingest registra un reporte GPS de una moto
+func Ingest(r Report) error {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(baseURL+"/positions", "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func get(path string, out interface{}) error {
+	resp, err := httpClient.Get(baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return &APIError{Status: resp.StatusCode, Message: body.Error}
+}
+
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
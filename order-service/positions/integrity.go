@@ -0,0 +1,54 @@
+package positions
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Fix es una lectura GPS a puntuar. Speed en m/s y Accuracy en metros.
+type Fix struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Accuracy  *float64  `json:"accuracy,omitempty"`
+	Speed     *float64  `json:"speed,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ScoreRequest lectura y su contexto: historial explícito (de la más reciente
+// a la más antigua) o la moto/driver cuyo turno activo sirve de historial
+type ScoreRequest struct {
+	Fix      Fix   `json:"fix"`
+	History  []Fix `json:"history,omitempty"`
+	DriverID *int  `json:"driver_id,omitempty"`
+	MotoID   *int  `json:"moto_id,omitempty"`
+}
+
+// Integrity resultado de la evaluación: 0 es limpia, 100 casi seguro falsa
+type Integrity struct {
+	Score      int      `json:"score"`
+	Suspicious bool     `json:"suspicious"`
+	Flags      []string `json:"flags"`
+}
+
+// Score puntúa una lectura GPS con las heurísticas de geolocation-service
+func Score(req ScoreRequest) (*Integrity, error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Post(baseURL+"/integrity/score", "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var out Integrity
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,201 @@
+// Package pricing calcula la tarifa de entrega de un pedido. Todos los montos
+// son centavos enteros (int64) y los recargos porcentuales se expresan en
+// puntos básicos (1 % = 100 bps), de modo que el cálculo no arrastra errores
+// de punto flotante.
+package pricing
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Códigos de las líneas del desglose
+const (
+	LineBase     = "base"     // Tarifa base (de la zona, la sucursal o general)
+	LineDistance = "distance" // Kilómetros desde la sucursal sobre los incluidos
+	LineWeight   = "weight"   // Kilos sobre los incluidos (por kilo iniciado)
+	LineWindow   = "window"   // Entrega con ventana horaria
+	LineRain     = "rain"     // Recargo por lluvia
+	LineNight    = "night"    // Recargo nocturno
+	LineExpress  = "express"  // Recargo por entrega exprés
+	LineMinimum  = "minimum"  // Ajuste hasta la tarifa mínima
+)
+
+// ZoneRate tarifa base propia de una zona de entrega o de una sucursal
+// (exactamente uno de ZoneID o BranchID). La de zona tiene prioridad.
+type ZoneRate struct {
+	ZoneID       *int  `json:"zone_id,omitempty"`
+	BranchID     *int  `json:"branch_id,omitempty"`
+	BaseFeeCents int64 `json:"base_fee_cents"`
+}
+
+// Tariff parámetros de cálculo de un contrato
+type Tariff struct {
+	Currency       string     `json:"currency"` // ISO 4217 ("GTQ")
+	BaseFeeCents   int64      `json:"base_fee_cents"`
+	ZoneRates      []ZoneRate `json:"zone_rates"`
+	IncludedKm     int        `json:"included_km"`
+	PerKmCents     int64      `json:"per_km_cents"`
+	IncludedKg     int        `json:"included_kg"`
+	PerKgCents     int64      `json:"per_kg_cents"`
+	WindowFeeCents int64      `json:"window_fee_cents"`
+	RainBps        int64      `json:"rain_bps"`
+	NightBps       int64      `json:"night_bps"`
+	ExpressBps     int64      `json:"express_bps"`
+	NightStartHour int        `json:"night_start_hour"` // Hora local en que empieza el recargo nocturno
+	NightEndHour   int        `json:"night_end_hour"`   // Hora local en que termina (igual al inicio = sin recargo)
+	MinFeeCents    int64      `json:"min_fee_cents"`
+}
+
+// Validate verifica que la tarifa sea coherente
+func (t Tariff) Validate() error {
+	if len(t.Currency) != 3 {
+		return errors.New("currency debe ser un código ISO de 3 letras")
+	}
+	for _, v := range []int64{t.BaseFeeCents, t.PerKmCents, t.PerKgCents, t.WindowFeeCents,
+		t.RainBps, t.NightBps, t.ExpressBps, t.MinFeeCents, int64(t.IncludedKm), int64(t.IncludedKg)} {
+		if v < 0 {
+			return errors.New("los montos, recargos y cantidades incluidas no pueden ser negativos")
+		}
+	}
+	if t.NightStartHour < 0 || t.NightStartHour > 23 || t.NightEndHour < 0 || t.NightEndHour > 23 {
+		return errors.New("night_start_hour y night_end_hour deben estar entre 0 y 23")
+	}
+	for _, r := range t.ZoneRates {
+		if (r.ZoneID == nil) == (r.BranchID == nil) {
+			return errors.New("cada zone_rate debe indicar zone_id o branch_id")
+		}
+		if r.BaseFeeCents < 0 {
+			return errors.New("base_fee_cents de zone_rates no puede ser negativo")
+		}
+	}
+	return nil
+}
+
+// Input datos del envío que se cotiza
+type Input struct {
+	ZoneID         int   `json:"zone_id,omitempty"` // 0 = fuera de zonas configuradas
+	BranchID       int   `json:"branch_id"`
+	DistanceMeters int64 `json:"distance_meters"` // Desde la sucursal hasta la entrega
+	WeightGrams    int64 `json:"weight_grams"`
+	HasWindow      bool  `json:"has_window"`
+	LocalHour      int   `json:"local_hour"` // Hora local de entrega (0-23)
+	Rain           bool  `json:"rain"`
+	Express        bool  `json:"express"`
+}
+
+// Line concepto del desglose
+type Line struct {
+	Code        string `json:"code"`
+	AmountCents int64  `json:"amount_cents"`
+}
+
+// Quote tarifa calculada con su desglose
+type Quote struct {
+	Currency      string `json:"currency"`
+	Lines         []Line `json:"lines"`
+	SubtotalCents int64  `json:"subtotal_cents"` // Antes de recargos porcentuales
+	TotalCents    int64  `json:"total_cents"`
+}
+
+// Calculate calcula la tarifa. Los recargos porcentuales se aplican cada uno
+// sobre el subtotal (no se acumulan entre sí) y se redondean al centavo.
+func Calculate(t Tariff, in Input) Quote {
+	q := Quote{Currency: t.Currency}
+	add := func(code string, cents int64) {
+		if cents > 0 {
+			q.Lines = append(q.Lines, Line{code, cents})
+		}
+	}
+
+	add(LineBase, t.baseFee(in.ZoneID, in.BranchID))
+	if extra := in.DistanceMeters - int64(t.IncludedKm)*1000; extra > 0 {
+		add(LineDistance, ceilDiv(extra*t.PerKmCents, 1000))
+	}
+	if extra := in.WeightGrams - int64(t.IncludedKg)*1000; extra > 0 {
+		add(LineWeight, ceilDiv(extra, 1000)*t.PerKgCents)
+	}
+	if in.HasWindow {
+		add(LineWindow, t.WindowFeeCents)
+	}
+	for _, l := range q.Lines {
+		q.SubtotalCents += l.AmountCents
+	}
+
+	total := q.SubtotalCents
+	surcharge := func(code string, applies bool, bps int64) {
+		if applies {
+			cents := percentOf(q.SubtotalCents, bps)
+			add(code, cents)
+			total += cents
+		}
+	}
+	surcharge(LineRain, in.Rain, t.RainBps)
+	surcharge(LineNight, t.IsNight(in.LocalHour), t.NightBps)
+	surcharge(LineExpress, in.Express, t.ExpressBps)
+
+	if total < t.MinFeeCents {
+		add(LineMinimum, t.MinFeeCents-total)
+		total = t.MinFeeCents
+	}
+	q.TotalCents = total
+	if q.Lines == nil {
+		q.Lines = []Line{}
+	}
+	return q
+}
+
+// IsNight indica si la hora local cae en el horario nocturno (que puede
+// cruzar la medianoche, p. ej. de 20 a 6)
+func (t Tariff) IsNight(hour int) bool {
+	start, end := t.NightStartHour, t.NightEndHour
+	switch {
+	case start == end:
+		return false
+	case start < end:
+		return hour >= start && hour < end
+	default:
+		return hour >= start || hour < end
+	}
+}
+
+// baseFee tarifa de la zona, si no la de la sucursal, si no la general
+func (t Tariff) baseFee(zoneID, branchID int) int64 {
+	fee, found := t.BaseFeeCents, false
+	for _, r := range t.ZoneRates {
+		if r.ZoneID != nil && *r.ZoneID == zoneID && zoneID != 0 {
+			return r.BaseFeeCents
+		}
+		if !found && r.BranchID != nil && *r.BranchID == branchID {
+			fee, found = r.BaseFeeCents, true
+		}
+	}
+	return fee
+}
+
+// percentOf bps puntos básicos de cents, redondeado al centavo más cercano
+func percentOf(cents, bps int64) int64 {
+	return (cents*bps + 5000) / 10000
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// Value guarda la cotización como JSON (columna JSONB)
+func (q Quote) Value() (driver.Value, error) {
+	return json.Marshal(q)
+}
+
+// Scan lee la cotización guardada como JSON
+func (q *Quote) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, q)
+	case string:
+		return json.Unmarshal([]byte(v), q)
+	}
+	return fmt.Errorf("pricing: no se puede leer %T como Quote", src)
+}
@@ -0,0 +1,208 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func intp(v int) *int { return &v }
+
+// general tarifa general sembrada en la migración 023
+var general = Tariff{
+	Currency: "GTQ", BaseFeeCents: 2500, IncludedKm: 5, PerKmCents: 300, IncludedKg: 5, PerKgCents: 200,
+	WindowFeeCents: 1000, RainBps: 2000, NightBps: 2500, ExpressBps: 5000,
+	NightStartHour: 20, NightEndHour: 6, MinFeeCents: 2000,
+}
+
+func TestCalculate(t *testing.T) {
+	zoned := general
+	zoned.ZoneRates = []ZoneRate{{BranchID: intp(2), BaseFeeCents: 1800}, {ZoneID: intp(9), BaseFeeCents: 3200}}
+	cheap := general
+	cheap.BaseFeeCents = 500
+
+	tests := []struct {
+		name   string
+		tariff Tariff
+		in     Input
+		want   []Line
+		total  int64
+	}{
+		{
+			name:   "solo tarifa base",
+			tariff: general,
+			in:     Input{BranchID: 1, DistanceMeters: 4000, WeightGrams: 2000, LocalHour: 12},
+			want:   []Line{{LineBase, 2500}},
+			total:  2500,
+		},
+		{
+			name:   "km extra exactos",
+			tariff: general,
+			in:     Input{DistanceMeters: 7300, LocalHour: 12},
+			want:   []Line{{LineBase, 2500}, {LineDistance, 690}},
+			total:  3190,
+		},
+		{
+			name:   "km extra se redondean hacia arriba al centavo",
+			tariff: general,
+			in:     Input{DistanceMeters: 7301, LocalHour: 12},
+			want:   []Line{{LineBase, 2500}, {LineDistance, 691}},
+			total:  3191,
+		},
+		{
+			name:   "peso por kilo iniciado",
+			tariff: general,
+			in:     Input{WeightGrams: 7001, LocalHour: 12},
+			want:   []Line{{LineBase, 2500}, {LineWeight, 600}},
+			total:  3100,
+		},
+		{
+			name:   "ventana horaria",
+			tariff: general,
+			in:     Input{HasWindow: true, LocalHour: 12},
+			want:   []Line{{LineBase, 2500}, {LineWindow, 1000}},
+			total:  3500,
+		},
+		{
+			// Subtotal 2510: lluvia 20 % = 502, nocturno 25 % = 627.5 → 628
+			name:   "recargos sobre el subtotal con redondeo a la mitad hacia arriba",
+			tariff: general,
+			in:     Input{DistanceMeters: 5033, Rain: true, LocalHour: 22},
+			want:   []Line{{LineBase, 2500}, {LineDistance, 10}, {LineRain, 502}, {LineNight, 628}},
+			total:  3640,
+		},
+		{
+			name:   "recargos no se acumulan entre sí",
+			tariff: general,
+			in:     Input{Rain: true, Express: true, LocalHour: 3},
+			want:   []Line{{LineBase, 2500}, {LineRain, 500}, {LineNight, 625}, {LineExpress, 1250}},
+			total:  4875,
+		},
+		{
+			name:   "tarifa mínima",
+			tariff: cheap,
+			in:     Input{LocalHour: 12},
+			want:   []Line{{LineBase, 500}, {LineMinimum, 1500}},
+			total:  2000,
+		},
+		{
+			name:   "la mínima se compara con el total con recargos",
+			tariff: cheap,
+			in:     Input{DistanceMeters: 9000, Express: true, LocalHour: 12},
+			want:   []Line{{LineBase, 500}, {LineDistance, 1200}, {LineExpress, 850}},
+			total:  2550,
+		},
+		{
+			name:   "tarifa de zona antes que la de sucursal",
+			tariff: zoned,
+			in:     Input{ZoneID: 9, BranchID: 2, LocalHour: 12},
+			want:   []Line{{LineBase, 3200}},
+			total:  3200,
+		},
+		{
+			name:   "tarifa de sucursal fuera de zonas",
+			tariff: zoned,
+			in:     Input{BranchID: 2, LocalHour: 12},
+			want:   []Line{{LineBase, 1800}, {LineMinimum, 200}},
+			total:  2000,
+		},
+		{
+			name:   "sin conceptos",
+			tariff: Tariff{Currency: "GTQ"},
+			in:     Input{LocalHour: 12},
+			want:   []Line{},
+			total:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Calculate(tt.tariff, tt.in)
+			if !reflect.DeepEqual(q.Lines, tt.want) {
+				t.Errorf("Lines = %v, se esperaba %v", q.Lines, tt.want)
+			}
+			if q.TotalCents != tt.total {
+				t.Errorf("TotalCents = %d, se esperaba %d", q.TotalCents, tt.total)
+			}
+			if q.Currency != tt.tariff.Currency {
+				t.Errorf("Currency = %q", q.Currency)
+			}
+			var sum int64
+			for _, l := range q.Lines {
+				sum += l.AmountCents
+			}
+			if sum != q.TotalCents {
+				t.Errorf("la suma del desglose (%d) no coincide con el total (%d)", sum, q.TotalCents)
+			}
+		})
+	}
+}
+
+func TestIsNight(t *testing.T) {
+	tests := []struct {
+		start, end int
+		hours      map[int]bool
+	}{
+		// Cruza la medianoche
+		{20, 6, map[int]bool{19: false, 20: true, 23: true, 0: true, 5: true, 6: false, 12: false}},
+		// Dentro del mismo día
+		{0, 5, map[int]bool{23: false, 0: true, 4: true, 5: false}},
+		// Inicio igual al fin: sin recargo
+		{22, 22, map[int]bool{21: false, 22: false, 23: false, 0: false}},
+	}
+	for _, tt := range tests {
+		tariff := Tariff{NightStartHour: tt.start, NightEndHour: tt.end}
+		for hour, want := range tt.hours {
+			if got := tariff.IsNight(hour); got != want {
+				t.Errorf("IsNight(%d) con horario %d-%d = %v, se esperaba %v", hour, tt.start, tt.end, got, want)
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Tariff)
+		ok     bool
+	}{
+		{"tarifa general", func(*Tariff) {}, true},
+		{"moneda", func(t *Tariff) { t.Currency = "Q" }, false},
+		{"monto negativo", func(t *Tariff) { t.PerKmCents = -1 }, false},
+		{"km incluidos negativos", func(t *Tariff) { t.IncludedKm = -1 }, false},
+		{"hora fuera de rango", func(t *Tariff) { t.NightEndHour = 24 }, false},
+		{"zone_rate sin zona ni sucursal", func(t *Tariff) { t.ZoneRates = []ZoneRate{{BaseFeeCents: 100}} }, false},
+		{"zone_rate con zona y sucursal", func(t *Tariff) {
+			t.ZoneRates = []ZoneRate{{ZoneID: intp(1), BranchID: intp(1), BaseFeeCents: 100}}
+		}, false},
+		{"zone_rate negativo", func(t *Tariff) { t.ZoneRates = []ZoneRate{{ZoneID: intp(1), BaseFeeCents: -5}} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tariff := general
+			tt.modify(&tariff)
+			if err := tariff.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, se esperaba ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestQuoteValueScan(t *testing.T) {
+	q := Calculate(general, Input{DistanceMeters: 7300, Rain: true, LocalHour: 12})
+	v, err := q.Value()
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+	var got Quote
+	if err := got.Scan(v); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !reflect.DeepEqual(got, q) {
+		t.Errorf("Scan(Value()) = %+v, se esperaba %+v", got, q)
+	}
+	if err := got.Scan(string(v.([]byte))); err != nil {
+		t.Errorf("Scan(string): %v", err)
+	}
+	if err := got.Scan(42); err == nil {
+		t.Error("Scan(int) no devolvió error")
+	}
+}
@@ -0,0 +1,169 @@
+package routing
+
+import (
+	"github.com/logitrack/order-service/geo"
+)
+
+// Tipos de parada
+const (
+	Pickup  = "pickup"
+	Dropoff = "dropoff"
+)
+
+// maxImprovePasses límite de pasadas de mejora por reubicación
+const maxImprovePasses = 50
+
+// Point es una coordenada
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Job es un pedido a secuenciar. Pickup nil indica que la carga ya va en la
+// moto (pedidos que salen de la sucursal o que ya fueron recogidos).
+type Job struct {
+	OrderID int
+	Pickup  *Point
+	Dropoff Point
+}
+
+// Stop es una parada de la ruta
+type Stop struct {
+	OrderID   int     `json:"order_id"`
+	Kind      string  `json:"kind"` // pickup o dropoff
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Sequence ordena las paradas desde start respetando que cada recogida vaya
+// antes de su entrega: construye la ruta con el vecino factible más cercano y
+// la mejora reubicando paradas mientras se acorte.
+func Sequence(start Point, jobs []Job) []Stop {
+	var open []Stop
+	for _, j := range jobs {
+		if j.Pickup != nil {
+			open = append(open, Stop{OrderID: j.OrderID, Kind: Pickup, Latitude: j.Pickup.Latitude, Longitude: j.Pickup.Longitude})
+		}
+		open = append(open, Stop{OrderID: j.OrderID, Kind: Dropoff, Latitude: j.Dropoff.Latitude, Longitude: j.Dropoff.Longitude})
+	}
+
+	// 1. Vecino más cercano entre las paradas disponibles
+	route := make([]Stop, 0, len(open))
+	pickedUp := map[int]bool{}
+	pending := map[int]bool{}
+	for _, s := range open {
+		if s.Kind == Pickup {
+			pending[s.OrderID] = true
+		}
+	}
+	cur := start
+	for len(open) > 0 {
+		best := -1
+		bestKm := 0.0
+		for i, s := range open {
+			if s.Kind == Dropoff && pending[s.OrderID] && !pickedUp[s.OrderID] {
+				continue
+			}
+			d := km(cur, point(s))
+			if best < 0 || d < bestKm || (d == bestKm && s.OrderID < open[best].OrderID) {
+				best, bestKm = i, d
+			}
+		}
+		s := open[best]
+		if s.Kind == Pickup {
+			pickedUp[s.OrderID] = true
+		}
+		route = append(route, s)
+		cur = point(s)
+		open = append(open[:best], open[best+1:]...)
+	}
+
+	// 2. Reubicación de paradas (solo movimientos factibles)
+	length := Length(start, route)
+	for pass := 0; pass < maxImprovePasses; pass++ {
+		improved := false
+		for i := range route {
+			for j := range route {
+				if i == j {
+					continue
+				}
+				candidate := relocate(route, i, j)
+				if !Feasible(candidate) {
+					continue
+				}
+				if l := Length(start, candidate); l < length-1e-9 {
+					route, length, improved = candidate, l, true
+				}
+			}
+		}
+		if !improved {
+			break
+		}
+	}
+	return route
+}
+
+// Feasible indica si cada entrega aparece después de su recogida
+func Feasible(stops []Stop) bool {
+	delivered := map[int]bool{}
+	for _, s := range stops {
+		if s.Kind == Pickup && delivered[s.OrderID] {
+			return false
+		}
+		if s.Kind == Dropoff {
+			delivered[s.OrderID] = true
+		}
+	}
+	return true
+}
+
+// Length km de la ruta desde start
+func Length(start Point, stops []Stop) float64 {
+	total := 0.0
+	cur := start
+	for _, s := range stops {
+		total += km(cur, point(s))
+		cur = point(s)
+	}
+	return total
+}
+
+// Path ruta como [lat, lng, lat, lng, ...] empezando en start
+func Path(start Point, stops []Stop) []float64 {
+	path := []float64{start.Latitude, start.Longitude}
+	for _, s := range stops {
+		path = append(path, s.Latitude, s.Longitude)
+	}
+	return path
+}
+
+// OrderSequence IDs de pedidos en el orden de su primera parada
+func OrderSequence(stops []Stop) []int {
+	seen := map[int]bool{}
+	var ids []int
+	for _, s := range stops {
+		if !seen[s.OrderID] {
+			seen[s.OrderID] = true
+			ids = append(ids, s.OrderID)
+		}
+	}
+	return ids
+}
+
+// relocate mueve la parada i a la posición j
+func relocate(stops []Stop, i, j int) []Stop {
+	out := make([]Stop, 0, len(stops))
+	out = append(out, stops[:i]...)
+	out = append(out, stops[i+1:]...)
+	s := stops[i]
+	out = append(out[:j], append([]Stop{s}, out[j:]...)...)
+	return out
+}
+
+func point(s Stop) Point {
+	return Point{Latitude: s.Latitude, Longitude: s.Longitude}
+}
+
+func km(a, b Point) float64 {
+	return geo.HaversineMeters(a.Latitude, a.Longitude, b.Latitude, b.Longitude) / 1000
+}
@@ -0,0 +1,121 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+)
+
+// p punto sobre el mismo meridiano: 0.01° de latitud ≈ 1.11 km
+func p(dLat float64) Point {
+	return Point{Latitude: 14.60 + dLat, Longitude: -90.50}
+}
+
+func stop(id int, kind string) Stop {
+	return Stop{OrderID: id, Kind: kind}
+}
+
+func kinds(stops []Stop) []string {
+	out := make([]string, len(stops))
+	for i, s := range stops {
+		out[i] = s.Kind[:1] + string(rune('0'+s.OrderID))
+	}
+	return out
+}
+
+func TestFeasible(t *testing.T) {
+	tests := []struct {
+		name  string
+		stops []Stop
+		want  bool
+	}{
+		{"vacía", nil, true},
+		{"solo entregas", []Stop{stop(1, Dropoff), stop(2, Dropoff)}, true},
+		{"recogida antes de entrega", []Stop{stop(1, Pickup), stop(2, Dropoff), stop(1, Dropoff)}, true},
+		{"entrega antes de recogida", []Stop{stop(1, Dropoff), stop(1, Pickup)}, false},
+		{"otro pedido en medio", []Stop{stop(2, Pickup), stop(1, Dropoff), stop(2, Dropoff), stop(1, Pickup)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Feasible(tt.stops); got != tt.want {
+				t.Errorf("Feasible = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	pick := func(dLat float64) *Point { pt := p(dLat); return &pt }
+	tests := []struct {
+		name string
+		jobs []Job
+		want []string
+	}{
+		{
+			name: "entregas por cercanía",
+			jobs: []Job{{OrderID: 1, Dropoff: p(0.03)}, {OrderID: 2, Dropoff: p(0.01)}, {OrderID: 3, Dropoff: p(0.02)}},
+			want: []string{"d2", "d3", "d1"},
+		},
+		{
+			// La entrega está junto al inicio pero hay que recoger primero
+			name: "recogida lejana antes de su entrega",
+			jobs: []Job{{OrderID: 1, Pickup: pick(0.05), Dropoff: p(0.01)}},
+			want: []string{"p1", "d1"},
+		},
+		{
+			name: "entrega de otro pedido en el camino",
+			jobs: []Job{
+				{OrderID: 1, Pickup: pick(0.04), Dropoff: p(0.01)},
+				{OrderID: 2, Dropoff: p(0.02)},
+			},
+			want: []string{"d2", "p1", "d1"},
+		},
+		{
+			name: "devolución: recoger en el cliente y llevar a la sucursal",
+			jobs: []Job{
+				{OrderID: 1, Pickup: pick(0.02), Dropoff: p(0)},
+				{OrderID: 2, Dropoff: p(0.03)},
+			},
+			want: []string{"p1", "d2", "d1"},
+		},
+		{
+			name: "empate por distancia gana el menor id",
+			jobs: []Job{{OrderID: 2, Dropoff: p(0.01)}, {OrderID: 1, Dropoff: p(0.01)}},
+			want: []string{"d1", "d2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := Sequence(p(0), tt.jobs)
+			if got := kinds(route); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sequence = %v, se esperaba %v", got, tt.want)
+			}
+			if !Feasible(route) {
+				t.Errorf("ruta no factible: %v", kinds(route))
+			}
+		})
+	}
+}
+
+func TestSequenceImprovesNearestNeighbour(t *testing.T) {
+	// El vecino más cercano visita 1, 3 y vuelve a 2 (0.055°); empezar por 2
+	// recorre 0.05°, y la reubicación lo encuentra.
+	jobs := []Job{{OrderID: 1, Dropoff: p(0.01)}, {OrderID: 2, Dropoff: p(-0.015)}, {OrderID: 3, Dropoff: p(0.02)}}
+	route := Sequence(p(0), jobs)
+	if got, want := kinds(route), []string{"d2", "d1", "d3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sequence = %v, se esperaba %v", got, want)
+	}
+}
+
+func TestOrderSequence(t *testing.T) {
+	stops := []Stop{stop(2, Dropoff), stop(3, Pickup), stop(1, Dropoff), stop(3, Dropoff)}
+	if got := OrderSequence(stops); !reflect.DeepEqual(got, []int{2, 3, 1}) {
+		t.Errorf("OrderSequence = %v", got)
+	}
+}
+
+func TestPath(t *testing.T) {
+	got := Path(Point{1, 2}, []Stop{{Latitude: 3, Longitude: 4}, {Latitude: 5, Longitude: 6}})
+	if want := []float64{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Path = %v", got)
+	}
+}
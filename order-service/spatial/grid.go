@@ -0,0 +1,216 @@
+package spatial
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/logitrack/order-service/geo"
+)
+
+// metersPerDegree longitud aproximada de un grado de latitud
+const metersPerDegree = 110574
+
+// Item es un objeto indexado (p. ej. una moto) con su posición
+type Item struct {
+	ID        int
+	Latitude  float64
+	Longitude float64
+}
+
+// Neighbor es un resultado de búsqueda con su distancia al punto consultado
+type Neighbor struct {
+	Item
+	DistanceMeters float64
+}
+
+type cell struct{ x, y int }
+
+// Grid es un índice espacial en memoria de celdas fijas (en grados). La
+// búsqueda de vecinos recorre anillos de celdas alrededor del punto y se
+// detiene cuando ninguna celda más lejana puede mejorar el resultado.
+type Grid struct {
+	mu      sync.RWMutex
+	cellDeg float64
+	items   map[int]Item
+	cells   map[cell]map[int]struct{}
+	// Celdas extremas ocupadas (limita la expansión de anillos)
+	minX, maxX, minY, maxY int
+}
+
+// NewGrid crea un índice con celdas de cellDeg grados (0.01° ≈ 1.1 km)
+func NewGrid(cellDeg float64) *Grid {
+	if cellDeg <= 0 {
+		cellDeg = 0.01
+	}
+	return &Grid{
+		cellDeg: cellDeg,
+		items:   make(map[int]Item),
+		cells:   make(map[cell]map[int]struct{}),
+	}
+}
+
+func (g *Grid) cellOf(lat, lng float64) cell {
+	return cell{
+		x: int(math.Floor(lng / g.cellDeg)),
+		y: int(math.Floor(lat / g.cellDeg)),
+	}
+}
+
+// Len cantidad de objetos indexados
+func (g *Grid) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.items)
+}
+
+// Upsert agrega o mueve un objeto
+func (g *Grid) Upsert(it Item) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.upsert(it)
+}
+
+// Remove quita un objeto del índice
+func (g *Grid) Remove(id int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.remove(id)
+}
+
+// Replace reconstruye el índice con los objetos indicados
+func (g *Grid) Replace(items []Item) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.items = make(map[int]Item, len(items))
+	g.cells = make(map[cell]map[int]struct{})
+	for _, it := range items {
+		g.upsert(it)
+	}
+}
+
+func (g *Grid) upsert(it Item) {
+	g.remove(it.ID)
+	c := g.cellOf(it.Latitude, it.Longitude)
+	if len(g.items) == 0 {
+		g.minX, g.maxX, g.minY, g.maxY = c.x, c.x, c.y, c.y
+	} else {
+		g.minX, g.maxX = min(g.minX, c.x), max(g.maxX, c.x)
+		g.minY, g.maxY = min(g.minY, c.y), max(g.maxY, c.y)
+	}
+	if g.cells[c] == nil {
+		g.cells[c] = make(map[int]struct{})
+	}
+	g.cells[c][it.ID] = struct{}{}
+	g.items[it.ID] = it
+}
+
+func (g *Grid) remove(id int) {
+	old, ok := g.items[id]
+	if !ok {
+		return
+	}
+	c := g.cellOf(old.Latitude, old.Longitude)
+	delete(g.cells[c], id)
+	if len(g.cells[c]) == 0 {
+		delete(g.cells, c)
+	}
+	delete(g.items, id)
+}
+
+// Nearest devuelve los k objetos más cercanos a (lat, lng) que cumplen accept,
+// ordenados por distancia. radiusMeters > 0 limita la búsqueda; k <= 0
+// devuelve todos los que estén dentro del radio.
+func (g *Grid) Nearest(lat, lng float64, k int, radiusMeters float64, accept func(Item) bool) []Neighbor {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if len(g.items) == 0 {
+		return nil
+	}
+
+	center := g.cellOf(lat, lng)
+	maxRing := max(center.x-g.minX, g.maxX-center.x, center.y-g.minY, g.maxY-center.y)
+	// Lado más corto de una celda en metros (las longitudes se acortan con la latitud)
+	cellMeters := g.cellDeg * metersPerDegree * math.Min(1, math.Cos(lat*math.Pi/180))
+	// Con radio, ningún anillo más allá de radio/celda (+1 por la celda del punto) aporta
+	if radiusMeters > 0 && cellMeters > 0 {
+		maxRing = min(maxRing, int(math.Ceil(radiusMeters/cellMeters))+1)
+	}
+
+	var found []Neighbor
+	visit := func(it Item) {
+		d := geo.HaversineMeters(lat, lng, it.Latitude, it.Longitude)
+		if radiusMeters > 0 && d > radiusMeters {
+			return
+		}
+		if accept != nil && !accept(it) {
+			return
+		}
+		found = append(found, Neighbor{Item: it, DistanceMeters: d})
+	}
+	visitCell := func(c cell) {
+		for id := range g.cells[c] {
+			visit(g.items[id])
+		}
+	}
+
+	// Si recorrer los anillos cuesta más celdas que objetos hay (punto lejos
+	// del área ocupada), es más barato revisar todos los objetos
+	side := 2*float64(maxRing) + 1
+	if side*side > float64(len(g.items)) {
+		for _, it := range g.items {
+			visit(it)
+		}
+		return truncateNeighbors(found, k)
+	}
+
+	for r := 0; r <= maxRing; r++ {
+		// Cualquier objeto del anillo r está al menos a (r-1) celdas del punto
+		if r > 0 {
+			bound := float64(r-1) * cellMeters
+			if radiusMeters > 0 && bound > radiusMeters {
+				break
+			}
+			if k > 0 && len(found) >= k {
+				sortNeighbors(found)
+				if found[k-1].DistanceMeters <= bound {
+					break
+				}
+			}
+		}
+
+		if r == 0 {
+			visitCell(center)
+			continue
+		}
+		for x := center.x - r; x <= center.x+r; x++ {
+			visitCell(cell{x, center.y - r})
+			visitCell(cell{x, center.y + r})
+		}
+		for y := center.y - r + 1; y <= center.y+r-1; y++ {
+			visitCell(cell{center.x - r, y})
+			visitCell(cell{center.x + r, y})
+		}
+	}
+
+	return truncateNeighbors(found, k)
+}
+
+// truncateNeighbors ordena por distancia y deja los k primeros (k <= 0: todos)
+func truncateNeighbors(found []Neighbor, k int) []Neighbor {
+	sortNeighbors(found)
+	if k > 0 && len(found) > k {
+		found = found[:k]
+	}
+	return found
+}
+
+func sortNeighbors(n []Neighbor) {
+	sort.Slice(n, func(i, j int) bool {
+		if n[i].DistanceMeters == n[j].DistanceMeters {
+			return n[i].ID < n[j].ID
+		}
+		return n[i].DistanceMeters < n[j].DistanceMeters
+	})
+}
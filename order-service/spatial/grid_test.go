@@ -0,0 +1,123 @@
+package spatial
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/logitrack/order-service/geo"
+)
+
+// bruteNearest referencia lineal con el mismo contrato que Grid.Nearest
+func bruteNearest(items []Item, lat, lng float64, k int, radiusMeters float64, accept func(Item) bool) []Neighbor {
+	var found []Neighbor
+	for _, it := range items {
+		d := geo.HaversineMeters(lat, lng, it.Latitude, it.Longitude)
+		if radiusMeters > 0 && d > radiusMeters {
+			continue
+		}
+		if accept != nil && !accept(it) {
+			continue
+		}
+		found = append(found, Neighbor{Item: it, DistanceMeters: d})
+	}
+	return truncateNeighbors(found, k)
+}
+
+func sameNeighbors(t *testing.T, name string, got, want []Neighbor) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: %d vecinos, se esperaban %d", name, len(got), len(want))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID {
+			t.Fatalf("%s: vecino %d = %d, se esperaba %d", name, i, got[i].ID, want[i].ID)
+		}
+	}
+}
+
+func TestNearestMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	items := make([]Item, 1000)
+	for i := range items {
+		items[i] = Item{
+			ID:        i + 1,
+			Latitude:  -34.7 + rng.Float64()*0.4,
+			Longitude: -58.6 + rng.Float64()*0.4,
+		}
+	}
+	// Celdas de 0.02°: desde el centro del área los anillos cuestan menos que
+	// el recorrido lineal, así que se ejercitan ambos caminos
+	g := NewGrid(0.02)
+	g.Replace(items)
+
+	even := func(it Item) bool { return it.ID%2 == 0 }
+	tests := []struct {
+		name     string
+		lat, lng float64
+		k        int
+		radius   float64
+		accept   func(Item) bool
+	}{
+		{"k=1 centro", -34.5, -58.4, 1, 0, nil},
+		{"k=10 centro", -34.5, -58.4, 10, 0, nil},
+		{"k=5 con filtro", -34.55, -58.45, 5, 0, even},
+		{"radio 2 km sin k", -34.5, -58.4, 0, 2000, nil},
+		{"k=3 radio 1 km", -34.6, -58.5, 3, 1000, nil},
+		{"borde del área", -34.7, -58.6, 4, 0, nil},
+		{"fuera del área", -34.0, -58.0, 3, 0, nil},
+		{"k mayor que el total", -34.5, -58.4, 1000, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.Nearest(tt.lat, tt.lng, tt.k, tt.radius, tt.accept)
+			want := bruteNearest(items, tt.lat, tt.lng, tt.k, tt.radius, tt.accept)
+			sameNeighbors(t, tt.name, got, want)
+		})
+	}
+}
+
+func TestNearestFarQueryPoint(t *testing.T) {
+	g := NewGrid(0.01)
+	g.Replace([]Item{
+		{ID: 1, Latitude: -34.60, Longitude: -58.38},
+		{ID: 2, Latitude: -34.61, Longitude: -58.39},
+	})
+
+	// Punto a miles de km: sin radio usa el recorrido lineal
+	got := g.Nearest(40.0, -3.7, 1, 0, nil)
+	if len(got) != 1 {
+		t.Fatalf("se esperaba un vecino, se obtuvo %+v", got)
+	}
+
+	// Con radio corto no hay resultados ni se recorren anillos de más
+	if got := g.Nearest(40.0, -3.7, 1, 5000, nil); len(got) != 0 {
+		t.Errorf("se esperaban 0 vecinos dentro de 5 km, se obtuvieron %d", len(got))
+	}
+}
+
+func TestGridUpsertAndRemove(t *testing.T) {
+	g := NewGrid(0.01)
+	g.Upsert(Item{ID: 1, Latitude: -34.60, Longitude: -58.38})
+	g.Upsert(Item{ID: 2, Latitude: -34.62, Longitude: -58.40})
+
+	// Mover la moto 2 junto al punto consultado
+	g.Upsert(Item{ID: 2, Latitude: -34.70, Longitude: -58.50})
+	if g.Len() != 2 {
+		t.Fatalf("Len = %d, se esperaba 2", g.Len())
+	}
+	got := g.Nearest(-34.70, -58.50, 1, 0, nil)
+	if len(got) != 1 || got[0].ID != 2 {
+		t.Fatalf("se esperaba la moto 2, se obtuvo %+v", got)
+	}
+
+	g.Remove(2)
+	got = g.Nearest(-34.70, -58.50, 1, 0, nil)
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Fatalf("se esperaba la moto 1 tras quitar la 2, se obtuvo %+v", got)
+	}
+
+	g.Remove(1)
+	if got := g.Nearest(-34.70, -58.50, 1, 0, nil); got != nil {
+		t.Errorf("índice vacío: se esperaba nil, se obtuvo %+v", got)
+	}
+}
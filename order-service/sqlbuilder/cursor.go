@@ -0,0 +1,46 @@
+package sqlbuilder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidCursor cursor mal formado
+var ErrInvalidCursor = errors.New("cursor inválido")
+
+// Cursor posición de la última fila devuelta: el valor de la columna de orden
+// y el id que desempata, para paginar sin saltos ni repetidos (keyset)
+type Cursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// Encode cursor opaco para el cliente
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor interpreta un cursor devuelto por Encode
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.ID <= 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Seek ordena por (key, id) y, si hay cursor, continúa después de él. cast es
+// el tipo SQL del valor de key ("timestamp", "text", "integer").
+func (s *Select) Seek(key, id, cast string, desc bool, after *Cursor) *Select {
+	dir, cmp := " ASC", ">"
+	if desc {
+		dir, cmp = " DESC", "<"
+	}
+	if after != nil {
+		s.Where("("+key+", "+id+") "+cmp+" (?::"+cast+", ?)", after.Value, after.ID)
+	}
+	return s.OrderBy(key+dir, id+dir)
+}
@@ -0,0 +1,101 @@
+// Package sqlbuilder arma consultas SELECT con filtros opcionales sin
+// concatenar valores: las condiciones se escriben con "?" y cada valor viaja
+// como parámetro numerado ($1, $2...) de database/sql. Las expresiones
+// (columnas, condiciones, orden) deben ser constantes del código, nunca texto
+// del usuario.
+package sqlbuilder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Select consulta en construcción
+type Select struct {
+	columns string
+	from    string
+	where   []string
+	args    []interface{}
+	orderBy []string
+	limit   int
+}
+
+// New crea un SELECT columns FROM from
+func New(columns, from string) *Select {
+	return &Select{columns: columns, from: from}
+}
+
+// Where agrega una condición (unida con AND). Cada "?" de cond se reemplaza
+// por el siguiente parámetro con el valor correspondiente de args.
+func (s *Select) Where(cond string, args ...interface{}) *Select {
+	if n := strings.Count(cond, "?"); n != len(args) {
+		panic(fmt.Sprintf("sqlbuilder: %q espera %d valores, recibió %d", cond, n, len(args)))
+	}
+	var b strings.Builder
+	for _, r := range cond {
+		if r == '?' {
+			s.args = append(s.args, args[0])
+			args = args[1:]
+			b.WriteString("$" + strconv.Itoa(len(s.args)))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	s.where = append(s.where, b.String())
+	return s
+}
+
+// OrderBy agrega expresiones de orden ("m.license_plate", "o.id DESC")
+func (s *Select) OrderBy(exprs ...string) *Select {
+	s.orderBy = append(s.orderBy, exprs...)
+	return s
+}
+
+// Limit limita la cantidad de filas (0 = sin límite)
+func (s *Select) Limit(n int) *Select {
+	s.limit = n
+	return s
+}
+
+// Clone copia la consulta para derivar otra (p. ej. la del total)
+func (s *Select) Clone() *Select {
+	c := *s
+	c.where = append([]string(nil), s.where...)
+	c.args = append([]interface{}(nil), s.args...)
+	c.orderBy = append([]string(nil), s.orderBy...)
+	return &c
+}
+
+// SQL devuelve la consulta y sus parámetros
+func (s *Select) SQL() (string, []interface{}) {
+	q := "SELECT " + s.columns + " FROM " + s.from + s.whereSQL()
+	if len(s.orderBy) > 0 {
+		q += " ORDER BY " + strings.Join(s.orderBy, ", ")
+	}
+	args := s.args
+	if s.limit > 0 {
+		args = append(append([]interface{}(nil), args...), s.limit)
+		q += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	return q, args
+}
+
+// CountSQL devuelve SELECT COUNT(*) con las mismas condiciones, sin orden ni límite
+func (s *Select) CountSQL() (string, []interface{}) {
+	return "SELECT COUNT(*) FROM " + s.from + s.whereSQL(), s.args
+}
+
+func (s *Select) whereSQL() string {
+	if len(s.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(s.where, " AND ")
+}
+
+// Contains patrón ILIKE/LIKE que busca text literal en cualquier posición
+// (escapa %, _ y \)
+func Contains(text string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(text) + "%"
+}
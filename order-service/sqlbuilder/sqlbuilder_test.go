@@ -0,0 +1,170 @@
+package sqlbuilder
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSelectSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		build     func() *Select
+		wantSQL   string
+		wantArgs  []interface{}
+		wantCount string
+	}{
+		{
+			name:      "sin filtros",
+			build:     func() *Select { return New("id", "orders") },
+			wantSQL:   "SELECT id FROM orders",
+			wantCount: "SELECT COUNT(*) FROM orders",
+		},
+		{
+			name: "renumera parámetros entre condiciones",
+			build: func() *Select {
+				return New("o.id", "orders o").
+					Where("o.status = ?", "pending").
+					Where("(o.branch_id = ? OR o.zone_id = ?)", 3, 7).
+					Where("o.deleted_at IS NULL").
+					Where("o.created_at >= ?", "2024-01-01")
+			},
+			wantSQL:   "SELECT o.id FROM orders o WHERE o.status = $1 AND (o.branch_id = $2 OR o.zone_id = $3) AND o.deleted_at IS NULL AND o.created_at >= $4",
+			wantArgs:  []interface{}{"pending", 3, 7, "2024-01-01"},
+			wantCount: "SELECT COUNT(*) FROM orders o WHERE o.status = $1 AND (o.branch_id = $2 OR o.zone_id = $3) AND o.deleted_at IS NULL AND o.created_at >= $4",
+		},
+		{
+			name: "orden y límite como último parámetro",
+			build: func() *Select {
+				return New("id", "motos").Where("branch_id = ?", 2).OrderBy("license_plate", "id DESC").Limit(50)
+			},
+			wantSQL:   "SELECT id FROM motos WHERE branch_id = $1 ORDER BY license_plate, id DESC LIMIT $2",
+			wantArgs:  []interface{}{2, 50},
+			wantCount: "SELECT COUNT(*) FROM motos WHERE branch_id = $1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.build()
+			q, args := s.SQL()
+			if q != tt.wantSQL {
+				t.Errorf("SQL =\n  %s\nse esperaba\n  %s", q, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, se esperaba %v", args, tt.wantArgs)
+			}
+			cq, cargs := s.CountSQL()
+			if cq != tt.wantCount {
+				t.Errorf("CountSQL =\n  %s\nse esperaba\n  %s", cq, tt.wantCount)
+			}
+			if len(cargs) != len(s.args) {
+				t.Errorf("CountSQL args = %v", cargs)
+			}
+		})
+	}
+}
+
+func TestWherePanicsOnArgMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Where con valores de menos no hizo panic")
+		}
+	}()
+	New("id", "orders").Where("a = ? AND b = ?", 1)
+}
+
+func TestSQLDoesNotMutate(t *testing.T) {
+	s := New("id", "orders").Where("status = ?", "pending").Limit(10)
+	s.SQL()
+	_, args := s.SQL()
+	if !reflect.DeepEqual(args, []interface{}{"pending", 10}) {
+		t.Errorf("args tras dos llamadas = %v", args)
+	}
+}
+
+func TestClone(t *testing.T) {
+	base := New("id", "orders").Where("status = ?", "pending").OrderBy("id")
+	c := base.Clone().Where("branch_id = ?", 4).OrderBy("created_at")
+
+	q, args := base.SQL()
+	if q != "SELECT id FROM orders WHERE status = $1 ORDER BY id" || len(args) != 1 {
+		t.Errorf("la copia modificó el original: %s %v", q, args)
+	}
+	q, args = c.SQL()
+	if q != "SELECT id FROM orders WHERE status = $1 AND branch_id = $2 ORDER BY id, created_at" || len(args) != 2 {
+		t.Errorf("copia: %s %v", q, args)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	after := &Cursor{Value: "2024-05-01T10:00:00Z", ID: 120}
+	tests := []struct {
+		name     string
+		desc     bool
+		after    *Cursor
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "primera página",
+			wantSQL:  "SELECT o.id FROM orders o WHERE o.status = $1 ORDER BY o.created_at ASC, o.id ASC LIMIT $2",
+			wantArgs: []interface{}{"pending", 20},
+		},
+		{
+			name:     "ascendente después del cursor",
+			after:    after,
+			wantSQL:  "SELECT o.id FROM orders o WHERE o.status = $1 AND (o.created_at, o.id) > ($2::timestamp, $3) ORDER BY o.created_at ASC, o.id ASC LIMIT $4",
+			wantArgs: []interface{}{"pending", after.Value, 120, 20},
+		},
+		{
+			name:     "descendente después del cursor",
+			desc:     true,
+			after:    after,
+			wantSQL:  "SELECT o.id FROM orders o WHERE o.status = $1 AND (o.created_at, o.id) < ($2::timestamp, $3) ORDER BY o.created_at DESC, o.id DESC LIMIT $4",
+			wantArgs: []interface{}{"pending", after.Value, 120, 20},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, args := New("o.id", "orders o").
+				Where("o.status = ?", "pending").
+				Seek("o.created_at", "o.id", "timestamp", tt.desc, tt.after).
+				Limit(20).
+				SQL()
+			if q != tt.wantSQL {
+				t.Errorf("SQL =\n  %s\nse esperaba\n  %s", q, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, se esperaba %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{Value: "Zona 10, \"Oakland\"", ID: 42}
+	got, err := DecodeCursor(c.Encode())
+	if err != nil || got != c {
+		t.Fatalf("DecodeCursor(Encode()) = %+v, %v", got, err)
+	}
+	for _, bad := range []string{"", "no-es-base64!", Cursor{Value: "x"}.Encode(), "bnVsbA"} {
+		if _, err := DecodeCursor(bad); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) err = %v, se esperaba ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := map[string]string{
+		"zona":    "%zona%",
+		"50%":     `%50\%%`,
+		"a_b":     `%a\_b%`,
+		`c:\ruta`: `%c:\\ruta%`,
+		"":        "%%",
+	}
+	for in, want := range tests {
+		if got := Contains(in); got != want {
+			t.Errorf("Contains(%q) = %q, se esperaba %q", in, got, want)
+		}
+	}
+}
@@ -1,28 +1,165 @@
 package validation
 
 import (
+	"time"
+
 	"github.com/go-playground/validator/v10"
+	"github.com/logitrack/order-service/pricing"
 )
 
 var validate *validator.Validate
 
+// Verificadores de sucursal configurados por el servicio (consultan la BD)
+var (
+	branchCodeChecker func(code string) bool
+	branchIDChecker   func(id int) bool
+)
+
 func init() {
 	validate = validator.New()
+	validate.RegisterValidation("active_branch", func(fl validator.FieldLevel) bool {
+		if branchCodeChecker == nil {
+			return true
+		}
+		return branchCodeChecker(fl.Field().String())
+	})
+	validate.RegisterValidation("active_branch_id", func(fl validator.FieldLevel) bool {
+		if branchIDChecker == nil {
+			return true
+		}
+		return branchIDChecker(int(fl.Field().Int()))
+	})
+}
+
+// SetBranchCheckers registra las funciones que validan que una sucursal
+// exista y esté activa (tags active_branch y active_branch_id)
+func SetBranchCheckers(byCode func(code string) bool, byID func(id int) bool) {
+	branchCodeChecker = byCode
+	branchIDChecker = byID
 }
 
-// CreateOrderRequest con validaciones
+// CreateOrderRequest con validaciones. Con customer_id y address_id los
+// datos del cliente y la dirección se toman de la libreta antes de validar.
 type CreateOrderRequest struct {
-	ClientName  string  `json:"client_name" validate:"required,min=3,max=100"`
-	ClientEmail string  `json:"client_email" validate:"omitempty,email"`
-	Address     string  `json:"address" validate:"required,min=10,max=500"`
-	Latitude    float64 `json:"latitude" validate:"required,min=-90,max=90"`
-	Longitude   float64 `json:"longitude" validate:"required,min=-180,max=180"`
-	Branch      string  `json:"branch" validate:"required,oneof=central norte sur este oeste"`
+	CustomerID *int `json:"customer_id" validate:"omitempty,min=1"`
+	AddressID  *int `json:"address_id" validate:"omitempty,min=1"`
+
+	ClientName  string `json:"client_name" validate:"required,min=3,max=100"`
+	ClientEmail string `json:"client_email" validate:"omitempty,email"`
+	Address     string `json:"address" validate:"required,min=10,max=500"`
+	BranchID    *int   `json:"branch_id" validate:"omitempty,active_branch_id"`
+	Branch      string `json:"branch" validate:"omitempty,active_branch"` // Código legado, usar branch_id
+
+	// Sin coordenadas la dirección se geocodifica
+	Latitude  *float64 `json:"latitude" validate:"omitempty,min=-90,max=90"`
+	Longitude *float64 `json:"longitude" validate:"omitempty,min=-180,max=180"`
+
+	MaxDeliveryAttempts *int `json:"max_delivery_attempts" validate:"omitempty,min=1,max=10"`
+
+	// Tipo de pedido (delivery por defecto); pickup_delivery requiere el punto de recogida
+	OrderType       string   `json:"order_type" validate:"omitempty,oneof=delivery pickup_delivery return"`
+	PickupAddress   *string  `json:"pickup_address" validate:"omitempty,max=500"`
+	PickupLatitude  *float64 `json:"pickup_latitude" validate:"omitempty,min=-90,max=90"`
+	PickupLongitude *float64 `json:"pickup_longitude" validate:"omitempty,min=-180,max=180"`
+	PickupContact   string   `json:"pickup_contact" validate:"omitempty,max=100"`
+
+	// Bultos del pedido; sin bultos se crea uno sin medidas
+	Parcels []ParcelRequest `json:"parcels" validate:"omitempty,max=50,dive"`
+
+	// Tarifa: ventana de entrega, entrega exprés y contrato (por defecto el
+	// del cliente o la tarifa general)
+	WindowStart *time.Time `json:"window_start"`
+	WindowEnd   *time.Time `json:"window_end"`
+	Express     bool       `json:"express"`
+	ContractID  *int       `json:"contract_id" validate:"omitempty,min=1"`
+
+	// Monto a cobrar en la entrega (centavos); sin él el pedido ya está pagado
+	CODAmountCents *int64 `json:"cod_amount_cents" validate:"omitempty,min=0"`
+}
+
+// QuoteRequest cotización de una entrega antes de crear el pedido
+type QuoteRequest struct {
+	CustomerID *int `json:"customer_id" validate:"omitempty,min=1"`
+	ContractID *int `json:"contract_id" validate:"omitempty,min=1"`
+
+	Address   string   `json:"address" validate:"omitempty,max=500"`
+	Latitude  *float64 `json:"latitude" validate:"omitempty,min=-90,max=90"`
+	Longitude *float64 `json:"longitude" validate:"omitempty,min=-180,max=180"`
+	BranchID  *int     `json:"branch_id" validate:"omitempty,active_branch_id"`
+
+	WeightKg    *float64        `json:"weight_kg" validate:"omitempty,min=0,max=50000"` // Alternativa a parcels
+	Parcels     []ParcelRequest `json:"parcels" validate:"omitempty,max=50,dive"`
+	WindowStart *time.Time      `json:"window_start"`
+	WindowEnd   *time.Time      `json:"window_end"`
+	Express     bool            `json:"express"`
+}
+
+// PricingContractRequest alta o reemplazo de un contrato de precios; la
+// tarifa se valida con pricing.Tariff.Validate
+type PricingContractRequest struct {
+	Name       string `json:"name" validate:"required,min=3,max=100"`
+	CustomerID *int   `json:"customer_id" validate:"omitempty,min=1"`
+	IsActive   *bool  `json:"is_active"`
+	pricing.Tariff
+}
+
+// CustomerRequest alta de cliente
+type CustomerRequest struct {
+	Name  string `json:"name" validate:"required,min=3,max=100"`
+	Email string `json:"email" validate:"omitempty,email,max=255"`
+	Phone string `json:"phone" validate:"omitempty,min=7,max=20"`
+	Notes string `json:"notes" validate:"omitempty,max=1000"`
+
+	Addresses []CustomerAddressRequest `json:"addresses" validate:"omitempty,max=20,dive"`
+}
+
+// UpdateCustomerRequest campos a modificar (vacío = no cambia)
+type UpdateCustomerRequest struct {
+	Name  *string `json:"name" validate:"omitempty,min=3,max=100"`
+	Email *string `json:"email" validate:"omitempty,email,max=255"`
+	Phone *string `json:"phone" validate:"omitempty,min=7,max=20"`
+	Notes *string `json:"notes" validate:"omitempty,max=1000"`
+}
+
+// CustomerAddressRequest dirección geocodificada del cliente
+type CustomerAddressRequest struct {
+	Label        string   `json:"label" validate:"omitempty,max=50"`
+	Address      string   `json:"address" validate:"required,min=10,max=500"`
+	Latitude     *float64 `json:"latitude" validate:"omitempty,min=-90,max=90"` // Sin coordenadas se geocodifica
+	Longitude    *float64 `json:"longitude" validate:"omitempty,min=-180,max=180"`
+	Instructions string   `json:"instructions" validate:"omitempty,max=500"`
+	IsDefault    bool     `json:"is_default"`
+}
+
+// MergeCustomersRequest cliente duplicado que se fusiona en el de la URL
+type MergeCustomersRequest struct {
+	SourceID int `json:"source_id" validate:"required,min=1"`
+}
+
+// ParcelRequest bulto con peso (kg) y medidas (cm)
+type ParcelRequest struct {
+	WeightKg    *float64 `json:"weight_kg" validate:"omitempty,min=0,max=1000"`
+	LengthCm    *float64 `json:"length_cm" validate:"omitempty,min=0,max=500"`
+	WidthCm     *float64 `json:"width_cm" validate:"omitempty,min=0,max=500"`
+	HeightCm    *float64 `json:"height_cm" validate:"omitempty,min=0,max=500"`
+	Description string   `json:"description" validate:"omitempty,max=200"`
+}
+
+// ScanRequest lectura de un bulto en la cadena de custodia
+type ScanRequest struct {
+	Barcode   string     `json:"barcode" validate:"required,max=20"`
+	Event     string     `json:"event" validate:"required,oneof=received_at_branch loaded_on_moto delivered returned"`
+	BranchID  *int       `json:"branch_id" validate:"omitempty,min=1"`
+	MotoID    *int       `json:"moto_id" validate:"omitempty,min=1"`
+	Latitude  *float64   `json:"latitude" validate:"omitempty,min=-90,max=90"`
+	Longitude *float64   `json:"longitude" validate:"omitempty,min=-180,max=180"`
+	ScannedAt *time.Time `json:"scanned_at"` // Lecturas sin conexión; por defecto ahora
+	Notes     string     `json:"notes" validate:"omitempty,max=500"`
 }
 
 // UpdateOrderStatusRequest con validaciones
 type UpdateOrderStatusRequest struct {
-	Status string `json:"status" validate:"required,oneof=pending assigned in_route delivered cancelled"`
+	Status string `json:"status" validate:"required,oneof=pending assigned in_route delivered failed cancelled"`
 }
 
 // AssignMotoRequest con validaciones